@@ -0,0 +1,31 @@
+// Command fakegateway runs a stand-in for the Kaspi merchant API, so
+// online payments can be tried locally without a merchant account. Point
+// the server's KASPI_API_URL at it and share KASPI_API_KEY and
+// KASPI_WEBHOOK_SECRET.
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"online-doctor-appointment/internal/config"
+	"online-doctor-appointment/internal/payments"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using system environment variables")
+	}
+
+	secret := config.String("KASPI_WEBHOOK_SECRET", "")
+	if secret == "" {
+		log.Fatal("KASPI_WEBHOOK_SECRET must be set")
+	}
+	server := payments.NewFakeServer(config.String("KASPI_API_KEY", ""), secret)
+
+	addr := config.String("FAKE_GATEWAY_ADDR", ":8090")
+	log.Printf("Fake Kaspi gateway listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, server))
+}
@@ -4,9 +4,20 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
+	_ "time/tzdata" // time zones work on hosts without a zoneinfo database
 
+	"online-doctor-appointment/internal/appointment"
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/config"
+	"online-doctor-appointment/internal/csrf"
 	"online-doctor-appointment/internal/database"
 	"online-doctor-appointment/internal/handlers"
+	"online-doctor-appointment/internal/mail"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/money"
+	"online-doctor-appointment/internal/payments"
+	"online-doctor-appointment/internal/schedule"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -19,13 +30,135 @@ func main() {
 		log.Println("Warning: .env file not found, using system environment variables")
 	}
 
+	// Parse page templates once; a broken template should stop startup
+	if err := handlers.LoadTemplates(); err != nil {
+		log.Fatal("Failed to load templates:", err)
+	}
+
 	// Initialize database
 	database.InitDB()
 	defer database.CloseDB()
 
+	// Secret for signed links; without one, links stop working after a restart
+	secretKey := config.String("SECRET_KEY", "")
+	if secretKey == "" {
+		log.Println("Warning: SECRET_KEY is not set, using a random key for this run")
+		if secretKey, err = auth.NewToken(); err != nil {
+			log.Fatal("Failed to generate secret key:", err)
+		}
+	}
+	handlers.SetSigningKey([]byte(secretKey))
+
+	// Only send cookies over HTTPS when deployed behind TLS
+	secureCookies := config.Bool("COOKIE_SECURE", false)
+
+	// Persist sessions in PostgreSQL and clean up expired ones in the background
+	sessionStore := auth.NewPostgresStore(database.DB)
+	handlers.ConfigureSessions(sessionStore, config.Duration("SESSION_TTL", 24*time.Hour), secureCookies)
+	stopSweeper := auth.StartSweeper(sessionStore, config.Duration("SESSION_SWEEP_INTERVAL", 15*time.Minute))
+	defer stopSweeper()
+
+	// Account emails (password resets etc.)
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure mailer:", err)
+	}
+	handlers.ConfigureMail(mailer, config.String("APP_BASE_URL", "http://localhost:"+config.String("PORT", "8080")))
+	handlers.SetPasswordResetTTL(config.Duration("PASSWORD_RESET_TTL", time.Hour))
+	handlers.ConfigureEmailVerification(
+		config.Duration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		config.Int("EMAIL_VERIFICATION_RESEND_LIMIT", 3),
+		config.Duration("EMAIL_VERIFICATION_RESEND_WINDOW", time.Hour),
+	)
+
+	// Brute-force protection for the login form
+	handlers.ConfigureLoginProtection(handlers.LoginProtection{
+		FreeAttempts:     config.Int("LOGIN_FREE_ATTEMPTS", 3),
+		BackoffBase:      config.Duration("LOGIN_BACKOFF_BASE", time.Second),
+		BackoffMax:       config.Duration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+		LockoutThreshold: config.Int("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LockoutDuration:  config.Duration("LOGIN_LOCKOUT_DURATION", 30*time.Minute),
+		IPFreeAttempts:   config.Int("LOGIN_IP_FREE_ATTEMPTS", 20),
+		IPWindow:         config.Duration("LOGIN_IP_WINDOW", time.Hour),
+		TrustProxy:       config.Bool("TRUST_PROXY", false),
+	})
+
+	// How close to the visit patients may still cancel or reschedule
+	handlers.SetAppointmentPolicy(appointment.Policy{
+		Cutoff:         config.Duration("APPOINTMENT_CHANGE_CUTOFF", 24*time.Hour),
+		MaxReschedules: config.Int("APPOINTMENT_MAX_RESCHEDULES", 2),
+	})
+
+	// Clinic time zone, for new doctors and visitors whose browser doesn't report one
+	clinicLocation, err := schedule.Location(config.String("CLINIC_TIMEZONE", "Asia/Almaty"))
+	if err != nil {
+		log.Fatal("Invalid CLINIC_TIMEZONE:", err)
+	}
+	handlers.SetClinicLocation(clinicLocation)
+
+	// Clinic currency, for new doctors' fees, and the locale amounts are shown in when
+	// the browser asks for none we support. PAYMENT_CURRENCY is the older name.
+	clinicCurrency, err := money.LookupCurrency(config.String("CLINIC_CURRENCY", config.String("PAYMENT_CURRENCY", "KZT")))
+	if err != nil {
+		log.Fatal("Invalid CLINIC_CURRENCY:", err)
+	}
+	clinicLocale := config.String("CLINIC_LOCALE", "en-KZ")
+	if !money.SupportsLocale(clinicLocale) {
+		log.Fatal("Unsupported CLINIC_LOCALE: ", clinicLocale)
+	}
+	handlers.SetClinicMoney(clinicCurrency.Code, clinicLocale)
+
+	// Patients can hold a slot while they pay; expired holds are cleared in the background
+	handlers.SetSlotHoldTTL(config.Duration("SLOT_HOLD_TTL", 10*time.Minute))
+	stopHoldSweeper := handlers.StartSlotHoldSweeper(config.Duration("SLOT_HOLD_SWEEP_INTERVAL", time.Minute))
+	defer stopHoldSweeper()
+
+	// Freed slots go to waitlisted patients in turn, each with a limited time to claim
+	handlers.SetWaitlistClaimTTL(config.Duration("WAITLIST_CLAIM_TTL", 30*time.Minute))
+	stopWaitlistSweeper := handlers.StartWaitlistSweeper(config.Duration("WAITLIST_SWEEP_INTERVAL", time.Minute))
+	defer stopWaitlistSweeper()
+
+	// Online payments; without a gateway patients pay at the clinic
+	paymentGateway, err := payments.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure payments:", err)
+	}
+	handlers.SetPaymentGateway(paymentGateway)
+
+	// Appointments booked to be paid online are cancelled if the payment doesn't arrive in time
+	handlers.SetPaymentTimeout(config.Duration("PAYMENT_TIMEOUT", 30*time.Minute))
+	stopPaymentSweeper := handlers.StartUnpaidAppointmentSweeper(config.Duration("PAYMENT_SWEEP_INTERVAL", time.Minute))
+	defer stopPaymentSweeper()
+
+	// How much of a paid fee patients get back when they cancel; doctors' cancellations are refunded in full
+	handlers.SetRefundPolicy(appointment.RefundPolicy{
+		FullBefore:     config.Duration("REFUND_FULL_BEFORE", 48*time.Hour),
+		PartialBefore:  config.Duration("REFUND_PARTIAL_BEFORE", 24*time.Hour),
+		PartialPercent: config.Int("REFUND_PARTIAL_PERCENT", 50),
+	})
+
+	// Clinic details and tax printed on invoices and receipts
+	handlers.SetInvoiceSettings(models.InvoiceSettings{
+		Prefix:        config.String("INVOICE_PREFIX", "INV"),
+		SellerName:    config.String("CLINIC_NAME", "Online Doctor Appointment"),
+		SellerAddress: config.String("CLINIC_ADDRESS", ""),
+		SellerTaxID:   config.String("CLINIC_TAX_ID", ""),
+		TaxName:       config.String("INVOICE_TAX_NAME", "VAT"),
+		TaxRate:       config.Float("INVOICE_TAX_RATE", 0),
+	})
+
+	// Two-factor authentication, mandatory for the roles in TOTP_REQUIRED_ROLES
+	handlers.ConfigureTwoFactor(
+		config.String("TOTP_ISSUER", "Online Doctor Appointment"),
+		config.List("TOTP_REQUIRED_ROLES", nil),
+	)
+
 	// Create router
 	router := mux.NewRouter()
 
+	// Reject cross-site form posts; the payment webhook is signed by the gateway instead
+	router.Use(csrf.Middleware(secureCookies, "/payments/webhook"))
+
 	// Serve static files
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
 
@@ -33,46 +166,122 @@ func main() {
 	router.HandleFunc("/", handlers.HomeHandler).Methods("GET")
 	router.HandleFunc("/login", handlers.LoginPageHandler).Methods("GET")
 	router.HandleFunc("/login", handlers.LoginHandler).Methods("POST")
+	router.HandleFunc("/login/2fa", handlers.TwoFactorLoginPageHandler).Methods("GET")
+	router.HandleFunc("/login/2fa", handlers.TwoFactorLoginHandler).Methods("POST")
 	router.HandleFunc("/register", handlers.RegisterPageHandler).Methods("GET")
 	router.HandleFunc("/register", handlers.RegisterHandler).Methods("POST")
 	router.HandleFunc("/logout", handlers.LogoutHandler).Methods("POST")
+	router.HandleFunc("/forgot-password", handlers.ForgotPasswordPageHandler).Methods("GET")
+	router.HandleFunc("/forgot-password", handlers.ForgotPasswordHandler).Methods("POST")
+	router.HandleFunc("/reset-password", handlers.ResetPasswordPageHandler).Methods("GET")
+	router.HandleFunc("/reset-password", handlers.ResetPasswordHandler).Methods("POST")
+	router.HandleFunc("/verify-email", handlers.VerifyEmailHandler).Methods("GET")
 
 	// API routes for getting data
 	router.HandleFunc("/api/doctors", handlers.GetDoctorsHandler).Methods("GET")
 	router.HandleFunc("/api/doctors/{specialty}", handlers.GetDoctorsBySpecialtyHandler).Methods("GET")
 
-	router.HandleFunc("/payment/success", handlers.PaymentSuccessHandler).Methods("GET")
-	router.HandleFunc("/payment/failure", handlers.PaymentFailureHandler).Methods("GET")
+	// Payment outcomes posted by the gateway
+	router.HandleFunc("/payments/webhook", handlers.PaymentWebhookHandler).Methods("POST")
+
+	// Payment return pages (patients only)
+	requirePatient := auth.RequireRole("patient")
+	router.Handle("/payment/success", handlers.OptionalAuthMiddleware(requirePatient(http.HandlerFunc(handlers.PaymentSuccessHandler)))).Methods("GET")
+	router.Handle("/payment/failure", handlers.OptionalAuthMiddleware(requirePatient(http.HandlerFunc(handlers.PaymentFailureHandler)))).Methods("GET")
+
+	// Two-factor settings stay reachable for users who still have to enroll
+	security := router.PathPrefix("/dashboard/security").Subrouter()
+	security.Use(handlers.AuthMiddleware)
+	security.HandleFunc("/2fa", handlers.TwoFactorSettingsHandler).Methods("GET")
+	security.HandleFunc("/2fa/enable", handlers.EnableTwoFactorHandler).Methods("POST")
+	security.HandleFunc("/2fa/recovery-codes", handlers.RegenerateRecoveryCodesHandler).Methods("POST")
+	security.HandleFunc("/2fa/disable", handlers.DisableTwoFactorHandler).Methods("POST")
 
 	// Protected routes (require authentication)
 	protected := router.PathPrefix("/dashboard").Subrouter()
 	protected.Use(handlers.AuthMiddleware)
-	protected.HandleFunc("/payment/success", handlers.PaymentSuccessHandler).Methods("GET")
-	protected.HandleFunc("/payment/failure", handlers.PaymentFailureHandler).Methods("GET")
+	protected.Use(handlers.RequireTwoFactorEnrollment)
+	protected.Handle("/payment/success", requirePatient(http.HandlerFunc(handlers.PaymentSuccessHandler))).Methods("GET")
+	protected.Handle("/payment/failure", requirePatient(http.HandlerFunc(handlers.PaymentFailureHandler))).Methods("GET")
+
+	// Account routes (all roles)
+	protected.HandleFunc("/verify-email/resend", handlers.ResendVerificationHandler).Methods("POST")
 
 	// Patient routes
-	protected.HandleFunc("/patient", handlers.PatientDashboardHandler).Methods("GET")
-	protected.HandleFunc("/patient/book", handlers.BookAppointmentPageHandler).Methods("GET")
-	protected.HandleFunc("/patient/book", handlers.BookAppointmentHandler).Methods("POST")
-	protected.HandleFunc("/patient/appointments", handlers.PatientAppointmentsHandler).Methods("GET")
+	patient := protected.PathPrefix("/patient").Subrouter()
+	patient.Use(requirePatient)
+	patient.HandleFunc("", handlers.PatientDashboardHandler).Methods("GET")
+	patient.HandleFunc("/book", handlers.BookAppointmentPageHandler).Methods("GET")
+	patient.HandleFunc("/book", handlers.BookAppointmentHandler).Methods("POST")
+	patient.HandleFunc("/appointments", handlers.PatientAppointmentsHandler).Methods("GET")
+	patient.HandleFunc("/holds", handlers.HoldSlotHandler).Methods("POST")
+	patient.HandleFunc("/holds/{id}", handlers.ReleaseSlotHoldHandler).Methods("DELETE")
+	patient.HandleFunc("/waitlist", handlers.JoinWaitlistHandler).Methods("POST")
+	patient.HandleFunc("/waitlist/claim", handlers.WaitlistClaimPageHandler).Methods("GET")
+	patient.HandleFunc("/waitlist/claim", handlers.ClaimWaitlistOfferHandler).Methods("POST")
+	patient.HandleFunc("/waitlist/{id}/leave", handlers.LeaveWaitlistHandler).Methods("POST")
+	patient.Handle("/appointment/{id}/pay",
+		auth.RequirePermission(auth.PermAppointmentsPayOwn, handlers.OwnsAppointment)(
+			http.HandlerFunc(handlers.PayAppointmentHandler))).Methods("POST")
+	patient.Handle("/appointment/{id}/receipt",
+		auth.RequirePermission(auth.PermAppointmentsReadOwn, handlers.OwnsAppointment)(
+			http.HandlerFunc(handlers.AppointmentReceiptHandler))).Methods("GET")
+	patient.Handle("/appointment/{id}/cancel",
+		auth.RequirePermission(auth.PermAppointmentsCancelOwn, handlers.OwnsAppointment)(
+			http.HandlerFunc(handlers.CancelAppointmentHandler))).Methods("POST")
+	patient.Handle("/appointment/{id}/reschedule",
+		auth.RequirePermission(auth.PermAppointmentsRescheduleOwn, handlers.OwnsAppointment)(
+			http.HandlerFunc(handlers.RescheduleAppointmentPageHandler))).Methods("GET")
+	patient.Handle("/appointment/{id}/reschedule",
+		auth.RequirePermission(auth.PermAppointmentsRescheduleOwn, handlers.OwnsAppointment)(
+			http.HandlerFunc(handlers.RescheduleAppointmentHandler))).Methods("POST")
 
 	// Doctor routes
-	protected.HandleFunc("/doctor", handlers.DoctorDashboardHandler).Methods("GET")
-	protected.HandleFunc("/doctor/appointments", handlers.DoctorAppointmentsHandler).Methods("GET")
-	protected.HandleFunc("/doctor/appointment/{id}/update", handlers.UpdateAppointmentStatusHandler).Methods("POST")
+	doctor := protected.PathPrefix("/doctor").Subrouter()
+	doctor.Use(auth.RequireRole("doctor"))
+	doctor.HandleFunc("", handlers.DoctorDashboardHandler).Methods("GET")
+	doctor.HandleFunc("/appointments", handlers.DoctorAppointmentsHandler).Methods("GET")
+	doctor.Handle("/appointment/{id}/update",
+		auth.RequirePermission(auth.PermAppointmentsUpdateOwn, handlers.OwnsAppointment)(
+			http.HandlerFunc(handlers.UpdateAppointmentStatusHandler))).Methods("POST")
+	doctor.HandleFunc("/availability", handlers.DoctorAvailabilityHandler).Methods("GET")
+	doctor.HandleFunc("/availability", handlers.CreateAvailabilityHandler).Methods("POST")
+	doctor.HandleFunc("/availability/windows", handlers.GetAvailabilityAPIHandler).Methods("GET")
+	doctor.HandleFunc("/availability/windows", handlers.CreateAvailabilityAPIHandler).Methods("POST")
+	doctor.HandleFunc("/availability/windows/{id}", handlers.UpdateAvailabilityAPIHandler).Methods("PUT")
+	doctor.HandleFunc("/availability/windows/{id}", handlers.DeactivateAvailabilityAPIHandler).Methods("DELETE")
+	doctor.HandleFunc("/availability/exceptions", handlers.CreateScheduleExceptionHandler).Methods("POST")
+	doctor.HandleFunc("/availability/exceptions/{id}/delete", handlers.DeleteScheduleExceptionHandler).Methods("POST")
+	doctor.HandleFunc("/availability/timezone", handlers.UpdateTimeZoneHandler).Methods("POST")
+	doctor.HandleFunc("/availability/{id}", handlers.UpdateAvailabilityHandler).Methods("POST")
+	doctor.HandleFunc("/availability/{id}/deactivate", handlers.DeactivateAvailabilityHandler).Methods("POST")
 
 	// Admin routes
-	protected.HandleFunc("/admin", handlers.AdminDashboardHandler).Methods("GET")
-	protected.HandleFunc("/admin/doctors", handlers.AdminDoctorsHandler).Methods("GET")
-	protected.HandleFunc("/admin/patients", handlers.AdminPatientsHandler).Methods("GET")
+	admin := protected.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.RequireRole("admin"))
+	admin.HandleFunc("", handlers.AdminDashboardHandler).Methods("GET")
+	admin.HandleFunc("/doctors", handlers.AdminDoctorsHandler).Methods("GET")
+	admin.HandleFunc("/doctors/pending", handlers.AdminDoctorQueueHandler).Methods("GET")
+	admin.HandleFunc("/doctors/{id}/approve", handlers.AdminApproveDoctorHandler).Methods("POST")
+	admin.HandleFunc("/doctors/{id}/reject", handlers.AdminRejectDoctorHandler).Methods("POST")
+	admin.HandleFunc("/patients", handlers.AdminPatientsHandler).Methods("GET")
+	admin.HandleFunc("/security", handlers.AdminLoginSecurityHandler).Methods("GET")
+	admin.HandleFunc("/holidays", handlers.AdminHolidaysHandler).Methods("GET")
+	admin.HandleFunc("/holidays", handlers.AdminCreateHolidayHandler).Methods("POST")
+	admin.HandleFunc("/holidays/import", handlers.AdminImportHolidaysHandler).Methods("POST")
+	admin.HandleFunc("/holidays/{id}/delete", handlers.AdminDeleteHolidayHandler).Methods("POST")
+	admin.HandleFunc("/refunds", handlers.AdminRefundsHandler).Methods("GET")
+	admin.HandleFunc("/refunds/{id}/retry", handlers.AdminRetryRefundHandler).Methods("POST")
+	admin.HandleFunc("/users/{id}/unlock", handlers.AdminUnlockUserHandler).Methods("POST")
 
 	// Chatbot routes (can be accessed by all authenticated users)
 	protected.HandleFunc("/chatbot", handlers.ChatbotPageHandler).Methods("GET")
 	// Chatbot API endpoint
-	router.HandleFunc("/api/chatbot", handlers.ChatbotAPIHandler).Methods("POST")
+	router.Handle("/api/chatbot", handlers.OptionalAuthMiddleware(http.HandlerFunc(handlers.ChatbotAPIHandler))).Methods("POST")
 
 	// API routes for available time slots
-	router.HandleFunc("/api/available-slots/{doctorId}/{date}", handlers.GetAvailableSlotsHandler).Methods("GET")
+	router.Handle("/api/available-slots/{doctorId}/{date}", handlers.OptionalAuthMiddleware(http.HandlerFunc(handlers.GetAvailableSlotsHandler))).Methods("GET")
+	router.Handle("/api/slots/search", handlers.OptionalAuthMiddleware(http.HandlerFunc(handlers.SearchSlotsHandler))).Methods("GET")
 
 	// Get port from environment or default to 8080
 	port := os.Getenv("PORT")
@@ -0,0 +1,70 @@
+package appointment
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTooLate is returned when a patient tries to change an appointment
+// inside the policy's cutoff
+var ErrTooLate = errors.New("appointment is too soon to change")
+
+// ErrRescheduleLimit is returned when an appointment has already been
+// moved as often as the policy allows
+var ErrRescheduleLimit = errors.New("appointment can't be rescheduled again")
+
+// Policy limits the changes patients may make to their own appointments
+type Policy struct {
+	Cutoff         time.Duration // no changes less than this before the visit
+	MaxReschedules int           // how many times one appointment may be moved
+}
+
+// CanCancel reports, as an error, whether a patient may cancel an
+// appointment in status that starts at start
+func (p Policy) CanCancel(status Status, start, now time.Time) error {
+	if err := Transition(status, Cancelled); err != nil {
+		return err
+	}
+	if start.Sub(now) < p.Cutoff {
+		return ErrTooLate
+	}
+	return nil
+}
+
+// CanReschedule reports, as an error, whether a patient may move an
+// appointment in status that starts at start and was already moved
+// reschedules times
+func (p Policy) CanReschedule(status Status, start time.Time, reschedules int, now time.Time) error {
+	if status != Pending && status != Confirmed {
+		return fmt.Errorf("%w: can't reschedule a %s appointment", ErrInvalidTransition, status)
+	}
+	if start.Sub(now) < p.Cutoff {
+		return ErrTooLate
+	}
+	if reschedules >= p.MaxReschedules {
+		return ErrRescheduleLimit
+	}
+	return nil
+}
+
+// RefundPolicy decides how much of a paid fee patients get back when they
+// cancel, depending on how close to the visit they do it. Appointments
+// doctors cancel are always refunded in full.
+type RefundPolicy struct {
+	FullBefore     time.Duration // cancelled at least this long before the visit: full refund
+	PartialBefore  time.Duration // at least this long before: PartialPercent back; later: nothing
+	PartialPercent int
+}
+
+// RefundPercent returns the percentage of the fee refunded when a patient
+// cancels an appointment that starts at start
+func (p RefundPolicy) RefundPercent(start, now time.Time) int {
+	switch until := start.Sub(now); {
+	case until >= p.FullBefore:
+		return 100
+	case until >= p.PartialBefore:
+		return p.PartialPercent
+	}
+	return 0
+}
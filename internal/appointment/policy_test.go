@@ -0,0 +1,101 @@
+package appointment
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefundPercent(t *testing.T) {
+	policy := RefundPolicy{FullBefore: 48 * time.Hour, PartialBefore: 24 * time.Hour, PartialPercent: 50}
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		policy RefundPolicy
+		until  time.Duration // from now to the visit
+		want   int
+	}{
+		{"well ahead", policy, 7 * 24 * time.Hour, 100},
+		{"exactly FullBefore", policy, 48 * time.Hour, 100},
+		{"just inside FullBefore", policy, 48*time.Hour - time.Second, 50},
+		{"exactly PartialBefore", policy, 24 * time.Hour, 50},
+		{"just inside PartialBefore", policy, 24*time.Hour - time.Second, 0},
+		{"at the visit", policy, 0, 0},
+		{"after the visit", policy, -time.Hour, 0},
+		{"no partial refunds", RefundPolicy{FullBefore: 24 * time.Hour, PartialBefore: 12 * time.Hour}, 18 * time.Hour, 0},
+		{"no notice needed", RefundPolicy{}, time.Minute, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.RefundPercent(now.Add(tt.until), now); got != tt.want {
+				t.Errorf("RefundPercent = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanCancel(t *testing.T) {
+	policy := Policy{Cutoff: 24 * time.Hour, MaxReschedules: 2}
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		status Status
+		until  time.Duration // from now to the visit
+		want   error
+	}{
+		{"well ahead", Confirmed, 7 * 24 * time.Hour, nil},
+		{"exactly at the cutoff", Confirmed, 24 * time.Hour, nil},
+		{"one second inside the cutoff", Confirmed, 24*time.Hour - time.Second, ErrTooLate},
+		{"after the visit", Confirmed, -time.Hour, ErrTooLate},
+		{"pending", Pending, 48 * time.Hour, nil},
+		{"awaiting payment", PendingPayment, 48 * time.Hour, nil},
+		{"already cancelled", Cancelled, 48 * time.Hour, ErrInvalidTransition},
+		{"completed", Completed, 48 * time.Hour, ErrInvalidTransition},
+		{"missed", NoShow, -time.Hour, ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := policy.CanCancel(tt.status, now.Add(tt.until), now); !errors.Is(err, tt.want) {
+				t.Errorf("CanCancel = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanReschedule(t *testing.T) {
+	policy := Policy{Cutoff: 24 * time.Hour, MaxReschedules: 2}
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		status      Status
+		until       time.Duration // from now to the visit
+		reschedules int
+		want        error
+	}{
+		{"never moved", Confirmed, 48 * time.Hour, 0, nil},
+		{"moved once", Pending, 48 * time.Hour, 1, nil},
+		{"at the limit", Confirmed, 48 * time.Hour, 2, ErrRescheduleLimit},
+		{"past the limit", Confirmed, 48 * time.Hour, 3, ErrRescheduleLimit},
+		{"exactly at the cutoff", Confirmed, 24 * time.Hour, 0, nil},
+		{"one second inside the cutoff", Confirmed, 24*time.Hour - time.Second, 0, ErrTooLate},
+		{"inside the cutoff at the limit", Confirmed, time.Hour, 2, ErrTooLate},
+		{"awaiting payment", PendingPayment, 48 * time.Hour, 0, ErrInvalidTransition},
+		{"cancelled", Cancelled, 48 * time.Hour, 0, ErrInvalidTransition},
+		{"completed", Completed, 48 * time.Hour, 0, ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.CanReschedule(tt.status, now.Add(tt.until), tt.reschedules, now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("CanReschedule = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := (Policy{}).CanReschedule(Confirmed, now.Add(time.Hour), 0, now); !errors.Is(err, ErrRescheduleLimit) {
+		t.Errorf("a policy allowing no reschedules gave %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Package appointment defines the appointment lifecycle: its statuses and
+// which changes between them are allowed.
+package appointment
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Status is the state of an appointment
+type Status string
+
+// Appointment statuses
+const (
+	Pending        Status = "pending"
+	PendingPayment Status = "pending_payment" // booked to be paid online; confirmed once paid
+	Confirmed      Status = "confirmed"
+	Completed      Status = "completed"
+	Cancelled      Status = "cancelled"
+	NoShow         Status = "no_show"
+)
+
+// transitions lists, for each status, the statuses it may move to. Statuses
+// missing from the map are final.
+var transitions = map[Status][]Status{
+	Pending:        {Confirmed, Cancelled},
+	PendingPayment: {Cancelled}, // confirmed only by its payment, never by hand
+	Confirmed:      {Completed, Cancelled, NoShow},
+}
+
+// ErrInvalidTransition is returned for a status change the lifecycle
+// doesn't allow
+var ErrInvalidTransition = errors.New("invalid appointment status transition")
+
+// ErrUnknownStatus is returned for a value that isn't a Status
+var ErrUnknownStatus = errors.New("unknown appointment status")
+
+// Parse returns the Status named s
+func Parse(s string) (Status, error) {
+	switch st := Status(s); st {
+	case Pending, PendingPayment, Confirmed, Completed, Cancelled, NoShow:
+		return st, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownStatus, s)
+}
+
+// Next returns the statuses s may move to
+func (s Status) Next() []Status {
+	return transitions[s]
+}
+
+// IsFinal reports whether no further changes are allowed from s
+func (s Status) IsFinal() bool {
+	return len(transitions[s]) == 0
+}
+
+// CanTransition reports whether an appointment may move from one status to
+// another
+func CanTransition(from, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition checks a status change, returning an error wrapping
+// ErrInvalidTransition if it isn't allowed
+func Transition(from, to Status) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
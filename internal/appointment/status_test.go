@@ -0,0 +1,63 @@
+package appointment
+
+import (
+	"errors"
+	"testing"
+)
+
+var statuses = []Status{Pending, PendingPayment, Confirmed, Completed, Cancelled, NoShow}
+
+func TestTransition(t *testing.T) {
+	// allowed lists every permitted change; all others must be rejected
+	allowed := map[[2]Status]bool{
+		{Pending, Confirmed}:        true,
+		{Pending, Cancelled}:        true,
+		{PendingPayment, Cancelled}: true, // only its payment confirms it
+		{Confirmed, Completed}:      true,
+		{Confirmed, Cancelled}:      true,
+		{Confirmed, NoShow}:         true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			err := Transition(from, to)
+			if allowed[[2]Status{from, to}] {
+				if err != nil {
+					t.Errorf("Transition(%s, %s) = %v, want allowed", from, to, err)
+				}
+			} else if !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("Transition(%s, %s) = %v, want ErrInvalidTransition", from, to, err)
+			}
+		}
+	}
+
+	// Statuses that aren't in the lifecycle go nowhere
+	if err := Transition("archived", Cancelled); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Transition from an unknown status = %v", err)
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	for _, s := range statuses {
+		want := s == Completed || s == Cancelled || s == NoShow
+		if s.IsFinal() != want {
+			t.Errorf("%s.IsFinal() = %v, want %v", s, s.IsFinal(), want)
+		}
+		if want && len(s.Next()) != 0 {
+			t.Errorf("final %s can move to %v", s, s.Next())
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range statuses {
+		if got, err := Parse(string(s)); err != nil || got != s {
+			t.Errorf("Parse(%q) = %q, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "Confirmed", "archived"} {
+		if _, err := Parse(s); !errors.Is(err, ErrUnknownStatus) {
+			t.Errorf("Parse(%q) = %v, want ErrUnknownStatus", s, err)
+		}
+	}
+}
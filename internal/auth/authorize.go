@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Permission names an action as "resource:action:scope", where scope is
+// "own" (only resources belonging to the principal) or "any"
+type Permission string
+
+const (
+	PermAppointmentsCreateOwn     Permission = "appointments:create:own"
+	PermAppointmentsReadOwn       Permission = "appointments:read:own"
+	PermAppointmentsReadAny       Permission = "appointments:read:any"
+	PermAppointmentsUpdateOwn     Permission = "appointments:update:own"
+	PermAppointmentsUpdateAny     Permission = "appointments:update:any"
+	PermAppointmentsCancelOwn     Permission = "appointments:cancel:own"
+	PermAppointmentsRescheduleOwn Permission = "appointments:reschedule:own"
+	PermAppointmentsPayOwn        Permission = "appointments:pay:own"
+	PermDoctorsReadAny            Permission = "doctors:read:any"
+	PermPatientsReadAny           Permission = "patients:read:any"
+)
+
+// rolePermissions lists what each role is allowed to do
+var rolePermissions = map[string][]Permission{
+	"patient": {
+		PermAppointmentsCreateOwn,
+		PermAppointmentsReadOwn,
+		PermAppointmentsCancelOwn,
+		PermAppointmentsRescheduleOwn,
+		PermAppointmentsPayOwn,
+	},
+	"doctor": {
+		PermAppointmentsReadOwn,
+		PermAppointmentsUpdateOwn,
+	},
+	"admin": {
+		PermAppointmentsReadAny,
+		PermAppointmentsUpdateAny,
+		PermDoctorsReadAny,
+		PermPatientsReadAny,
+	},
+}
+
+// ErrResourceNotFound may be returned by an OwnershipFunc when the resource
+// being checked does not exist; RequirePermission responds with 404
+var ErrResourceNotFound = errors.New("resource not found")
+
+// OwnershipFunc reports whether the resource addressed by r belongs to p
+type OwnershipFunc func(r *http.Request, p Principal) (bool, error)
+
+// HasRole reports whether the principal has one of roles
+func (p Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether the principal's role grants perm exactly
+func (p Principal) Has(perm Permission) bool {
+	for _, granted := range rolePermissions[p.Role] {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// anyScope returns the ":any" form of an ":own" permission
+func anyScope(perm Permission) Permission {
+	return Permission(strings.TrimSuffix(string(perm), ":own") + ":any")
+}
+
+// RequireRole rejects requests whose principal has none of roles
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := FromContext(r.Context())
+			if !principal.HasRole(roles...) {
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission rejects requests whose principal lacks perm. For ":own"
+// permissions the principal passes outright if it holds the ":any" form,
+// otherwise owns is consulted to check the resource belongs to them.
+func RequirePermission(perm Permission, owns OwnershipFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := FromContext(r.Context())
+
+			switch {
+			case principal.Has(anyScope(perm)):
+				// Allowed regardless of ownership
+			case principal.Has(perm) && strings.HasSuffix(string(perm), ":own"):
+				owned, err := owns(r, principal)
+				if errors.Is(err, ErrResourceNotFound) {
+					http.Error(w, "Not found", http.StatusNotFound)
+					return
+				}
+				if err != nil {
+					log.Printf("Error checking ownership for %s: %v", perm, err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if !owned {
+					http.Error(w, "Access denied", http.StatusForbidden)
+					return
+				}
+			case principal.Has(perm):
+				// Permission without an ownership scope
+			default:
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ok answers 200 for requests that get through
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+// owner returns an OwnershipFunc that answers owned, err and counts its calls
+func owner(owned bool, err error, calls *int) OwnershipFunc {
+	return func(*http.Request, Principal) (bool, error) {
+		*calls++
+		return owned, err
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	patient := &Principal{UserID: 1, Role: "patient"}
+	doctor := &Principal{UserID: 2, Role: "doctor", DoctorID: 7}
+	admin := &Principal{UserID: 3, Role: "admin"}
+
+	tests := []struct {
+		name      string
+		principal *Principal // nil for anonymous requests
+		perm      Permission
+		owned     bool
+		ownsErr   error
+		want      int
+		wantCalls int // of the ownership check
+	}{
+		{"anonymous", nil, PermAppointmentsReadOwn, true, nil, http.StatusForbidden, 0},
+		{"missing permission", patient, PermPatientsReadAny, true, nil, http.StatusForbidden, 0},
+		{"missing own permission", doctor, PermAppointmentsCancelOwn, true, nil, http.StatusForbidden, 0},
+		{"own resource", patient, PermAppointmentsCancelOwn, true, nil, http.StatusOK, 1},
+		{"someone else's resource", patient, PermAppointmentsCancelOwn, false, nil, http.StatusForbidden, 1},
+		{"missing resource", doctor, PermAppointmentsUpdateOwn, false, ErrResourceNotFound, http.StatusNotFound, 1},
+		{"ownership check fails", doctor, PermAppointmentsUpdateOwn, false, errors.New("db down"), http.StatusInternalServerError, 1},
+		{"any scope skips the ownership check", admin, PermAppointmentsReadOwn, false, nil, http.StatusOK, 0},
+		{"any permission", admin, PermPatientsReadAny, false, nil, http.StatusOK, 0},
+		{"any permission without the role", patient, PermAppointmentsReadAny, true, nil, http.StatusForbidden, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := RequirePermission(tt.perm, owner(tt.owned, tt.ownsErr, &calls))(ok)
+
+			req := httptest.NewRequest("GET", "/appointments/1", nil)
+			if tt.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), *tt.principal))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("ownership checked %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole("doctor", "admin")(ok)
+	for role, want := range map[string]int{
+		"doctor":  http.StatusOK,
+		"admin":   http.StatusOK,
+		"patient": http.StatusForbidden,
+		"":        http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/dashboard/doctor", nil)
+		req = req.WithContext(WithPrincipal(req.Context(), Principal{UserID: 1, Role: role}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("role %q: status = %d, want %d", role, rec.Code, want)
+		}
+	}
+}
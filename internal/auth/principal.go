@@ -0,0 +1,27 @@
+package auth
+
+import "context"
+
+// Principal is the authenticated user behind a request
+type Principal struct {
+	UserID   int
+	Role     string // patient, doctor, admin
+	Email    string
+	DoctorID int // doctors.id for doctors, 0 otherwise
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// FromContext returns the principal stored in ctx. The second result is
+// false for anonymous requests, in which case the zero Principal is returned.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
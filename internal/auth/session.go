@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrSessionNotFound is returned when a session does not exist or has expired
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionData is the server-side state behind a session cookie
+type SessionData struct {
+	UserID   int
+	UserType string
+	Email    string
+	DoctorID int
+	ExpireAt time.Time
+}
+
+// Principal returns the identity carried by the session
+func (s *SessionData) Principal() Principal {
+	return Principal{
+		UserID:   s.UserID,
+		Role:     s.UserType,
+		Email:    s.Email,
+		DoctorID: s.DoctorID,
+	}
+}
+
+// SessionStore persists sessions keyed by their token
+type SessionStore interface {
+	// Create stores a new session under token
+	Create(token string, data *SessionData) error
+	// Get returns the session for token, or ErrSessionNotFound if it is
+	// missing or expired
+	Get(token string) (*SessionData, error)
+	// Touch moves the expiry of an existing session to expireAt
+	Touch(token string, expireAt time.Time) error
+	// Delete removes the session for token, if any
+	Delete(token string) error
+	// DeleteForUser removes every session belonging to userID
+	DeleteForUser(userID int) error
+	// DeleteExpired removes every session that expired before now
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// Refresh implements sliding expiry: once less than half of ttl remains on
+// the session it is extended to a full ttl from now. It reports whether the
+// expiry was moved so the caller can reissue the cookie.
+func Refresh(store SessionStore, token string, session *SessionData, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	if session.ExpireAt.Sub(now) > ttl/2 {
+		return false, nil
+	}
+
+	expireAt := now.Add(ttl)
+	if err := store.Touch(token, expireAt); err != nil {
+		return false, err
+	}
+	session.ExpireAt = expireAt
+	return true, nil
+}
+
+// StartSweeper periodically deletes expired sessions from store until the
+// returned stop function is called
+func StartSweeper(store SessionStore, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				n, err := store.DeleteExpired(now)
+				if err != nil {
+					log.Printf("Error sweeping expired sessions: %v", err)
+				} else if n > 0 {
+					log.Printf("Removed %d expired sessions", n)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in process memory. It is intended for tests
+// and local development; sessions are lost on restart. It is safe for
+// concurrent use and, like PostgresStore, only keeps token hashes.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]SessionData
+}
+
+// NewMemoryStore returns an empty in-memory session store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]SessionData)}
+}
+
+// Create stores a copy of data under token
+func (s *MemoryStore) Create(token string, data *SessionData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[HashToken(token)] = *data
+	return nil
+}
+
+// Get returns a copy of the session for token if it has not expired
+func (s *MemoryStore) Get(token string) (*SessionData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.sessions[HashToken(token)]
+	if !ok || !data.ExpireAt.After(time.Now()) {
+		return nil, ErrSessionNotFound
+	}
+	return &data, nil
+}
+
+// Touch updates the expiry of an existing session
+func (s *MemoryStore) Touch(token string, expireAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := HashToken(token)
+	data, ok := s.sessions[key]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	data.ExpireAt = expireAt
+	s.sessions[key] = data
+	return nil
+}
+
+// Delete removes the session for token
+func (s *MemoryStore) Delete(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, HashToken(token))
+	return nil
+}
+
+// DeleteForUser removes every session of a user
+func (s *MemoryStore) DeleteForUser(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, data := range s.sessions {
+		if data.UserID == userID {
+			delete(s.sessions, key)
+		}
+	}
+	return nil
+}
+
+// DeleteExpired removes all sessions that expired before now
+func (s *MemoryStore) DeleteExpired(now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key, data := range s.sessions {
+		if !data.ExpireAt.After(now) {
+			delete(s.sessions, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps sessions in the sessions table so they survive
+// restarts and can be shared between replicas. Tokens are stored hashed.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore returns a session store backed by db
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Create inserts a new session row
+func (s *PostgresStore) Create(token string, data *SessionData) error {
+	query := `
+		INSERT INTO sessions (token_hash, user_id, user_type, email, doctor_id, expire_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6)
+	`
+	_, err := s.db.Exec(query, HashToken(token), data.UserID, data.UserType, data.Email,
+		data.DoctorID, data.ExpireAt)
+	return err
+}
+
+// Get retrieves an unexpired session by token
+func (s *PostgresStore) Get(token string) (*SessionData, error) {
+	data := &SessionData{}
+	query := `
+		SELECT user_id, user_type, email, COALESCE(doctor_id, 0), expire_at
+		FROM sessions WHERE token_hash = $1 AND expire_at > $2
+	`
+
+	err := s.db.QueryRow(query, HashToken(token), time.Now()).Scan(
+		&data.UserID, &data.UserType, &data.Email, &data.DoctorID, &data.ExpireAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Touch updates the expiry of a session
+func (s *PostgresStore) Touch(token string, expireAt time.Time) error {
+	_, err := s.db.Exec(`UPDATE sessions SET expire_at = $1 WHERE token_hash = $2`, expireAt, HashToken(token))
+	return err
+}
+
+// Delete removes a session
+func (s *PostgresStore) Delete(token string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token_hash = $1`, HashToken(token))
+	return err
+}
+
+// DeleteForUser removes every session of a user
+func (s *PostgresStore) DeleteForUser(userID int) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+	return err
+}
+
+// DeleteExpired removes all sessions that expired before now
+func (s *PostgresStore) DeleteExpired(now time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM sessions WHERE expire_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
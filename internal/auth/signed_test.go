@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("signing key")
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	token := Sign(key, "42:patient@example.com", now.Add(time.Hour))
+	encoded, sig, _ := strings.Cut(token, ".")
+
+	// forged re-encodes a body under the genuine signature
+	forged := func(body string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(body)) + "." + sig
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		token string
+		now   time.Time
+		ok    bool
+	}{
+		{"genuine", "signing key", token, now, true},
+		{"at expiry", "signing key", token, now.Add(time.Hour), true},
+		{"just after expiry", "signing key", token, now.Add(time.Hour + time.Second), false},
+		{"wrong key", "other key", token, now, false},
+		{"changed payload", "signing key", forged(strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + "|42:attacker@example.com"), now, false},
+		{"extended expiry", "signing key", forged("9999999999|42:patient@example.com"), now, false},
+		{"changed signature", "signing key", encoded + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")), now, false},
+		{"signature of another token", "signing key", encoded + "." + strings.SplitN(Sign(key, "43:x", now.Add(time.Hour)), ".", 2)[1], now, false},
+		{"undecodable signature", "signing key", encoded + ".!!", now, false},
+		{"no signature", "signing key", encoded, now, false},
+		{"empty", "signing key", "", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := Verify([]byte(tt.key), tt.token, tt.now)
+			if tt.ok && (err != nil || payload != "42:patient@example.com") {
+				t.Errorf("Verify = %q, %v; want the payload", payload, err)
+			}
+			if !tt.ok && (!errors.Is(err, ErrInvalidSignature) || payload != "") {
+				t.Errorf("Verify = %q, %v; want ErrInvalidSignature", payload, err)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformedBody(t *testing.T) {
+	key := []byte("signing key")
+	for _, body := range []string{"no separator", "soon|payload", "|payload"} {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(body))
+		token := encoded + "." + base64.RawURLEncoding.EncodeToString(mac(key, encoded))
+		if _, err := Verify(key, token, time.Now()); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidSignature", body, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestNewToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		token, err := NewToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) != base64.RawURLEncoding.EncodedLen(tokenBytes) {
+			t.Fatalf("token %q has length %d, want %d", token, len(token), base64.RawURLEncoding.EncodedLen(tokenBytes))
+		}
+		if _, err := base64.RawURLEncoding.DecodeString(token); err != nil {
+			t.Fatalf("token %q is not URL-safe base64: %v", token, err)
+		}
+		if seen[token] {
+			t.Fatalf("token %q generated twice", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	// SHA-256 of "abc"
+	const want = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := HashToken("abc"); got != want {
+		t.Errorf("HashToken(abc) = %s, want %s", got, want)
+	}
+}
+
+func TestMemoryStoreKeepsOnlyHashes(t *testing.T) {
+	store := NewMemoryStore()
+	token, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(token, &SessionData{UserID: 7, ExpireAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.sessions[token]; ok {
+		t.Error("the raw token is stored")
+	}
+	if _, ok := store.sessions[HashToken(token)]; !ok {
+		t.Error("the session is not stored under the token's hash")
+	}
+	if s, err := store.Get(token); err != nil || s.UserID != 7 {
+		t.Errorf("Get = %v, %v; want the session of user 7", s, err)
+	}
+	if _, err := store.Get(HashToken(token)); err != ErrSessionNotFound {
+		t.Errorf("Get with the hash as token = %v, want ErrSessionNotFound", err)
+	}
+}
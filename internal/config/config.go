@@ -0,0 +1,70 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns the environment variable key, or def when it is unset
+func String(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Int returns the environment variable key parsed as an integer, or def
+func Int(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Float returns the environment variable key parsed as a decimal number, or
+// def
+func Float(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Bool returns the environment variable key parsed as a boolean, or def
+func Bool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Duration returns the environment variable key parsed with time.ParseDuration
+// (e.g. "24h", "15m"), or def when it is unset or invalid
+func Duration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// List returns the comma-separated environment variable key as a slice
+func List(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,98 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const cookieToken = "the-token-in-the-cookie"
+
+// echoToken answers 200 with the token the middleware put in the context
+var echoToken = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(Token(r)))
+})
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware(false, "/payments/webhook")(echoToken)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		cookie string // the csrf_token cookie, none if empty
+		field  string // the csrf_token form field, none if empty
+		header string // the X-CSRF-Token header, none if empty
+		want   int
+	}{
+		{name: "POST without a token", method: "POST", path: "/login", cookie: cookieToken, want: http.StatusForbidden},
+		{name: "POST without a cookie", method: "POST", path: "/login", field: cookieToken, want: http.StatusForbidden},
+		{name: "POST with a forged field", method: "POST", path: "/login", cookie: cookieToken, field: "forged", want: http.StatusForbidden},
+		{name: "POST with a forged header", method: "POST", path: "/login", cookie: cookieToken, header: "forged", want: http.StatusForbidden},
+		{name: "DELETE without a token", method: "DELETE", path: "/api/x", cookie: cookieToken, want: http.StatusForbidden},
+		{name: "POST with a matching field", method: "POST", path: "/login", cookie: cookieToken, field: cookieToken, want: http.StatusOK},
+		{name: "POST with a matching header", method: "POST", path: "/login", cookie: cookieToken, header: cookieToken, want: http.StatusOK},
+		{name: "GET without a token", method: "GET", path: "/login", want: http.StatusOK},
+		{name: "HEAD without a token", method: "HEAD", path: "/login", cookie: cookieToken, want: http.StatusOK},
+		{name: "exempt webhook", method: "POST", path: "/payments/webhook", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.field != "" {
+				form := url.Values{FieldName: {tt.field}}
+				req = httptest.NewRequest(tt.method, tt.path, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(tt.method, tt.path, nil)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(HeaderName, tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareIssuesToken(t *testing.T) {
+	handler := Middleware(true)(echoToken)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == CookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" {
+		t.Fatal("no CSRF cookie was set")
+	}
+	if !cookie.HttpOnly || !cookie.Secure {
+		t.Errorf("cookie is not HttpOnly and Secure: %+v", cookie)
+	}
+	if rec.Body.String() != cookie.Value {
+		t.Errorf("Token = %q, want the cookie's %q", rec.Body.String(), cookie.Value)
+	}
+
+	// A browser that already has a token keeps it
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: CookieName, Value: cookieToken})
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if len(rec.Result().Cookies()) != 0 || rec.Body.String() != cookieToken {
+		t.Errorf("existing token replaced: body %q, cookies %v", rec.Body.String(), rec.Result().Cookies())
+	}
+}
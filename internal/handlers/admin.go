@@ -1,21 +1,22 @@
 package handlers
 
 import (
-	"fmt"
+	"database/sql"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"online-doctor-appointment/internal/auth"
 	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/mail"
 	"online-doctor-appointment/internal/models"
+
+	"github.com/gorilla/mux"
 )
 
 // AdminDashboardHandler serves the admin dashboard
 func AdminDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	_, userType, email := GetCurrentUser(r)
-	if userType != "admin" {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
-	}
-
 	// Get counts for dashboard
 	// For simplicity, we'll do basic counts (in production, you'd want proper count queries)
 
@@ -24,124 +25,24 @@ func AdminDashboardHandler(w http.ResponseWriter, r *http.Request) {
 
 	doctorCount := len(doctors)
 	patientCount := len(patients)
+	pendingCount, _ := models.CountPendingDoctors(database.DB)
 
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Admin Dashboard - Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-</head>
-<body>
-    <div class="container">
-        <div class="dashboard">
-            <div class="dashboard-header">
-                <h2>Admin Dashboard</h2>
-                <div class="user-info">
-                    <span>Administrator</span>
-                    <span>` + email + `</span>
-                    <form method="POST" action="/logout" style="margin-top: 10px;">
-                        <button type="submit" class="btn btn-secondary">Logout</button>
-                    </form>
-                </div>
-            </div>
-
-            <div class="dashboard-content">
-                <div class="card">
-                    <h3>System Overview</h3>
-                    <div class="stats-grid" style="display: grid; grid-template-columns: repeat(auto-fit, minmax(200px, 1fr)); gap: 20px;">
-                        <div class="stat-card" style="background: #d4edda; padding: 20px; border-radius: 8px; text-align: center;">
-                            <h4 style="margin: 0; color: #155724; font-size: 2rem;">` + fmt.Sprintf("%d", doctorCount) + `</h4>
-                            <p style="margin: 5px 0 0 0; color: #155724;">Total Doctors</p>
-                        </div>
-                        <div class="stat-card" style="background: #d1ecf1; padding: 20px; border-radius: 8px; text-align: center;">
-                            <h4 style="margin: 0; color: #0c5460; font-size: 2rem;">` + fmt.Sprintf("%d", patientCount) + `</h4>
-                            <p style="margin: 5px 0 0 0; color: #0c5460;">Total Patients</p>
-                        </div>
-                    </div>
-                </div>
-
-                <div class="card">
-                    <h3>Quick Actions</h3>
-                    <div class="action-buttons">
-                        <a href="/dashboard/admin/doctors" class="btn btn-primary">Manage Doctors</a>
-                        <a href="/dashboard/admin/patients" class="btn btn-info">View Patients</a>
-                    </div>
-                </div>
-
-                <div class="card">
-                    <h3>Recent Doctors</h3>`
-
-	if len(doctors) == 0 {
-		tmpl += `<p>No doctors registered.</p>`
-	} else {
-		tmpl += `<table class="table">
-                        <thead>
-                            <tr>
-                                <th>Name</th>
-                                <th>Specialty</th>
-                                <th>Experience</th>
-                                <th>Fee</th>
-                                <th>Status</th>
-                            </tr>
-                        </thead>
-                        <tbody>`
-
-		for i, doctor := range doctors {
-			if i >= 5 { // Show only first 5
-				break
-			}
-			status := "Active"
-			if !doctor.IsActive {
-				status = "Inactive"
-			}
-			tmpl += fmt.Sprintf(`
-                            <tr>
-                                <td>Dr. %s</td>
-                                <td>%s</td>
-                                <td>%d years</td>
-                                <td>$%.2f</td>
-                                <td><span class="status %s">%s</span></td>
-                            </tr>`,
-				doctor.User.GetFullName(),
-				doctor.Specialty,
-				doctor.ExperienceYears,
-				doctor.ConsultationFee,
-				func() string {
-					if doctor.IsActive {
-						return "confirmed"
-					}
-					return "cancelled"
-				}(),
-				status)
-		}
-
-		tmpl += `</tbody></table>`
+	// Show only the first 5 doctors
+	recentDoctors := doctors
+	if len(recentDoctors) > 5 {
+		recentDoctors = recentDoctors[:5]
 	}
 
-	tmpl += `
-                </div>
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
-
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	render(w, r, "admin-dashboard.html", struct {
+		DoctorCount  int
+		PatientCount int
+		PendingCount int
+		Doctors      []models.Doctor
+	}{doctorCount, patientCount, pendingCount, recentDoctors})
 }
 
 // AdminDoctorsHandler shows all doctors for admin management
 func AdminDoctorsHandler(w http.ResponseWriter, r *http.Request) {
-	_, userType, email := GetCurrentUser(r)
-	if userType != "admin" {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
-	}
-
 	// Get all doctors
 	doctors, err := models.GetAllDoctors(database.DB)
 	if err != nil {
@@ -149,176 +50,101 @@ func AdminDoctorsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Manage Doctors - Admin Dashboard</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-</head>
-<body>
-    <div class="container">
-        <div class="dashboard">
-            <div class="dashboard-header">
-                <h2>Manage Doctors</h2>
-                <div class="user-info">
-                    <span>Administrator</span>
-                    <span>` + email + `</span>
-                    <a href="/dashboard/admin" class="btn btn-secondary">← Back to Dashboard</a>
-                </div>
-            </div>
-
-            <div class="card">
-                <h3>All Doctors (` + fmt.Sprintf("%d", len(doctors)) + `)</h3>`
+	render(w, r, "admin-doctors.html", struct {
+		Doctors []models.Doctor
+	}{doctors})
+}
 
-	if len(doctors) == 0 {
-		tmpl += `<p>No doctors registered in the system.</p>`
-	} else {
-		tmpl += `<table class="table">
-                    <thead>
-                        <tr>
-                            <th>ID</th>
-                            <th>Name</th>
-                            <th>Email</th>
-                            <th>Phone</th>
-                            <th>Specialty</th>
-                            <th>Experience</th>
-                            <th>Fee</th>
-                            <th>Status</th>
-                            <th>Joined</th>
-                        </tr>
-                    </thead>
-                    <tbody>`
+// AdminPatientsHandler shows all patients for admin
+func AdminPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get all patients
+	patients, err := models.GetAllPatients(database.DB)
+	if err != nil {
+		http.Error(w, "Error loading patients", http.StatusInternalServerError)
+		return
+	}
 
-		for _, doctor := range doctors {
-			status := "Active"
-			statusClass := "confirmed"
-			if !doctor.IsActive {
-				status = "Inactive"
-				statusClass = "cancelled"
-			}
-			tmpl += fmt.Sprintf(`
-                        <tr>
-                            <td>%d</td>
-                            <td>Dr. %s</td>
-                            <td>%s</td>
-                            <td>%s</td>
-                            <td>%s</td>
-                            <td>%d years</td>
-                            <td>$%.2f</td>
-                            <td><span class="status %s">%s</span></td>
-                            <td>%s</td>
-                        </tr>`,
-				doctor.ID,
-				doctor.User.GetFullName(),
-				doctor.User.Email,
-				doctor.User.Phone,
-				doctor.Specialty,
-				doctor.ExperienceYears,
-				doctor.ConsultationFee,
-				statusClass,
-				status,
-				doctor.CreatedAt.Format("2006-01-02"))
-		}
+	render(w, r, "admin-patients.html", struct {
+		Patients []models.User
+	}{patients})
+}
 
-		tmpl += `</tbody></table>`
+// AdminDoctorQueueHandler shows doctors waiting for their credentials to be
+// reviewed
+func AdminDoctorQueueHandler(w http.ResponseWriter, r *http.Request) {
+	doctors, err := models.GetPendingDoctors(database.DB)
+	if err != nil {
+		http.Error(w, "Error loading doctors", http.StatusInternalServerError)
+		return
 	}
 
-	tmpl += `
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
+	render(w, r, "admin-doctor-queue.html", struct {
+		Doctors []models.Doctor
+	}{doctors})
+}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+// AdminApproveDoctorHandler approves a pending doctor so patients can book them
+func AdminApproveDoctorHandler(w http.ResponseWriter, r *http.Request) {
+	reviewDoctor(w, r, models.DoctorApproved)
 }
 
-// AdminPatientsHandler shows all patients for admin
-func AdminPatientsHandler(w http.ResponseWriter, r *http.Request) {
-	_, userType, email := GetCurrentUser(r)
-	if userType != "admin" {
-		http.Error(w, "Access denied", http.StatusForbidden)
+// AdminRejectDoctorHandler rejects a pending doctor. A reason is required.
+func AdminRejectDoctorHandler(w http.ResponseWriter, r *http.Request) {
+	reviewDoctor(w, r, models.DoctorRejected)
+}
+
+// reviewDoctor records the admin's decision on a pending doctor and tells
+// the doctor by email
+func reviewDoctor(w http.ResponseWriter, r *http.Request, status string) {
+	principal, _ := auth.FromContext(r.Context())
+
+	doctorID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid doctor ID", http.StatusBadRequest)
 		return
 	}
 
-	// Get all patients
-	patients, err := models.GetAllPatients(database.DB)
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if status == models.DoctorRejected && reason == "" {
+		http.Error(w, "A reason is required to reject a doctor", http.StatusBadRequest)
+		return
+	}
+
+	err = models.ReviewDoctor(database.DB, doctorID, status, reason, principal.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Doctor not found or already reviewed", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Error loading patients", http.StatusInternalServerError)
+		log.Printf("Error reviewing doctor %d: %v", doctorID, err)
+		http.Error(w, "Failed to save review", http.StatusInternalServerError)
 		return
 	}
 
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>View Patients - Admin Dashboard</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-</head>
-<body>
-    <div class="container">
-        <div class="dashboard">
-            <div class="dashboard-header">
-                <h2>View Patients</h2>
-                <div class="user-info">
-                    <span>Administrator</span>
-                    <span>` + email + `</span>
-                    <a href="/dashboard/admin" class="btn btn-secondary">← Back to Dashboard</a>
-                </div>
-            </div>
+	if doctor, err := models.GetDoctorByID(database.DB, doctorID); err == nil {
+		sendDoctorReviewEmail(doctor)
+	}
 
-            <div class="card">
-                <h3>All Patients (` + fmt.Sprintf("%d", len(patients)) + `)</h3>`
+	http.Redirect(w, r, "/dashboard/admin/doctors/pending", http.StatusSeeOther)
+}
 
-	if len(patients) == 0 {
-		tmpl += `<p>No patients registered in the system.</p>`
+// sendDoctorReviewEmail tells a doctor the outcome of their verification
+func sendDoctorReviewEmail(doctor *models.Doctor) {
+	body := "Hello Dr. " + doctor.User.GetFullName() + ",\n\n"
+	subject := "Your doctor account has been approved"
+	if doctor.IsApproved() {
+		body += "Your credentials have been verified. Patients can now find and book you on Online Doctor Appointment.\n"
 	} else {
-		tmpl += `<table class="table">
-                    <thead>
-                        <tr>
-                            <th>ID</th>
-                            <th>Name</th>
-                            <th>Email</th>
-                            <th>Phone</th>
-                            <th>Joined</th>
-                        </tr>
-                    </thead>
-                    <tbody>`
-
-		for _, patient := range patients {
-			tmpl += fmt.Sprintf(`
-                        <tr>
-                            <td>%d</td>
-                            <td>%s</td>
-                            <td>%s</td>
-                            <td>%s</td>
-                            <td>%s</td>
-                        </tr>`,
-				patient.ID,
-				patient.GetFullName(),
-				patient.Email,
-				patient.Phone,
-				patient.CreatedAt.Format("2006-01-02"))
-		}
-
-		tmpl += `</tbody></table>`
+		subject = "Your doctor account could not be verified"
+		body += "We were unable to verify your credentials, so your profile won't be shown to patients.\n"
+	}
+	if doctor.VerificationNote != "" {
+		body += "\nNote from the reviewer:\n" + doctor.VerificationNote + "\n"
 	}
 
-	tmpl += `
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
-
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	sendMail(mail.Message{
+		To:      doctor.User.Email,
+		Subject: subject,
+		Body:    body,
+	})
 }
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// listed reports whether a doctor is among those shown to patients, in
+// the full list and by specialty
+func listed(t *testing.T, doctor *models.Doctor) bool {
+	t.Helper()
+	isDoctor := func(d models.Doctor) bool { return d.ID == doctor.ID }
+	all, err := models.GetAllDoctors(database.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bySpecialty, err := models.GetDoctorsBySpecialty(database.DB, doctor.Specialty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inAll, inSpecialty := slices.ContainsFunc(all, isDoctor), slices.ContainsFunc(bySpecialty, isDoctor)
+	if inAll != inSpecialty {
+		t.Errorf("doctor %d is listed %v, but by specialty %v", doctor.ID, inAll, inSpecialty)
+	}
+	return inAll
+}
+
+// bookable reports whether a patient gets past the doctor check when
+// booking the doctor; the slot itself is never offered
+func bookable(t *testing.T, doctor *models.Doctor, patient *auth.Principal) bool {
+	t.Helper()
+	rec := postForm(BookAppointmentHandler, "/dashboard/patient/book", url.Values{
+		"doctor_id": {strconv.Itoa(doctor.ID)},
+		"starts_at": {time.Now().Add(48 * time.Hour).Truncate(time.Hour).Format(time.RFC3339)},
+	}, patient)
+	switch rec.Code {
+	case http.StatusBadRequest:
+		return false
+	case http.StatusConflict:
+		return true
+	}
+	t.Fatalf("booking answered %d: %s", rec.Code, rec.Body.String())
+	return false
+}
+
+// reviewAs sends an admin's approval or rejection of a doctor
+func reviewAs(admin *auth.Principal, handler http.HandlerFunc, doctorID int, reason string) int {
+	id := strconv.Itoa(doctorID)
+	rec := postForm(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	}, "/dashboard/admin/doctors/"+id, url.Values{"reason": {reason}}, admin)
+	return rec.Code
+}
+
+// TestDoctorApproval checks self-registered doctors stay hidden and
+// unbookable until an admin approves them, and for good if rejected
+func TestDoctorApproval(t *testing.T) {
+	db := useTestDB(t)
+	mails := useMemoryMailer(t)
+	admin := &auth.Principal{UserID: createTestUser(t, db, "admin").ID, Role: "admin"}
+	user := createTestUser(t, db, "patient")
+	if err := models.MarkEmailVerified(db, user.ID, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	patient := &auth.Principal{UserID: user.ID, Role: "patient"}
+
+	approved := createTestDoctor(t, db)
+	rejected := createTestDoctor(t, db)
+	for _, d := range []*models.Doctor{approved, rejected} {
+		if _, err := db.Exec(`UPDATE doctors SET verification_status = 'pending_verification' WHERE id = $1`, d.ID); err != nil {
+			t.Fatal(err)
+		}
+		if listed(t, d) || bookable(t, d, patient) {
+			t.Errorf("pending doctor %d is listed or bookable", d.ID)
+		}
+	}
+
+	if code := reviewAs(admin, AdminRejectDoctorHandler, rejected.ID, ""); code != http.StatusBadRequest {
+		t.Errorf("rejection without a reason answered %d, want 400", code)
+	}
+	if code := reviewAs(admin, AdminRejectDoctorHandler, rejected.ID, "Licence not found"); code != http.StatusSeeOther {
+		t.Fatalf("rejection answered %d", code)
+	}
+	if code := reviewAs(admin, AdminApproveDoctorHandler, approved.ID, ""); code != http.StatusSeeOther {
+		t.Fatalf("approval answered %d", code)
+	}
+
+	if listed(t, rejected) || bookable(t, rejected, patient) {
+		t.Error("rejected doctor is listed or bookable")
+	}
+	if !listed(t, approved) || !bookable(t, approved, patient) {
+		t.Error("approved doctor is not listed or bookable")
+	}
+
+	// A decision is final
+	if code := reviewAs(admin, AdminApproveDoctorHandler, rejected.ID, ""); code != http.StatusNotFound {
+		t.Errorf("approving a rejected doctor answered %d, want 404", code)
+	}
+	if listed(t, rejected) {
+		t.Error("rejected doctor was approved after all")
+	}
+
+	if n := len(mails.Messages()); n != 2 {
+		t.Errorf("%d review emails sent, want 2", n)
+	}
+}
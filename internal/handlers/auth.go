@@ -1,173 +1,202 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"online-doctor-appointment/internal/auth"
 	"online-doctor-appointment/internal/database"
 	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/money"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Session management (simple in-memory store for MVP)
-var sessions = make(map[string]*SessionData)
+// sessions holds all login sessions. It defaults to an in-memory store and
+// is replaced with the PostgreSQL store at startup via ConfigureSessions.
+var sessions auth.SessionStore = auth.NewMemoryStore()
 
-type SessionData struct {
-	UserID   int
-	UserType string
-	Email    string
-	ExpireAt time.Time
+// sessionTTL is how long a session stays valid without activity
+var sessionTTL = 24 * time.Hour
+
+// secureCookies marks cookies Secure so browsers only send them over HTTPS
+var secureCookies = false
+
+// signingKey authenticates signed links such as email verification URLs
+var signingKey []byte
+
+// SetSigningKey sets the secret used to sign links. It must be called
+// before the server starts handling requests.
+func SetSigningKey(key []byte) {
+	signingKey = key
 }
 
-// Generate simple session token (in production, use proper JWT or secure sessions)
-func generateSessionToken() string {
-	return generateRandomString(32)
+// ConfigureSessions sets the store used for login sessions, their lifetime
+// and whether the session cookie requires HTTPS. It must be called before
+// the server starts handling requests.
+func ConfigureSessions(store auth.SessionStore, ttl time.Duration, secure bool) {
+	sessions = store
+	sessionTTL = ttl
+	secureCookies = secure
 }
 
-func generateRandomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+// setSessionCookie writes the session cookie with the given expiry
+func setSessionCookie(w http.ResponseWriter, token string, expireAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    token,
+		Expires:  expireAt,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	})
+}
+
+// currentSession returns the session token and data for the request
+func currentSession(r *http.Request) (string, *auth.SessionData, error) {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return "", nil, auth.ErrSessionNotFound
+	}
+
+	session, err := sessions.Get(cookie.Value)
+	if err != nil {
+		return "", nil, err
 	}
-	return string(b)
+
+	return cookie.Value, session, nil
 }
 
 // HomeHandler serves the home page
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-</head>
-<body>
-    <div class="container">
-        <header class="header">
-            <h1>🏥 Online Doctor Appointment</h1>
-            <p>Book appointments with qualified doctors online</p>
-        </header>
-        
-        <div class="home-content">
-            <div class="hero-section">
-                <h2>Welcome to Our Medical Platform</h2>
-                <p>Easy, fast, and secure way to book appointments with healthcare professionals</p>
-                
-                <div class="action-buttons">
-                    <a href="/login" class="btn btn-primary">Login</a>
-                    <a href="/register" class="btn btn-secondary">Register</a>
-                </div>
-            </div>
-            
-            <div class="features">
-                <div class="feature-card">
-                    <h3>👨‍⚕️ Qualified Doctors</h3>
-                    <p>Connect with experienced and certified medical professionals</p>
-                </div>
-                <div class="feature-card">
-                    <h3>📅 Easy Booking</h3>
-                    <p>Simple and intuitive appointment booking system</p>
-                </div>
-                <div class="feature-card">
-                    <h3>🔒 Secure Platform</h3>
-                    <p>Your health data is protected with industry-standard security</p>
-                </div>
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	render(w, r, "home.html", nil)
+}
+
+// loginNotices are the messages the login page can show after a redirect.
+// Only these fixed strings are displayed, never the query parameter itself.
+var loginNotices = map[string]string{
+	"password_reset": "Your password has been changed. Please log in with your new password.",
 }
 
 // LoginPageHandler serves the login page
 func LoginPageHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Login - Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-</head>
-<body>
-    <div class="container">
-        <div class="auth-form">
-            <h2>Login</h2>
-            <form method="POST" action="/login">
-                <div class="form-group">
-                    <label for="email">Email:</label>
-                    <input type="email" id="email" name="email" required>
-                </div>
-                <div class="form-group">
-                    <label for="password">Password:</label>
-                    <input type="password" id="password" name="password" required>
-                </div>
-                <button type="submit" class="btn btn-primary">Login</button>
-            </form>
-            <p><a href="/register">Don't have an account? Register here</a></p>
-            <p><a href="/">← Back to Home</a></p>
-            
-            <div class="demo-accounts">
-                <h4>Demo Accounts:</h4>
-                <p><strong>Patient:</strong> patient1@email.com / password123</p>
-                <p><strong>Doctor:</strong> dr.smith@hospital.com / password123</p>
-                <p><strong>Admin:</strong> admin@hospital.com / password123</p>
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	render(w, r, "login.html", struct {
+		Notice string
+	}{loginNotices[r.URL.Query().Get("notice")]})
 }
 
 // LoginHandler handles login form submission
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	password := r.FormValue("password")
+	ip := clientIP(r)
+	now := loginClock()
+
+	// Slow down addresses that keep failing, whichever accounts they try
+	wait, err := ipRetryAfter(ip, now)
+	if err != nil {
+		log.Printf("Error checking failed logins: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		tooManyLoginAttempts(w, wait)
+		return
+	}
 
 	// Get user from database
 	user, err := models.GetUserByEmail(database.DB, email)
-	if err != nil {
+	if err == sql.ErrNoRows {
+		recordLoginFailure(email, ip, 0, models.LoginUnknownEmail, now)
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		log.Printf("Error loading user for login: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Back off, then lock, accounts under attack
+	state, err := models.GetLoginState(database.DB, user.ID)
+	if err != nil {
+		log.Printf("Error loading login state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if wait := accountRetryAfter(state, now); wait > 0 {
+		tooManyLoginAttempts(w, wait)
+		return
+	}
 
 	// Check password
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
+		recordLoginFailure(email, ip, user.ID, models.LoginBadPassword, now)
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
+	if state.FailedLogins > 0 {
+		if err := models.ResetFailedLogins(database.DB, user.ID); err != nil {
+			log.Printf("Error resetting failed logins: %v", err)
+		}
+	}
+
+	// Accounts with two-factor authentication must confirm a code first
+	enabled, err := models.TOTPEnabled(database.DB, user.ID)
+	if err != nil {
+		log.Printf("Error checking two-factor status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		startTwoFactorLogin(w, r, user)
+		return
+	}
+
+	startSession(w, r, user)
+}
+
+// startSession logs user in and redirects them to their dashboard
+func startSession(w http.ResponseWriter, r *http.Request, user *models.User) {
+	// Doctors carry their profile ID in the session
+	doctorID := 0
+	if user.UserType == "doctor" {
+		if doctor, err := models.GetDoctorByUserID(database.DB, user.ID); err == nil {
+			doctorID = doctor.ID
+		}
+	}
+
 	// Create session
-	sessionToken := generateSessionToken()
-	sessions[sessionToken] = &SessionData{
+	sessionToken, err := auth.NewToken()
+	if err != nil {
+		log.Printf("Error generating session token: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	expireAt := time.Now().Add(sessionTTL)
+	err = sessions.Create(sessionToken, &auth.SessionData{
 		UserID:   user.ID,
 		UserType: user.UserType,
 		Email:    user.Email,
-		ExpireAt: time.Now().Add(24 * time.Hour), // Session expires in 24 hours
+		DoctorID: doctorID,
+		ExpireAt: expireAt,
+	})
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
 	}
 
 	// Set session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    sessionToken,
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: true,
-		Path:     "/",
-	})
+	setSessionCookie(w, sessionToken, expireAt)
 
 	// Redirect based on user type
 	switch user.UserType {
@@ -184,59 +213,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 // RegisterPageHandler serves the registration page
 func RegisterPageHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Register - Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-</head>
-<body>
-    <div class="container">
-        <div class="auth-form">
-            <h2>Register</h2>
-            <form method="POST" action="/register">
-                <div class="form-group">
-                    <label for="first_name">First Name:</label>
-                    <input type="text" id="first_name" name="first_name" required>
-                </div>
-                <div class="form-group">
-                    <label for="last_name">Last Name:</label>
-                    <input type="text" id="last_name" name="last_name" required>
-                </div>
-                <div class="form-group">
-                    <label for="email">Email:</label>
-                    <input type="email" id="email" name="email" required>
-                </div>
-                <div class="form-group">
-                    <label for="phone">Phone:</label>
-                    <input type="tel" id="phone" name="phone">
-                </div>
-                <div class="form-group">
-                    <label for="password">Password:</label>
-                    <input type="password" id="password" name="password" required minlength="6">
-                </div>
-                <div class="form-group">
-                    <label for="user_type">I am a:</label>
-                    <select id="user_type" name="user_type" required>
-                        <option value="">Select...</option>
-                        <option value="patient">Patient</option>
-                        <option value="doctor">Doctor</option>
-                    </select>
-                </div>
-                <button type="submit" class="btn btn-primary">Register</button>
-            </form>
-            <p><a href="/login">Already have an account? Login here</a></p>
-            <p><a href="/">← Back to Home</a></p>
-        </div>
-    </div>
-</body>
-</html>
-`
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	render(w, r, "register.html", nil)
 }
 
 // RegisterHandler handles registration form submission
@@ -248,6 +225,27 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 	userType := r.FormValue("user_type")
 
+	// Admins are never self-registered
+	if userType != "patient" && userType != "doctor" {
+		http.Error(w, "Invalid account type", http.StatusBadRequest)
+		return
+	}
+
+	// Doctors submit credentials for an admin to verify
+	specialty := strings.TrimSpace(r.FormValue("specialty"))
+	licenseNumber := strings.TrimSpace(r.FormValue("license_number"))
+	education := strings.TrimSpace(r.FormValue("education"))
+	experienceYears, _ := strconv.Atoi(r.FormValue("experience_years"))
+	if userType == "doctor" {
+		if licenseNumber == "" || education == "" {
+			http.Error(w, "License number and education are required for doctor accounts", http.StatusBadRequest)
+			return
+		}
+		if specialty == "" {
+			specialty = "General Practice"
+		}
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -272,15 +270,21 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If user is a doctor, create doctor profile
+	// Ask the user to confirm their address before they can book
+	sendVerificationEmail(user)
+
+	// If user is a doctor, create doctor profile. It stays hidden from
+	// patients until an admin approves it.
 	if userType == "doctor" {
 		doctor := &models.Doctor{
 			UserID:          user.ID,
-			Specialty:       "General Practice", // Default specialty
-			ExperienceYears: 0,
-			Education:       "",
+			Specialty:       specialty,
+			ExperienceYears: max(experienceYears, 0),
+			Education:       education,
 			About:           "",
-			ConsultationFee: 50.00, // Default fee
+			ConsultationFee: money.FromMajor(50, clinicCurrency), // Default fee
+			LicenseNumber:   licenseNumber,
+			TimeZone:        clinicLocation.String(),
 		}
 		err = models.CreateDoctor(database.DB, doctor)
 		if err != nil {
@@ -296,61 +300,63 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("session_token")
 	if err == nil {
-		// Delete session from memory
-		delete(sessions, cookie.Value)
+		if err := sessions.Delete(cookie.Value); err != nil {
+			log.Printf("Error deleting session: %v", err)
+		}
 	}
 
 	// Clear cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour),
-		HttpOnly: true,
-		Path:     "/",
-	})
+	setSessionCookie(w, "", time.Now().Add(-time.Hour))
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// AuthMiddleware checks if user is authenticated
+// AuthMiddleware checks if user is authenticated and stores their
+// auth.Principal in the request context
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
-		if err != nil {
+		principal, ok := authenticate(w, r)
+		if !ok {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
-		session, exists := sessions[cookie.Value]
-		if !exists || session.ExpireAt.Before(time.Now()) {
-			// Session expired or doesn't exist
-			delete(sessions, cookie.Value)
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
-		}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
 
-		// Add user info to request context (we'll use a simple approach)
-		r.Header.Set("X-User-ID", string(rune(session.UserID)))
-		r.Header.Set("X-User-Type", session.UserType)
-		r.Header.Set("X-User-Email", session.Email)
+// OptionalAuthMiddleware stores the principal in the request context when
+// the request carries a valid session, and lets anonymous requests through
+func OptionalAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := authenticate(w, r); ok {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-// GetCurrentUser extracts current user from request
-func GetCurrentUser(r *http.Request) (int, string, string) {
-	cookie, err := r.Cookie("session_token")
+// authenticate resolves the session cookie into a principal, extending the
+// session if it is due for a sliding refresh
+func authenticate(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	token, session, err := currentSession(r)
 	if err != nil {
-		return 0, "", ""
+		if err != auth.ErrSessionNotFound {
+			log.Printf("Error loading session: %v", err)
+		}
+		return auth.Principal{}, false
 	}
 
-	session, exists := sessions[cookie.Value]
-	if !exists {
-		return 0, "", ""
+	// Sliding expiry: keep active users logged in
+	refreshed, err := auth.Refresh(sessions, token, session, sessionTTL)
+	if err != nil {
+		log.Printf("Error refreshing session: %v", err)
+	} else if refreshed {
+		setSessionCookie(w, token, session.ExpireAt)
 	}
 
-	return session.UserID, session.UserType, session.Email
+	return session.Principal(), true
 }
 
 // Helper function to respond with JSON
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useTestDB points database.DB at the database in DATABASE_URL, which must
+// have sql/schema.sql applied, and skips the test when it isn't set
+func useTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	old := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = old
+		db.Close()
+	})
+	return db
+}
+
+// useMemorySessions gives the test its own in-memory session store
+func useMemorySessions(t *testing.T) *auth.MemoryStore {
+	store := auth.NewMemoryStore()
+	oldStore, oldTTL, oldSecure := sessions, sessionTTL, secureCookies
+	ConfigureSessions(store, time.Hour, false)
+	t.Cleanup(func() { ConfigureSessions(oldStore, oldTTL, oldSecure) })
+	return store
+}
+
+// sessionCookie returns the session cookie a response set, if any
+func sessionCookie(resp *http.Response) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == "session_token" {
+			return c
+		}
+	}
+	return nil
+}
+
+// whoAmI is behind AuthMiddleware and writes the principal's user ID
+var whoAmI = AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	fmt.Fprint(w, p.UserID)
+}))
+
+// newSession stores a patient session for userID, the way LoginHandler
+// does, and returns its cookie
+func newSession(t *testing.T, userID int) *http.Cookie {
+	token, err := auth.NewToken()
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	err = sessions.Create(token, &auth.SessionData{
+		UserID:   userID,
+		UserType: "patient",
+		Email:    fmt.Sprintf("patient%d@example.com", userID),
+		ExpireAt: time.Now().Add(sessionTTL),
+	})
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	return &http.Cookie{Name: "session_token", Value: token}
+}
+
+// checkSessionLifecycle uses the session in cookie for userID, logs out
+// with it, and checks it no longer works, all while other goroutines do
+// the same
+func checkSessionLifecycle(t *testing.T, cookie *http.Cookie, userID int) {
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		whoAmI.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != fmt.Sprint(userID) {
+			t.Errorf("user %d: middleware answered %d %q", userID, rec.Code, rec.Body.String())
+			return
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	LogoutHandler(rec, req)
+	if c := sessionCookie(rec.Result()); c == nil || c.Value != "" {
+		t.Errorf("user %d: logout did not clear the cookie", userID)
+	}
+
+	req = httptest.NewRequest("GET", "/dashboard", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	whoAmI.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("user %d: session still works after logout (%d)", userID, rec.Code)
+	}
+}
+
+// TestConcurrentSessions logs many patients in, uses and ends their
+// sessions in parallel; run it with -race
+func TestConcurrentSessions(t *testing.T) {
+	store := useMemorySessions(t)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			cookie := newSession(t, userID)
+			if cookie == nil {
+				return
+			}
+			if s, err := store.Get(cookie.Value); err != nil || s.UserID != userID {
+				t.Errorf("user %d: stored session is %v, %v", userID, s, err)
+				return
+			}
+			checkSessionLifecycle(t, cookie, userID)
+		}(i)
+	}
+	wg.Wait()
+
+	if n, _ := store.DeleteExpired(time.Now().Add(2 * time.Hour)); n != 0 {
+		t.Errorf("%d sessions are left after everyone logged out", n)
+	}
+}
+
+// TestConcurrentLogins sends parallel logins for the same account through
+// LoginHandler; each gets its own session, and logging out of one leaves
+// the others working
+func TestConcurrentLogins(t *testing.T) {
+	db := useTestDB(t)
+	useMemorySessions(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{
+		Email:        fmt.Sprintf("login-race-%d@example.com", time.Now().UnixNano()),
+		PasswordHash: string(hash),
+		FirstName:    "Race",
+		LastName:     "Test",
+		UserType:     "patient",
+	}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, user.ID) })
+
+	const logins = 20
+	cookies := make(chan *http.Cookie, logins)
+	var wg sync.WaitGroup
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			form := url.Values{"email": {user.Email}, "password": {"correct horse"}}
+			req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			LoginHandler(rec, req)
+			if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/dashboard/patient" {
+				t.Errorf("login answered %d %q", rec.Code, rec.Body.String())
+				return
+			}
+			cookies <- sessionCookie(rec.Result())
+		}()
+	}
+	wg.Wait()
+	close(cookies)
+
+	seen := make(map[string]bool)
+	var all []*http.Cookie
+	for c := range cookies {
+		if seen[c.Value] {
+			t.Fatal("two logins got the same session token")
+		}
+		seen[c.Value] = true
+		all = append(all, c)
+	}
+
+	for _, c := range all {
+		wg.Add(1)
+		go func(c *http.Cookie) {
+			defer wg.Done()
+			checkSessionLifecycle(t, c, user.ID)
+		}(c)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// OwnsAppointment reports whether the appointment in the {id} route
+// variable belongs to the principal, as its patient or its doctor
+func OwnsAppointment(r *http.Request, p auth.Principal) (bool, error) {
+	appointmentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return false, auth.ErrResourceNotFound
+	}
+
+	appointment, err := models.GetAppointmentByID(database.DB, appointmentID)
+	if err == sql.ErrNoRows {
+		return false, auth.ErrResourceNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch p.Role {
+	case "patient":
+		return appointment.PatientID == p.UserID, nil
+	case "doctor":
+		return p.DoctorID != 0 && appointment.DoctorID == p.DoctorID, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"online-doctor-appointment/internal/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// TestOwnsAppointment puts OwnsAppointment behind RequirePermission the
+// way the routes do, and checks who gets through to an appointment
+func TestOwnsAppointment(t *testing.T) {
+	db := useTestDB(t)
+	doctor := createTestDoctor(t, db)
+	otherDoctor := createTestDoctor(t, db)
+	patient := createTestUser(t, db, "patient")
+	otherPatient := createTestUser(t, db, "patient")
+	apt := createTestAppointment(t, db, doctor, patient, "pending")
+	id := strconv.Itoa(apt.ID)
+
+	read := auth.RequirePermission(auth.PermAppointmentsReadOwn, OwnsAppointment)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		id        string
+		want      int
+	}{
+		{"its patient", auth.Principal{UserID: patient.ID, Role: "patient"}, id, http.StatusOK},
+		{"another patient", auth.Principal{UserID: otherPatient.ID, Role: "patient"}, id, http.StatusForbidden},
+		{"its doctor", auth.Principal{UserID: doctor.UserID, Role: "doctor", DoctorID: doctor.ID}, id, http.StatusOK},
+		{"another doctor", auth.Principal{UserID: otherDoctor.UserID, Role: "doctor", DoctorID: otherDoctor.ID}, id, http.StatusForbidden},
+		{"a doctor without a profile", auth.Principal{UserID: doctor.UserID, Role: "doctor"}, id, http.StatusForbidden},
+		{"an admin", auth.Principal{UserID: otherPatient.ID, Role: "admin"}, id, http.StatusOK},
+		{"a missing appointment", auth.Principal{UserID: patient.ID, Role: "patient"}, "0", http.StatusNotFound},
+		{"a malformed ID", auth.Principal{UserID: patient.ID, Role: "patient"}, "x", http.StatusNotFound},
+		{"an admin asking for a missing appointment", auth.Principal{UserID: patient.ID, Role: "admin"}, "0", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/appointments/"+tt.id, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+			req = req.WithContext(auth.WithPrincipal(req.Context(), tt.principal))
+			rec := httptest.NewRecorder()
+			read.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/schedule"
+
+	"github.com/gorilla/mux"
+)
+
+// weekdays are the days a working window can be set for, in display order
+var weekdays = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// availabilityPage is the data for the doctor's working hours page
+type availabilityPage struct {
+	Windows    []models.DoctorAvailability
+	Exceptions []models.ScheduleException
+	Weekdays   []time.Weekday
+	TimeZone   string // the zone the working hours are in
+	Today      string
+	Warnings   []string
+	Error      string
+}
+
+// availabilityInput is a working window as submitted by a form or JSON
+// request
+type availabilityInput struct {
+	DayOfWeek int    `json:"day_of_week"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	IsActive  *bool  `json:"is_active"` // defaults to true
+}
+
+// window validates the input and returns it as a working window of the
+// doctor. The error message is suitable for showing to the doctor.
+func (in availabilityInput) window(doctorID, windowID int) (*models.DoctorAvailability, error) {
+	if in.DayOfWeek < 0 || in.DayOfWeek > 6 {
+		return nil, errors.New("Please choose a day of the week.")
+	}
+
+	start, err := time.Parse("15:04", in.StartTime)
+	if err != nil {
+		return nil, errors.New("Start time must be in HH:MM format.")
+	}
+	end, err := time.Parse("15:04", in.EndTime)
+	if err != nil {
+		return nil, errors.New("End time must be in HH:MM format.")
+	}
+	if !start.Before(end) {
+		return nil, errors.New("Start time must be before end time.")
+	}
+
+	active := in.IsActive == nil || *in.IsActive
+	return &models.DoctorAvailability{
+		ID:        windowID,
+		DoctorID:  doctorID,
+		DayOfWeek: in.DayOfWeek,
+		StartTime: start.Format("15:04"),
+		EndTime:   end.Format("15:04"),
+		IsActive:  active,
+	}, nil
+}
+
+// formAvailabilityInput reads a working window from a form submission. The
+// edit form sends an active_field marker so an unticked is_active checkbox
+// deactivates the window; forms without it create active windows.
+func formAvailabilityInput(r *http.Request) availabilityInput {
+	day, err := strconv.Atoi(r.FormValue("day_of_week"))
+	if err != nil {
+		day = -1
+	}
+
+	in := availabilityInput{
+		DayOfWeek: day,
+		StartTime: r.FormValue("start_time"),
+		EndTime:   r.FormValue("end_time"),
+	}
+	if r.Form.Has("active_field") {
+		active := r.FormValue("is_active") == "on"
+		in.IsActive = &active
+	}
+	return in
+}
+
+// availabilityError returns the status code and message for a failed
+// change to a working window
+func availabilityError(err error) (int, string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, "Availability window not found"
+	case errors.Is(err, models.ErrAvailabilityOverlap):
+		return http.StatusConflict, "This window overlaps another of your working hours on the same day."
+	default:
+		log.Printf("Error saving availability: %v", err)
+		return http.StatusInternalServerError, "Failed to save availability"
+	}
+}
+
+// conflictWarnings describes confirmed appointments flagged by a schedule
+// change
+func conflictWarnings(flagged []models.Appointment) []string {
+	warnings := make([]string, 0, len(flagged))
+	for _, a := range flagged {
+		warnings = append(warnings, fmt.Sprintf(
+			"Your confirmed appointment with %s on %s at %s (%s) conflicts with your schedule (%s). Please reschedule or cancel it.",
+			a.Patient.GetFullName(), a.AppointmentDate, a.AppointmentTime, a.TimeZone, a.ConflictNote))
+	}
+	return warnings
+}
+
+// renderAvailability shows the doctor's working hours page with any
+// warnings or error from the last change
+func renderAvailability(w http.ResponseWriter, r *http.Request, status int, warnings []string, errMsg string) {
+	principal, _ := auth.FromContext(r.Context())
+
+	windows, err := models.GetAvailability(database.DB, principal.DoctorID)
+	if err != nil {
+		log.Printf("Error loading availability: %v", err)
+		http.Error(w, "Error loading availability", http.StatusInternalServerError)
+		return
+	}
+
+	exceptions, err := models.GetScheduleExceptions(database.DB, principal.DoctorID)
+	if err != nil {
+		log.Printf("Error loading schedule exceptions: %v", err)
+		http.Error(w, "Error loading availability", http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := models.GetScheduleSettings(database.DB, principal.DoctorID)
+	if err != nil {
+		log.Printf("Error loading schedule settings: %v", err)
+		http.Error(w, "Error loading availability", http.StatusInternalServerError)
+		return
+	}
+	loc := settings.Location()
+
+	renderStatus(w, r, status, "doctor-availability.html", availabilityPage{
+		Windows:    windows,
+		Exceptions: exceptions,
+		Weekdays:   weekdays,
+		TimeZone:   loc.String(),
+		Today:      time.Now().In(loc).Format("2006-01-02"),
+		Warnings:   warnings,
+		Error:      errMsg,
+	})
+}
+
+// afterAvailabilityChange shows warnings about flagged appointments, or
+// returns to the working hours page when there are none
+func afterAvailabilityChange(w http.ResponseWriter, r *http.Request, orphans []models.Appointment) {
+	if len(orphans) > 0 {
+		renderAvailability(w, r, http.StatusOK, conflictWarnings(orphans), "")
+		return
+	}
+	http.Redirect(w, r, "/dashboard/doctor/availability", http.StatusSeeOther)
+}
+
+// DoctorAvailabilityHandler shows the doctor's weekly working hours
+func DoctorAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	renderAvailability(w, r, http.StatusOK, nil, "")
+}
+
+// CreateAvailabilityHandler adds a working window from the form
+func CreateAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	window, err := formAvailabilityInput(r).window(principal.DoctorID, 0)
+	if err != nil {
+		renderAvailability(w, r, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	orphans, err := models.CreateAvailability(database.DB, window)
+	if err != nil {
+		status, msg := availabilityError(err)
+		renderAvailability(w, r, status, nil, msg)
+		return
+	}
+
+	afterAvailabilityChange(w, r, orphans)
+}
+
+// UpdateAvailabilityHandler changes a working window from the form
+func UpdateAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	windowID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid availability ID", http.StatusBadRequest)
+		return
+	}
+
+	window, err := formAvailabilityInput(r).window(principal.DoctorID, windowID)
+	if err != nil {
+		renderAvailability(w, r, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	orphans, err := models.UpdateAvailability(database.DB, window)
+	if err != nil {
+		status, msg := availabilityError(err)
+		renderAvailability(w, r, status, nil, msg)
+		return
+	}
+
+	afterAvailabilityChange(w, r, orphans)
+}
+
+// DeactivateAvailabilityHandler switches off a working window
+func DeactivateAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	windowID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid availability ID", http.StatusBadRequest)
+		return
+	}
+
+	orphans, err := models.DeactivateAvailability(database.DB, principal.DoctorID, windowID)
+	if err != nil {
+		status, msg := availabilityError(err)
+		renderAvailability(w, r, status, nil, msg)
+		return
+	}
+
+	afterAvailabilityChange(w, r, orphans)
+}
+
+// UpdateTimeZoneHandler changes the time zone the doctor's working hours
+// are in
+func UpdateTimeZoneHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	timeZone := strings.TrimSpace(r.FormValue("time_zone"))
+	if _, err := schedule.Location(timeZone); err != nil || timeZone == "" || timeZone == "Local" {
+		renderAvailability(w, r, http.StatusBadRequest, nil, "Please enter an IANA time zone such as Asia/Almaty.")
+		return
+	}
+
+	orphans, err := models.UpdateDoctorTimeZone(database.DB, principal.DoctorID, timeZone)
+	if err != nil {
+		status, msg := availabilityError(err)
+		renderAvailability(w, r, status, nil, msg)
+		return
+	}
+
+	afterAvailabilityChange(w, r, orphans)
+}
+
+// GetAvailabilityAPIHandler returns the doctor's working windows as JSON
+func GetAvailabilityAPIHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	windows, err := models.GetAvailability(database.DB, principal.DoctorID)
+	if err != nil {
+		log.Printf("Error loading availability: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Error loading availability"})
+		return
+	}
+	if windows == nil {
+		windows = []models.DoctorAvailability{}
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"windows": windows})
+}
+
+// CreateAvailabilityAPIHandler adds a working window from a JSON body
+func CreateAvailabilityAPIHandler(w http.ResponseWriter, r *http.Request) {
+	saveAvailabilityJSON(w, r, 0)
+}
+
+// UpdateAvailabilityAPIHandler replaces a working window with a JSON body
+func UpdateAvailabilityAPIHandler(w http.ResponseWriter, r *http.Request) {
+	windowID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid availability ID"})
+		return
+	}
+
+	saveAvailabilityJSON(w, r, windowID)
+}
+
+// saveAvailabilityJSON creates the window in the request body, or updates
+// it when windowID is set, and reports flagged appointments as warnings
+func saveAvailabilityJSON(w http.ResponseWriter, r *http.Request, windowID int) {
+	principal, _ := auth.FromContext(r.Context())
+
+	var in availabilityInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		return
+	}
+
+	window, err := in.window(principal.DoctorID, windowID)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	var orphans []models.Appointment
+	if windowID == 0 {
+		status = http.StatusCreated
+		orphans, err = models.CreateAvailability(database.DB, window)
+	} else {
+		orphans, err = models.UpdateAvailability(database.DB, window)
+	}
+	if err != nil {
+		code, msg := availabilityError(err)
+		respondWithJSON(w, code, map[string]string{"error": msg})
+		return
+	}
+
+	respondWithJSON(w, status, map[string]interface{}{
+		"window":   window,
+		"warnings": conflictWarnings(orphans),
+	})
+}
+
+// DeactivateAvailabilityAPIHandler switches off a working window
+func DeactivateAvailabilityAPIHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	windowID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid availability ID"})
+		return
+	}
+
+	orphans, err := models.DeactivateAvailability(database.DB, principal.DoctorID, windowID)
+	if err != nil {
+		code, msg := availabilityError(err)
+		respondWithJSON(w, code, map[string]string{"error": msg})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"warnings": conflictWarnings(orphans),
+	})
+}
+
+// scheduleExceptionFromForm validates a time off or extra hours form. The
+// error message is suitable for showing to the doctor.
+func scheduleExceptionFromForm(r *http.Request, doctorID int) (*models.ScheduleException, error) {
+	e := &models.ScheduleException{
+		DoctorID:  doctorID,
+		Kind:      r.FormValue("kind"),
+		StartDate: r.FormValue("start_date"),
+		EndDate:   r.FormValue("end_date"),
+		Reason:    strings.TrimSpace(r.FormValue("reason")),
+	}
+	if e.Kind != models.ExceptionBlock && e.Kind != models.ExceptionExtra {
+		return nil, errors.New("Please choose time off or extra hours.")
+	}
+
+	start, err := time.Parse("2006-01-02", e.StartDate)
+	if err != nil {
+		return nil, errors.New("Please choose a start date.")
+	}
+	if e.EndDate == "" {
+		e.EndDate = e.StartDate
+	}
+	end, err := time.Parse("2006-01-02", e.EndDate)
+	if err != nil || end.Before(start) {
+		return nil, errors.New("End date must be on or after the start date.")
+	}
+
+	// Time off without hours covers whole days; extra hours always need them
+	if e.Kind == models.ExceptionBlock && r.FormValue("start_time") == "" && r.FormValue("end_time") == "" {
+		return e, nil
+	}
+	from, err := time.Parse("15:04", r.FormValue("start_time"))
+	if err != nil {
+		return nil, errors.New("Start time must be in HH:MM format.")
+	}
+	to, err := time.Parse("15:04", r.FormValue("end_time"))
+	if err != nil {
+		return nil, errors.New("End time must be in HH:MM format.")
+	}
+	if !from.Before(to) {
+		return nil, errors.New("Start time must be before end time.")
+	}
+	e.StartTime, e.EndTime = from.Format("15:04"), to.Format("15:04")
+
+	return e, nil
+}
+
+// CreateScheduleExceptionHandler adds time off or extra working hours and
+// warns about confirmed appointments inside the time off
+func CreateScheduleExceptionHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	exception, err := scheduleExceptionFromForm(r, principal.DoctorID)
+	if err != nil {
+		renderAvailability(w, r, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	flagged, err := models.CreateScheduleException(database.DB, exception)
+	if err != nil {
+		status, msg := availabilityError(err)
+		renderAvailability(w, r, status, nil, msg)
+		return
+	}
+
+	afterAvailabilityChange(w, r, flagged)
+}
+
+// DeleteScheduleExceptionHandler removes time off or extra working hours and
+// warns about confirmed appointments left outside working hours
+func DeleteScheduleExceptionHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	exceptionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid exception ID", http.StatusBadRequest)
+		return
+	}
+
+	flagged, err := models.DeleteScheduleException(database.DB, principal.DoctorID, exceptionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Schedule exception not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting schedule exception %d: %v", exceptionID, err)
+		http.Error(w, "Failed to delete schedule exception", http.StatusInternalServerError)
+		return
+	}
+
+	afterAvailabilityChange(w, r, flagged)
+}
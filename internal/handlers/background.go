@@ -0,0 +1,24 @@
+package handlers
+
+import "time"
+
+// runEvery calls job with the current time every interval, in the
+// background, until the returned stop function is called
+func runEvery(interval time.Duration, job func(now time.Time)) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				job(now)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
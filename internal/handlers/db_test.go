@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/models"
+)
+
+var testUsers atomic.Int64
+
+// createTestUser inserts a user that is deleted, along with everything
+// that cascades from it, when the test ends
+func createTestUser(t *testing.T, db *sql.DB, userType string) *models.User {
+	t.Helper()
+	user := &models.User{
+		Email:        fmt.Sprintf("handlers-%d-%d@example.com", time.Now().UnixNano(), testUsers.Add(1)),
+		PasswordHash: "not a hash",
+		FirstName:    "Test",
+		LastName:     userType,
+		UserType:     userType,
+	}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, user.ID) })
+	return user
+}
+
+// createTestDoctor inserts an approved doctor in Asia/Almaty with one hour
+// slots
+func createTestDoctor(t *testing.T, db *sql.DB) *models.Doctor {
+	t.Helper()
+	user := createTestUser(t, db, "doctor")
+	doctor := &models.Doctor{UserID: user.ID, Specialty: "Testing", TimeZone: "Asia/Almaty", User: user}
+	if err := models.CreateDoctor(db, doctor); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`
+		UPDATE doctors SET slot_minutes = 60, buffer_minutes = 0, verification_status = 'approved'
+		WHERE id = $1
+	`, doctor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doctor.VerificationStatus = models.DoctorApproved
+	return doctor
+}
+
+// createTestAppointment books an appointment with doctor for patient a
+// month from now
+func createTestAppointment(t *testing.T, db *sql.DB, doctor *models.Doctor, patient *models.User, status string) *models.Appointment {
+	t.Helper()
+	a := &models.Appointment{
+		PatientID: patient.ID,
+		DoctorID:  doctor.ID,
+		StartsAt:  time.Now().UTC().Truncate(time.Hour).AddDate(0, 1, 0),
+		Status:    status,
+	}
+	if err := models.CreateAppointment(db, a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// addWorkingHours gives doctor a weekly window on weekday
+func addWorkingHours(t *testing.T, db *sql.DB, doctor *models.Doctor, weekday time.Weekday, start, end string) {
+	t.Helper()
+	_, err := models.CreateAvailability(db, &models.DoctorAvailability{
+		DoctorID: doctor.ID, DayOfWeek: int(weekday), StartTime: start, EndTime: end, IsActive: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextWeek returns clock on the date a week from now in doctor's zone
+func nextWeek(t *testing.T, doctor *models.Doctor, clock string) time.Time {
+	t.Helper()
+	day := time.Now().In(doctor.Location()).AddDate(0, 0, 7)
+	at, err := time.ParseInLocation("2006-01-02 15:04", day.Format("2006-01-02")+" "+clock, doctor.Location())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// slotHoldTTL is how long a patient can hold a slot before booking it
+var slotHoldTTL = 10 * time.Minute
+
+// SetSlotHoldTTL sets how long slot holds last
+func SetSlotHoldTTL(ttl time.Duration) {
+	slotHoldTTL = ttl
+}
+
+// StartSlotHoldSweeper periodically deletes expired slot holds until the
+// returned stop function is called. Expired holds never block a slot, so
+// this only keeps the table small.
+func StartSlotHoldSweeper(interval time.Duration) (stop func()) {
+	return runEvery(interval, func(now time.Time) {
+		n, err := models.DeleteExpiredSlotHolds(database.DB, now)
+		if err != nil {
+			log.Printf("Error sweeping expired slot holds: %v", err)
+		} else if n > 0 {
+			log.Printf("Removed %d expired slot holds", n)
+		}
+	})
+}
+
+// HoldSlotHandler reserves a free slot for the patient while they finish
+// booking, replacing any slot they held before
+func HoldSlotHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	doctorID, _ := strconv.Atoi(r.FormValue("doctor_id"))
+	doctor, err := models.GetDoctorByID(database.DB, doctorID)
+	if err != nil || !doctor.IsBookable() {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "This doctor is not available for booking."})
+		return
+	}
+
+	startsAt, ok := offeredSlot(doctor, r.FormValue("starts_at"), principal.UserID)
+	if !ok {
+		respondWithJSON(w, http.StatusConflict, map[string]string{"error": "That time is not available. Please choose another slot."})
+		return
+	}
+
+	hold := &models.SlotHold{DoctorID: doctorID, PatientID: principal.UserID, StartsAt: startsAt}
+	err = models.HoldSlot(database.DB, hold, slotHoldTTL)
+	if errors.Is(err, models.ErrSlotUnavailable) {
+		respondWithJSON(w, http.StatusConflict, map[string]string{"error": "That time slot has just been taken. Please choose another."})
+		return
+	}
+	if err != nil {
+		log.Printf("Error holding slot: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to hold the slot"})
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, hold)
+}
+
+// ReleaseSlotHoldHandler gives up the patient's hold on a slot
+func ReleaseSlotHoldHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	holdID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid hold ID"})
+		return
+	}
+
+	err = models.ReleaseSlotHold(database.DB, holdID, principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Hold not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error releasing slot hold %d: %v", holdID, err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to release the slot"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/ical"
+	"online-doctor-appointment/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// maxCalendarSize limits uploaded iCalendar files
+const maxCalendarSize = 1 << 20
+
+// holidaysPage is the data for the clinic holiday calendar page
+type holidaysPage struct {
+	Holidays []models.ClinicHoliday
+	Flagged  []models.Appointment
+	Notice   string
+	Error    string
+}
+
+// renderHolidays shows the holiday calendar with the result of the last
+// change
+func renderHolidays(w http.ResponseWriter, r *http.Request, status int, page holidaysPage) {
+	holidays, err := models.GetClinicHolidays(database.DB)
+	if err != nil {
+		log.Printf("Error loading holidays: %v", err)
+		http.Error(w, "Error loading holidays", http.StatusInternalServerError)
+		return
+	}
+
+	page.Holidays = holidays
+	renderStatus(w, r, status, "admin-holidays.html", page)
+}
+
+// saveHolidays stores holidays and reports the confirmed appointments that
+// fall on them
+func saveHolidays(w http.ResponseWriter, r *http.Request, holidays []models.ClinicHoliday) {
+	flagged, err := models.SaveClinicHolidays(database.DB, holidays)
+	if err != nil {
+		log.Printf("Error saving holidays: %v", err)
+		renderHolidays(w, r, http.StatusInternalServerError, holidaysPage{Error: "Failed to save holidays"})
+		return
+	}
+
+	notice := fmt.Sprintf("Saved %d holiday(s).", len(holidays))
+	if len(flagged) > 0 {
+		notice += fmt.Sprintf(" %d confirmed appointment(s) fall on a holiday and have been flagged for their doctors.", len(flagged))
+	}
+	renderHolidays(w, r, http.StatusOK, holidaysPage{Flagged: flagged, Notice: notice})
+}
+
+// AdminHolidaysHandler shows the clinic holiday calendar
+func AdminHolidaysHandler(w http.ResponseWriter, r *http.Request) {
+	renderHolidays(w, r, http.StatusOK, holidaysPage{})
+}
+
+// AdminCreateHolidayHandler adds a holiday entered by hand
+func AdminCreateHolidayHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	startDate := r.FormValue("start_date")
+	endDate := r.FormValue("end_date")
+	if endDate == "" {
+		endDate = startDate
+	}
+
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil || name == "" {
+		renderHolidays(w, r, http.StatusBadRequest, holidaysPage{Error: "Please enter a name and a date."})
+		return
+	}
+	end, err := time.Parse("2006-01-02", endDate)
+	if err != nil || end.Before(start) {
+		renderHolidays(w, r, http.StatusBadRequest, holidaysPage{Error: "End date must be on or after the start date."})
+		return
+	}
+
+	saveHolidays(w, r, []models.ClinicHoliday{{Name: name, StartDate: startDate, EndDate: endDate}})
+}
+
+// AdminImportHolidaysHandler adds every event of an uploaded iCalendar file
+// as a holiday. Events already imported are updated by their UID.
+func AdminImportHolidaysHandler(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("calendar")
+	if err != nil || header.Size > maxCalendarSize {
+		renderHolidays(w, r, http.StatusBadRequest, holidaysPage{Error: "Please choose an iCalendar (.ics) file of at most 1 MB."})
+		return
+	}
+	defer file.Close()
+
+	events, err := ical.Parse(file)
+	if err != nil {
+		log.Printf("Error parsing holiday calendar: %v", err)
+		renderHolidays(w, r, http.StatusBadRequest, holidaysPage{Error: "This file is not a valid iCalendar file."})
+		return
+	}
+
+	holidays := make([]models.ClinicHoliday, 0, len(events))
+	for _, e := range events {
+		days := e.Days()
+		name := strings.TrimSpace(e.Summary)
+		if name == "" {
+			name = "Holiday"
+		}
+		holidays = append(holidays, models.ClinicHoliday{
+			Name:      name,
+			StartDate: days[0].Format("2006-01-02"),
+			EndDate:   days[len(days)-1].Format("2006-01-02"),
+			UID:       e.UID,
+		})
+	}
+	if len(holidays) == 0 {
+		renderHolidays(w, r, http.StatusBadRequest, holidaysPage{Error: "The file contains no events."})
+		return
+	}
+
+	saveHolidays(w, r, holidays)
+}
+
+// AdminDeleteHolidayHandler removes a holiday
+func AdminDeleteHolidayHandler(w http.ResponseWriter, r *http.Request) {
+	holidayID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid holiday ID", http.StatusBadRequest)
+		return
+	}
+
+	err = models.DeleteClinicHoliday(database.DB, holidayID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Holiday not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting holiday %d: %v", holidayID, err)
+		http.Error(w, "Failed to delete holiday", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard/admin/holidays", http.StatusSeeOther)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/pdf"
+
+	"github.com/gorilla/mux"
+)
+
+// invoiceSettings are the clinic details and tax printed on invoices
+var invoiceSettings = models.InvoiceSettings{Prefix: "INV", SellerName: "Online Doctor Appointment", TaxName: "VAT"}
+
+// SetInvoiceSettings sets the clinic details and tax for new invoices
+func SetInvoiceSettings(settings models.InvoiceSettings) {
+	invoiceSettings = settings
+}
+
+// issueInvoice issues the invoice for a payment that just went through
+func issueInvoice(paymentID int) {
+	inv, err := models.IssueInvoice(database.DB, paymentID, invoiceSettings, time.Now().In(clinicLocation))
+	if err != nil {
+		log.Printf("Error issuing invoice for payment %d: %v", paymentID, err)
+		return
+	}
+	log.Printf("Issued invoice %s for payment %d", inv.Number, paymentID)
+}
+
+// AppointmentReceiptHandler sends the PDF receipt for a paid appointment,
+// issuing its invoice first if the payment predates invoicing
+func AppointmentReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	appointmentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := models.GetSettledPayment(database.DB, appointmentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "This appointment hasn't been paid online, so there is no receipt for it.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading payment of appointment %d: %v", appointmentID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	inv, err := models.IssueInvoice(database.DB, payment.ID, invoiceSettings, time.Now().In(clinicLocation))
+	if err != nil {
+		log.Printf("Error issuing invoice for payment %d: %v", payment.ID, err)
+		http.Error(w, "Failed to create the receipt", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="receipt-`+inv.Number+`.pdf"`)
+	if err := receiptPDF(inv, viewerLocale(r)).Write(w); err != nil {
+		log.Printf("Error writing receipt %s: %v", inv.Number, err)
+	}
+}
+
+// Receipt layout, in points
+const (
+	receiptMargin = 50
+	receiptRight  = pdf.PageWidth - receiptMargin
+	receiptQtyX   = 370 // right edges of the number columns
+	receiptPriceX = 450
+)
+
+// receiptPDF lays out an invoice as a one page receipt, with amounts
+// written for locale
+func receiptPDF(inv *models.Invoice, locale string) *pdf.Document {
+	doc := &pdf.Document{Title: "Receipt " + inv.Number}
+	page := doc.AddPage()
+	y := pdf.PageHeight - 70.0
+
+	page.Text(receiptMargin, y, pdf.Bold, 22, "Receipt")
+	page.TextRight(receiptRight, y+8, pdf.Bold, 11, "Invoice No. "+inv.Number)
+	page.TextRight(receiptRight, y-8, pdf.Regular, 10, "Issued "+inv.IssuedAt.In(clinicLocation).Format("2 January 2006"))
+	y -= 45
+
+	// Seller and buyer side by side
+	seller := []string{inv.SellerAddress}
+	if inv.SellerTaxID != "" {
+		seller = append(seller, "Tax ID: "+inv.SellerTaxID)
+	}
+	page.Text(receiptMargin, y, pdf.Bold, 11, inv.SellerName)
+	page.Text(320, y, pdf.Bold, 11, "Billed to")
+	lines := 0
+	for _, field := range seller {
+		for _, line := range strings.Split(field, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines++
+				page.Text(receiptMargin, y-14*float64(lines), pdf.Regular, 10, line)
+			}
+		}
+	}
+	page.Text(320, y-14, pdf.Regular, 10, inv.BuyerName)
+	page.Text(320, y-28, pdf.Regular, 10, inv.BuyerEmail)
+	y -= 14*float64(max(lines, 2)) + 40
+
+	// Line items
+	page.Box(receiptMargin-5, y-6, receiptRight-receiptMargin+10, 20, 0.92)
+	page.Text(receiptMargin, y, pdf.Bold, 10, "Description")
+	page.TextRight(receiptQtyX, y, pdf.Bold, 10, "Qty")
+	page.TextRight(receiptPriceX, y, pdf.Bold, 10, "Unit price")
+	page.TextRight(receiptRight, y, pdf.Bold, 10, "Amount")
+	y -= 24
+	for _, item := range inv.Items {
+		page.TextRight(receiptQtyX, y, pdf.Regular, 10, strconv.Itoa(item.Quantity))
+		page.TextRight(receiptPriceX, y, pdf.Regular, 10, item.UnitPrice.FormatCode(locale))
+		page.TextRight(receiptRight, y, pdf.Regular, 10, item.Amount.FormatCode(locale))
+		for _, line := range wrapText(item.Description, pdf.Regular, 10, receiptQtyX-receiptMargin-40) {
+			page.Text(receiptMargin, y, pdf.Regular, 10, line)
+			y -= 14
+		}
+		y -= 6
+	}
+	page.Line(receiptMargin, y+8, receiptRight, y+8, 0.5)
+	y -= 10
+
+	// Totals
+	totals := [][2]string{{"Subtotal", inv.Subtotal.FormatCode(locale)}}
+	if inv.TaxRate > 0 {
+		label := fmt.Sprintf("%s %s%%", inv.TaxName, strconv.FormatFloat(inv.TaxRate, 'f', -1, 64))
+		totals = append(totals, [2]string{label, inv.TaxAmount.FormatCode(locale)})
+	}
+	for _, row := range totals {
+		page.TextRight(receiptPriceX, y, pdf.Regular, 10, row[0])
+		page.TextRight(receiptRight, y, pdf.Regular, 10, row[1])
+		y -= 16
+	}
+	page.TextRight(receiptPriceX, y, pdf.Bold, 11, "Total")
+	page.TextRight(receiptRight, y, pdf.Bold, 11, inv.Total.FormatCode(locale))
+	y -= 40
+
+	// Payment
+	paid := "Paid online"
+	if inv.Gateway != "" {
+		paid += " with " + strings.ToUpper(inv.Gateway[:1]) + inv.Gateway[1:]
+	}
+	if inv.PaidAt != nil {
+		paid += " on " + inv.PaidAt.In(clinicLocation).Format("2 January 2006 15:04 MST")
+	}
+	page.Text(receiptMargin, y, pdf.Regular, 10, paid+".")
+	page.Text(receiptMargin, y-14, pdf.Regular, 8, "Payment reference: "+inv.PaymentReference)
+	if inv.TaxRate > 0 {
+		page.Text(receiptMargin, y-28, pdf.Regular, 8, "Prices include "+inv.TaxName+".")
+	}
+
+	page.Line(receiptMargin, 60, receiptRight, 60, 0.5)
+	page.Text(receiptMargin, 45, pdf.Regular, 8, "Thank you for choosing "+inv.SellerName+".")
+	return doc
+}
+
+// wrapText breaks s into lines no wider than width
+func wrapText(s string, font pdf.Font, size, width float64) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		next := word
+		if line != "" {
+			next = line + " " + word
+		}
+		if line != "" && pdf.TextWidth(next, font, size) > width {
+			lines = append(lines, line)
+			next = word
+		}
+		line = next
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"online-doctor-appointment/internal/money"
+)
+
+// clinicCurrency is the ISO 4217 currency new doctors' fees are set in
+var clinicCurrency = "KZT"
+
+// clinicLocale formats amounts for viewers whose browser doesn't ask for a
+// supported language
+var clinicLocale = "en-KZ"
+
+// SetClinicMoney sets the clinic's currency and default locale
+func SetClinicMoney(currency, locale string) {
+	clinicCurrency = currency
+	clinicLocale = locale
+}
+
+// viewerLocale returns the locale to format amounts in: the first language
+// in the browser's Accept-Language header that amounts can be formatted
+// in, then the clinic's locale
+func viewerLocale(r *http.Request) string {
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ = strings.Cut(tag, ";")
+		if tag = strings.TrimSpace(tag); tag != "" && tag != "*" && money.SupportsLocale(tag) {
+			return tag
+		}
+	}
+	return clinicLocale
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/ratelimit"
+
+	"github.com/gorilla/mux"
+)
+
+// LoginProtection configures brute-force protection for the login form
+type LoginProtection struct {
+	FreeAttempts     int           // failures per account before backoff starts
+	BackoffBase      time.Duration // delay after the first failure past the free ones
+	BackoffMax       time.Duration
+	LockoutThreshold int // consecutive failures that lock the account; 0 disables lockout
+	LockoutDuration  time.Duration
+	IPFreeAttempts   int // failures per IP within IPWindow before backoff starts
+	IPWindow         time.Duration
+	TrustProxy       bool // take the client IP from X-Forwarded-For set by a reverse proxy
+}
+
+// loginProtection is the active brute-force protection policy
+var loginProtection = LoginProtection{
+	FreeAttempts:     3,
+	BackoffBase:      time.Second,
+	BackoffMax:       5 * time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  30 * time.Minute,
+	IPFreeAttempts:   20,
+	IPWindow:         time.Hour,
+}
+
+// loginClock tells the time for brute-force protection; tests replace it
+var loginClock = time.Now
+
+// ConfigureLoginProtection sets the brute-force protection policy. It must
+// be called before the server starts handling requests.
+func ConfigureLoginProtection(p LoginProtection) {
+	loginProtection = p
+}
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) string {
+	if loginProtection.TrustProxy {
+		// The proxy appends the address it saw; anything before it is
+		// client-supplied and can't be trusted
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ipRetryAfter returns how long ip must wait before its next login attempt
+func ipRetryAfter(ip string, now time.Time) (time.Duration, error) {
+	p := loginProtection
+
+	failures, last, err := models.CountFailedLoginsByIP(database.DB, ip, now.Add(-p.IPWindow))
+	if err != nil || last == nil {
+		return 0, err
+	}
+
+	next := last.Add(ratelimit.Backoff(failures, p.IPFreeAttempts, p.BackoffBase, p.BackoffMax))
+	return max(next.Sub(now), 0), nil
+}
+
+// accountRetryAfter returns how long an account in state must wait before
+// its next login attempt
+func accountRetryAfter(state *models.LoginState, now time.Time) time.Duration {
+	p := loginProtection
+
+	if state.IsLocked(now) {
+		return state.LockedUntil.Sub(now)
+	}
+	if state.LastFailedLoginAt == nil {
+		return 0
+	}
+
+	next := state.LastFailedLoginAt.Add(ratelimit.Backoff(state.FailedLogins, p.FreeAttempts, p.BackoffBase, p.BackoffMax))
+	return max(next.Sub(now), 0)
+}
+
+// recordLoginFailure stores a failed login made at now and locks the
+// account once it reaches the lockout threshold
+func recordLoginFailure(email, ip string, userID int, reason string, now time.Time) {
+	failures, err := models.RecordFailedLogin(database.DB, email, ip, userID, reason, now)
+	if err != nil {
+		log.Printf("Error recording failed login: %v", err)
+		return
+	}
+
+	threshold := loginProtection.LockoutThreshold
+	if userID == 0 || threshold <= 0 || failures < threshold {
+		return
+	}
+
+	until := now.Add(loginProtection.LockoutDuration)
+	if err := models.LockAccount(database.DB, userID, until); err != nil {
+		log.Printf("Error locking account %d: %v", userID, err)
+		return
+	}
+	log.Printf("Locked account %d until %s after %d failed logins", userID, until.Format(time.RFC3339), failures)
+}
+
+// tooManyLoginAttempts rejects a login attempt made before the backoff
+// delay has passed
+func tooManyLoginAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed login attempts. Please try again later.", http.StatusTooManyRequests)
+}
+
+// AdminLoginSecurityHandler shows locked accounts and recent failed logins,
+// optionally filtered by email or IP
+func AdminLoginSecurityHandler(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	ip := strings.TrimSpace(r.URL.Query().Get("ip"))
+
+	locked, err := models.GetLockedAccounts(database.DB)
+	if err != nil {
+		log.Printf("Error loading locked accounts: %v", err)
+		http.Error(w, "Error loading locked accounts", http.StatusInternalServerError)
+		return
+	}
+
+	attempts, err := models.GetFailedLoginAttempts(database.DB, email, ip, 100)
+	if err != nil {
+		log.Printf("Error loading failed logins: %v", err)
+		http.Error(w, "Error loading failed logins", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, r, "admin-security.html", struct {
+		Locked   []models.LockedAccount
+		Attempts []models.LoginAttempt
+		Email    string
+		IP       string
+	}{locked, attempts, email, ip})
+}
+
+// AdminUnlockUserHandler lifts a lockout and clears the account's failures
+func AdminUnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.ResetFailedLogins(database.DB, userID); err != nil {
+		log.Printf("Error unlocking account %d: %v", userID, err)
+		http.Error(w, "Failed to unlock account", http.StatusInternalServerError)
+		return
+	}
+
+	principal, _ := auth.FromContext(r.Context())
+	log.Printf("Account %d unlocked by %s", userID, principal.Email)
+
+	http.Redirect(w, r, "/dashboard/admin/security", http.StatusSeeOther)
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testProtection frees two failures, then backs off from a second and
+// locks on the fifth
+var testProtection = LoginProtection{
+	FreeAttempts:     2,
+	BackoffBase:      time.Second,
+	BackoffMax:       time.Minute,
+	LockoutThreshold: 5,
+	LockoutDuration:  10 * time.Minute,
+	IPFreeAttempts:   2,
+	IPWindow:         time.Hour,
+}
+
+// useLoginClock sets the login protection policy and stops its clock at
+// the returned time, which the test may move
+func useLoginClock(t *testing.T, p LoginProtection) *time.Time {
+	now := time.Now().Truncate(time.Second)
+	oldProtection, oldClock := loginProtection, loginClock
+	ConfigureLoginProtection(p)
+	loginClock = func() time.Time { return now }
+	t.Cleanup(func() { loginProtection, loginClock = oldProtection, oldClock })
+	return &now
+}
+
+func TestAccountRetryAfter(t *testing.T) {
+	useLoginClock(t, testProtection)
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time {
+		at := now.Add(-d)
+		return &at
+	}
+
+	tests := []struct {
+		name  string
+		state models.LoginState
+		want  time.Duration
+	}{
+		{"no failures", models.LoginState{}, 0},
+		{"free failures", models.LoginState{FailedLogins: 2, LastFailedLoginAt: ago(0)}, 0},
+		{"first backoff", models.LoginState{FailedLogins: 3, LastFailedLoginAt: ago(0)}, time.Second},
+		{"backoff partly served", models.LoginState{FailedLogins: 5, LastFailedLoginAt: ago(time.Second)}, 3 * time.Second},
+		{"backoff served", models.LoginState{FailedLogins: 5, LastFailedLoginAt: ago(4 * time.Second)}, 0},
+		{"backoff capped", models.LoginState{FailedLogins: 50, LastFailedLoginAt: ago(0)}, time.Minute},
+		{"locked", models.LoginState{LastFailedLoginAt: ago(time.Minute), LockedUntil: ago(-9 * time.Minute)}, 9 * time.Minute},
+		{"lock ends", models.LoginState{LastFailedLoginAt: ago(10 * time.Minute), LockedUntil: ago(0)}, 0},
+	}
+	for _, tt := range tests {
+		if got := accountRetryAfter(&tt.state, now); got != tt.want {
+			t.Errorf("%s: accountRetryAfter = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for _, tt := range []struct {
+		trustProxy bool
+		forwarded  string
+		want       string
+	}{
+		{false, "", "192.0.2.1"},
+		{false, "203.0.113.9", "192.0.2.1"},
+		{true, "", "192.0.2.1"},
+		{true, "203.0.113.9", "203.0.113.9"},
+		{true, "10.0.0.1, 203.0.113.9", "203.0.113.9"}, // the client may forge all but the last
+	} {
+		p := testProtection
+		p.TrustProxy = tt.trustProxy
+		useLoginClock(t, p)
+
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = "192.0.2.1:51234"
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := clientIP(req); got != tt.want {
+			t.Errorf("clientIP with proxy %v and %q = %s, want %s", tt.trustProxy, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+// loginAttempt posts a login from ip and returns the status and Retry-After
+func loginAttempt(email, password, ip string) (int, string) {
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{
+		"email": {email}, "password": {password},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = ip + ":51234"
+	rec := httptest.NewRecorder()
+	LoginHandler(rec, req)
+	return rec.Code, rec.Header().Get("Retry-After")
+}
+
+// testIP returns an address no earlier test has failed logins from
+func testIP() string {
+	return fmt.Sprintf("test-%d", time.Now().UnixNano())
+}
+
+// createLoginUser inserts a patient whose password is "correct horse"
+func createLoginUser(t *testing.T) *models.User {
+	t.Helper()
+	user := createTestUser(t, database.DB, "patient")
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.DB.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, hash, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestLoginLockout(t *testing.T) {
+	useTestDB(t)
+	useMemorySessions(t)
+	p := testProtection
+	p.IPFreeAttempts = 100
+	now := useLoginClock(t, p)
+	start := *now
+	user := createLoginUser(t)
+	ip := testIP()
+
+	steps := []struct {
+		after      time.Duration // since the start
+		password   string
+		want       int
+		retryAfter string
+	}{
+		{0, "wrong", http.StatusUnauthorized, ""},
+		{0, "wrong", http.StatusUnauthorized, ""},
+		{0, "wrong", http.StatusUnauthorized, ""}, // the free ones are used up
+		{0, "correct horse", http.StatusTooManyRequests, "1"},
+		{time.Second, "wrong", http.StatusUnauthorized, ""},
+		{time.Second, "wrong", http.StatusTooManyRequests, "2"},
+		{3 * time.Second, "wrong", http.StatusUnauthorized, ""}, // the fifth locks the account
+		{4 * time.Second, "correct horse", http.StatusTooManyRequests, "599"},
+		{10*time.Minute + 2*time.Second, "correct horse", http.StatusTooManyRequests, "1"},
+		{10*time.Minute + 3*time.Second, "correct horse", http.StatusSeeOther, ""},
+	}
+	for i, step := range steps {
+		*now = start.Add(step.after)
+		code, retryAfter := loginAttempt(user.Email, step.password, ip)
+		if code != step.want || retryAfter != step.retryAfter {
+			t.Fatalf("attempt %d at +%v: %d with Retry-After %q, want %d with %q",
+				i+1, step.after, code, retryAfter, step.want, step.retryAfter)
+		}
+
+		if i == 6 {
+			state, err := models.GetLoginState(database.DB, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unlock := start.Add(3*time.Second + p.LockoutDuration); state.LockedUntil == nil || !state.LockedUntil.Equal(unlock) {
+				t.Errorf("locked until %v, want %v", state.LockedUntil, unlock)
+			}
+		}
+	}
+
+	// The lock has run out and its failures are forgotten
+	for i := 1; i <= 3; i++ {
+		if code, _ := loginAttempt(user.Email, "wrong", ip); code != http.StatusUnauthorized {
+			t.Errorf("failure %d after the lock answered %d, want 401", i, code)
+		}
+	}
+}
+
+func TestLoginResetsFailures(t *testing.T) {
+	useTestDB(t)
+	useMemorySessions(t)
+	p := testProtection
+	p.IPFreeAttempts = 100
+	now := useLoginClock(t, p)
+	user := createLoginUser(t)
+	ip := testIP()
+
+	for i := 0; i < 3; i++ {
+		loginAttempt(user.Email, "wrong", ip)
+	}
+	*now = now.Add(time.Second)
+	if code, _ := loginAttempt(user.Email, "correct horse", ip); code != http.StatusSeeOther {
+		t.Fatalf("login answered %d", code)
+	}
+	state, err := models.GetLoginState(database.DB, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.FailedLogins != 0 || state.LastFailedLoginAt != nil {
+		t.Errorf("state after logging in: %+v", state)
+	}
+
+	// The free failures are available again
+	for i := 1; i <= 3; i++ {
+		if code, _ := loginAttempt(user.Email, "wrong", ip); code != http.StatusUnauthorized {
+			t.Errorf("failure %d after logging in answered %d, want 401", i, code)
+		}
+	}
+}
+
+func TestLoginIPBackoff(t *testing.T) {
+	useTestDB(t)
+	now := useLoginClock(t, testProtection)
+	ip := testIP()
+
+	for i, want := range []int{401, 401, 401, 429} {
+		if code, _ := loginAttempt(fmt.Sprintf("nobody%d@example.com", i), "wrong", ip); code != want {
+			t.Errorf("attempt %d answered %d, want %d", i+1, code, want)
+		}
+	}
+	if code, _ := loginAttempt("nobody@example.com", "wrong", testIP()); code != http.StatusUnauthorized {
+		t.Errorf("another address answered %d, want 401", code)
+	}
+	*now = now.Add(time.Second)
+	if code, _ := loginAttempt("nobody@example.com", "wrong", ip); code != http.StatusUnauthorized {
+		t.Errorf("attempt after the backoff answered %d, want 401", code)
+	}
+}
+
+// TestLoginDatabaseError checks a failing user lookup is a server error,
+// not a wrong password that counts against the address
+func TestLoginDatabaseError(t *testing.T) {
+	useTestDB(t)
+	useLoginClock(t, testProtection)
+	ip := testIP()
+
+	// PostgreSQL refuses text that isn't UTF-8
+	for i := 0; i < 3; i++ {
+		if code, _ := loginAttempt("\xff@example.com", "wrong", ip); code != http.StatusInternalServerError {
+			t.Errorf("login with a failing lookup answered %d, want 500", code)
+		}
+	}
+	attempts, err := models.GetFailedLoginAttempts(database.DB, "", ip, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 0 {
+		t.Errorf("%d failed logins recorded for database errors", len(attempts))
+	}
+}
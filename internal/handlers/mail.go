@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+
+	"online-doctor-appointment/internal/mail"
+)
+
+// mailer sends account emails. It defaults to an in-memory mailer and is
+// replaced at startup via ConfigureMail.
+var mailer mail.Mailer = mail.NewMemoryMailer()
+
+// baseURL is the public address of the site, used to build links in emails
+var baseURL = "http://localhost:8080"
+
+// ConfigureMail sets the mailer and the public base URL used in email links.
+// It must be called before the server starts handling requests.
+func ConfigureMail(m mail.Mailer, siteURL string) {
+	mailer = m
+	baseURL = strings.TrimSuffix(siteURL, "/")
+}
+
+// sendMail delivers msg, logging rather than failing the request on error
+func sendMail(msg mail.Message) {
+	if err := mailer.Send(msg); err != nil {
+		log.Printf("Error sending email to %s: %v", msg.To, err)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/mail"
+)
+
+func TestMain(m *testing.M) {
+	if err := LoadTemplates(); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// useMemoryMailer captures the emails the test sends
+func useMemoryMailer(t *testing.T) *mail.MemoryMailer {
+	m := mail.NewMemoryMailer()
+	oldMailer, oldURL := mailer, baseURL
+	ConfigureMail(m, "http://test")
+	t.Cleanup(func() { ConfigureMail(oldMailer, oldURL) })
+	return m
+}
+
+// linkToken finds the token in a link in an email
+var linkToken = regexp.MustCompile(`[?&]token=(\S+)`)
+
+// emailedToken returns the token in the last link mailed to address
+func emailedToken(t *testing.T, m *mail.MemoryMailer, address string) string {
+	t.Helper()
+	msgs := m.Messages()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].To != address {
+			continue
+		}
+		if match := linkToken.FindStringSubmatch(msgs[i].Body); match != nil {
+			token, err := url.QueryUnescape(match[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}
+	}
+	t.Fatalf("no link was mailed to %s", address)
+	return ""
+}
+
+// postForm sends form to handler as a POST, by principal if it isn't nil
+func postForm(handler http.HandlerFunc, path string, form url.Values, principal *auth.Principal) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if principal != nil {
+		req = req.WithContext(auth.WithPrincipal(req.Context(), *principal))
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
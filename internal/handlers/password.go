@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/mail"
+	"online-doctor-appointment/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is how long an emailed reset link stays valid
+var passwordResetTTL = time.Hour
+
+// SetPasswordResetTTL sets how long password reset links stay valid
+func SetPasswordResetTTL(ttl time.Duration) {
+	passwordResetTTL = ttl
+}
+
+// resetPasswordPage is the data for reset-password.html
+type resetPasswordPage struct {
+	Token string
+	Valid bool // false when the token is unknown, expired or used
+	Error string
+}
+
+// ForgotPasswordPageHandler serves the "forgot password" form
+func ForgotPasswordPageHandler(w http.ResponseWriter, r *http.Request) {
+	render(w, r, "forgot-password.html", struct {
+		Sent bool
+	}{false})
+}
+
+// ForgotPasswordHandler emails a reset link if the address belongs to an
+// account. The response is the same either way so it can't be used to
+// discover which emails are registered.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+
+	user, err := models.GetUserByEmail(database.DB, email)
+	if err == nil {
+		sendPasswordReset(user)
+	} else if err != sql.ErrNoRows {
+		log.Printf("Error looking up user for password reset: %v", err)
+	}
+
+	render(w, r, "forgot-password.html", struct {
+		Sent bool
+	}{true})
+}
+
+// sendPasswordReset stores a new reset token for user and emails the link
+func sendPasswordReset(user *models.User) {
+	token, err := auth.NewToken()
+	if err != nil {
+		log.Printf("Error generating password reset token: %v", err)
+		return
+	}
+
+	err = models.CreatePasswordReset(database.DB, user.ID, auth.HashToken(token), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		log.Printf("Error saving password reset token: %v", err)
+		return
+	}
+
+	link := baseURL + "/reset-password?token=" + url.QueryEscape(token)
+	sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hello " + user.FirstName + ",\n\n" +
+			"We received a request to reset your Online Doctor Appointment password.\n" +
+			"Open the link below to choose a new one. It expires in " + passwordResetTTL.String() + ".\n\n" +
+			link + "\n\n" +
+			"If you didn't ask for this, you can ignore this email.\n",
+	})
+}
+
+// ResetPasswordPageHandler serves the "choose a new password" form for a
+// valid reset token
+func ResetPasswordPageHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	valid, err := models.PasswordResetIsValid(database.DB, auth.HashToken(token))
+	if err != nil {
+		log.Printf("Error checking password reset token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, r, "reset-password.html", resetPasswordPage{Token: token, Valid: valid && token != ""})
+}
+
+// ResetPasswordHandler sets a new password using a single-use reset token
+// and logs the user out everywhere
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+
+	if len(password) < 6 || password != r.FormValue("confirm_password") {
+		render(w, r, "reset-password.html", resetPasswordPage{
+			Token: token,
+			Valid: true,
+			Error: "Passwords must match and be at least 6 characters long.",
+		})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error processing password", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := models.ResetPassword(database.DB, auth.HashToken(token), string(hashedPassword))
+	if err == sql.ErrNoRows {
+		render(w, r, "reset-password.html", resetPasswordPage{})
+		return
+	}
+	if err != nil {
+		log.Printf("Error resetting password: %v", err)
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	// Anyone holding an old session must log in again
+	if err := sessions.DeleteForUser(userID); err != nil {
+		log.Printf("Error deleting sessions after password reset: %v", err)
+	}
+
+	http.Redirect(w, r, "/login?notice=password_reset", http.StatusSeeOther)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// resetPassword posts a new password with a reset token, and returns the
+// page it was sent to, or "" if the form was shown again
+func resetPassword(t *testing.T, token, password string) string {
+	rec := postForm(ResetPasswordHandler, "/reset-password", url.Values{
+		"token": {token}, "password": {password}, "confirm_password": {password},
+	}, nil)
+	if rec.Code != http.StatusOK && rec.Code != http.StatusSeeOther {
+		t.Fatalf("reset answered %d: %s", rec.Code, rec.Body.String())
+	}
+	return rec.Header().Get("Location")
+}
+
+// TestResetPassword resets a password from the emailed link, and checks
+// the link works once and the user's sessions end
+func TestResetPassword(t *testing.T) {
+	db := useTestDB(t)
+	mails := useMemoryMailer(t)
+	useMemorySessions(t)
+	user := createTestUser(t, db, "patient")
+	session := newSession(t, user.ID)
+
+	postForm(ForgotPasswordHandler, "/forgot-password", url.Values{"email": {user.Email}}, nil)
+	token := emailedToken(t, mails, user.Email)
+
+	if to := resetPassword(t, token, "new secret"); to != "/login?notice=password_reset" {
+		t.Fatalf("reset went to %q, want the login page", to)
+	}
+	stored, err := models.GetUserByID(database.DB, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.PasswordHash), []byte("new secret")) != nil {
+		t.Error("the new password was not saved")
+	}
+	if _, err := sessions.Get(session.Value); err == nil {
+		t.Error("the user's session survived the reset")
+	}
+
+	if to := resetPassword(t, token, "another secret"); to != "" {
+		t.Errorf("reusing the link went to %q, want the invalid link page", to)
+	}
+	stored, _ = models.GetUserByID(database.DB, user.ID)
+	if bcrypt.CompareHashAndPassword([]byte(stored.PasswordHash), []byte("another secret")) == nil {
+		t.Error("a used link changed the password")
+	}
+}
+
+// TestResetPasswordExpired checks an expired link no longer resets the
+// password
+func TestResetPasswordExpired(t *testing.T) {
+	db := useTestDB(t)
+	mails := useMemoryMailer(t)
+	user := createTestUser(t, db, "patient")
+
+	old := passwordResetTTL
+	SetPasswordResetTTL(-time.Second)
+	t.Cleanup(func() { SetPasswordResetTTL(old) })
+	postForm(ForgotPasswordHandler, "/forgot-password", url.Values{"email": {user.Email}}, nil)
+	token := emailedToken(t, mails, user.Email)
+
+	if to := resetPassword(t, token, "new secret"); to != "" {
+		t.Errorf("reset with an expired link went to %q, want the invalid link page", to)
+	}
+	if to := resetPassword(t, "not a token", "new secret"); to != "" {
+		t.Errorf("reset with an unknown token went to %q, want the invalid link page", to)
+	}
+}
@@ -1,130 +1,72 @@
 package handlers
 
 import (
-	"fmt"
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"online-doctor-appointment/internal/appointment"
+	"online-doctor-appointment/internal/auth"
 	"online-doctor-appointment/internal/database"
 	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/money"
 
 	"github.com/gorilla/mux"
 )
 
+// appointmentPolicy limits when patients may cancel or reschedule
+var appointmentPolicy = appointment.Policy{Cutoff: 24 * time.Hour, MaxReschedules: 2}
+
+// SetAppointmentPolicy sets the patient cancellation and rescheduling policy
+func SetAppointmentPolicy(policy appointment.Policy) {
+	appointmentPolicy = policy
+}
+
 // PatientDashboardHandler serves the patient dashboard
 func PatientDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	userID, userType, email := GetCurrentUser(r)
-	if userType != "patient" {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
-	}
+	principal, _ := auth.FromContext(r.Context())
 
 	// Get user info
-	user, err := models.GetUserByID(database.DB, userID)
+	user, err := models.GetUserByID(database.DB, principal.UserID)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusInternalServerError)
 		return
 	}
 
 	// Get recent appointments
-	appointments, err := models.GetAppointmentsByPatientID(database.DB, userID)
+	appointments, err := models.GetAppointmentsByPatientID(database.DB, principal.UserID)
 	if err != nil {
 		appointments = []models.Appointment{} // Empty if error
 	}
 
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Patient Dashboard - Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-</head>
-<body>
-    <div class="container">
-        <div class="dashboard">
-            <div class="dashboard-header">
-                <h2>Patient Dashboard</h2>
-                <div class="user-info">
-                    <span>Welcome, ` + user.GetFullName() + `</span>
-                    <span>` + email + `</span>
-                    <form method="POST" action="/logout" style="margin-top: 10px;">
-                        <button type="submit" class="btn btn-secondary">Logout</button>
-                    </form>
-                </div>
-            </div>
-
-            <div class="dashboard-content">
-                <div class="card">
-                    <h3>Quick Actions</h3>
-                    <div class="action-buttons">
-                        <a href="/dashboard/patient/book" class="btn btn-primary">Book New Appointment</a>
-                        <a href="/dashboard/patient/appointments" class="btn btn-info">View All Appointments</a>
-						<a href="/dashboard/chatbot" class="btn btn-success">🤖 Ask AI Assistant</a>
-                    </div>
-                </div>
-
-                <div class="card">
-                    <h3>Recent Appointments</h3>`
-
-	if len(appointments) == 0 {
-		tmpl += `<p>No appointments found. <a href="/dashboard/patient/book">Book your first appointment</a></p>`
-	} else {
-		tmpl += `<table class="table">
-                        <thead>
-                            <tr>
-                                <th>Doctor</th>
-                                <th>Specialty</th>
-                                <th>Date</th>
-                                <th>Time</th>
-                                <th>Status</th>
-                            </tr>
-                        </thead>
-                        <tbody>`
-
-		for i, appointment := range appointments {
-			if i >= 5 { // Show only first 5
-				break
-			}
-			tmpl += fmt.Sprintf(`
-                            <tr>
-                                <td>Dr. %s</td>
-                                <td>%s</td>
-                                <td>%s</td>
-                                <td>%s</td>
-                                <td><span class="status %s">%s</span></td>
-                            </tr>`,
-				appointment.Doctor.User.GetFullName(),
-				appointment.Doctor.Specialty,
-				appointment.AppointmentDate,
-				appointment.AppointmentTime,
-				appointment.Status,
-				appointment.Status)
-		}
-
-		tmpl += `</tbody></table>`
+	// Show only the first 5 appointments
+	if len(appointments) > 5 {
+		appointments = appointments[:5]
 	}
+	if err := models.AttachStatusHistory(database.DB, appointments); err != nil {
+		log.Printf("Error loading appointment history: %v", err)
+	}
+	localize(r, appointments)
 
-	tmpl += `
-                </div>
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
-
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	render(w, r, "patient-dashboard.html", struct {
+		User         *models.User
+		Appointments []models.Appointment
+		Notice       string
+	}{user, appointments, verificationNotices[r.URL.Query().Get("verification")]})
 }
 
 // BookAppointmentPageHandler serves the appointment booking page
 func BookAppointmentPageHandler(w http.ResponseWriter, r *http.Request) {
-	_, userType, email := GetCurrentUser(r)
-	if userType != "patient" {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	principal, _ := auth.FromContext(r.Context())
+
+	user, err := models.GetUserByID(database.DB, principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusInternalServerError)
 		return
 	}
 
@@ -135,285 +77,80 @@ func BookAppointmentPageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Book Appointment - Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-    <style>
-        .payment-section {
-            background: #f8f9fa;
-            border: 2px solid #e9ecef;
-            border-radius: 10px;
-            padding: 20px;
-            margin-top: 20px;
-        }
-        .payment-info {
-            display: flex;
-            justify-content: space-between;
-            align-items: center;
-            margin-bottom: 15px;
-        }
-        .fee-display {
-            font-size: 1.2rem;
-            font-weight: bold;
-            color: #28a745;
-        }
-        .kaspi-button {
-            background: linear-gradient(135deg, #00a651 0%, #00d4aa 100%);
-            color: white;
-            border: none;
-            padding: 12px 30px;
-            border-radius: 8px;
-            font-weight: 600;
-            cursor: pointer;
-            font-size: 1rem;
-            transition: all 0.3s ease;
-            text-decoration: none;
-            display: inline-block;
-            text-align: center;
-        }
-        .kaspi-button:hover {
-            transform: translateY(-2px);
-            box-shadow: 0 5px 15px rgba(0, 166, 81, 0.4);
-        }
-        .kaspi-logo {
-            width: 20px;
-            height: 20px;
-            margin-right: 8px;
-        }
-        .payment-options {
-            display: flex;
-            gap: 15px;
-            align-items: center;
-            flex-wrap: wrap;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="dashboard">
-            <div class="dashboard-header">
-                <h2>Book New Appointment</h2>
-                <div class="user-info">
-                    <span>` + email + `</span>
-                    <a href="/dashboard/patient" class="btn btn-secondary">← Back to Dashboard</a>
-                </div>
-            </div>
-
-            <form method="POST" action="/dashboard/patient/book" class="booking-form" id="bookingForm">
-                <div class="form-group">
-                    <label for="doctor_id">Select Doctor:</label>
-                    <select id="doctor_id" name="doctor_id" required onchange="updateFee()">
-                        <option value="">Choose a doctor...</option>`
-
-	for _, doctor := range doctors {
-		tmpl += fmt.Sprintf(`
-                        <option value="%d" data-fee="%.2f" data-name="Dr. %s" data-specialty="%s">Dr. %s - %s ($%.2f)</option>`,
-			doctor.ID,
-			doctor.ConsultationFee,
-			doctor.User.GetFullName(),
-			doctor.Specialty,
-			doctor.User.GetFullName(),
-			doctor.Specialty,
-			doctor.ConsultationFee)
-	}
-
 	// Get tomorrow's date as minimum
 	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
 
-	tmpl += `
-                    </select>
-                </div>
-
-                <div class="form-group">
-                    <label for="appointment_date">Appointment Date:</label>
-                    <input type="date" id="appointment_date" name="appointment_date" 
-                           min="` + tomorrow + `" required>
-                </div>
-
-                <div class="form-group">
-                    <label for="appointment_time">Appointment Time:</label>
-                    <select id="appointment_time" name="appointment_time" required>
-                        <option value="">Select time...</option>
-                        <option value="09:00">09:00 AM</option>
-                        <option value="10:00">10:00 AM</option>
-                        <option value="11:00">11:00 AM</option>
-                        <option value="12:00">12:00 PM</option>
-                        <option value="13:00">01:00 PM</option>
-                        <option value="14:00">02:00 PM</option>
-                        <option value="15:00">03:00 PM</option>
-                        <option value="16:00">04:00 PM</option>
-                        <option value="17:00">05:00 PM</option>
-                    </select>
-                </div>
-
-                <div class="form-group">
-                    <label for="notes">Notes (optional):</label>
-                    <textarea id="notes" name="notes" rows="4" 
-                              placeholder="Describe your symptoms or reason for visit..."></textarea>
-                </div>
-
-                <!-- Payment Section -->
-                <div class="payment-section" id="paymentSection" style="display: none;">
-                    <h3>💳 Payment Information</h3>
-                    <div class="payment-info">
-                        <div>
-                            <strong>Consultation Fee:</strong>
-                            <span class="fee-display" id="consultationFee">$0.00</span>
-                        </div>
-                        <div>
-                            <strong>Doctor:</strong>
-                            <span id="selectedDoctor">-</span>
-                        </div>
-                    </div>
-                    <p><small>💡 You can pay now via Kaspi or pay at the clinic during your visit.</small></p>
-                    <div class="payment-options">
-                        <button type="submit" class="btn btn-primary">Book Appointment (Pay Later)</button>
-                        <span style="margin: 0 10px; color: #666;">OR</span>
-                        <a href="#" id="kaspiPayButton" class="kaspi-button" onclick="payWithKaspi(event)">
-                            🏦 Pay with Kaspi
-                        </a>
-                    </div>
-                </div>
-
-                <!-- Default button when no doctor selected -->
-                <div id="defaultButton">
-                    <button type="submit" class="btn btn-primary">Book Appointment</button>
-                </div>
-            </form>
-        </div>
-    </div>
-
-    <script>
-        function updateFee() {
-            const doctorSelect = document.getElementById('doctor_id');
-            const paymentSection = document.getElementById('paymentSection');
-            const defaultButton = document.getElementById('defaultButton');
-            const feeDisplay = document.getElementById('consultationFee');
-            const doctorDisplay = document.getElementById('selectedDoctor');
-            
-            if (doctorSelect.value) {
-                const selectedOption = doctorSelect.options[doctorSelect.selectedIndex];
-                const fee = selectedOption.dataset.fee;
-                const doctorName = selectedOption.dataset.name;
-                const specialty = selectedOption.dataset.specialty;
-                
-                feeDisplay.textContent = '$' + parseFloat(fee).toFixed(2);
-                doctorDisplay.textContent = doctorName + ' (' + specialty + ')';
-                
-                paymentSection.style.display = 'block';
-                defaultButton.style.display = 'none';
-            } else {
-                paymentSection.style.display = 'none';
-                defaultButton.style.display = 'block';
-            }
-        }
-
-        function payWithKaspi(event) {
-            event.preventDefault();
-            
-            const doctorSelect = document.getElementById('doctor_id');
-            const dateInput = document.getElementById('appointment_date');
-            const timeInput = document.getElementById('appointment_time');
-            
-            if (!doctorSelect.value || !dateInput.value || !timeInput.value) {
-                alert('Please fill in all required fields before proceeding to payment.');
-                return;
-            }
-            
-            const selectedOption = doctorSelect.options[doctorSelect.selectedIndex];
-            const fee = selectedOption.dataset.fee;
-            const doctorName = selectedOption.dataset.name;
-            const appointmentDate = dateInput.value;
-            const appointmentTime = timeInput.value;
-            
-            // Create payment description
-            const description = 'Medical consultation with ' + doctorName + ' on ' + appointmentDate + ' at ' + appointmentTime;
-            
-            // Redirect to Kaspi payment (this is a demo URL - replace with actual Kaspi integration)
-            const kaspiUrl = generateKaspiPaymentUrl(fee, description);
-            
-            // In a real application, you would:
-            // 1. First save the appointment with "pending_payment" status
-            // 2. Then redirect to Kaspi
-            // 3. Handle the callback to confirm payment
-            
-            // For now, we'll show the Kaspi payment link
-            if (confirm('Proceed to Kaspi payment for $' + fee + '?')) {
-                window.open(kaspiUrl, '_blank');
-                // Optionally submit the form after payment
-                // document.getElementById('bookingForm').submit();
-            }
-        }
-
-        function generateKaspiPaymentUrl(amount, description) {
-            // This is a simplified Kaspi payment URL structure
-            // In production, you would use official Kaspi Payment API
-            const baseUrl = 'https://kaspi.kz/pay';
-            const merchantId = 'DEMO_MERCHANT'; // Replace with your actual merchant ID
-            const orderId = 'ORDER_' + Date.now();
-            
-            const params = new URLSearchParams({
-                'amount': amount,
-                'currency': 'KZT', // Assuming Kazakhstani Tenge
-                'description': description,
-                'merchant_id': merchantId,
-                'order_id': orderId,
-                'return_url': window.location.origin + '/dashboard/patient/appointments',
-                'cancel_url': window.location.href
-            });
-            
-            // Note: This is a demo URL structure
-            // For real Kaspi integration, you need to:
-            // 1. Register as a Kaspi merchant
-            // 2. Use their official API endpoints
-            // 3. Implement proper authentication and callbacks
-            
-            return baseUrl + '?' + params.toString();
-        }
-
-        // Auto-update fee when page loads if doctor is pre-selected
-        document.addEventListener('DOMContentLoaded', function() {
-            updateFee();
-        });
-    </script>
-</body>
-</html>
-`
-
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	render(w, r, "book-appointment.html", struct {
+		User          *models.User
+		Doctors       []models.Doctor
+		MinDate       string
+		OnlinePayment bool
+	}{user, doctors, tomorrow, paymentGateway != nil})
 }
 
 // BookAppointmentHandler handles appointment booking form submission
 func BookAppointmentHandler(w http.ResponseWriter, r *http.Request) {
-	userID, userType, _ := GetCurrentUser(r)
-	if userType != "patient" {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	principal, _ := auth.FromContext(r.Context())
+
+	// Only patients with a confirmed email address may book
+	user, err := models.GetUserByID(database.DB, principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+	if !user.IsEmailVerified() {
+		http.Error(w, "Please verify your email address before booking an appointment.", http.StatusForbidden)
 		return
 	}
 
 	doctorID, _ := strconv.Atoi(r.FormValue("doctor_id"))
-	appointmentDate := r.FormValue("appointment_date")
-	appointmentTime := r.FormValue("appointment_time")
+
+	// Doctors awaiting verification can't be booked
+	doctor, err := models.GetDoctorByID(database.DB, doctorID)
+	if err != nil || !doctor.IsBookable() {
+		http.Error(w, "This doctor is not available for booking.", http.StatusBadRequest)
+		return
+	}
+
 	notes := r.FormValue("notes")
 
+	// Only offered slots can be booked, so visits never overlap
+	startsAt, ok := offeredSlot(doctor, r.FormValue("starts_at"), principal.UserID)
+	if !ok {
+		http.Error(w, "That time is not available. Please choose another slot.", http.StatusConflict)
+		return
+	}
+
+	// Appointments paid online wait for the payment instead of the doctor
+	payOnline := r.FormValue("pay") == "online" && paymentGateway != nil
+	status := appointment.Pending
+	if payOnline {
+		status = appointment.PendingPayment
+	}
+
 	// Create appointment
-	appointment := &models.Appointment{
-		PatientID:       userID,
-		DoctorID:        doctorID,
-		AppointmentDate: appointmentDate,
-		AppointmentTime: appointmentTime,
-		Notes:           notes,
+	apt := &models.Appointment{
+		PatientID: principal.UserID,
+		DoctorID:  doctorID,
+		StartsAt:  startsAt,
+		Status:    string(status),
+		Notes:     notes,
 	}
 
-	err := models.CreateAppointment(database.DB, appointment)
+	err = models.CreateAppointment(database.DB, apt)
+	if errors.Is(err, models.ErrSlotUnavailable) {
+		http.Error(w, "That time slot has just been taken. Please choose another.", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to book appointment. Time slot may be unavailable.", http.StatusBadRequest)
+		log.Printf("Error booking appointment: %v", err)
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+
+	// The fee is charged on the server, never taken from the form
+	if payOnline {
+		startPayment(w, r, apt, doctor)
 		return
 	}
 
@@ -421,101 +158,186 @@ func BookAppointmentHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/dashboard/patient/appointments", http.StatusSeeOther)
 }
 
+// offeredSlot parses a slot's start time as sent by the slots API and
+// reports whether the doctor still offers it to the patient
+func offeredSlot(doctor *models.Doctor, value string, patientID int) (time.Time, bool) {
+	startsAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	date := startsAt.In(doctor.Location()).Format("2006-01-02")
+	slots, err := models.GetAvailableTimeSlots(database.DB, doctor.ID, date, patientID)
+	if err != nil {
+		log.Printf("Error loading time slots: %v", err)
+		return time.Time{}, false
+	}
+	return startsAt, slices.ContainsFunc(slots, startsAt.Equal)
+}
+
 // PatientAppointmentsHandler shows all patient appointments
 func PatientAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, userType, email := GetCurrentUser(r)
-	if userType != "patient" {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	principal, _ := auth.FromContext(r.Context())
+
+	// Get all appointments
+	appointments, err := models.GetAppointmentsByPatientID(database.DB, principal.UserID)
+	if err != nil {
+		http.Error(w, "Error loading appointments", http.StatusInternalServerError)
+		return
+	}
+	if err := models.AttachStatusHistory(database.DB, appointments); err != nil {
+		http.Error(w, "Error loading appointments", http.StatusInternalServerError)
 		return
 	}
+	localize(r, appointments)
+
+	// Work out which appointments the patient may still change
+	now := time.Now()
+	cancellable := make(map[int]bool)
+	reschedulable := make(map[int]bool)
+	for _, a := range appointments {
+		status := appointment.Status(a.Status)
+		cancellable[a.ID] = appointmentPolicy.CanCancel(status, a.StartsAt, now) == nil
+		reschedulable[a.ID] = appointmentPolicy.CanReschedule(status, a.StartsAt, a.RescheduleCount, now) == nil
+	}
 
-	// Get all appointments
-	appointments, err := models.GetAppointmentsByPatientID(database.DB, userID)
+	waitlist, err := models.GetWaitlistEntriesByPatientID(database.DB, principal.UserID)
 	if err != nil {
 		http.Error(w, "Error loading appointments", http.StatusInternalServerError)
 		return
 	}
 
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>My Appointments - Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-</head>
-<body>
-    <div class="container">
-        <div class="dashboard">
-            <div class="dashboard-header">
-                <h2>My Appointments</h2>
-                <div class="user-info">
-                    <span>` + email + `</span>
-                    <a href="/dashboard/patient" class="btn btn-secondary">← Back to Dashboard</a>
-                </div>
-            </div>
-
-            <div class="card">
-                <div class="action-buttons">
-                    <a href="/dashboard/patient/book" class="btn btn-primary">Book New Appointment</a>
-                </div>
-            </div>
-
-            <div class="card">
-                <h3>All Appointments</h3>`
-
-	if len(appointments) == 0 {
-		tmpl += `<p>No appointments found. <a href="/dashboard/patient/book">Book your first appointment</a></p>`
-	} else {
-		tmpl += `<table class="table">
-                    <thead>
-                        <tr>
-                            <th>Doctor</th>
-                            <th>Specialty</th>
-                            <th>Date</th>
-                            <th>Time</th>
-                            <th>Status</th>
-                            <th>Fee</th>
-                            <th>Notes</th>
-                        </tr>
-                    </thead>
-                    <tbody>`
-
-		for _, appointment := range appointments {
-			tmpl += fmt.Sprintf(`
-                        <tr>
-                            <td>Dr. %s</td>
-                            <td>%s</td>
-                            <td>%s</td>
-                            <td>%s</td>
-                            <td><span class="status %s">%s</span></td>
-                            <td>$%.2f</td>
-                            <td>%s</td>
-                        </tr>`,
-				appointment.Doctor.User.GetFullName(),
-				appointment.Doctor.Specialty,
-				appointment.AppointmentDate,
-				appointment.AppointmentTime,
-				appointment.Status,
-				appointment.Status,
-				appointment.Doctor.ConsultationFee,
-				appointment.Notes)
-		}
+	render(w, r, "patient-appointments.html", struct {
+		Appointments  []models.Appointment
+		Cancellable   map[int]bool
+		Reschedulable map[int]bool
+		Policy        appointment.Policy
+		Waitlist      []models.WaitlistEntry
+		OnlinePayment bool
+		Notice        string
+	}{appointments, cancellable, reschedulable, appointmentPolicy, waitlist, paymentGateway != nil,
+		appointmentNotices[r.URL.Query().Get("notice")]})
+}
+
+// appointmentNotices are the messages shown after a patient changes an
+// appointment
+var appointmentNotices = map[string]string{
+	"cancelled":     "Your appointment has been cancelled.",
+	"rescheduled":   "Your appointment has been rescheduled.",
+	"claimed":       "Your appointment has been booked from the waitlist.",
+	"waitlisted":    "You're on the waitlist. We'll email you if a slot opens up.",
+	"left-waitlist": "You've left the waitlist.",
+}
+
+// CancelAppointmentHandler lets a patient cancel their own appointment
+// within the appointment policy
+func CancelAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	appointmentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
 
-		tmpl += `</tbody></table>`
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	err = models.CancelAppointment(database.DB, appointmentID, principal.UserID, reason, appointmentPolicy)
+	if err != nil {
+		appointmentChangeError(w, err)
+		return
 	}
+	refundCancelledAppointment(appointmentID, true)
+	releaseCancelledSlot(appointmentID)
 
-	tmpl += `
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
+	http.Redirect(w, r, "/dashboard/patient/appointments?notice=cancelled", http.StatusSeeOther)
+}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+// reschedulePage is the data for reschedule-appointment.html
+type reschedulePage struct {
+	Appointment *models.Appointment
+	MinDate     string
+	Error       string
+}
+
+// RescheduleAppointmentPageHandler serves the form for moving an appointment
+func RescheduleAppointmentPageHandler(w http.ResponseWriter, r *http.Request) {
+	appointmentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+
+	apt, err := models.GetAppointmentByID(database.DB, appointmentID)
+	if err != nil {
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	}
+	apt.Localize(viewerLocation(r))
+
+	render(w, r, "reschedule-appointment.html", reschedulePage{
+		Appointment: apt,
+		MinDate:     time.Now().AddDate(0, 0, 1).Format("2006-01-02"),
+	})
+}
+
+// RescheduleAppointmentHandler moves a patient's appointment to a free slot
+// with the same doctor, within the appointment policy
+func RescheduleAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	appointmentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+
+	apt, err := models.GetAppointmentByID(database.DB, appointmentID)
+	if err != nil {
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	}
+
+	// The new time must be one of the doctor's free slots
+	startsAt, ok := offeredSlot(apt.Doctor, r.FormValue("starts_at"), apt.PatientID)
+	if !ok {
+		apt.Localize(viewerLocation(r))
+		renderStatus(w, r, http.StatusConflict, "reschedule-appointment.html", reschedulePage{
+			Appointment: apt,
+			MinDate:     time.Now().AddDate(0, 0, 1).Format("2006-01-02"),
+			Error:       "That time is not available. Please choose another slot.",
+		})
+		return
+	}
+
+	err = models.RescheduleAppointment(database.DB, appointmentID, startsAt, principal.UserID, appointmentPolicy)
+	if err != nil {
+		appointmentChangeError(w, err)
+		return
+	}
+
+	// The old time is free again for patients on the waitlist
+	offerFreedSlot(apt.DoctorID, apt.StartsAt)
+
+	http.Redirect(w, r, "/dashboard/patient/appointments?notice=rescheduled", http.StatusSeeOther)
+}
+
+// appointmentChangeError responds to a failed cancellation or reschedule
+func appointmentChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+	case errors.Is(err, appointment.ErrTooLate):
+		http.Error(w, "This appointment is too soon to change. Please contact the clinic.", http.StatusConflict)
+	case errors.Is(err, appointment.ErrRescheduleLimit):
+		http.Error(w, "This appointment has already been rescheduled the maximum number of times.", http.StatusConflict)
+	case errors.Is(err, appointment.ErrInvalidTransition):
+		http.Error(w, "This appointment can no longer be changed.", http.StatusConflict)
+	case errors.Is(err, models.ErrSlotUnavailable):
+		http.Error(w, "That time slot has just been taken. Please choose another.", http.StatusConflict)
+	default:
+		log.Printf("Error changing appointment: %v", err)
+		http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+	}
 }
 
 // GetDoctorsHandler returns all doctors as JSON
@@ -543,7 +365,17 @@ func GetDoctorsBySpecialtyHandler(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, doctors)
 }
 
-// GetAvailableSlotsHandler returns available time slots for a doctor on a specific date
+// slotJSON is a free slot as returned by the slots API: the instant it
+// starts, and its date and time in the display zone
+type slotJSON struct {
+	StartsAt time.Time `json:"starts_at"`
+	Date     string    `json:"date"`
+	Time     string    `json:"time"`
+}
+
+// GetAvailableSlotsHandler returns the free slots on a date in the doctor's
+// time zone. Each slot is an instant, also given in the viewer's zone, which
+// can be chosen with ?tz=.
 func GetAvailableSlotsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	doctorIDStr := vars["doctorId"]
@@ -555,151 +387,138 @@ func GetAvailableSlotsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	slots, err := models.GetAvailableTimeSlots(database.DB, doctorID, date)
+	settings, err := models.GetScheduleSettings(database.DB, doctorID)
+	if err != nil {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Doctor not found"})
+		return
+	}
+
+	// Patients still see the slot they are holding
+	principal, _ := auth.FromContext(r.Context())
+	slots, err := models.GetAvailableTimeSlots(database.DB, doctorID, date, principal.UserID)
 	if err != nil {
 		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Error loading time slots"})
 		return
 	}
 
+	display := viewerLocation(r)
+	out := make([]slotJSON, 0, len(slots))
+	for _, slot := range slots {
+		local := slot.In(display)
+		out = append(out, slotJSON{
+			StartsAt: slot,
+			Date:     local.Format("2006-01-02"),
+			Time:     local.Format("15:04"),
+		})
+	}
+
 	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"doctor_id": doctorID,
-		"date":      date,
-		"slots":     slots,
+		"doctor_id":         doctorID,
+		"date":              date,
+		"time_zone":         settings.Location().String(),
+		"display_time_zone": display.String(),
+		"slots":             out,
 	})
 }
 
-// PaymentSuccessHandler handles successful payments from Kaspi
-func PaymentSuccessHandler(w http.ResponseWriter, r *http.Request) {
-	_, userType, email := GetCurrentUser(r)
-	if userType != "patient" {
-		http.Error(w, "Access denied", http.StatusForbidden)
+// Bounds of the slot search
+const (
+	defaultSearchDays  = 14
+	maxSearchDays      = 31
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// foundSlotJSON is a slot returned by the slot search, with its doctor
+type foundSlotJSON struct {
+	slotJSON
+	DoctorID        int         `json:"doctor_id"`
+	DoctorName      string      `json:"doctor_name"`
+	Specialty       string      `json:"specialty"`
+	ConsultationFee money.Money `json:"consultation_fee"`
+	TimeZone        string      `json:"time_zone"`
+}
+
+// SearchSlotsHandler returns the earliest free slots across all bookable
+// doctors of a specialty, ordered by time and then fee. from and to are
+// dates, inclusive, defaulting to the next two weeks; limit caps the number
+// of slots.
+func SearchSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	display := viewerLocation(r)
+
+	from := query.Get("from")
+	if from == "" {
+		from = time.Now().In(display).Format("2006-01-02")
+	}
+	fromDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid from date"})
 		return
 	}
 
-	// Get payment parameters from URL (in real implementation, verify with Kaspi)
-	orderId := r.URL.Query().Get("order_id")
-	amount := r.URL.Query().Get("amount")
-
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Payment Successful - Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-    <style>
-        .success-card {
-            background: #d4edda;
-            border: 2px solid #c3e6cb;
-            border-radius: 10px;
-            padding: 30px;
-            text-align: center;
-            color: #155724;
-        }
-        .success-icon {
-            font-size: 4rem;
-            margin-bottom: 20px;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="dashboard">
-            <div class="dashboard-header">
-                <h2>Payment Successful</h2>
-                <div class="user-info">
-                    <span>` + email + `</span>
-                </div>
-            </div>
-
-            <div class="success-card">
-                <div class="success-icon">✅</div>
-                <h3>Payment Completed Successfully!</h3>
-                <p><strong>Order ID:</strong> ` + orderId + `</p>
-                <p><strong>Amount:</strong> $` + amount + `</p>
-                <p>Your appointment has been confirmed and payment processed via Kaspi.</p>
-                <p>You will receive a confirmation email shortly.</p>
-                
-                <div class="action-buttons" style="margin-top: 30px;">
-                    <a href="/dashboard/patient/appointments" class="btn btn-primary">View My Appointments</a>
-                    <a href="/dashboard/patient" class="btn btn-secondary">Back to Dashboard</a>
-                </div>
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
-
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
-}
+	to := query.Get("to")
+	if to == "" {
+		to = fromDate.AddDate(0, 0, defaultSearchDays-1).Format("2006-01-02")
+	}
+	toDate, err := time.Parse("2006-01-02", to)
+	if err != nil || toDate.Before(fromDate) {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid to date"})
+		return
+	}
+	if toDate.After(fromDate.AddDate(0, 0, maxSearchDays-1)) {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Search at most 31 days at a time"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+			return
+		}
+		limit = min(limit, maxSearchLimit)
+	}
+
+	specialty := query.Get("specialty")
+	doctors, err := models.GetDoctorsBySpecialty(database.DB, specialty)
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Error loading doctors"})
+		return
+	}
 
-// PaymentFailureHandler handles failed payments from Kaspi
-func PaymentFailureHandler(w http.ResponseWriter, r *http.Request) {
-	_, userType, email := GetCurrentUser(r)
-	if userType != "patient" {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	// Patients still see the slot they are holding
+	principal, _ := auth.FromContext(r.Context())
+	slots, err := models.SearchAvailableSlots(database.DB, doctors, from, to, principal.UserID, limit)
+	if err != nil {
+		log.Printf("Error searching slots: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Error loading time slots"})
 		return
 	}
 
-	// Get error details from URL
-	errorMessage := r.URL.Query().Get("error")
-	if errorMessage == "" {
-		errorMessage = "Payment was cancelled or failed"
-	}
-
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Payment Failed - Online Doctor Appointment</title>
-    <link rel="stylesheet" href="/static/css/style.css">
-    <style>
-        .error-card {
-            background: #f8d7da;
-            border: 2px solid #f5c6cb;
-            border-radius: 10px;
-            padding: 30px;
-            text-align: center;
-            color: #721c24;
-        }
-        .error-icon {
-            font-size: 4rem;
-            margin-bottom: 20px;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="dashboard">
-            <div class="dashboard-header">
-                <h2>Payment Failed</h2>
-                <div class="user-info">
-                    <span>` + email + `</span>
-                </div>
-            </div>
-
-            <div class="error-card">
-                <div class="error-icon">❌</div>
-                <h3>Payment Could Not Be Processed</h3>
-                <p>` + errorMessage + `</p>
-                <p>Don't worry! You can still book the appointment and pay at the clinic, or try the payment again.</p>
-                
-                <div class="action-buttons" style="margin-top: 30px;">
-                    <a href="/dashboard/patient/book" class="btn btn-primary">Try Again</a>
-                    <a href="/dashboard/patient" class="btn btn-secondary">Back to Dashboard</a>
-                </div>
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
-
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	out := make([]foundSlotJSON, 0, len(slots))
+	for _, slot := range slots {
+		local := slot.StartsAt.In(display)
+		out = append(out, foundSlotJSON{
+			slotJSON: slotJSON{
+				StartsAt: slot.StartsAt,
+				Date:     local.Format("2006-01-02"),
+				Time:     local.Format("15:04"),
+			},
+			DoctorID:        slot.Doctor.ID,
+			DoctorName:      "Dr. " + slot.Doctor.User.GetFullName(),
+			Specialty:       slot.Doctor.Specialty,
+			ConsultationFee: slot.Doctor.ConsultationFee,
+			TimeZone:        slot.Doctor.Location().String(),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"specialty":         specialty,
+		"from":              from,
+		"to":                to,
+		"display_time_zone": display.String(),
+		"slots":             out,
+	})
 }
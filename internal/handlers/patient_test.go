@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// lastSunday returns the last Sunday of month in year
+func lastSunday(year int, month time.Month) time.Time {
+	d := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+	return d.AddDate(0, 0, -int(d.Weekday()))
+}
+
+// TestAvailableSlotsAcrossDaylightSaving asks for a Berlin doctor's slots
+// on next year's daylight saving days. Slots are instants, and their date
+// and time follow the viewer's zone.
+func TestAvailableSlotsAcrossDaylightSaving(t *testing.T) {
+	db := useTestDB(t)
+
+	user := &models.User{
+		Email:        fmt.Sprintf("dst-doctor-%d@example.com", time.Now().UnixNano()),
+		PasswordHash: "not a hash",
+		FirstName:    "Daylight",
+		LastName:     "Saving",
+		UserType:     "doctor",
+	}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, user.ID) })
+	doctor := &models.Doctor{UserID: user.ID, Specialty: "Chronobiology", TimeZone: "Europe/Berlin"}
+	if err := models.CreateDoctor(db, doctor); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE doctors SET slot_minutes = 60, buffer_minutes = 0 WHERE id = $1`, doctor.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err := models.CreateAvailability(db, &models.DoctorAvailability{
+		DoctorID: doctor.ID, DayOfWeek: int(time.Sunday), StartTime: "01:00", EndTime: "05:00", IsActive: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	year := time.Now().Year() + 1
+	spring := lastSunday(year, time.March).Format("2006-01-02")
+	autumn := lastSunday(year, time.October).Format("2006-01-02")
+
+	tests := []struct {
+		date      string
+		tz        string
+		wantTimes []string
+		wantHours []int // hours between consecutive starts_at
+	}{
+		{spring, "Europe/Berlin", []string{"01:00", "03:00", "04:00"}, []int{1, 1}},
+		{spring, "UTC", []string{"00:00", "01:00", "02:00"}, []int{1, 1}},
+		{autumn, "Europe/Berlin", []string{"01:00", "02:00", "02:00", "03:00", "04:00"}, []int{1, 1, 1, 1}},
+		{autumn, "Asia/Tokyo", []string{"08:00", "09:00", "10:00", "11:00", "12:00"}, []int{1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.date+" in "+tt.tz, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/available-slots/x/y?tz="+tt.tz, nil)
+			req = mux.SetURLVars(req, map[string]string{"doctorId": strconv.Itoa(doctor.ID), "date": tt.date})
+			rec := httptest.NewRecorder()
+			GetAvailableSlotsHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var resp struct {
+				TimeZone        string     `json:"time_zone"`
+				DisplayTimeZone string     `json:"display_time_zone"`
+				Slots           []slotJSON `json:"slots"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.TimeZone != "Europe/Berlin" || resp.DisplayTimeZone != tt.tz {
+				t.Errorf("time_zone %q, display_time_zone %q; want Europe/Berlin, %s", resp.TimeZone, resp.DisplayTimeZone, tt.tz)
+			}
+
+			var times []string
+			var hours []int
+			for i, s := range resp.Slots {
+				times = append(times, s.Time)
+				if i > 0 {
+					hours = append(hours, int(s.StartsAt.Sub(resp.Slots[i-1].StartsAt)/time.Hour))
+				}
+			}
+			if !slices.Equal(times, tt.wantTimes) || !slices.Equal(hours, tt.wantHours) {
+				t.Errorf("slots at %v, %v hours apart; want %v, %v", times, hours, tt.wantTimes, tt.wantHours)
+			}
+		})
+	}
+}
+
+// TestRescheduleOffersFreedSlot moves an appointment and checks its old
+// time goes to the patient waiting for it
+func TestRescheduleOffersFreedSlot(t *testing.T) {
+	db := useTestDB(t)
+	mails := useMemoryMailer(t)
+	doctor := createTestDoctor(t, db)
+	from, to := nextWeek(t, doctor, "09:00"), nextWeek(t, doctor, "10:00")
+	addWorkingHours(t, db, doctor, from.Weekday(), "09:00", "11:00")
+
+	patient := createTestUser(t, db, "patient")
+	apt := &models.Appointment{PatientID: patient.ID, DoctorID: doctor.ID, StartsAt: from, Status: "confirmed"}
+	if err := models.CreateAppointment(db, apt); err != nil {
+		t.Fatal(err)
+	}
+	waiting := createTestUser(t, db, "patient")
+	date := from.Format("2006-01-02")
+	if err := models.JoinWaitlist(db, &models.WaitlistEntry{DoctorID: doctor.ID, PatientID: waiting.ID, FromDate: date, ToDate: date}); err != nil {
+		t.Fatal(err)
+	}
+
+	id := strconv.Itoa(apt.ID)
+	rec := postForm(func(w http.ResponseWriter, r *http.Request) {
+		RescheduleAppointmentHandler(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	}, "/dashboard/patient/appointment/"+id+"/reschedule", url.Values{
+		"starts_at": {to.Format(time.RFC3339)},
+	}, &auth.Principal{UserID: patient.ID, Role: "patient"})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("reschedule answered %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var offered time.Time
+	err := db.QueryRow(`SELECT starts_at FROM waitlist_offers WHERE patient_id = $1 AND status = 'open'`, waiting.ID).Scan(&offered)
+	if err != nil {
+		t.Fatalf("no offer for the waiting patient: %v", err)
+	}
+	if !offered.Equal(from) {
+		t.Errorf("offered %v, want the old time %v", offered, from)
+	}
+	emailedToken(t, mails, waiting.Email)
+}
+
+// TestSearchSlotsRejectsBadRanges checks the slot search refuses date
+// ranges it won't search before it touches the database
+func TestSearchSlotsRejectsBadRanges(t *testing.T) {
+	for _, query := range []string{
+		"from=2030-01-01&to=2030-02-01", // 32 days
+		"from=2030-01-01&to=2029-12-31",
+		"from=2030-01-32",
+		"from=2030-01-01&limit=0",
+	} {
+		rec := httptest.NewRecorder()
+		SearchSlotsHandler(rec, httptest.NewRequest("GET", "/api/slots/search?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s answered %d, want 400", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"online-doctor-appointment/internal/appointment"
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/payments"
+
+	"github.com/gorilla/mux"
+)
+
+// paymentGateway takes online payments; nil when patients can only pay at
+// the clinic
+var paymentGateway payments.Gateway
+
+// paymentTimeout is how long an appointment booked to be paid online waits
+// for the payment before it is cancelled
+var paymentTimeout = 30 * time.Minute
+
+// SetPaymentGateway sets the payment gateway, nil to turn online payment
+// off
+func SetPaymentGateway(gateway payments.Gateway) {
+	paymentGateway = gateway
+}
+
+// SetPaymentTimeout sets how long unpaid appointments are kept
+func SetPaymentTimeout(timeout time.Duration) {
+	paymentTimeout = timeout
+}
+
+// StartUnpaidAppointmentSweeper periodically cancels appointments that
+// weren't paid within the payment timeout and offers their slots to the
+// waitlist, until the returned stop function is called
+func StartUnpaidAppointmentSweeper(interval time.Duration) (stop func()) {
+	return runEvery(interval, func(time.Time) {
+		cancelled, err := models.CancelUnpaidAppointments(database.DB, paymentTimeout)
+		if err != nil {
+			log.Printf("Error cancelling unpaid appointments: %v", err)
+			return
+		}
+		for _, a := range cancelled {
+			log.Printf("Cancelled appointment %d: not paid in time", a.ID)
+			offerFreedSlot(a.DoctorID, a.StartsAt)
+		}
+	})
+}
+
+// startPayment creates a gateway order for the appointment's consultation
+// fee and sends the patient to pay it, or back to the order they already
+// opened. If the order can't be created the patient is sent to the failure
+// page, where they can try again until the payment timeout.
+func startPayment(w http.ResponseWriter, r *http.Request, apt *models.Appointment, doctor *models.Doctor) {
+	reference, err := auth.NewToken()
+	if err != nil {
+		log.Printf("Error generating payment reference: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	payment, err := models.OpenPayment(database.DB, &models.Payment{
+		AppointmentID: apt.ID,
+		Reference:     reference,
+		Gateway:       paymentGateway.Name(),
+		Amount:        doctor.ConsultationFee,
+	})
+	switch {
+	case errors.Is(err, models.ErrNotPayable):
+		http.Error(w, "This appointment can no longer be paid online.", http.StatusConflict)
+		return
+	case errors.Is(err, models.ErrAlreadyPaid):
+		http.Error(w, "This appointment has already been paid.", http.StatusConflict)
+		return
+	case errors.Is(err, models.ErrPaymentInProgress):
+		http.Error(w, "A payment for this appointment is already being started. Please try again in a moment.", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Error creating payment: %v", err)
+		http.Error(w, "Failed to start payment", http.StatusInternalServerError)
+		return
+	}
+	if payment.PaymentURL != "" {
+		http.Redirect(w, r, payment.PaymentURL, http.StatusSeeOther)
+		return
+	}
+
+	ref := url.QueryEscape(payment.Reference)
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	order, err := paymentGateway.CreateOrder(ctx, payments.OrderRequest{
+		Reference: payment.Reference,
+		Amount:    payment.Amount,
+		Description: "Consultation with Dr. " + doctor.User.GetFullName() + ", " +
+			apt.StartsAt.In(doctor.Location()).Format("2 January 2006 15:04 MST"),
+		ReturnURL:   baseURL + "/dashboard/payment/success?ref=" + ref,
+		FailURL:     baseURL + "/dashboard/payment/failure?ref=" + ref,
+		CallbackURL: baseURL + "/payments/webhook",
+	})
+	if err == nil {
+		err = models.SetPaymentOrder(database.DB, payment.ID, order.ID, order.PaymentURL)
+		if err != nil {
+			// Without the order ID its callback can't be matched, so the
+			// order must not be paid
+			err = fmt.Errorf("saving order %s: %w", order.ID, err)
+		}
+	}
+	if err != nil {
+		log.Printf("Error creating payment order %d: %v", payment.ID, err)
+		if err := models.FailPayment(database.DB, payment.ID, "The payment service is unavailable"); err != nil {
+			log.Printf("Error failing payment %d: %v", payment.ID, err)
+		}
+		http.Redirect(w, r, "/dashboard/payment/failure?ref="+ref, http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, order.PaymentURL, http.StatusSeeOther)
+}
+
+// PayAppointmentHandler starts an online payment for a booked appointment,
+// e.g. after an earlier attempt failed
+func PayAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	if paymentGateway == nil {
+		http.Error(w, "Online payment is not available. Please pay at the clinic.", http.StatusNotFound)
+		return
+	}
+
+	appointmentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+
+	apt, err := models.GetAppointmentByID(database.DB, appointmentID)
+	if err != nil {
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	}
+
+	doctor, err := models.GetDoctorByID(database.DB, apt.DoctorID)
+	if err != nil {
+		http.Error(w, "Doctor not found", http.StatusInternalServerError)
+		return
+	}
+
+	// startPayment checks the appointment can be paid under its lock
+	startPayment(w, r, apt, doctor)
+}
+
+// patientPayment returns the payment a return page is for, if it belongs
+// to the patient
+func patientPayment(r *http.Request) (*models.Payment, error) {
+	principal, _ := auth.FromContext(r.Context())
+
+	payment, err := models.GetPaymentByReference(database.DB, r.URL.Query().Get("ref"))
+	if err != nil {
+		return nil, err
+	}
+	if payment.PatientID != principal.UserID {
+		return nil, sql.ErrNoRows
+	}
+	return payment, nil
+}
+
+// PaymentSuccessHandler shows a payment after the gateway sends the patient
+// back. The outcome comes from the gateway's signed callback, so the page
+// may still show the payment as pending.
+func PaymentSuccessHandler(w http.ResponseWriter, r *http.Request) {
+	payment, err := patientPayment(r)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading payment: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if payment.Status == models.PaymentFailed {
+		http.Redirect(w, r, "/dashboard/payment/failure?ref="+url.QueryEscape(payment.Reference), http.StatusSeeOther)
+		return
+	}
+
+	render(w, r, "payment-success.html", struct {
+		Payment *models.Payment
+	}{payment})
+}
+
+// PaymentFailureHandler shows why a payment failed and lets the patient try
+// again
+func PaymentFailureHandler(w http.ResponseWriter, r *http.Request) {
+	errorMessage := "Payment was cancelled or failed"
+	appointmentID := 0
+
+	payment, err := patientPayment(r)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error loading payment: %v", err)
+	}
+	if payment != nil {
+		if payment.FailureReason != "" {
+			errorMessage = payment.FailureReason
+		}
+		if paymentGateway != nil && payment.Status != models.PaymentPaid {
+			appointmentID = payment.AppointmentID
+		}
+	}
+
+	render(w, r, "payment-failure.html", struct {
+		ErrorMessage  string
+		AppointmentID int // to pay again, 0 if the payment can't be retried
+	}{errorMessage, appointmentID})
+}
+
+// PaymentWebhookHandler receives the gateway's signed payment outcomes. It
+// is exempt from CSRF checks, which the signature replaces.
+func PaymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if paymentGateway == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	cb, err := paymentGateway.ParseCallback(r)
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Invalid payment callback: %v", err)
+		http.Error(w, "Invalid callback", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := models.RecordPaymentResult(database.DB, cb.Reference, cb.OrderID, cb.Status, cb.Amount, cb.Reason)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Unknown order", http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrPaymentMismatch):
+		log.Printf("Payment callback for %s does not match the order: order %s, %s", cb.Reference, cb.OrderID, cb.Amount)
+		http.Error(w, "Callback does not match the order", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error recording payment callback for %s: %v", cb.Reference, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Payment %d for appointment %d is %s", payment.ID, payment.AppointmentID, payment.Status)
+	if payment.Status == models.PaymentPaid {
+		issueInvoice(payment.ID)
+	}
+	if payment.Status == models.PaymentPaid && payment.AppointmentStatus == string(appointment.Cancelled) {
+		log.Printf("Payment %d arrived for cancelled appointment %d; refunding it", payment.ID, payment.AppointmentID)
+		refundAppointment(payment.AppointmentID, 100, "Paid after the appointment was cancelled")
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
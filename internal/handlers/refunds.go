@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"online-doctor-appointment/internal/appointment"
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/payments"
+
+	"github.com/gorilla/mux"
+)
+
+// refundPolicy decides how much patients get back when they cancel a paid
+// appointment
+var refundPolicy = appointment.RefundPolicy{FullBefore: 48 * time.Hour, PartialBefore: 24 * time.Hour, PartialPercent: 50}
+
+// SetRefundPolicy sets how much of the fee patients get back when they
+// cancel
+func SetRefundPolicy(policy appointment.RefundPolicy) {
+	refundPolicy = policy
+}
+
+// refundCancelledAppointment refunds the payments of an appointment that
+// was just cancelled: by the policy if the patient cancelled it, in full if
+// the doctor did. Failed refunds are left for an administrator to retry.
+func refundCancelledAppointment(appointmentID int, byPatient bool) {
+	percent, reason := 100, "Cancelled by the doctor"
+	if byPatient {
+		apt, err := models.GetAppointmentByID(database.DB, appointmentID)
+		if err != nil {
+			log.Printf("Error loading cancelled appointment %d: %v", appointmentID, err)
+			return
+		}
+		percent, reason = refundPolicy.RefundPercent(apt.StartsAt, time.Now()), "Cancelled by the patient"
+	}
+	refundAppointment(appointmentID, percent, reason)
+}
+
+// refundAppointment refunds percent of every paid payment of an appointment
+func refundAppointment(appointmentID, percent int, reason string) {
+	paid, err := models.GetPaidPayments(database.DB, appointmentID)
+	if err != nil {
+		log.Printf("Error loading payments of appointment %d: %v", appointmentID, err)
+		return
+	}
+
+	for _, p := range paid {
+		amount := p.Amount.Percent(percent)
+		if amount.Minor <= 0 {
+			log.Printf("Payment %d for appointment %d is not refundable", p.ID, appointmentID)
+			continue
+		}
+
+		reference, err := auth.NewToken()
+		if err != nil {
+			log.Printf("Error generating refund reference: %v", err)
+			return
+		}
+		refund := &models.Refund{
+			PaymentID: p.ID,
+			Reference: reference,
+			Amount:    amount,
+			Reason:    reason,
+		}
+		if err := models.CreateRefund(database.DB, refund); err != nil {
+			log.Printf("Error recording refund of payment %d: %v", p.ID, err)
+			continue
+		}
+		if refund.Status == models.RefundSucceeded {
+			continue
+		}
+		processRefund(refund)
+	}
+}
+
+// processRefund asks the gateway for a recorded refund and stores the
+// outcome. It returns the gateway's error, if any.
+func processRefund(refund *models.Refund) error {
+	var err error
+	var made *payments.Refund
+	switch {
+	case paymentGateway == nil:
+		err = errors.New("online payments are turned off")
+	case paymentGateway.Name() != refund.Gateway:
+		err = fmt.Errorf("the payment was made with %s", refund.Gateway)
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		made, err = paymentGateway.Refund(ctx, payments.RefundRequest{
+			Reference: refund.Reference,
+			OrderID:   refund.GatewayOrderID,
+			Amount:    refund.Amount,
+			Reason:    refund.Reason,
+		})
+	}
+
+	if err != nil {
+		log.Printf("Refund %d of payment %d failed: %v", refund.ID, refund.PaymentID, err)
+		if err := models.FailRefund(database.DB, refund.ID, err.Error()); err != nil {
+			log.Printf("Error failing refund %d: %v", refund.ID, err)
+		}
+		return err
+	}
+
+	if err := models.CompleteRefund(database.DB, refund.ID, made.ID); err != nil && !errors.Is(err, models.ErrRefundDone) {
+		log.Printf("Error completing refund %d: %v", refund.ID, err)
+	}
+	log.Printf("Refunded %s of payment %d", refund.Amount, refund.PaymentID)
+	return nil
+}
+
+// refundsPage is the data for the admin refunds page
+type refundsPage struct {
+	Refunds []models.Refund
+	Notice  string
+	Error   string
+}
+
+// renderRefunds shows the refunds that still need attention
+func renderRefunds(w http.ResponseWriter, r *http.Request, status int, page refundsPage) {
+	refunds, err := models.GetOpenRefunds(database.DB)
+	if err != nil {
+		log.Printf("Error loading refunds: %v", err)
+		http.Error(w, "Error loading refunds", http.StatusInternalServerError)
+		return
+	}
+
+	page.Refunds = refunds
+	renderStatus(w, r, status, "admin-refunds.html", page)
+}
+
+// AdminRefundsHandler lists refunds that are pending or failed
+func AdminRefundsHandler(w http.ResponseWriter, r *http.Request) {
+	renderRefunds(w, r, http.StatusOK, refundsPage{})
+}
+
+// AdminRetryRefundHandler asks the gateway again for a pending or failed
+// refund. The refund keeps its reference, so the patient is never paid
+// twice.
+func AdminRetryRefundHandler(w http.ResponseWriter, r *http.Request) {
+	refundID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid refund ID", http.StatusBadRequest)
+		return
+	}
+
+	refund, err := models.GetRefundByID(database.DB, refundID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Refund not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading refund %d: %v", refundID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if refund.Status == models.RefundSucceeded {
+		renderRefunds(w, r, http.StatusOK, refundsPage{Notice: "This refund has already been made."})
+		return
+	}
+
+	if err := processRefund(refund); err != nil {
+		renderRefunds(w, r, http.StatusBadGateway, refundsPage{Error: "The refund failed again: " + err.Error()})
+		return
+	}
+	renderRefunds(w, r, http.StatusOK, refundsPage{
+		Notice: fmt.Sprintf("Refunded %s to %s.", refund.Amount.Format(viewerLocale(r)), refund.PatientName),
+	})
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/money"
+	"online-doctor-appointment/internal/payments"
+)
+
+// stubGateway records the refunds asked of it
+type stubGateway struct {
+	mu      sync.Mutex
+	refunds []payments.RefundRequest
+}
+
+func (g *stubGateway) Name() string { return "stub" }
+
+func (g *stubGateway) CreateOrder(context.Context, payments.OrderRequest) (*payments.Order, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (g *stubGateway) ParseCallback(*http.Request) (*payments.Callback, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (g *stubGateway) Refund(_ context.Context, req payments.RefundRequest) (*payments.Refund, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.refunds = append(g.refunds, req)
+	return &payments.Refund{ID: "stub-" + req.Reference}, nil
+}
+
+// paidAppointment books an appointment and pays amount for it through the
+// stub gateway
+func paidAppointment(t *testing.T, amount money.Money) *models.Payment {
+	t.Helper()
+	db := useTestDB(t)
+	newUser := func(userType string) *models.User {
+		u := &models.User{
+			Email:        fmt.Sprintf("refund-%s-%d@example.com", userType, time.Now().UnixNano()),
+			PasswordHash: "not a hash",
+			FirstName:    "Refund",
+			LastName:     "Test",
+			UserType:     userType,
+		}
+		if err := models.CreateUser(db, u); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, u.ID) })
+		return u
+	}
+
+	doctor := &models.Doctor{UserID: newUser("doctor").ID, Specialty: "Testing", TimeZone: "Asia/Almaty", ConsultationFee: amount}
+	if err := models.CreateDoctor(db, doctor); err != nil {
+		t.Fatal(err)
+	}
+	apt := &models.Appointment{
+		PatientID: newUser("patient").ID,
+		DoctorID:  doctor.ID,
+		StartsAt:  time.Now().UTC().Truncate(time.Hour).AddDate(0, 1, 0),
+		Status:    "cancelled",
+	}
+	if err := models.CreateAppointment(db, apt); err != nil {
+		t.Fatal(err)
+	}
+	payment := &models.Payment{
+		AppointmentID: apt.ID,
+		Reference:     fmt.Sprintf("refund-test-%d", time.Now().UnixNano()),
+		Gateway:       "stub",
+		Amount:        amount,
+	}
+	if err := models.CreatePayment(db, payment); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.SetPaymentOrder(db, payment.ID, "order-"+payment.Reference, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.RecordPaymentResult(db, payment.Reference, "order-"+payment.Reference, models.PaymentPaid, amount, ""); err != nil {
+		t.Fatal(err)
+	}
+	return payment
+}
+
+// useStubGateway swaps in a stub payment gateway for the test
+func useStubGateway(t *testing.T) *stubGateway {
+	gateway := &stubGateway{}
+	old := paymentGateway
+	SetPaymentGateway(gateway)
+	t.Cleanup(func() { SetPaymentGateway(old) })
+	return gateway
+}
+
+// TestRepeatedRefundsPayOnce refunds the same cancelled appointment from
+// several goroutines, as a patient's cancellation and a late webhook
+// might; there is one refund record and every gateway call uses its
+// reference, so the money goes back once
+func TestRepeatedRefundsPayOnce(t *testing.T) {
+	gateway := useStubGateway(t)
+	payment := paidAppointment(t, money.New(1500000, "KZT"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refundAppointment(payment.AppointmentID, 50, "Cancelled by the patient")
+		}()
+	}
+	wg.Wait()
+	refundAppointment(payment.AppointmentID, 100, "Paid after the appointment was cancelled")
+
+	var count int
+	var status, paymentStatus string
+	err := database.DB.QueryRow(`
+		SELECT COUNT(*) OVER (), r.status, p.status
+		FROM refunds r JOIN payments p ON r.payment_id = p.id
+		WHERE r.payment_id = $1
+	`, payment.ID).Scan(&count, &status, &paymentStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || status != models.RefundSucceeded || paymentStatus != models.PaymentPartlyRefunded {
+		t.Errorf("%d refunds, %s, payment %s; want 1 succeeded refund of a partially refunded payment", count, status, paymentStatus)
+	}
+
+	if len(gateway.refunds) == 0 {
+		t.Fatal("the gateway was never asked for the refund")
+	}
+	for _, req := range gateway.refunds {
+		if req.Reference != gateway.refunds[0].Reference || req.Amount != money.New(750000, "KZT") {
+			t.Errorf("gateway asked to refund %s under %s; first request was %s under %s",
+				req.Amount, req.Reference, gateway.refunds[0].Amount, gateway.refunds[0].Reference)
+		}
+	}
+}
+
+// TestNothingToRefund leaves payments alone when the policy gives nothing
+// back
+func TestNothingToRefund(t *testing.T) {
+	gateway := useStubGateway(t)
+	payment := paidAppointment(t, money.New(1500000, "KZT"))
+
+	refundAppointment(payment.AppointmentID, 0, "Cancelled by the patient")
+
+	var count int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM refunds WHERE payment_id = $1`, payment.ID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || len(gateway.refunds) != 0 {
+		t.Errorf("a 0%% refund recorded %d refunds and made %d gateway calls", count, len(gateway.refunds))
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/csrf"
+	"online-doctor-appointment/templates"
+)
+
+// pages maps a page file name (e.g. "login.html") to its parsed template,
+// which also contains the shared layout and partials
+var pages map[string]*template.Template
+
+// pageData is what every page template is executed with
+type pageData struct {
+	CSRFToken string
+	Principal auth.Principal
+	Locale    string // for formatting amounts of money
+	Data      interface{}
+}
+
+// LoadTemplates parses every page in the embedded templates directory
+// together with base.html and partials.html. It must be called once at
+// startup, before the server starts handling requests.
+func LoadTemplates() error {
+	layout, err := template.ParseFS(templates.FS, "base.html", "partials.html")
+	if err != nil {
+		return fmt.Errorf("parsing layout: %w", err)
+	}
+
+	names, err := fs.Glob(templates.FS, "*.html")
+	if err != nil {
+		return err
+	}
+
+	parsed := make(map[string]*template.Template)
+	for _, name := range names {
+		if name == "base.html" || name == "partials.html" {
+			continue
+		}
+
+		page, err := template.Must(layout.Clone()).ParseFS(templates.FS, name)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", name, err)
+		}
+		parsed[name] = page
+	}
+
+	pages = parsed
+	return nil
+}
+
+// render executes the named page inside the base layout. html/template
+// escapes every value according to where it appears in the page, so data
+// must be passed as plain strings rather than pre-built HTML.
+func render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	renderStatus(w, r, http.StatusOK, name, data)
+}
+
+// renderStatus is render with a response status other than 200 OK, e.g.
+// for a form redisplayed with an error
+func renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	page, ok := pages[name]
+	if !ok {
+		log.Printf("Template %s not found", name)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	principal, _ := auth.FromContext(r.Context())
+
+	// Render into a buffer so a failing template doesn't send half a page
+	var buf bytes.Buffer
+	err := page.ExecuteTemplate(&buf, "base", pageData{
+		CSRFToken: csrf.Token(r),
+		Principal: principal,
+		Locale:    viewerLocale(r),
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("Error rendering %s: %v", name, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
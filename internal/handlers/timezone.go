@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/schedule"
+)
+
+// timeZoneCookie holds the IANA time zone of the viewer's browser, set by
+// the script in base.html
+const timeZoneCookie = "tz"
+
+// clinicLocation is the clinic's time zone, used for new doctors and for
+// viewers whose browser hasn't reported a zone
+var clinicLocation = time.UTC
+
+// SetClinicLocation sets the clinic's time zone
+func SetClinicLocation(loc *time.Location) {
+	clinicLocation = loc
+}
+
+// viewerLocation returns the time zone to show times in: the ?tz= query
+// parameter, then the browser's zone cookie, then the clinic's zone
+func viewerLocation(r *http.Request) *time.Location {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		if cookie, err := r.Cookie(timeZoneCookie); err == nil {
+			name, _ = url.QueryUnescape(cookie.Value)
+		}
+	}
+	return schedule.LocationOr(name, clinicLocation)
+}
+
+// localize shows appointments in the viewer's time zone
+func localize(r *http.Request, appointments []models.Appointment) {
+	loc := viewerLocation(r)
+	for i := range appointments {
+		appointments[i].Localize(loc)
+	}
+}
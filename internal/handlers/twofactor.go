@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/ratelimit"
+	"online-doctor-appointment/internal/totp"
+)
+
+// twoFactorCookie carries the signed user ID between the password step and
+// the code step of a two-factor login
+const twoFactorCookie = "login_2fa"
+
+// twoFactorLoginTTL is how long the user has to enter their code after
+// entering their password
+const twoFactorLoginTTL = 5 * time.Minute
+
+// recoveryCodeCount is how many recovery codes are issued at a time
+const recoveryCodeCount = 10
+
+// totpIssuer is the account name prefix shown in authenticator apps
+var totpIssuer = "Online Doctor Appointment"
+
+// twoFactorRequiredRoles are the roles that must enroll before they can
+// use their dashboard
+var twoFactorRequiredRoles = map[string]bool{}
+
+// twoFactorLimiter throttles code attempts per user
+var twoFactorLimiter = ratelimit.New(5, 5*time.Minute)
+
+// ConfigureTwoFactor sets the issuer shown in authenticator apps and the
+// roles for which two-factor authentication is mandatory
+func ConfigureTwoFactor(issuer string, requiredRoles []string) {
+	totpIssuer = issuer
+	twoFactorRequiredRoles = map[string]bool{}
+	for _, role := range requiredRoles {
+		twoFactorRequiredRoles[role] = true
+	}
+}
+
+// twoFactorPage is the data for security-2fa.html
+type twoFactorPage struct {
+	Enabled        bool
+	Required       bool // the user's role may not turn two-factor off
+	Secret         string
+	URI            template.URL // otpauth:// provisioning URI for authenticator apps
+	RecoveryCodes  []string     // shown once, right after they are generated
+	RemainingCodes int
+	Error          string
+}
+
+// startTwoFactorLogin remembers that user passed the password check and
+// sends them to the code step
+func startTwoFactorLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	expireAt := time.Now().Add(twoFactorLoginTTL)
+	setTwoFactorCookie(w, auth.Sign(signingKey, "2fa:"+strconv.Itoa(user.ID), expireAt), expireAt)
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+// setTwoFactorCookie writes the pending two-factor login cookie
+func setTwoFactorCookie(w http.ResponseWriter, value string, expireAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     twoFactorCookie,
+		Value:    value,
+		Expires:  expireAt,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/login",
+	})
+}
+
+// pendingTwoFactorUser returns the user ID from a valid pending login cookie
+func pendingTwoFactorUser(r *http.Request) (int, bool) {
+	cookie, err := r.Cookie(twoFactorCookie)
+	if err != nil {
+		return 0, false
+	}
+
+	payload, err := auth.Verify(signingKey, cookie.Value, time.Now())
+	if err != nil {
+		return 0, false
+	}
+
+	idStr, ok := strings.CutPrefix(payload, "2fa:")
+	if !ok {
+		return 0, false
+	}
+	userID, err := strconv.Atoi(idStr)
+	return userID, err == nil
+}
+
+// TwoFactorLoginPageHandler serves the code step of a two-factor login
+func TwoFactorLoginPageHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := pendingTwoFactorUser(r); !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	render(w, r, "login-2fa.html", struct {
+		Error string
+	}{""})
+}
+
+// TwoFactorLoginHandler checks the authenticator or recovery code and
+// creates the session
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pendingTwoFactorUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	renderError := func(status int, msg string) {
+		renderStatus(w, r, status, "login-2fa.html", struct {
+			Error string
+		}{msg})
+	}
+
+	if !twoFactorLimiter.Allow(strconv.Itoa(userID)) {
+		renderError(http.StatusTooManyRequests, "Too many attempts. Please wait a few minutes and try again.")
+		return
+	}
+
+	valid, err := checkTwoFactorCode(userID, r.FormValue("code"))
+	if err != nil {
+		log.Printf("Error checking two-factor code: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		renderError(http.StatusUnauthorized, "Invalid or already used code.")
+		return
+	}
+
+	user, err := models.GetUserByID(database.DB, userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	setTwoFactorCookie(w, "", time.Now().Add(-time.Hour))
+	startSession(w, r, user)
+}
+
+// checkTwoFactorCode accepts either a current authenticator code or an
+// unused recovery code. Each code works only once.
+func checkTwoFactorCode(userID int, code string) (bool, error) {
+	code = normalizeCode(code)
+
+	if len(code) != totp.Digits || strings.Trim(code, "0123456789") != "" {
+		return models.UseRecoveryCode(database.DB, userID, auth.HashToken(code))
+	}
+
+	enrollment, err := models.GetTOTP(database.DB, userID)
+	if err == sql.ErrNoRows || (err == nil && !enrollment.IsEnabled()) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return models.UseTOTPStep(database.DB, userID, step)
+}
+
+// normalizeCode strips the separators users tend to type into codes
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// newRecoveryCodes returns fresh recovery codes for display and their hashes
+// for storage
+func newRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = auth.HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// TwoFactorSettingsHandler shows the user's two-factor status, or a new
+// secret to enroll if they haven't set it up yet
+func TwoFactorSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	page, err := loadTwoFactorPage(principal)
+	if err != nil {
+		log.Printf("Error loading two-factor settings: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, r, "security-2fa.html", page)
+}
+
+// loadTwoFactorPage builds the settings page for principal, generating a
+// pending secret when they are not enrolled
+func loadTwoFactorPage(principal auth.Principal) (*twoFactorPage, error) {
+	page := &twoFactorPage{Required: twoFactorRequiredRoles[principal.Role]}
+
+	enrollment, err := models.GetTOTP(database.DB, principal.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if enrollment != nil && enrollment.IsEnabled() {
+		page.Enabled = true
+		page.RemainingCodes, err = models.CountRecoveryCodes(database.DB, principal.UserID)
+		return page, err
+	}
+
+	// Keep showing the same pending secret until it is confirmed
+	secret := ""
+	if enrollment != nil {
+		secret = enrollment.Secret
+	} else {
+		if secret, err = totp.GenerateSecret(); err != nil {
+			return nil, err
+		}
+		if err := models.SaveTOTPSecret(database.DB, principal.UserID, secret); err != nil {
+			return nil, err
+		}
+	}
+
+	page.Secret = secret
+	// html/template only trusts http(s) and mailto links; this URI is built
+	// by the totp package with every component escaped
+	page.URI = template.URL(totp.ProvisioningURI(totpIssuer, principal.Email, secret))
+	return page, nil
+}
+
+// EnableTwoFactorHandler confirms enrollment with a first code and shows
+// the recovery codes
+func EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	enrollment, err := models.GetTOTP(database.DB, principal.UserID)
+	if err == sql.ErrNoRows || (err == nil && enrollment.IsEnabled()) {
+		http.Redirect(w, r, "/dashboard/security/2fa", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading two-factor enrollment: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, normalizeCode(r.FormValue("code")), time.Now())
+	if !ok {
+		page, err := loadTwoFactorPage(principal)
+		if err != nil {
+			log.Printf("Error loading two-factor settings: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		page.Error = "That code didn't match. Check the time on your phone and try again."
+		renderStatus(w, r, http.StatusBadRequest, "security-2fa.html", page)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := models.EnableTOTP(database.DB, principal.UserID, step, hashes); err != nil {
+		log.Printf("Error enabling two-factor authentication: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, r, "security-2fa.html", &twoFactorPage{
+		Enabled:        true,
+		Required:       twoFactorRequiredRoles[principal.Role],
+		RecoveryCodes:  codes,
+		RemainingCodes: len(codes),
+	})
+}
+
+// RegenerateRecoveryCodesHandler replaces the user's recovery codes after
+// checking a current code
+func RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	if !confirmTwoFactorChange(w, r, principal) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := models.ReplaceRecoveryCodes(database.DB, principal.UserID, hashes); err != nil {
+		log.Printf("Error saving recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, r, "security-2fa.html", &twoFactorPage{
+		Enabled:        true,
+		Required:       twoFactorRequiredRoles[principal.Role],
+		RecoveryCodes:  codes,
+		RemainingCodes: len(codes),
+	})
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off after
+// checking a current code. Roles that require it can't turn it off.
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	if twoFactorRequiredRoles[principal.Role] {
+		http.Error(w, "Two-factor authentication is required for your account", http.StatusForbidden)
+		return
+	}
+
+	if !confirmTwoFactorChange(w, r, principal) {
+		return
+	}
+
+	if err := models.DisableTOTP(database.DB, principal.UserID); err != nil {
+		log.Printf("Error disabling two-factor authentication: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard/security/2fa", http.StatusSeeOther)
+}
+
+// confirmTwoFactorChange checks the code submitted with a settings change
+// and renders the settings page with an error if it is wrong
+func confirmTwoFactorChange(w http.ResponseWriter, r *http.Request, principal auth.Principal) bool {
+	if !twoFactorLimiter.Allow(strconv.Itoa(principal.UserID)) {
+		http.Error(w, "Too many attempts. Please wait a few minutes and try again.", http.StatusTooManyRequests)
+		return false
+	}
+
+	valid, err := checkTwoFactorCode(principal.UserID, r.FormValue("code"))
+	if err != nil {
+		log.Printf("Error checking two-factor code: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if valid {
+		return true
+	}
+
+	page, err := loadTwoFactorPage(principal)
+	if err != nil {
+		log.Printf("Error loading two-factor settings: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	page.Error = "Invalid or already used code."
+	renderStatus(w, r, http.StatusBadRequest, "security-2fa.html", page)
+	return false
+}
+
+// RequireTwoFactorEnrollment sends users whose role requires two-factor
+// authentication to the enrollment page until they have set it up
+func RequireTwoFactorEnrollment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := auth.FromContext(r.Context())
+		if ok && twoFactorRequiredRoles[principal.Role] {
+			enabled, err := models.TOTPEnabled(database.DB, principal.UserID)
+			if err != nil {
+				log.Printf("Error checking two-factor status: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !enabled {
+				http.Redirect(w, r, "/dashboard/security/2fa", http.StatusSeeOther)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
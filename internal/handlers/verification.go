@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/mail"
+	"online-doctor-appointment/internal/models"
+	"online-doctor-appointment/internal/ratelimit"
+)
+
+// verificationTTL is how long an email verification link stays valid
+var verificationTTL = 48 * time.Hour
+
+// resendLimiter throttles verification email resends per user
+var resendLimiter = ratelimit.New(3, time.Hour)
+
+// ConfigureEmailVerification sets how long verification links stay valid
+// and how many resends a user may request per window
+func ConfigureEmailVerification(ttl time.Duration, resendLimit int, resendWindow time.Duration) {
+	verificationTTL = ttl
+	resendLimiter = ratelimit.New(resendLimit, resendWindow)
+}
+
+// verificationNotices are the messages shown after a resend request
+var verificationNotices = map[string]string{
+	"sent":    "We've sent a new verification link. Please check your inbox.",
+	"limited": "Too many verification emails requested. Please try again later.",
+}
+
+// sendVerificationEmail emails user a signed link confirming their address
+func sendVerificationEmail(user *models.User) {
+	payload := strconv.Itoa(user.ID) + ":" + user.Email
+	token := auth.Sign(signingKey, payload, time.Now().Add(verificationTTL))
+
+	link := baseURL + "/verify-email?token=" + url.QueryEscape(token)
+	sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: "Hello " + user.FirstName + ",\n\n" +
+			"Please confirm your email address for Online Doctor Appointment by opening the link below.\n" +
+			"You need a confirmed address to book appointments. The link expires in " + verificationTTL.String() + ".\n\n" +
+			link + "\n",
+	})
+}
+
+// VerifyEmailHandler confirms an email address from a signed link
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	verified := false
+
+	payload, err := auth.Verify(signingKey, r.URL.Query().Get("token"), time.Now())
+	if err == nil {
+		idStr, email, _ := strings.Cut(payload, ":")
+		userID, _ := strconv.Atoi(idStr)
+
+		if err := models.MarkEmailVerified(database.DB, userID, email); err != nil {
+			log.Printf("Error marking email verified: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		verified = true
+	}
+
+	render(w, r, "verify-email.html", struct {
+		Verified bool
+	}{verified})
+}
+
+// ResendVerificationHandler sends a fresh verification link to the
+// logged-in user, subject to the resend rate limit
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	user, err := models.GetUserByID(database.DB, principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	notice := "sent"
+	if user.IsEmailVerified() {
+		notice = ""
+	} else if !resendLimiter.Allow(strconv.Itoa(user.ID)) {
+		notice = "limited"
+	} else {
+		sendVerificationEmail(user)
+	}
+
+	target := "/dashboard/" + principal.Role
+	if notice != "" {
+		target += "?verification=" + notice
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
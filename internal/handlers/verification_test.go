@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/models"
+)
+
+// emailVerified reports whether the user has confirmed their address
+func emailVerified(t *testing.T, userID int) bool {
+	t.Helper()
+	user, err := models.GetUserByID(database.DB, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.IsEmailVerified()
+}
+
+func TestVerifyEmail(t *testing.T) {
+	db := useTestDB(t)
+	mails := useMemoryMailer(t)
+	user := createTestUser(t, db, "patient")
+	sendVerificationEmail(user)
+	token := emailedToken(t, mails, user.Email)
+	payload := strconv.Itoa(user.ID) + ":" + user.Email
+
+	for _, tt := range []struct {
+		name  string
+		token string
+	}{
+		{"tampered", token[:len(token)-1] + string(token[len(token)-1]^1)},
+		{"expired", auth.Sign(signingKey, payload, time.Now().Add(-time.Second))},
+		{"signed with another key", auth.Sign([]byte("another key"), payload, time.Now().Add(time.Hour))},
+		{"for an old address", auth.Sign(signingKey, strconv.Itoa(user.ID)+":old@example.com", time.Now().Add(time.Hour))},
+		{"missing", ""},
+	} {
+		rec := httptest.NewRecorder()
+		VerifyEmailHandler(rec, httptest.NewRequest("GET", "/verify-email?token="+url.QueryEscape(tt.token), nil))
+		if rec.Code != http.StatusOK || emailVerified(t, user.ID) {
+			t.Errorf("%s link: status %d, verified %v", tt.name, rec.Code, emailVerified(t, user.ID))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	VerifyEmailHandler(rec, httptest.NewRequest("GET", "/verify-email?token="+url.QueryEscape(token), nil))
+	if rec.Code != http.StatusOK || !emailVerified(t, user.ID) {
+		t.Errorf("emailed link: status %d, verified %v", rec.Code, emailVerified(t, user.ID))
+	}
+}
+
+func TestResendVerificationLimit(t *testing.T) {
+	db := useTestDB(t)
+	mails := useMemoryMailer(t)
+	oldTTL, oldLimiter := verificationTTL, resendLimiter
+	ConfigureEmailVerification(time.Hour, 2, time.Hour)
+	t.Cleanup(func() { verificationTTL, resendLimiter = oldTTL, oldLimiter })
+
+	user := createTestUser(t, db, "patient")
+	principal := &auth.Principal{UserID: user.ID, Role: "patient", Email: user.Email}
+	for i, want := range []string{"sent", "sent", "limited", "limited"} {
+		rec := postForm(ResendVerificationHandler, "/dashboard/verify-email/resend", nil, principal)
+		if to := rec.Header().Get("Location"); to != "/dashboard/patient?verification="+want {
+			t.Errorf("resend %d went to %q, want %s", i+1, to, want)
+		}
+	}
+	if n := len(mails.Messages()); n != 2 {
+		t.Errorf("%d emails sent, want 2", n)
+	}
+
+	// Nothing is sent once the address is confirmed
+	if err := models.MarkEmailVerified(db, user.ID, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	rec := postForm(ResendVerificationHandler, "/dashboard/verify-email/resend", nil, principal)
+	if to := rec.Header().Get("Location"); to != "/dashboard/patient" || len(mails.Messages()) != 2 {
+		t.Errorf("resend for a confirmed address went to %q and sent %d emails", to, len(mails.Messages())-2)
+	}
+}
+
+// TestBookingNeedsVerifiedEmail checks patients can't book until they
+// confirm their address
+func TestBookingNeedsVerifiedEmail(t *testing.T) {
+	db := useTestDB(t)
+	doctor := createTestDoctor(t, db)
+	patient := createTestUser(t, db, "patient")
+
+	rec := postForm(BookAppointmentHandler, "/dashboard/patient/book", url.Values{
+		"doctor_id": {strconv.Itoa(doctor.ID)},
+		"starts_at": {time.Now().Add(48 * time.Hour).Truncate(time.Hour).Format(time.RFC3339)},
+	}, &auth.Principal{UserID: patient.ID, Role: "patient"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("booking with an unconfirmed email answered %d, want 403", rec.Code)
+	}
+}
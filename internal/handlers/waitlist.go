@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"online-doctor-appointment/internal/appointment"
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/database"
+	"online-doctor-appointment/internal/mail"
+	"online-doctor-appointment/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// waitlistClaimTTL is how long a waitlisted patient has to claim a freed
+// slot before it is offered to the next patient in line
+var waitlistClaimTTL = 30 * time.Minute
+
+// maxWaitlistDays limits the date range of a waitlist entry
+const maxWaitlistDays = 90
+
+// SetWaitlistClaimTTL sets how long waitlist offers stay open
+func SetWaitlistClaimTTL(ttl time.Duration) {
+	waitlistClaimTTL = ttl
+}
+
+// StartWaitlistSweeper periodically closes expired waitlist offers and
+// offers their slots to the next patients in line, until the returned stop
+// function is called
+func StartWaitlistSweeper(interval time.Duration) (stop func()) {
+	return runEvery(interval, func(now time.Time) {
+		expired, err := models.ExpireWaitlistOffers(database.DB, now)
+		if err != nil {
+			log.Printf("Error expiring waitlist offers: %v", err)
+			return
+		}
+		for _, o := range expired {
+			offerFreedSlot(o.DoctorID, o.StartsAt)
+		}
+	})
+}
+
+// releaseCancelledSlot offers the slot of a just-cancelled appointment to
+// the waitlist
+func releaseCancelledSlot(appointmentID int) {
+	apt, err := models.GetAppointmentByID(database.DB, appointmentID)
+	if err != nil {
+		log.Printf("Error loading cancelled appointment %d: %v", appointmentID, err)
+		return
+	}
+	if apt.Status == string(appointment.Cancelled) {
+		offerFreedSlot(apt.DoctorID, apt.StartsAt)
+	}
+}
+
+// offerFreedSlot offers a slot that has become free to the first patient
+// waiting for it, if the doctor still offers the slot, and emails them a
+// claim link
+func offerFreedSlot(doctorID int, startsAt time.Time) {
+	settings, err := models.GetScheduleSettings(database.DB, doctorID)
+	if err != nil {
+		log.Printf("Error loading schedule settings: %v", err)
+		return
+	}
+
+	// The slot may have gone with a schedule change, or be in the past
+	date := startsAt.In(settings.Location()).Format("2006-01-02")
+	slots, err := models.GetAvailableTimeSlots(database.DB, doctorID, date, 0)
+	if err != nil {
+		log.Printf("Error loading time slots: %v", err)
+		return
+	}
+	if !slices.ContainsFunc(slots, startsAt.Equal) {
+		return
+	}
+
+	offer, err := models.OfferSlot(database.DB, doctorID, startsAt, waitlistClaimTTL)
+	if err != nil {
+		log.Printf("Error offering slot to the waitlist: %v", err)
+		return
+	}
+	if offer != nil {
+		sendWaitlistOffer(offer)
+	}
+}
+
+// sendWaitlistOffer emails a patient a signed link to claim an offered slot
+func sendWaitlistOffer(offer *models.WaitlistOffer) {
+	token := auth.Sign(signingKey, "waitlist:"+strconv.Itoa(offer.ID), offer.ExpiresAt)
+	link := baseURL + "/dashboard/patient/waitlist/claim?token=" + url.QueryEscape(token)
+
+	const layout = "Monday, 2 January 2006 at 15:04 MST"
+	sendMail(mail.Message{
+		To:      offer.Patient.Email,
+		Subject: "A slot with Dr. " + offer.Doctor.User.GetFullName() + " has opened up",
+		Body: "Hello " + offer.Patient.FirstName + ",\n\n" +
+			"A slot you were waiting for is free: " + offer.StartsAt.Format(layout) + " with Dr. " +
+			offer.Doctor.User.GetFullName() + " (" + offer.Doctor.Specialty + ").\n" +
+			"It is reserved for you until " + offer.ExpiresAt.In(offer.StartsAt.Location()).Format("15:04 MST") +
+			". After that it goes to the next patient on the waitlist.\n\n" +
+			"Book it here:\n" + link + "\n",
+	})
+}
+
+// waitlistOfferFromToken returns the offer a claim link is for, if it is
+// still open for the patient
+func waitlistOfferFromToken(token string, patientID int) (*models.WaitlistOffer, error) {
+	payload, err := auth.Verify(signingKey, token, time.Now())
+	if err != nil {
+		return nil, models.ErrOfferUnavailable
+	}
+	idStr, ok := strings.CutPrefix(payload, "waitlist:")
+	if !ok {
+		return nil, models.ErrOfferUnavailable
+	}
+	offerID, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil, models.ErrOfferUnavailable
+	}
+
+	offer, err := models.GetWaitlistOfferByID(database.DB, offerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrOfferUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if offer.PatientID != patientID || offer.Status != models.OfferOpen || !time.Now().Before(offer.ExpiresAt) {
+		return nil, models.ErrOfferUnavailable
+	}
+	return offer, nil
+}
+
+// waitlistClaimPage is the data for waitlist-claim.html
+type waitlistClaimPage struct {
+	Offer         *models.WaitlistOffer // nil when the link is no longer valid
+	Token         string
+	OnlinePayment bool
+	Error         string
+}
+
+// WaitlistClaimPageHandler shows the slot a claim link offers
+func WaitlistClaimPageHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	token := r.URL.Query().Get("token")
+	offer, err := waitlistOfferFromToken(token, principal.UserID)
+	if err != nil && !errors.Is(err, models.ErrOfferUnavailable) {
+		log.Printf("Error loading waitlist offer: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if offer != nil {
+		offer.StartsAt = offer.StartsAt.In(viewerLocation(r))
+	}
+
+	render(w, r, "waitlist-claim.html", waitlistClaimPage{Offer: offer, Token: token, OnlinePayment: paymentGateway != nil})
+}
+
+// ClaimWaitlistOfferHandler books the slot a claim link offers
+func ClaimWaitlistOfferHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	// Claims are bookings, so the same rules apply
+	user, err := models.GetUserByID(database.DB, principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+	if !user.IsEmailVerified() {
+		http.Error(w, "Please verify your email address before booking an appointment.", http.StatusForbidden)
+		return
+	}
+
+	token := r.FormValue("token")
+	offer, err := waitlistOfferFromToken(token, principal.UserID)
+	var doctor *models.Doctor
+	if err == nil {
+		doctor, err = models.GetDoctorByID(database.DB, offer.DoctorID)
+	}
+	if err == nil && !doctor.IsBookable() {
+		err = models.ErrOfferUnavailable
+	}
+
+	// Appointments paid online wait for the payment instead of the doctor
+	payOnline := r.FormValue("pay") == "online" && paymentGateway != nil
+	status := appointment.Pending
+	if payOnline {
+		status = appointment.PendingPayment
+	}
+	apt := &models.Appointment{
+		PatientID: principal.UserID,
+		Status:    string(status),
+		Notes:     strings.TrimSpace(r.FormValue("notes")),
+	}
+	if err == nil {
+		err = models.ClaimWaitlistOffer(database.DB, offer.ID, apt)
+	}
+	switch {
+	case errors.Is(err, models.ErrOfferUnavailable), errors.Is(err, models.ErrSlotUnavailable):
+		renderStatus(w, r, http.StatusConflict, "waitlist-claim.html", waitlistClaimPage{
+			Error: "Sorry, this slot is no longer available. You are still on the waitlist.",
+		})
+		return
+	case err != nil:
+		log.Printf("Error claiming waitlist offer: %v", err)
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+
+	// The fee is charged on the server, never taken from the form
+	if payOnline {
+		startPayment(w, r, apt, doctor)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard/patient/appointments?notice=claimed", http.StatusSeeOther)
+}
+
+// JoinWaitlistHandler puts the patient in line for a doctor on a range of
+// dates
+func JoinWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	doctorID, _ := strconv.Atoi(r.FormValue("doctor_id"))
+	doctor, err := models.GetDoctorByID(database.DB, doctorID)
+	if err != nil || !doctor.IsBookable() {
+		http.Error(w, "This doctor is not available for booking.", http.StatusBadRequest)
+		return
+	}
+
+	entry := &models.WaitlistEntry{
+		DoctorID:  doctorID,
+		PatientID: principal.UserID,
+		FromDate:  r.FormValue("from_date"),
+		ToDate:    r.FormValue("to_date"),
+	}
+	if entry.ToDate == "" {
+		entry.ToDate = entry.FromDate
+	}
+
+	today := time.Now().In(doctor.Location()).Format("2006-01-02")
+	from, err := time.Parse("2006-01-02", entry.FromDate)
+	if err != nil || entry.FromDate < today {
+		http.Error(w, "Please choose a start date from today on.", http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse("2006-01-02", entry.ToDate)
+	if err != nil || to.Before(from) || to.Sub(from) > maxWaitlistDays*24*time.Hour {
+		http.Error(w, "The end date must be on or after the start date, at most 90 days later.", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.JoinWaitlist(database.DB, entry); err != nil {
+		log.Printf("Error joining waitlist: %v", err)
+		http.Error(w, "Failed to join the waitlist", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard/patient/appointments?notice=waitlisted", http.StatusSeeOther)
+}
+
+// LeaveWaitlistHandler takes the patient out of line
+func LeaveWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	entryID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid waitlist entry ID", http.StatusBadRequest)
+		return
+	}
+
+	err = models.LeaveWaitlist(database.DB, entryID, principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Waitlist entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error leaving waitlist %d: %v", entryID, err)
+		http.Error(w, "Failed to leave the waitlist", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard/patient/appointments?notice=left-waitlist", http.StatusSeeOther)
+}
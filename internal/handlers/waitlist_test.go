@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/auth"
+	"online-doctor-appointment/internal/models"
+)
+
+// offerTestSlot puts a new patient on the waitlist of a new doctor and
+// offers them a slot next week. It returns the offer and its claim token.
+func offerTestSlot(t *testing.T, db *sql.DB) (*models.WaitlistOffer, string) {
+	t.Helper()
+	doctor := createTestDoctor(t, db)
+	startsAt := nextWeek(t, doctor, "09:00")
+	addWorkingHours(t, db, doctor, startsAt.Weekday(), "09:00", "11:00")
+
+	patient := createTestUser(t, db, "patient")
+	if err := models.MarkEmailVerified(db, patient.ID, patient.Email); err != nil {
+		t.Fatal(err)
+	}
+	date := startsAt.Format("2006-01-02")
+	if err := models.JoinWaitlist(db, &models.WaitlistEntry{DoctorID: doctor.ID, PatientID: patient.ID, FromDate: date, ToDate: date}); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := models.OfferSlot(db, doctor.ID, startsAt, time.Hour)
+	if err != nil || offer == nil {
+		t.Fatalf("OfferSlot = %v, %v", offer, err)
+	}
+	return offer, auth.Sign(signingKey, "waitlist:"+strconv.Itoa(offer.ID), offer.ExpiresAt)
+}
+
+// claim posts a claim link's token as the patient
+func claim(token string, patientID int) *httptest.ResponseRecorder {
+	return postForm(ClaimWaitlistOfferHandler, "/dashboard/patient/waitlist/claim", url.Values{
+		"token": {token},
+	}, &auth.Principal{UserID: patientID, Role: "patient"})
+}
+
+// bookingsOf counts the patient's appointments
+func bookingsOf(t *testing.T, db *sql.DB, patientID int) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM appointments WHERE patient_id = $1`, patientID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestClaimWaitlistOffer(t *testing.T) {
+	db := useTestDB(t)
+	offer, token := offerTestSlot(t, db)
+
+	if rec := claim(token, offer.PatientID); rec.Code != http.StatusSeeOther {
+		t.Fatalf("claim answered %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := claim(token, offer.PatientID); rec.Code != http.StatusConflict {
+		t.Errorf("second claim answered %d, want 409", rec.Code)
+	}
+	if n := bookingsOf(t, db, offer.PatientID); n != 1 {
+		t.Errorf("claiming twice booked %d appointments, want 1", n)
+	}
+}
+
+func TestClaimWaitlistOfferByAnotherPatient(t *testing.T) {
+	db := useTestDB(t)
+	offer, token := offerTestSlot(t, db)
+	other := createTestUser(t, db, "patient")
+	if err := models.MarkEmailVerified(db, other.ID, other.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := claim(token, other.ID); rec.Code != http.StatusConflict {
+		t.Errorf("claim by another patient answered %d, want 409", rec.Code)
+	}
+	if n := bookingsOf(t, db, other.ID); n != 0 {
+		t.Errorf("another patient booked %d appointments through the offer", n)
+	}
+	if rec := claim(token, offer.PatientID); rec.Code != http.StatusSeeOther {
+		t.Errorf("the offer's own patient could no longer claim it: %d", rec.Code)
+	}
+}
+
+func TestClaimExpiredWaitlistOffer(t *testing.T) {
+	db := useTestDB(t)
+	offer, _ := offerTestSlot(t, db)
+	if _, err := db.Exec(`UPDATE waitlist_offers SET expires_at = CURRENT_TIMESTAMP - INTERVAL '1 minute' WHERE id = $1`, offer.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Even a link that hasn't expired yet can't claim an expired offer
+	token := auth.Sign(signingKey, "waitlist:"+strconv.Itoa(offer.ID), time.Now().Add(time.Hour))
+	if rec := claim(token, offer.PatientID); rec.Code != http.StatusConflict {
+		t.Errorf("claiming an expired offer answered %d, want 409", rec.Code)
+	}
+	if n := bookingsOf(t, db, offer.PatientID); n != 0 {
+		t.Errorf("expired offer booked %d appointments", n)
+	}
+}
+
+func TestClaimNeedsVerifiedEmail(t *testing.T) {
+	db := useTestDB(t)
+	offer, token := offerTestSlot(t, db)
+	if _, err := db.Exec(`UPDATE users SET email_verified_at = NULL WHERE id = $1`, offer.PatientID); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := claim(token, offer.PatientID); rec.Code != http.StatusForbidden {
+		t.Errorf("claim with an unconfirmed email answered %d, want 403", rec.Code)
+	}
+}
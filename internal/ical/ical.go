@@ -0,0 +1,193 @@
+// Package ical reads events from iCalendar (RFC 5545) files, enough to
+// import holiday calendars published by governments and calendar apps.
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNoCalendar is returned when the input has no VCALENDAR object
+var ErrNoCalendar = errors.New("ical: no VCALENDAR found")
+
+// Event is a single VEVENT. Recurrence rules are not expanded; holiday
+// calendars list each occurrence as its own event.
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time // exclusive; equals Start when the event has no end
+	AllDay  bool      // DTSTART is a DATE rather than a DATE-TIME
+}
+
+// Days returns the calendar dates the event covers, in the event's own
+// zone. All-day events end the day before End.
+func (e Event) Days() []time.Time {
+	first := dateOf(e.Start)
+	last := first
+	if e.End.After(e.Start) {
+		end := e.End
+		if e.AllDay || end.Equal(dateOf(end)) {
+			end = end.AddDate(0, 0, -1)
+		}
+		last = dateOf(end)
+	}
+
+	var days []time.Time
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+// dateOf returns midnight of t's date in t's location
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// property is one content line: NAME;PARAM=VALUE:value
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads every VEVENT in r. Events without a DTSTART are skipped.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var current *Event
+	found := false
+
+	for n, line := range lines {
+		if line == "" {
+			continue
+		}
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("ical: line %d: %w", n+1, err)
+		}
+
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VCALENDAR"):
+			found = true
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT"):
+			current = &Event{}
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT"):
+			if current != nil && !current.Start.IsZero() {
+				if current.End.IsZero() {
+					current.End = current.Start
+				}
+				events = append(events, *current)
+			}
+			current = nil
+		case current == nil:
+			continue
+		case prop.name == "UID":
+			current.UID = unescape(prop.value)
+		case prop.name == "SUMMARY":
+			current.Summary = unescape(prop.value)
+		case prop.name == "DTSTART":
+			t, allDay, err := parseTime(prop)
+			if err != nil {
+				return nil, fmt.Errorf("ical: line %d: %w", n+1, err)
+			}
+			current.Start, current.AllDay = t, allDay
+		case prop.name == "DTEND":
+			t, _, err := parseTime(prop)
+			if err != nil {
+				return nil, fmt.Errorf("ical: line %d: %w", n+1, err)
+			}
+			current.End = t
+		}
+	}
+
+	if !found {
+		return nil, ErrNoCalendar
+	}
+	return events, nil
+}
+
+// unfold joins continuation lines, which start with a space or tab
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine splits a content line into its name, parameters and value
+func parseLine(line string) (property, error) {
+	// The value starts at the first colon outside a quoted parameter value
+	colon := -1
+	quoted := false
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, errors.New("missing ':'")
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	prop := property{
+		name:   strings.ToUpper(parts[0]),
+		params: make(map[string]string),
+		value:  line[colon+1:],
+	}
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			prop.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return prop, nil
+}
+
+// parseTime reads a DATE or DATE-TIME value. Floating times and unknown
+// TZIDs are read in the server's local zone.
+func parseTime(prop property) (time.Time, bool, error) {
+	value := prop.value
+	if prop.params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	loc := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// unescape decodes TEXT values
+func unescape(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, `;`, `\,`, `,`, `\n`, "\n", `\N`, "\n").Replace(s)
+}
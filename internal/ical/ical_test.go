@@ -0,0 +1,128 @@
+package ical
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseFixture parses a file in testdata, with its line endings turned
+// into CRLF when crlf is set
+func parseFixture(t *testing.T, name string, crlf bool) []Event {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if crlf {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	events, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// dates formats the days an event covers
+func dates(e Event) []string {
+	var out []string
+	for _, d := range e.Days() {
+		out = append(out, d.Format("2006-01-02"))
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	almaty, err := time.LoadLocation("Asia/Almaty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, crlf := range []bool{false, true} {
+		name := "LF"
+		if crlf {
+			name = "CRLF"
+		}
+		t.Run(name, func(t *testing.T) {
+			events := parseFixture(t, "holidays.ics", crlf)
+			if len(events) != 5 {
+				t.Fatalf("got %d events, want 5 (the one without DTSTART skipped)", len(events))
+			}
+
+			tests := []struct {
+				uid     string
+				summary string
+				allDay  bool
+				days    []string
+			}{
+				// DTEND of an all-day event is exclusive
+				{"nauryz-2024@example.com", "Nauryz meiramy", true, []string{"2024-03-21", "2024-03-22", "2024-03-23"}},
+				// No DTEND: a single day
+				{"independence-2024@example.com", "Independence Day", true, []string{"2024-12-16"}},
+				{"folded-2024@example.com", "Kazakhstan People's Unity Day, celebrated with concerts and a" +
+					"long folded line that calendar apps wrap at seventy-five octets" +
+					" and continue with a tab", true, []string{"2024-05-01"}},
+				{"staff-meeting@example.com", "Staff meeting", false, []string{"2024-06-10"}},
+				// Ending at midnight doesn't take the next day
+				{"utc-event@example.com", "Maintenance", false, []string{"2024-07-01"}},
+			}
+			for i, tt := range tests {
+				e := events[i]
+				if e.UID != tt.uid || e.Summary != tt.summary || e.AllDay != tt.allDay {
+					t.Errorf("event %d = %q %q all day %v, want %q %q %v", i, e.UID, e.Summary, e.AllDay, tt.uid, tt.summary, tt.allDay)
+				}
+				if got := dates(e); strings.Join(got, ",") != strings.Join(tt.days, ",") {
+					t.Errorf("%s covers %v, want %v", tt.uid, got, tt.days)
+				}
+			}
+
+			if e := events[1]; !e.End.Equal(e.Start) {
+				t.Errorf("event without DTEND ends at %v, want its start %v", e.End, e.Start)
+			}
+			want := time.Date(2024, 6, 10, 9, 0, 0, 0, almaty)
+			if e := events[3]; !e.Start.Equal(want) || e.End.Sub(e.Start) != 2*time.Hour {
+				t.Errorf("TZID event is %v to %v, want 2 hours from %v", e.Start, e.End, want)
+			}
+			if e := events[4]; !e.Start.Equal(time.Date(2024, 7, 1, 3, 0, 0, 0, time.UTC)) {
+				t.Errorf("UTC event starts at %v", e.Start)
+			}
+		})
+	}
+}
+
+// TestParseReimport parses the same calendar twice, as when an admin
+// uploads it again; the UIDs, which the import upserts on, must match
+func TestParseReimport(t *testing.T) {
+	first := parseFixture(t, "holidays.ics", false)
+	second := parseFixture(t, "holidays.ics", true)
+	if len(first) != len(second) {
+		t.Fatalf("re-import found %d events, first import %d", len(second), len(first))
+	}
+	seen := make(map[string]bool)
+	for i := range first {
+		if first[i].UID == "" || first[i].UID != second[i].UID {
+			t.Errorf("event %d has UID %q, then %q", i, first[i].UID, second[i].UID)
+		}
+		if seen[first[i].UID] {
+			t.Errorf("UID %q appears twice", first[i].UID)
+		}
+		seen[first[i].UID] = true
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse(strings.NewReader("BEGIN:VEVENT\nEND:VEVENT\n")); !errors.Is(err, ErrNoCalendar) {
+		t.Errorf("no VCALENDAR: err = %v, want ErrNoCalendar", err)
+	}
+	bad := "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:not-a-date\nEND:VEVENT\nEND:VCALENDAR\n"
+	if _, err := Parse(strings.NewReader(bad)); err == nil {
+		t.Error("an invalid DTSTART was accepted")
+	}
+	if _, err := Parse(strings.NewReader("BEGIN:VCALENDAR\nno colon here\nEND:VCALENDAR\n")); err == nil {
+		t.Error("a line without a colon was accepted")
+	}
+}
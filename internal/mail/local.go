@@ -0,0 +1,68 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer writes each message as an .eml file for local development
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer returns a mailer writing into dir, creating it if needed
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes msg to a new file in the mail directory
+func (m *FileMailer) Send(msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s.eml", time.Now().Format("20060102-150405.000000000"))
+	if err := os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o600); err != nil {
+		return err
+	}
+	logSend("file "+name, msg)
+	return nil
+}
+
+// MemoryMailer keeps sent messages in memory so tests can inspect them.
+// It is safe for concurrent use.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryMailer returns an empty in-memory mailer
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send records msg
+func (m *MemoryMailer) Send(msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of every message sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,86 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"online-doctor-appointment/internal/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv builds the mailer selected by MAIL_DRIVER:
+//
+//	smtp   - deliver through SMTP_HOST/SMTP_PORT with SMTP_USER/SMTP_PASSWORD
+//	file   - write each message to MAIL_DIR (default ./tmp/mail)
+//	memory - keep messages in memory (useful for tests)
+//
+// When MAIL_DRIVER is unset, smtp is used if SMTP_HOST is set and file otherwise.
+func FromEnv() (Mailer, error) {
+	from := config.String("MAIL_FROM", config.String("SMTP_USER", "no-reply@localhost"))
+
+	driver := config.String("MAIL_DRIVER", "")
+	if driver == "" {
+		driver = "file"
+		if config.String("SMTP_HOST", "") != "" {
+			driver = "smtp"
+		}
+	}
+
+	switch driver {
+	case "smtp":
+		return NewSMTPMailer(
+			config.String("SMTP_HOST", ""),
+			config.String("SMTP_PORT", "587"),
+			config.String("SMTP_USER", ""),
+			config.String("SMTP_PASSWORD", ""),
+			from,
+		), nil
+	case "file":
+		return NewFileMailer(config.String("MAIL_DIR", "./tmp/mail"), from)
+	case "memory":
+		return NewMemoryMailer(), nil
+	}
+	return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+}
+
+// format renders msg as an RFC 5322 message
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validate rejects header injection through the recipient or subject
+func validate(msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid newline in message header")
+	}
+	if msg.To == "" {
+		return fmt.Errorf("message has no recipient")
+	}
+	return nil
+}
+
+// logSend records a delivered message without its body, which may contain
+// tokens
+func logSend(driver string, msg Message) {
+	log.Printf("Sent email via %s to %s: %s", driver, msg.To, msg.Subject)
+}
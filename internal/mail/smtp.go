@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer delivers mail through an SMTP server using PLAIN auth
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer returns a mailer for host:port. Authentication is skipped
+// when username is empty.
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send delivers msg
+func (m *SMTPMailer) Send(msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg)); err != nil {
+		return err
+	}
+	logSend("smtp", msg)
+	return nil
+}
@@ -3,47 +3,133 @@ package models
 import (
 	"database/sql"
 	"time"
+
+	"online-doctor-appointment/internal/schedule"
+
+	"github.com/lib/pq"
 )
 
 type Appointment struct {
 	ID              int       `json:"id"`
 	PatientID       int       `json:"patient_id"`
 	DoctorID        int       `json:"doctor_id"`
-	AppointmentDate string    `json:"appointment_date"` // YYYY-MM-DD format
-	AppointmentTime string    `json:"appointment_time"` // HH:MM format
-	Status          string    `json:"status"`           // pending, confirmed, cancelled, completed
+	StartsAt        time.Time `json:"starts_at"`
+	AppointmentDate string    `json:"appointment_date"` // YYYY-MM-DD, StartsAt in TimeZone
+	AppointmentTime string    `json:"appointment_time"` // HH:MM, StartsAt in TimeZone
+	TimeZone        string    `json:"time_zone"`        // IANA zone of the date and time, see Localize
+	Status          string    `json:"status"`           // see appointment.Status
+	PaymentStatus   string    `json:"payment_status"`   // of the latest online payment, empty if none
 	Notes           string    `json:"notes"`
+	DurationMinutes int       `json:"duration_minutes"` // the doctor's slot length when booked
+	RescheduleCount int       `json:"reschedule_count"`
+	ConflictNote    string    `json:"conflict_note,omitempty"` // set when the schedule changed under a confirmed appointment
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 
 	// Embedded information
 	Patient *User   `json:"patient,omitempty"`
 	Doctor  *Doctor `json:"doctor,omitempty"`
+
+	// Status history, loaded by AttachStatusHistory
+	History []StatusChange `json:"history,omitempty"`
+}
+
+// Localize sets the appointment's display date and time to StartsAt in loc,
+// along with the times in its status history
+func (a *Appointment) Localize(loc *time.Location) {
+	start := a.StartsAt.In(loc)
+	a.AppointmentDate = start.Format("2006-01-02")
+	a.AppointmentTime = start.Format("15:04")
+	a.TimeZone = loc.String()
+	for i := range a.History {
+		a.History[i].ChangedAt = a.History[i].ChangedAt.In(loc)
+	}
+}
+
+// localizeToDoctor shows the appointment in the time zone of its doctor
+func (a *Appointment) localizeToDoctor(timeZone string) {
+	a.Localize(schedule.LocationOr(timeZone, time.Local))
 }
 
-// CreateAppointment inserts a new appointment
+// CreateAppointment books a slot lasting the doctor's current slot length,
+// in appointment.Status or pending if that is empty, and starts its status
+// history with the patient as the actor. Bookings for a doctor are
+// serialized, and ErrSlotUnavailable is returned if the slot overlaps
+// another appointment or someone else's hold. The patient's own hold with
+// the doctor is used up.
 func CreateAppointment(db *sql.DB, appointment *Appointment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createAppointment(tx, appointment); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// createAppointment is CreateAppointment inside tx
+func createAppointment(tx *sql.Tx, appointment *Appointment) error {
+	settings, err := lockScheduleSettings(tx, appointment.DoctorID)
+	if err != nil {
+		return err
+	}
+
+	err = checkSlotFree(tx, slotClaim{
+		doctorID:  appointment.DoctorID,
+		start:     appointment.StartsAt,
+		minutes:   settings.SlotMinutes,
+		buffer:    settings.BufferMinutes,
+		patientID: appointment.PatientID,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM slot_holds WHERE doctor_id = $1 AND patient_id = $2 AND offer_id IS NULL`,
+		appointment.DoctorID, appointment.PatientID)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO appointments (patient_id, doctor_id, appointment_date, appointment_time, notes)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, status, created_at, updated_at
+		INSERT INTO appointments (patient_id, doctor_id, starts_at, notes, duration_minutes, status)
+		VALUES ($1, $2, $3, $4, $5, COALESCE(NULLIF($6, ''), 'pending'))
+		RETURNING id, status, duration_minutes, created_at, updated_at
 	`
 
-	err := db.QueryRow(query, appointment.PatientID, appointment.DoctorID,
-		appointment.AppointmentDate, appointment.AppointmentTime, appointment.Notes).Scan(
-		&appointment.ID, &appointment.Status, &appointment.CreatedAt, &appointment.UpdatedAt)
+	err = tx.QueryRow(query, appointment.PatientID, appointment.DoctorID,
+		appointment.StartsAt, appointment.Notes, settings.SlotMinutes, appointment.Status).Scan(
+		&appointment.ID, &appointment.Status, &appointment.DurationMinutes,
+		&appointment.CreatedAt, &appointment.UpdatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { // unique_violation
+		return ErrSlotUnavailable
+	}
+	if err != nil {
+		return err
+	}
+	appointment.localizeToDoctor(settings.TimeZone)
 
-	return err
+	return recordStatusChange(tx, appointment.ID, "", appointment.Status, appointment.PatientID, "")
 }
 
+// latestPaymentStatus selects the status of the latest payment for the
+// appointment a, or an empty string if it has none
+const latestPaymentStatus = `
+	COALESCE((SELECT p.status FROM payments p WHERE p.appointment_id = a.id ORDER BY p.id DESC LIMIT 1), '')
+`
+
 // GetAppointmentByID retrieves an appointment by ID
 func GetAppointmentByID(db *sql.DB, appointmentID int) (*Appointment, error) {
 	appointment := &Appointment{}
 	query := `
-		SELECT a.id, a.patient_id, a.doctor_id, a.appointment_date, 
-		       a.appointment_time, a.status, a.notes, a.created_at, a.updated_at,
+		SELECT a.id, a.patient_id, a.doctor_id, a.starts_at, a.duration_minutes,
+		       a.status, a.notes, a.reschedule_count, a.created_at, a.updated_at,
 		       u.first_name, u.last_name, u.email, u.phone,
-		       d.specialty, d.consultation_fee,
+		       d.specialty, d.consultation_fee_minor, d.consultation_fee_currency, d.timezone,
 		       du.first_name, du.last_name
 		FROM appointments a
 		JOIN users u ON a.patient_id = u.id
@@ -58,10 +144,11 @@ func GetAppointmentByID(db *sql.DB, appointmentID int) (*Appointment, error) {
 
 	err := db.QueryRow(query, appointmentID).Scan(
 		&appointment.ID, &appointment.PatientID, &appointment.DoctorID,
-		&appointment.AppointmentDate, &appointment.AppointmentTime,
-		&appointment.Status, &appointment.Notes, &appointment.CreatedAt, &appointment.UpdatedAt,
+		&appointment.StartsAt, &appointment.DurationMinutes,
+		&appointment.Status, &appointment.Notes, &appointment.RescheduleCount,
+		&appointment.CreatedAt, &appointment.UpdatedAt,
 		&patient.FirstName, &patient.LastName, &patient.Email, &patient.Phone,
-		&doctor.Specialty, &doctor.ConsultationFee,
+		&doctor.Specialty, &doctor.ConsultationFee.Minor, &doctor.ConsultationFee.Currency, &doctor.TimeZone,
 		&doctorUser.FirstName, &doctorUser.LastName,
 	)
 
@@ -70,10 +157,12 @@ func GetAppointmentByID(db *sql.DB, appointmentID int) (*Appointment, error) {
 	}
 
 	patient.ID = appointment.PatientID
+	doctor.ID = appointment.DoctorID
 	doctorUser.ID = doctor.UserID
 	doctor.User = &doctorUser
 	appointment.Patient = &patient
 	appointment.Doctor = &doctor
+	appointment.Localize(doctor.Location())
 
 	return appointment, nil
 }
@@ -81,15 +170,15 @@ func GetAppointmentByID(db *sql.DB, appointmentID int) (*Appointment, error) {
 // GetAppointmentsByPatientID retrieves all appointments for a patient
 func GetAppointmentsByPatientID(db *sql.DB, patientID int) ([]Appointment, error) {
 	query := `
-		SELECT a.id, a.patient_id, a.doctor_id, a.appointment_date, 
-		       a.appointment_time, a.status, a.notes, a.created_at, a.updated_at,
-		       d.specialty, d.consultation_fee,
+		SELECT a.id, a.patient_id, a.doctor_id, a.starts_at, a.duration_minutes,
+		       a.status, ` + latestPaymentStatus + `, a.notes, a.reschedule_count, a.created_at, a.updated_at,
+		       d.specialty, d.consultation_fee_minor, d.consultation_fee_currency, d.timezone,
 		       du.first_name, du.last_name
 		FROM appointments a
 		JOIN doctors d ON a.doctor_id = d.id
 		JOIN users du ON d.user_id = du.id
 		WHERE a.patient_id = $1
-		ORDER BY a.appointment_date DESC, a.appointment_time DESC
+		ORDER BY a.starts_at DESC
 	`
 
 	rows, err := db.Query(query, patientID)
@@ -106,17 +195,20 @@ func GetAppointmentsByPatientID(db *sql.DB, patientID int) ([]Appointment, error
 
 		err := rows.Scan(
 			&appointment.ID, &appointment.PatientID, &appointment.DoctorID,
-			&appointment.AppointmentDate, &appointment.AppointmentTime,
-			&appointment.Status, &appointment.Notes, &appointment.CreatedAt, &appointment.UpdatedAt,
-			&doctor.Specialty, &doctor.ConsultationFee,
+			&appointment.StartsAt, &appointment.DurationMinutes,
+			&appointment.Status, &appointment.PaymentStatus, &appointment.Notes, &appointment.RescheduleCount,
+			&appointment.CreatedAt, &appointment.UpdatedAt,
+			&doctor.Specialty, &doctor.ConsultationFee.Minor, &doctor.ConsultationFee.Currency, &doctor.TimeZone,
 			&doctorUser.FirstName, &doctorUser.LastName,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		doctor.ID = appointment.DoctorID
 		doctor.User = &doctorUser
 		appointment.Doctor = &doctor
+		appointment.Localize(doctor.Location())
 		appointments = append(appointments, appointment)
 	}
 
@@ -126,13 +218,15 @@ func GetAppointmentsByPatientID(db *sql.DB, patientID int) ([]Appointment, error
 // GetAppointmentsByDoctorID retrieves all appointments for a doctor
 func GetAppointmentsByDoctorID(db *sql.DB, doctorID int) ([]Appointment, error) {
 	query := `
-		SELECT a.id, a.patient_id, a.doctor_id, a.appointment_date, 
-		       a.appointment_time, a.status, a.notes, a.created_at, a.updated_at,
-		       u.first_name, u.last_name, u.email, u.phone
+		SELECT a.id, a.patient_id, a.doctor_id, a.starts_at, a.duration_minutes,
+		       a.status, ` + latestPaymentStatus + `, a.notes, COALESCE(a.conflict_note, ''), a.created_at, a.updated_at,
+		       u.first_name, u.last_name, u.email, u.phone,
+		       d.timezone
 		FROM appointments a
 		JOIN users u ON a.patient_id = u.id
+		JOIN doctors d ON a.doctor_id = d.id
 		WHERE a.doctor_id = $1
-		ORDER BY a.appointment_date DESC, a.appointment_time DESC
+		ORDER BY a.starts_at DESC
 	`
 
 	rows, err := db.Query(query, doctorID)
@@ -145,12 +239,15 @@ func GetAppointmentsByDoctorID(db *sql.DB, doctorID int) ([]Appointment, error)
 	for rows.Next() {
 		var appointment Appointment
 		var patient User
+		var timeZone string
 
 		err := rows.Scan(
 			&appointment.ID, &appointment.PatientID, &appointment.DoctorID,
-			&appointment.AppointmentDate, &appointment.AppointmentTime,
-			&appointment.Status, &appointment.Notes, &appointment.CreatedAt, &appointment.UpdatedAt,
+			&appointment.StartsAt, &appointment.DurationMinutes,
+			&appointment.Status, &appointment.PaymentStatus, &appointment.Notes, &appointment.ConflictNote,
+			&appointment.CreatedAt, &appointment.UpdatedAt,
 			&patient.FirstName, &patient.LastName, &patient.Email, &patient.Phone,
+			&timeZone,
 		)
 		if err != nil {
 			return nil, err
@@ -158,77 +255,9 @@ func GetAppointmentsByDoctorID(db *sql.DB, doctorID int) ([]Appointment, error)
 
 		patient.ID = appointment.PatientID
 		appointment.Patient = &patient
+		appointment.localizeToDoctor(timeZone)
 		appointments = append(appointments, appointment)
 	}
 
 	return appointments, nil
 }
-
-// UpdateAppointmentStatus updates the status of an appointment
-func UpdateAppointmentStatus(db *sql.DB, appointmentID int, status string) error {
-	query := `UPDATE appointments SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
-	_, err := db.Exec(query, status, appointmentID)
-	return err
-}
-
-// GetAvailableTimeSlots gets available time slots for a doctor on a specific date
-func GetAvailableTimeSlots(db *sql.DB, doctorID int, date string) ([]string, error) {
-	// First, get the day of week for the date
-	dayQuery := `SELECT EXTRACT(DOW FROM DATE $1)`
-	var dayOfWeek int
-	err := db.QueryRow(dayQuery, date).Scan(&dayOfWeek)
-	if err != nil {
-		return nil, err
-	}
-
-	// Get doctor's availability for that day
-	availQuery := `
-		SELECT start_time, end_time 
-		FROM doctor_availability 
-		WHERE doctor_id = $1 AND day_of_week = $2 AND is_active = true
-	`
-
-	var startTime, endTime string
-	err = db.QueryRow(availQuery, doctorID, dayOfWeek).Scan(&startTime, &endTime)
-	if err != nil {
-		return []string{}, nil // No availability that day
-	}
-
-	// Get booked appointments for that day
-	bookedQuery := `
-		SELECT appointment_time 
-		FROM appointments 
-		WHERE doctor_id = $1 AND appointment_date = $2 AND status != 'cancelled'
-	`
-
-	rows, err := db.Query(bookedQuery, doctorID, date)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	bookedTimes := make(map[string]bool)
-	for rows.Next() {
-		var bookedTime string
-		err := rows.Scan(&bookedTime)
-		if err != nil {
-			return nil, err
-		}
-		bookedTimes[bookedTime] = true
-	}
-
-	// Generate available time slots (every hour from start to end)
-	var availableSlots []string
-	// This is a simplified version - you might want to make it more sophisticated
-	start, _ := time.Parse("15:04", startTime)
-	end, _ := time.Parse("15:04", endTime)
-
-	for current := start; current.Before(end); current = current.Add(time.Hour) {
-		timeStr := current.Format("15:04")
-		if !bookedTimes[timeStr] {
-			availableSlots = append(availableSlots, timeStr)
-		}
-	}
-
-	return availableSlots, nil
-}
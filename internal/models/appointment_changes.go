@@ -0,0 +1,146 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"online-doctor-appointment/internal/appointment"
+	"online-doctor-appointment/internal/schedule"
+
+	"github.com/lib/pq"
+)
+
+// ErrSlotUnavailable is returned when the requested time slot is already taken
+var ErrSlotUnavailable = errors.New("time slot is not available")
+
+// lockedAppointment is the part of an appointment row the patient change
+// functions check while holding its lock
+type lockedAppointment struct {
+	status      appointment.Status
+	reschedules int
+	minutes     int
+	timeZone    string // the doctor's
+}
+
+// lockAppointment reads and row-locks the appointment for the rest of tx
+func lockAppointment(tx *sql.Tx, appointmentID int) (*lockedAppointment, time.Time, error) {
+	query := `
+		SELECT a.status, a.starts_at, a.reschedule_count, a.duration_minutes, d.timezone
+		FROM appointments a
+		JOIN doctors d ON a.doctor_id = d.id
+		WHERE a.id = $1
+		FOR UPDATE OF a
+	`
+
+	a := &lockedAppointment{}
+	var start time.Time
+	err := tx.QueryRow(query, appointmentID).Scan(&a.status, &start, &a.reschedules, &a.minutes, &a.timeZone)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return a, start, nil
+}
+
+// CancelAppointment cancels an appointment on behalf of its patient if
+// policy allows it. It returns sql.ErrNoRows if the appointment doesn't
+// exist, or the policy's error.
+func CancelAppointment(db *sql.DB, appointmentID, actorID int, reason string, policy appointment.Policy) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, start, err := lockAppointment(tx, appointmentID)
+	if err != nil {
+		return err
+	}
+
+	if err := policy.CanCancel(current.status, start, time.Now()); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE appointments SET status = $1, conflict_note = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
+		appointment.Cancelled, appointmentID)
+	if err != nil {
+		return err
+	}
+
+	if err := recordStatusChange(tx, appointmentID, string(current.status), string(appointment.Cancelled), actorID, reason); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RescheduleAppointment moves an appointment to start at a new time on
+// behalf of its patient if policy allows it. The old slot is released and
+// the new one claimed in the same transaction, one booking at a time for
+// the doctor; ErrSlotUnavailable is returned if the new slot overlaps
+// another appointment or someone else's hold.
+func RescheduleAppointment(db *sql.DB, appointmentID int, startsAt time.Time, actorID int, policy appointment.Policy) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the doctor's schedule before the appointment, in the same order
+	// as schedule changes, which flag appointments while holding it
+	var doctorID, patientID int
+	err = tx.QueryRow(`SELECT doctor_id, patient_id FROM appointments WHERE id = $1`, appointmentID).Scan(
+		&doctorID, &patientID)
+	if err != nil {
+		return err
+	}
+	settings, err := lockScheduleSettings(tx, doctorID)
+	if err != nil {
+		return err
+	}
+
+	current, start, err := lockAppointment(tx, appointmentID)
+	if err != nil {
+		return err
+	}
+
+	if err := policy.CanReschedule(current.status, start, current.reschedules, time.Now()); err != nil {
+		return err
+	}
+
+	err = checkSlotFree(tx, slotClaim{
+		doctorID:      doctorID,
+		start:         startsAt,
+		minutes:       current.minutes,
+		buffer:        settings.BufferMinutes,
+		appointmentID: appointmentID,
+		patientID:     patientID,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE appointments
+		SET starts_at = $2, reschedule_count = reschedule_count + 1,
+		    conflict_note = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, appointmentID, startsAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { // unique_violation
+		return ErrSlotUnavailable
+	}
+	if err != nil {
+		return err
+	}
+
+	// Describe the move in the doctor's zone, where the slots are defined
+	loc := schedule.LocationOr(current.timeZone, time.Local)
+	const layout = "2006-01-02 15:04 MST"
+	reason := "Rescheduled from " + start.In(loc).Format(layout) + " to " + startsAt.In(loc).Format(layout)
+	err = recordStatusChange(tx, appointmentID, string(current.status), string(current.status), actorID, reason)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"online-doctor-appointment/internal/appointment"
+
+	"github.com/lib/pq"
+)
+
+// StatusChange is one entry in an appointment's status history
+type StatusChange struct {
+	ID            int       `json:"id"`
+	AppointmentID int       `json:"appointment_id"`
+	FromStatus    string    `json:"from_status,omitempty"` // empty when the appointment was created
+	ToStatus      string    `json:"to_status"`
+	ActorID       *int      `json:"actor_id,omitempty"` // nil for automatic changes
+	ActorName     string    `json:"actor_name,omitempty"`
+	ActorRole     string    `json:"actor_role,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	ChangedAt     time.Time `json:"changed_at"`
+}
+
+// NextStatuses returns the statuses the appointment may move to
+func (a Appointment) NextStatuses() []string {
+	var next []string
+	for _, s := range appointment.Status(a.Status).Next() {
+		next = append(next, string(s))
+	}
+	return next
+}
+
+// ChangeAppointmentStatus moves an appointment to a new status and records
+// who did it and why. It returns an error wrapping
+// appointment.ErrInvalidTransition if the lifecycle doesn't allow the change,
+// and sql.ErrNoRows if the appointment doesn't exist. actorID 0 records an
+// automatic change.
+func ChangeAppointmentStatus(db *sql.DB, appointmentID int, to appointment.Status, actorID int, reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var from appointment.Status
+	err = tx.QueryRow(`SELECT status FROM appointments WHERE id = $1 FOR UPDATE`, appointmentID).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	if err := appointment.Transition(from, to); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE appointments SET status = $1, conflict_note = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, to, appointmentID)
+	if err != nil {
+		return err
+	}
+
+	if err := recordStatusChange(tx, appointmentID, string(from), string(to), actorID, reason); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// recordStatusChange appends an entry to the appointment's history. from is
+// empty when the appointment is being created.
+func recordStatusChange(tx *sql.Tx, appointmentID int, from, to string, actorID int, reason string) error {
+	query := `
+		INSERT INTO appointment_status_history (appointment_id, from_status, to_status, actor_id, reason)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, 0), NULLIF($5, ''))
+	`
+	_, err := tx.Exec(query, appointmentID, from, to, actorID, reason)
+	return err
+}
+
+// GetStatusHistory returns the history of each appointment in
+// appointmentIDs, oldest change first
+func GetStatusHistory(db *sql.DB, appointmentIDs []int) (map[int][]StatusChange, error) {
+	query := `
+		SELECT h.id, h.appointment_id, COALESCE(h.from_status, ''), h.to_status, h.actor_id,
+		       COALESCE(u.first_name || ' ' || u.last_name, ''), COALESCE(u.user_type, ''),
+		       COALESCE(h.reason, ''), h.changed_at
+		FROM appointment_status_history h
+		LEFT JOIN users u ON h.actor_id = u.id
+		WHERE h.appointment_id = ANY($1)
+		ORDER BY h.changed_at, h.id
+	`
+
+	rows, err := db.Query(query, pq.Array(appointmentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make(map[int][]StatusChange)
+	for rows.Next() {
+		var c StatusChange
+		err := rows.Scan(&c.ID, &c.AppointmentID, &c.FromStatus, &c.ToStatus, &c.ActorID,
+			&c.ActorName, &c.ActorRole, &c.Reason, &c.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		history[c.AppointmentID] = append(history[c.AppointmentID], c)
+	}
+
+	return history, rows.Err()
+}
+
+// AttachStatusHistory loads the status history of every appointment in
+// appointments into its History field
+func AttachStatusHistory(db *sql.DB, appointments []Appointment) error {
+	if len(appointments) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(appointments))
+	for i, a := range appointments {
+		ids[i] = a.ID
+	}
+
+	history, err := GetStatusHistory(db, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range appointments {
+		appointments[i].History = history[appointments[i].ID]
+	}
+	return nil
+}
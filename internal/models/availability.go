@@ -0,0 +1,237 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// ErrAvailabilityOverlap is returned when a working window overlaps another
+// active window on the same day
+var ErrAvailabilityOverlap = errors.New("availability window overlaps an existing window")
+
+// GetAvailability retrieves all of a doctor's weekly working windows,
+// including deactivated ones
+func GetAvailability(db *sql.DB, doctorID int) ([]DoctorAvailability, error) {
+	query := `
+		SELECT id, doctor_id, day_of_week, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'),
+		       is_active, created_at
+		FROM doctor_availability
+		WHERE doctor_id = $1
+		ORDER BY day_of_week, start_time
+	`
+
+	rows, err := db.Query(query, doctorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []DoctorAvailability
+	for rows.Next() {
+		var a DoctorAvailability
+		err := rows.Scan(&a.ID, &a.DoctorID, &a.DayOfWeek, &a.StartTime, &a.EndTime, &a.IsActive, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, a)
+	}
+
+	return windows, rows.Err()
+}
+
+// CreateAvailability adds a working window for a doctor and returns the
+// confirmed appointments on that weekday left outside working hours
+func CreateAvailability(db *sql.DB, window *DoctorAvailability) ([]Appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockSchedule(tx, window.DoctorID); err != nil {
+		return nil, err
+	}
+	if err := checkOverlap(tx, window); err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO doctor_availability (doctor_id, day_of_week, start_time, end_time, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	err = tx.QueryRow(query, window.DoctorID, window.DayOfWeek, window.StartTime, window.EndTime, window.IsActive).Scan(
+		&window.ID, &window.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return commitWithOrphans(tx, window.DoctorID, window.DayOfWeek)
+}
+
+// UpdateAvailability changes one of a doctor's working windows and returns
+// the confirmed appointments left outside working hours on the days it
+// affects. It returns sql.ErrNoRows if the window doesn't belong to the
+// doctor.
+func UpdateAvailability(db *sql.DB, window *DoctorAvailability) ([]Appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockSchedule(tx, window.DoctorID); err != nil {
+		return nil, err
+	}
+
+	// Moving a window to another day can orphan appointments on both days
+	var oldDay int
+	err = tx.QueryRow(`SELECT day_of_week FROM doctor_availability WHERE id = $1 AND doctor_id = $2`,
+		window.ID, window.DoctorID).Scan(&oldDay)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOverlap(tx, window); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE doctor_availability
+		SET day_of_week = $1, start_time = $2, end_time = $3, is_active = $4
+		WHERE id = $5 AND doctor_id = $6
+		RETURNING created_at
+	`
+	err = tx.QueryRow(query, window.DayOfWeek, window.StartTime, window.EndTime, window.IsActive,
+		window.ID, window.DoctorID).Scan(&window.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldDay == window.DayOfWeek {
+		return commitWithOrphans(tx, window.DoctorID, window.DayOfWeek)
+	}
+	return commitWithOrphans(tx, window.DoctorID, oldDay, window.DayOfWeek)
+}
+
+// DeactivateAvailability switches off one of a doctor's working windows and
+// returns the confirmed appointments left outside working hours. It returns
+// sql.ErrNoRows if the window doesn't belong to the doctor.
+func DeactivateAvailability(db *sql.DB, doctorID, windowID int) ([]Appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockSchedule(tx, doctorID); err != nil {
+		return nil, err
+	}
+
+	var day int
+	query := `
+		UPDATE doctor_availability SET is_active = false
+		WHERE id = $1 AND doctor_id = $2
+		RETURNING day_of_week
+	`
+	if err := tx.QueryRow(query, windowID, doctorID).Scan(&day); err != nil {
+		return nil, err
+	}
+
+	return commitWithOrphans(tx, doctorID, day)
+}
+
+// lockSchedule serializes changes to a doctor's working windows so two
+// concurrent edits can't create overlapping windows
+func lockSchedule(tx *sql.Tx, doctorID int) error {
+	var id int
+	return tx.QueryRow(`SELECT id FROM doctors WHERE id = $1 FOR UPDATE`, doctorID).Scan(&id)
+}
+
+// checkOverlap returns ErrAvailabilityOverlap if an active window would
+// overlap another active window of the same doctor on the same day
+func checkOverlap(tx *sql.Tx, window *DoctorAvailability) error {
+	if !window.IsActive {
+		return nil
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM doctor_availability
+			WHERE doctor_id = $1 AND day_of_week = $2 AND is_active = true AND id != $3
+			  AND start_time < $5 AND $4 < end_time
+		)
+	`
+
+	var overlaps bool
+	err := tx.QueryRow(query, window.DoctorID, window.DayOfWeek, window.ID, window.StartTime, window.EndTime).Scan(&overlaps)
+	if err != nil {
+		return err
+	}
+	if overlaps {
+		return ErrAvailabilityOverlap
+	}
+	return nil
+}
+
+// outsideHoursNote marks confirmed appointments left outside working hours
+const outsideHoursNote = "Outside working hours"
+
+// inWorkingHours is true when appointment a, whose doctor is aliased d,
+// fits inside an active weekly window or one-off extra hours
+const inWorkingHours = `(
+	EXISTS (
+		SELECT 1 FROM doctor_availability w
+		WHERE w.doctor_id = a.doctor_id AND w.is_active = true
+		  AND w.day_of_week = EXTRACT(DOW FROM a.starts_at AT TIME ZONE d.timezone)
+		  AND w.start_time <= (a.starts_at AT TIME ZONE d.timezone)::time
+		  AND (a.starts_at AT TIME ZONE d.timezone)::time + a.duration_minutes * INTERVAL '1 minute' <= w.end_time
+	) OR EXISTS (
+		SELECT 1 FROM schedule_exceptions x
+		WHERE x.doctor_id = a.doctor_id AND x.kind = 'extra'
+		  AND (a.starts_at AT TIME ZONE d.timezone)::date BETWEEN x.start_date AND x.end_date
+		  AND x.start_time <= (a.starts_at AT TIME ZONE d.timezone)::time
+		  AND (a.starts_at AT TIME ZONE d.timezone)::time + a.duration_minutes * INTERVAL '1 minute' <= x.end_time
+	)
+)`
+
+// commitWithOrphans re-checks the doctor's upcoming confirmed appointments
+// on the given weekdays against working hours, then commits tx and returns
+// those left outside them
+func commitWithOrphans(tx *sql.Tx, doctorID int, days ...int) ([]Appointment, error) {
+	orphans, err := refreshWorkingHours(tx,
+		`a.doctor_id = $1 AND EXTRACT(DOW FROM a.starts_at AT TIME ZONE d.timezone) = ANY($2)`,
+		doctorID, pq.Array(days))
+	if err != nil {
+		return nil, err
+	}
+
+	return orphans, tx.Commit()
+}
+
+// refreshWorkingHours clears the note on upcoming confirmed appointments
+// matching where that are back inside working hours, and flags and
+// returns those outside them. Appointments flagged for another reason keep
+// their note. where may refer to the appointment a and its doctor d.
+func refreshWorkingHours(tx *sql.Tx, where string, args ...interface{}) ([]Appointment, error) {
+	_, err := tx.Exec(`
+		UPDATE appointments a SET conflict_note = NULL
+		FROM doctors d
+		WHERE d.id = a.doctor_id AND a.status = 'confirmed' AND a.starts_at >= CURRENT_TIMESTAMP
+		  AND a.conflict_note = '`+outsideHoursNote+`' AND `+where+` AND `+inWorkingHours,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return flagConflicts(tx, `
+		UPDATE appointments a SET conflict_note = '`+outsideHoursNote+`'
+		FROM users u, doctors d
+		WHERE u.id = a.patient_id AND d.id = a.doctor_id AND a.status = 'confirmed'
+		  AND a.starts_at >= CURRENT_TIMESTAMP
+		  AND (a.conflict_note IS NULL OR a.conflict_note = '`+outsideHoursNote+`')
+		  AND `+where+` AND NOT `+inWorkingHours+`
+		RETURNING `+flaggedColumns, args...)
+}
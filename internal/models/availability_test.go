@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAvailabilityOverlap(t *testing.T) {
+	db := testDB(t)
+
+	tests := []struct {
+		name   string
+		window DoctorAvailability
+		want   error
+	}{
+		{"overlapping", DoctorAvailability{DayOfWeek: 1, StartTime: "11:00", EndTime: "13:00", IsActive: true}, ErrAvailabilityOverlap},
+		{"inside", DoctorAvailability{DayOfWeek: 1, StartTime: "10:00", EndTime: "11:00", IsActive: true}, ErrAvailabilityOverlap},
+		{"adjacent", DoctorAvailability{DayOfWeek: 1, StartTime: "12:00", EndTime: "14:00", IsActive: true}, nil},
+		{"other day", DoctorAvailability{DayOfWeek: 2, StartTime: "09:00", EndTime: "12:00", IsActive: true}, nil},
+		{"inactive", DoctorAvailability{DayOfWeek: 1, StartTime: "09:00", EndTime: "12:00"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doctor := createTestDoctor(t, db, 60, 0)
+			existing := &DoctorAvailability{DoctorID: doctor.ID, DayOfWeek: 1, StartTime: "09:00", EndTime: "12:00", IsActive: true}
+			if _, err := CreateAvailability(db, existing); err != nil {
+				t.Fatal(err)
+			}
+
+			window := tt.window
+			window.DoctorID = doctor.ID
+			if _, err := CreateAvailability(db, &window); !errors.Is(err, tt.want) {
+				t.Errorf("CreateAvailability = %v, want %v", err, tt.want)
+			}
+
+			// Moving the existing window onto the new one must be caught too
+			if tt.want == nil && window.IsActive {
+				moved := *existing
+				moved.DayOfWeek, moved.StartTime, moved.EndTime = window.DayOfWeek, window.StartTime, window.EndTime
+				if _, err := UpdateAvailability(db, &moved); !errors.Is(err, ErrAvailabilityOverlap) {
+					t.Errorf("UpdateAvailability onto the new window = %v, want %v", err, ErrAvailabilityOverlap)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateAvailabilityIgnoresItself(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+
+	window := &DoctorAvailability{DoctorID: doctor.ID, DayOfWeek: 3, StartTime: "09:00", EndTime: "12:00", IsActive: true}
+	if _, err := CreateAvailability(db, window); err != nil {
+		t.Fatal(err)
+	}
+	window.StartTime = "08:00"
+	if _, err := UpdateAvailability(db, window); err != nil {
+		t.Errorf("widening a window: %v", err)
+	}
+}
+
+// bookLocal books a confirmed appointment with doctor a month from now at
+// hour o'clock in the doctor's time zone
+func bookLocal(t *testing.T, doctor *Doctor, hour int) *Appointment {
+	t.Helper()
+	db := testDB(t)
+	loc := mustLoadLocation(t, doctor.TimeZone)
+	day := time.Now().In(loc).AddDate(0, 1, 0)
+	a := &Appointment{
+		PatientID: createTestUser(t, db, "patient").ID,
+		DoctorID:  doctor.ID,
+		StartsAt:  time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, loc),
+		Status:    "confirmed",
+	}
+	if err := CreateAppointment(db, a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// conflictNote returns an appointment's conflict note, or "" if it has none
+func conflictNote(t *testing.T, appointmentID int) string {
+	t.Helper()
+	var note string
+	err := testDB(t).QueryRow(`SELECT COALESCE(conflict_note, '') FROM appointments WHERE id = $1`,
+		appointmentID).Scan(&note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return note
+}
+
+func TestWorkingHoursConflicts(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	a := bookLocal(t, doctor, 10)
+	day := int(a.StartsAt.In(mustLoadLocation(t, doctor.TimeZone)).Weekday())
+
+	window := &DoctorAvailability{DoctorID: doctor.ID, DayOfWeek: day, StartTime: "09:00", EndTime: "12:00", IsActive: true}
+	if orphans, err := CreateAvailability(db, window); err != nil || len(orphans) != 0 {
+		t.Fatalf("CreateAvailability = %v, %v; want no orphans", orphans, err)
+	}
+
+	orphans, err := DeactivateAvailability(db, doctor.ID, window.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != a.ID {
+		t.Fatalf("deactivating the window orphaned %v, want appointment %d", orphans, a.ID)
+	}
+	if note := conflictNote(t, a.ID); note != outsideHoursNote {
+		t.Fatalf("note after deactivating = %q, want %q", note, outsideHoursNote)
+	}
+
+	window.IsActive = true
+	if _, err := UpdateAvailability(db, window); err != nil {
+		t.Fatal(err)
+	}
+	if note := conflictNote(t, a.ID); note != "" {
+		t.Errorf("note after reactivating = %q, want none", note)
+	}
+}
+
+func TestExtraHoursCountAsWorkingTime(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	a := bookLocal(t, doctor, 10)
+	local := a.StartsAt.In(mustLoadLocation(t, doctor.TimeZone))
+
+	extra := &ScheduleException{
+		DoctorID:  doctor.ID,
+		Kind:      ExceptionExtra,
+		StartDate: local.Format("2006-01-02"),
+		EndDate:   local.Format("2006-01-02"),
+		StartTime: "09:00",
+		EndTime:   "11:00",
+	}
+	if _, err := CreateScheduleException(db, extra); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later window on the same weekday re-checks the appointment
+	window := &DoctorAvailability{DoctorID: doctor.ID, DayOfWeek: int(local.Weekday()), StartTime: "14:00", EndTime: "16:00", IsActive: true}
+	orphans, err := CreateAvailability(db, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("appointment inside extra hours orphaned: %v", orphans)
+	}
+	if note := conflictNote(t, a.ID); note != "" {
+		t.Errorf("note = %q, want none", note)
+	}
+}
+
+// mustLoadLocation loads a time zone or fails the test
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
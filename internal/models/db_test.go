@@ -0,0 +1,110 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+
+	_ "github.com/lib/pq"
+)
+
+// testDB opens the database in DATABASE_URL, which must have
+// sql/schema.sql applied, and skips the test when it isn't set
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var testUsers atomic.Int64
+
+// createTestUser inserts a user that is deleted, along with everything
+// that cascades from it, when the test ends
+func createTestUser(t *testing.T, db *sql.DB, userType string) *User {
+	t.Helper()
+	user := &User{
+		Email:        fmt.Sprintf("test-%d-%d@example.com", time.Now().UnixNano(), testUsers.Add(1)),
+		PasswordHash: "not a hash",
+		FirstName:    "Test",
+		LastName:     userType,
+		UserType:     userType,
+	}
+	if err := CreateUser(db, user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, user.ID) })
+	return user
+}
+
+// createTestDoctor inserts a doctor in Asia/Almaty with the given slot
+// length and buffer, deleted with its appointments when the test ends
+func createTestDoctor(t *testing.T, db *sql.DB, slotMinutes, bufferMinutes int) *Doctor {
+	t.Helper()
+	user := createTestUser(t, db, "doctor")
+	doctor := &Doctor{UserID: user.ID, Specialty: "Testing", TimeZone: "Asia/Almaty", User: user}
+	if err := CreateDoctor(db, doctor); err != nil {
+		t.Fatal(err)
+	}
+	setSlotLength(t, db, doctor.ID, slotMinutes, bufferMinutes)
+	return doctor
+}
+
+// setSlotLength changes a doctor's slot length and buffer
+func setSlotLength(t *testing.T, db *sql.DB, doctorID, slotMinutes, bufferMinutes int) {
+	t.Helper()
+	_, err := db.Exec(`UPDATE doctors SET slot_minutes = $2, buffer_minutes = $3 WHERE id = $1`,
+		doctorID, slotMinutes, bufferMinutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestPayment books an appointment a month from now with a new
+// doctor and patient, and records a paid payment of amount for it through
+// gateway
+func createTestPayment(t *testing.T, db *sql.DB, gateway string, amount money.Money) *Payment {
+	t.Helper()
+	doctor := createTestDoctor(t, db, 60, 0)
+	patient := createTestUser(t, db, "patient")
+	a := &Appointment{
+		PatientID: patient.ID,
+		DoctorID:  doctor.ID,
+		StartsAt:  time.Now().UTC().Truncate(time.Hour).AddDate(0, 1, 0),
+		Status:    "confirmed",
+	}
+	if err := CreateAppointment(db, a); err != nil {
+		t.Fatal(err)
+	}
+	p := &Payment{
+		AppointmentID: a.ID,
+		Reference:     fmt.Sprintf("test-%d-%d", time.Now().UnixNano(), testUsers.Add(1)),
+		Gateway:       gateway,
+		Amount:        amount,
+	}
+	if err := CreatePayment(db, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPaymentOrder(db, p.ID, "order-"+p.Reference, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecordPaymentResult(db, p.Reference, "order-"+p.Reference, PaymentPaid, amount, ""); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
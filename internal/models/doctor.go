@@ -3,24 +3,56 @@ package models
 import (
 	"database/sql"
 	"time"
+
+	"online-doctor-appointment/internal/money"
+	"online-doctor-appointment/internal/schedule"
 )
 
 type Doctor struct {
-	ID              int       `json:"id"`
-	UserID          int       `json:"user_id"`
-	Specialty       string    `json:"specialty"`
-	ExperienceYears int       `json:"experience_years"`
-	Education       string    `json:"education"`
-	About           string    `json:"about"`
-	ConsultationFee float64   `json:"consultation_fee"`
-	IsActive        bool      `json:"is_active"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID              int         `json:"id"`
+	UserID          int         `json:"user_id"`
+	Specialty       string      `json:"specialty"`
+	ExperienceYears int         `json:"experience_years"`
+	Education       string      `json:"education"`
+	About           string      `json:"about"`
+	ConsultationFee money.Money `json:"consultation_fee"`
+	IsActive        bool        `json:"is_active"`
+	TimeZone        string      `json:"time_zone"` // IANA name; working hours are in this zone
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+
+	// Credentials reviewed by an admin before the doctor is listed
+	LicenseNumber      string     `json:"-"`
+	VerificationStatus string     `json:"-"` // pending_verification, approved, rejected
+	VerificationNote   string     `json:"-"` // reason given with the decision
+	ReviewedAt         *time.Time `json:"-"`
 
 	// Embedded user information
 	User *User `json:"user,omitempty"`
 }
 
+// Doctor verification states
+const (
+	DoctorPendingVerification = "pending_verification"
+	DoctorApproved            = "approved"
+	DoctorRejected            = "rejected"
+)
+
+// IsApproved reports whether an admin has verified the doctor's credentials
+func (d *Doctor) IsApproved() bool {
+	return d.VerificationStatus == DoctorApproved
+}
+
+// IsBookable reports whether patients may book the doctor
+func (d *Doctor) IsBookable() bool {
+	return d.IsActive && d.IsApproved()
+}
+
+// Location returns the doctor's time zone, or the server's if it is unknown
+func (d *Doctor) Location() *time.Location {
+	return schedule.LocationOr(d.TimeZone, time.Local)
+}
+
 type DoctorAvailability struct {
 	ID        int       `json:"id"`
 	DoctorID  int       `json:"doctor_id"`
@@ -34,14 +66,16 @@ type DoctorAvailability struct {
 // CreateDoctor inserts a new doctor into the database
 func CreateDoctor(db *sql.DB, doctor *Doctor) error {
 	query := `
-		INSERT INTO doctors (user_id, specialty, experience_years, education, about, consultation_fee)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at, updated_at
+		INSERT INTO doctors (user_id, specialty, experience_years, education, about,
+		                     consultation_fee_minor, consultation_fee_currency, license_number, timezone)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, verification_status, created_at, updated_at
 	`
 
 	err := db.QueryRow(query, doctor.UserID, doctor.Specialty, doctor.ExperienceYears,
-		doctor.Education, doctor.About, doctor.ConsultationFee).Scan(
-		&doctor.ID, &doctor.CreatedAt, &doctor.UpdatedAt)
+		doctor.Education, doctor.About, doctor.ConsultationFee.Minor, doctor.ConsultationFee.Currency,
+		doctor.LicenseNumber, doctor.TimeZone).Scan(
+		&doctor.ID, &doctor.VerificationStatus, &doctor.CreatedAt, &doctor.UpdatedAt)
 
 	return err
 }
@@ -51,7 +85,9 @@ func GetDoctorByUserID(db *sql.DB, userID int) (*Doctor, error) {
 	doctor := &Doctor{}
 	query := `
 		SELECT d.id, d.user_id, d.specialty, d.experience_years, d.education, 
-		       d.about, d.consultation_fee, d.is_active, d.created_at, d.updated_at,
+		       d.about, d.consultation_fee_minor, d.consultation_fee_currency, d.is_active, d.timezone, d.created_at, d.updated_at,
+		       COALESCE(d.license_number, ''), d.verification_status, COALESCE(d.verification_note, ''),
+		       d.reviewed_at,
 		       u.email, u.first_name, u.last_name, u.phone
 		FROM doctors d
 		JOIN users u ON d.user_id = u.id
@@ -61,8 +97,10 @@ func GetDoctorByUserID(db *sql.DB, userID int) (*Doctor, error) {
 	user := &User{}
 	err := db.QueryRow(query, userID).Scan(
 		&doctor.ID, &doctor.UserID, &doctor.Specialty, &doctor.ExperienceYears,
-		&doctor.Education, &doctor.About, &doctor.ConsultationFee, &doctor.IsActive,
-		&doctor.CreatedAt, &doctor.UpdatedAt,
+		&doctor.Education, &doctor.About, &doctor.ConsultationFee.Minor, &doctor.ConsultationFee.Currency, &doctor.IsActive,
+		&doctor.TimeZone, &doctor.CreatedAt, &doctor.UpdatedAt,
+		&doctor.LicenseNumber, &doctor.VerificationStatus, &doctor.VerificationNote,
+		&doctor.ReviewedAt,
 		&user.Email, &user.FirstName, &user.LastName, &user.Phone,
 	)
 
@@ -82,7 +120,9 @@ func GetDoctorByID(db *sql.DB, doctorID int) (*Doctor, error) {
 	doctor := &Doctor{}
 	query := `
 		SELECT d.id, d.user_id, d.specialty, d.experience_years, d.education, 
-		       d.about, d.consultation_fee, d.is_active, d.created_at, d.updated_at,
+		       d.about, d.consultation_fee_minor, d.consultation_fee_currency, d.is_active, d.timezone, d.created_at, d.updated_at,
+		       COALESCE(d.license_number, ''), d.verification_status, COALESCE(d.verification_note, ''),
+		       d.reviewed_at,
 		       u.email, u.first_name, u.last_name, u.phone
 		FROM doctors d
 		JOIN users u ON d.user_id = u.id
@@ -92,8 +132,10 @@ func GetDoctorByID(db *sql.DB, doctorID int) (*Doctor, error) {
 	user := &User{}
 	err := db.QueryRow(query, doctorID).Scan(
 		&doctor.ID, &doctor.UserID, &doctor.Specialty, &doctor.ExperienceYears,
-		&doctor.Education, &doctor.About, &doctor.ConsultationFee, &doctor.IsActive,
-		&doctor.CreatedAt, &doctor.UpdatedAt,
+		&doctor.Education, &doctor.About, &doctor.ConsultationFee.Minor, &doctor.ConsultationFee.Currency, &doctor.IsActive,
+		&doctor.TimeZone, &doctor.CreatedAt, &doctor.UpdatedAt,
+		&doctor.LicenseNumber, &doctor.VerificationStatus, &doctor.VerificationNote,
+		&doctor.ReviewedAt,
 		&user.Email, &user.FirstName, &user.LastName, &user.Phone,
 	)
 
@@ -108,15 +150,15 @@ func GetDoctorByID(db *sql.DB, doctorID int) (*Doctor, error) {
 	return doctor, nil
 }
 
-// GetAllDoctors retrieves all active doctors
+// GetAllDoctors retrieves all active, approved doctors
 func GetAllDoctors(db *sql.DB) ([]Doctor, error) {
 	query := `
 		SELECT d.id, d.user_id, d.specialty, d.experience_years, d.education, 
-		       d.about, d.consultation_fee, d.is_active, d.created_at, d.updated_at,
+		       d.about, d.consultation_fee_minor, d.consultation_fee_currency, d.is_active, d.timezone, d.created_at, d.updated_at,
 		       u.email, u.first_name, u.last_name, u.phone
 		FROM doctors d
 		JOIN users u ON d.user_id = u.id
-		WHERE d.is_active = true
+		WHERE d.is_active = true AND d.verification_status = 'approved'
 		ORDER BY u.first_name, u.last_name
 	`
 
@@ -133,8 +175,8 @@ func GetAllDoctors(db *sql.DB) ([]Doctor, error) {
 
 		err := rows.Scan(
 			&doctor.ID, &doctor.UserID, &doctor.Specialty, &doctor.ExperienceYears,
-			&doctor.Education, &doctor.About, &doctor.ConsultationFee, &doctor.IsActive,
-			&doctor.CreatedAt, &doctor.UpdatedAt,
+			&doctor.Education, &doctor.About, &doctor.ConsultationFee.Minor, &doctor.ConsultationFee.Currency, &doctor.IsActive,
+			&doctor.TimeZone, &doctor.CreatedAt, &doctor.UpdatedAt,
 			&user.Email, &user.FirstName, &user.LastName, &user.Phone,
 		)
 		if err != nil {
@@ -150,15 +192,15 @@ func GetAllDoctors(db *sql.DB) ([]Doctor, error) {
 	return doctors, nil
 }
 
-// GetDoctorsBySpecialty retrieves doctors by specialty
+// GetDoctorsBySpecialty retrieves active, approved doctors by specialty
 func GetDoctorsBySpecialty(db *sql.DB, specialty string) ([]Doctor, error) {
 	query := `
 		SELECT d.id, d.user_id, d.specialty, d.experience_years, d.education, 
-		       d.about, d.consultation_fee, d.is_active, d.created_at, d.updated_at,
+		       d.about, d.consultation_fee_minor, d.consultation_fee_currency, d.is_active, d.timezone, d.created_at, d.updated_at,
 		       u.email, u.first_name, u.last_name, u.phone
 		FROM doctors d
 		JOIN users u ON d.user_id = u.id
-		WHERE d.specialty ILIKE $1 AND d.is_active = true
+		WHERE d.specialty ILIKE $1 AND d.is_active = true AND d.verification_status = 'approved'
 		ORDER BY u.first_name, u.last_name
 	`
 
@@ -175,8 +217,52 @@ func GetDoctorsBySpecialty(db *sql.DB, specialty string) ([]Doctor, error) {
 
 		err := rows.Scan(
 			&doctor.ID, &doctor.UserID, &doctor.Specialty, &doctor.ExperienceYears,
-			&doctor.Education, &doctor.About, &doctor.ConsultationFee, &doctor.IsActive,
-			&doctor.CreatedAt, &doctor.UpdatedAt,
+			&doctor.Education, &doctor.About, &doctor.ConsultationFee.Minor, &doctor.ConsultationFee.Currency, &doctor.IsActive,
+			&doctor.TimeZone, &doctor.CreatedAt, &doctor.UpdatedAt,
+			&user.Email, &user.FirstName, &user.LastName, &user.Phone,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		user.ID = doctor.UserID
+		user.UserType = "doctor"
+		doctor.User = &user
+		doctors = append(doctors, doctor)
+	}
+
+	return doctors, nil
+}
+
+// GetPendingDoctors retrieves doctors awaiting verification, oldest first
+func GetPendingDoctors(db *sql.DB) ([]Doctor, error) {
+	query := `
+		SELECT d.id, d.user_id, d.specialty, d.experience_years, d.education,
+		       d.about, d.consultation_fee_minor, d.consultation_fee_currency, d.is_active, d.timezone, d.created_at, d.updated_at,
+		       COALESCE(d.license_number, ''), d.verification_status,
+		       u.email, u.first_name, u.last_name, u.phone
+		FROM doctors d
+		JOIN users u ON d.user_id = u.id
+		WHERE d.verification_status = 'pending_verification'
+		ORDER BY d.created_at
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var doctors []Doctor
+	for rows.Next() {
+		var doctor Doctor
+		var user User
+
+		err := rows.Scan(
+			&doctor.ID, &doctor.UserID, &doctor.Specialty, &doctor.ExperienceYears,
+			&doctor.Education, &doctor.About, &doctor.ConsultationFee.Minor, &doctor.ConsultationFee.Currency, &doctor.IsActive,
+			&doctor.TimeZone, &doctor.CreatedAt, &doctor.UpdatedAt,
+			&doctor.LicenseNumber, &doctor.VerificationStatus,
 			&user.Email, &user.FirstName, &user.LastName, &user.Phone,
 		)
 		if err != nil {
@@ -191,3 +277,29 @@ func GetDoctorsBySpecialty(db *sql.DB, specialty string) ([]Doctor, error) {
 
 	return doctors, nil
 }
+
+// CountPendingDoctors returns how many doctors are awaiting verification
+func CountPendingDoctors(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM doctors WHERE verification_status = 'pending_verification'`).Scan(&count)
+	return count, err
+}
+
+// ReviewDoctor records an admin's approval or rejection of a pending
+// doctor. It returns sql.ErrNoRows if the doctor isn't pending.
+func ReviewDoctor(db *sql.DB, doctorID int, status, note string, reviewerID int) error {
+	query := `
+		UPDATE doctors
+		SET verification_status = $2, verification_note = $3, reviewed_by = $4, reviewed_at = $5
+		WHERE id = $1 AND verification_status = 'pending_verification'
+	`
+
+	res, err := db.Exec(query, doctorID, status, note, reviewerID, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
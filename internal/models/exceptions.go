@@ -0,0 +1,356 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Kinds of schedule exception
+const (
+	ExceptionBlock = "block" // the doctor is away; no slots are offered
+	ExceptionExtra = "extra" // one-off working hours on top of the weekly schedule
+)
+
+// ScheduleException blocks or adds working time for a doctor on a range of
+// dates. Blocks without a start and end time cover the whole day.
+type ScheduleException struct {
+	ID        int       `json:"id"`
+	DoctorID  int       `json:"doctor_id"`
+	Kind      string    `json:"kind"`
+	StartDate string    `json:"start_date"` // YYYY-MM-DD
+	EndDate   string    `json:"end_date"`   // YYYY-MM-DD, inclusive
+	StartTime string    `json:"start_time"` // HH:MM, empty for a full-day block
+	EndTime   string    `json:"end_time"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IsFullDay reports whether the exception covers whole days
+func (e ScheduleException) IsFullDay() bool {
+	return e.StartTime == ""
+}
+
+// ClinicHoliday is a range of dates the whole clinic is closed
+type ClinicHoliday struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	StartDate string    `json:"start_date"` // YYYY-MM-DD
+	EndDate   string    `json:"end_date"`   // YYYY-MM-DD, inclusive
+	UID       string    `json:"-"`          // iCalendar UID, so re-imports update instead of duplicating
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// scheduleExceptionColumns are selected by every schedule exception query
+const scheduleExceptionColumns = `
+	id, doctor_id, kind, to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD'),
+	COALESCE(to_char(start_time, 'HH24:MI'), ''), COALESCE(to_char(end_time, 'HH24:MI'), ''),
+	COALESCE(reason, ''), created_at
+`
+
+// queryScheduleExceptions runs a schedule exception query
+func queryScheduleExceptions(db *sql.DB, query string, args ...interface{}) ([]ScheduleException, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exceptions []ScheduleException
+	for rows.Next() {
+		var e ScheduleException
+		err := rows.Scan(&e.ID, &e.DoctorID, &e.Kind, &e.StartDate, &e.EndDate,
+			&e.StartTime, &e.EndTime, &e.Reason, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		exceptions = append(exceptions, e)
+	}
+
+	return exceptions, rows.Err()
+}
+
+// GetScheduleExceptions retrieves a doctor's current and future exceptions
+func GetScheduleExceptions(db *sql.DB, doctorID int) ([]ScheduleException, error) {
+	return queryScheduleExceptions(db, `
+		SELECT `+scheduleExceptionColumns+`
+		FROM schedule_exceptions
+		WHERE doctor_id = $1 AND end_date >= CURRENT_DATE
+		ORDER BY start_date, start_time NULLS FIRST
+	`, doctorID)
+}
+
+// GetScheduleExceptionsForDate retrieves a doctor's exceptions covering date
+func GetScheduleExceptionsForDate(db *sql.DB, doctorID int, date string) ([]ScheduleException, error) {
+	return queryScheduleExceptions(db, `
+		SELECT `+scheduleExceptionColumns+`
+		FROM schedule_exceptions
+		WHERE doctor_id = $1 AND $2 BETWEEN start_date AND end_date
+		ORDER BY start_time NULLS FIRST
+	`, doctorID, date)
+}
+
+// CreateScheduleException stores an exception. For blocks it flags and
+// returns the doctor's confirmed appointments inside the blocked time;
+// extra hours clear the note on appointments they bring back into working
+// hours.
+func CreateScheduleException(db *sql.DB, e *ScheduleException) ([]Appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockSchedule(tx, e.DoctorID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO schedule_exceptions (doctor_id, kind, start_date, end_date, start_time, end_time, reason)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::time, NULLIF($6, '')::time, NULLIF($7, ''))
+		RETURNING id, created_at
+	`
+	err = tx.QueryRow(query, e.DoctorID, e.Kind, e.StartDate, e.EndDate, e.StartTime, e.EndTime, e.Reason).Scan(
+		&e.ID, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var flagged []Appointment
+	if e.Kind == ExceptionBlock {
+		note := "Doctor unavailable"
+		if e.Reason != "" {
+			note += ": " + e.Reason
+		}
+		flagged, err = flagConflicts(tx, `
+			UPDATE appointments a SET conflict_note = $6
+			FROM users u, doctors d
+			WHERE u.id = a.patient_id AND d.id = a.doctor_id AND a.doctor_id = $1 AND a.status = 'confirmed'
+			  AND a.starts_at >= CURRENT_TIMESTAMP
+			  AND (a.starts_at AT TIME ZONE d.timezone)::date BETWEEN $2 AND $3
+			  AND (NULLIF($4, '') IS NULL OR (
+				(a.starts_at AT TIME ZONE d.timezone)::time < NULLIF($5, '')::time
+				AND NULLIF($4, '')::time < (a.starts_at AT TIME ZONE d.timezone)::time + a.duration_minutes * INTERVAL '1 minute'))
+			RETURNING `+flaggedColumns,
+			e.DoctorID, e.StartDate, e.EndDate, e.StartTime, e.EndTime, note)
+		if err != nil {
+			return nil, err
+		}
+	} else if _, err := refreshWorkingHoursBetween(tx, e.DoctorID, e.StartDate, e.EndDate); err != nil {
+		return nil, err
+	}
+
+	return flagged, tx.Commit()
+}
+
+// DeleteScheduleException removes one of a doctor's exceptions and
+// re-checks the confirmed appointments on its dates: a removed block clears
+// the notes it set, and removed extra hours can leave appointments outside
+// working hours. It returns the appointments flagged as a result, or
+// sql.ErrNoRows if the exception doesn't belong to the doctor.
+func DeleteScheduleException(db *sql.DB, doctorID, exceptionID int) ([]Appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockSchedule(tx, doctorID); err != nil {
+		return nil, err
+	}
+
+	var kind, startDate, endDate string
+	err = tx.QueryRow(`
+		DELETE FROM schedule_exceptions WHERE id = $1 AND doctor_id = $2
+		RETURNING kind, to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD')
+	`, exceptionID, doctorID).Scan(&kind, &startDate, &endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == ExceptionBlock {
+		// Appointments still inside another block keep their note
+		_, err = tx.Exec(`
+			UPDATE appointments a SET conflict_note = NULL
+			FROM doctors d
+			WHERE d.id = a.doctor_id AND a.doctor_id = $1 AND a.status = 'confirmed'
+			  AND a.starts_at >= CURRENT_TIMESTAMP
+			  AND a.conflict_note LIKE 'Doctor unavailable%'
+			  AND (a.starts_at AT TIME ZONE d.timezone)::date BETWEEN $2 AND $3
+			  AND NOT EXISTS (
+				SELECT 1 FROM schedule_exceptions x
+				WHERE x.doctor_id = a.doctor_id AND x.kind = 'block'
+				  AND (a.starts_at AT TIME ZONE d.timezone)::date BETWEEN x.start_date AND x.end_date
+				  AND (x.start_time IS NULL OR (
+					(a.starts_at AT TIME ZONE d.timezone)::time < x.end_time
+					AND x.start_time < (a.starts_at AT TIME ZONE d.timezone)::time + a.duration_minutes * INTERVAL '1 minute'))
+			  )
+		`, doctorID, startDate, endDate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	flagged, err := refreshWorkingHoursBetween(tx, doctorID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return flagged, tx.Commit()
+}
+
+// refreshWorkingHoursBetween re-checks the doctor's confirmed appointments
+// between two dates, inclusive, against working hours
+func refreshWorkingHoursBetween(tx *sql.Tx, doctorID int, startDate, endDate string) ([]Appointment, error) {
+	return refreshWorkingHours(tx,
+		`a.doctor_id = $1 AND (a.starts_at AT TIME ZONE d.timezone)::date BETWEEN $2 AND $3`,
+		doctorID, startDate, endDate)
+}
+
+// GetClinicHolidays retrieves the clinic's current and future holidays
+func GetClinicHolidays(db *sql.DB) ([]ClinicHoliday, error) {
+	query := `
+		SELECT id, name, to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD'),
+		       COALESCE(uid, ''), created_at
+		FROM clinic_holidays
+		WHERE end_date >= CURRENT_DATE
+		ORDER BY start_date
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holidays []ClinicHoliday
+	for rows.Next() {
+		var h ClinicHoliday
+		if err := rows.Scan(&h.ID, &h.Name, &h.StartDate, &h.EndDate, &h.UID, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, h)
+	}
+
+	return holidays, rows.Err()
+}
+
+// IsClinicHoliday reports whether the clinic is closed on date
+func IsClinicHoliday(db *sql.DB, date string) (bool, error) {
+	var closed bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM clinic_holidays WHERE $1 BETWEEN start_date AND end_date)`,
+		date).Scan(&closed)
+	return closed, err
+}
+
+// SaveClinicHolidays adds holidays, updating those already imported with
+// the same UID, then flags and returns confirmed appointments on them
+func SaveClinicHolidays(db *sql.DB, holidays []ClinicHoliday) ([]Appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO clinic_holidays (name, start_date, end_date, uid)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		ON CONFLICT (uid) DO UPDATE SET name = EXCLUDED.name, start_date = EXCLUDED.start_date, end_date = EXCLUDED.end_date
+		RETURNING id, created_at
+	`
+	for i := range holidays {
+		h := &holidays[i]
+		if err := tx.QueryRow(query, h.Name, h.StartDate, h.EndDate, h.UID).Scan(&h.ID, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	flagged, err := flagConflicts(tx, `
+		UPDATE appointments a SET conflict_note = 'Clinic closed: ' || h.name
+		FROM users u, doctors d, clinic_holidays h
+		WHERE u.id = a.patient_id AND d.id = a.doctor_id AND a.status = 'confirmed'
+		  AND a.starts_at >= CURRENT_TIMESTAMP
+		  AND (a.starts_at AT TIME ZONE d.timezone)::date BETWEEN h.start_date AND h.end_date
+		  AND a.conflict_note IS NULL
+		RETURNING `+flaggedColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	return flagged, tx.Commit()
+}
+
+// DeleteClinicHoliday removes a holiday and clears the note it set on
+// confirmed appointments no other holiday covers. It returns sql.ErrNoRows
+// if there is no such holiday.
+func DeleteClinicHoliday(db *sql.DB, holidayID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name, startDate, endDate string
+	err = tx.QueryRow(`
+		DELETE FROM clinic_holidays WHERE id = $1
+		RETURNING name, to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD')
+	`, holidayID).Scan(&name, &startDate, &endDate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE appointments a SET conflict_note = NULL
+		FROM doctors d
+		WHERE d.id = a.doctor_id AND a.status = 'confirmed' AND a.starts_at >= CURRENT_TIMESTAMP
+		  AND a.conflict_note = 'Clinic closed: ' || $1
+		  AND (a.starts_at AT TIME ZONE d.timezone)::date BETWEEN $2 AND $3
+		  AND NOT EXISTS (
+			SELECT 1 FROM clinic_holidays h
+			WHERE (a.starts_at AT TIME ZONE d.timezone)::date BETWEEN h.start_date AND h.end_date
+		  )
+	`, name, startDate, endDate)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// flaggedColumns are returned by the queries that flag appointments, for
+// flagConflicts to scan. The appointment table must be aliased a, its
+// doctor d and the patient's user row u.
+const flaggedColumns = `
+	a.id, a.patient_id, a.doctor_id, a.starts_at, a.status, a.duration_minutes, a.conflict_note,
+	u.first_name, u.last_name, u.email, d.timezone
+`
+
+// flagConflicts runs a query that marks appointments as conflicting with
+// the schedule and returns them, shown in their doctor's time zone
+func flagConflicts(tx *sql.Tx, query string, args ...interface{}) ([]Appointment, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flagged []Appointment
+	for rows.Next() {
+		var a Appointment
+		var patient User
+		var timeZone string
+		err := rows.Scan(&a.ID, &a.PatientID, &a.DoctorID, &a.StartsAt, &a.Status, &a.DurationMinutes,
+			&a.ConflictNote, &patient.FirstName, &patient.LastName, &patient.Email, &timeZone)
+		if err != nil {
+			return nil, err
+		}
+		patient.ID = a.PatientID
+		a.Patient = &patient
+		a.localizeToDoctor(timeZone)
+		flagged = append(flagged, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return flagged, rows.Close()
+}
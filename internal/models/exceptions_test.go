@@ -0,0 +1,134 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestSaveClinicHolidaysReimport imports a holiday twice, and then once
+// more in the same batch, under one UID; it must stay a single row
+// carrying the latest details
+func TestSaveClinicHolidaysReimport(t *testing.T) {
+	db := testDB(t)
+	uid := fmt.Sprintf("reimport-%d@example.com", time.Now().UnixNano())
+	t.Cleanup(func() { db.Exec(`DELETE FROM clinic_holidays WHERE uid = $1`, uid) })
+
+	holiday := ClinicHoliday{Name: "Founders Day", StartDate: "2099-12-24", EndDate: "2099-12-24", UID: uid}
+	if _, err := SaveClinicHolidays(db, []ClinicHoliday{holiday}); err != nil {
+		t.Fatal(err)
+	}
+	holiday.Name, holiday.EndDate = "Founders Days", "2099-12-25"
+	renamed := holiday
+	renamed.Name = "Founders Holidays"
+	if _, err := SaveClinicHolidays(db, []ClinicHoliday{holiday, renamed}); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	var name, end string
+	err := db.QueryRow(`
+		SELECT COUNT(*) OVER (), name, to_char(end_date, 'YYYY-MM-DD') FROM clinic_holidays WHERE uid = $1
+	`, uid).Scan(&count, &name, &end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || name != "Founders Holidays" || end != "2099-12-25" {
+		t.Errorf("got %d rows, %q until %s; want 1 row, Founders Holidays until 2099-12-25", count, name, end)
+	}
+}
+
+func TestDeleteBlockClearsNote(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	a := bookLocal(t, doctor, 10)
+	local := a.StartsAt.In(mustLoadLocation(t, doctor.TimeZone))
+	window := &DoctorAvailability{DoctorID: doctor.ID, DayOfWeek: int(local.Weekday()), StartTime: "09:00", EndTime: "12:00", IsActive: true}
+	if _, err := CreateAvailability(db, window); err != nil {
+		t.Fatal(err)
+	}
+
+	date := local.Format("2006-01-02")
+	day := &ScheduleException{DoctorID: doctor.ID, Kind: ExceptionBlock, StartDate: date, EndDate: date, Reason: "Conference"}
+	morning := &ScheduleException{DoctorID: doctor.ID, Kind: ExceptionBlock, StartDate: date, EndDate: date, StartTime: "09:30", EndTime: "10:30"}
+	for _, e := range []*ScheduleException{day, morning} {
+		if _, err := CreateScheduleException(db, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The morning block still covers the appointment
+	if _, err := DeleteScheduleException(db, doctor.ID, day.ID); err != nil {
+		t.Fatal(err)
+	}
+	if note := conflictNote(t, a.ID); note == "" {
+		t.Fatal("note cleared while another block still covers the appointment")
+	}
+
+	flagged, err := DeleteScheduleException(db, doctor.ID, morning.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flagged) != 0 {
+		t.Errorf("deleting the last block flagged %v", flagged)
+	}
+	if note := conflictNote(t, a.ID); note != "" {
+		t.Errorf("note after deleting every block = %q, want none", note)
+	}
+}
+
+func TestExtraHoursClearAndRestoreNote(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	a := bookLocal(t, doctor, 10)
+	local := a.StartsAt.In(mustLoadLocation(t, doctor.TimeZone))
+
+	// A window later that day leaves the appointment outside working hours
+	window := &DoctorAvailability{DoctorID: doctor.ID, DayOfWeek: int(local.Weekday()), StartTime: "14:00", EndTime: "16:00", IsActive: true}
+	if _, err := CreateAvailability(db, window); err != nil {
+		t.Fatal(err)
+	}
+	if note := conflictNote(t, a.ID); note != outsideHoursNote {
+		t.Fatalf("note = %q, want %q", note, outsideHoursNote)
+	}
+
+	date := local.Format("2006-01-02")
+	extra := &ScheduleException{DoctorID: doctor.ID, Kind: ExceptionExtra, StartDate: date, EndDate: date, StartTime: "09:00", EndTime: "12:00"}
+	if _, err := CreateScheduleException(db, extra); err != nil {
+		t.Fatal(err)
+	}
+	if note := conflictNote(t, a.ID); note != "" {
+		t.Errorf("note after adding extra hours = %q, want none", note)
+	}
+
+	flagged, err := DeleteScheduleException(db, doctor.ID, extra.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flagged) != 1 || flagged[0].ID != a.ID {
+		t.Errorf("deleting the extra hours flagged %v, want appointment %d", flagged, a.ID)
+	}
+}
+
+func TestDeleteClinicHolidayClearsNote(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	a := bookLocal(t, doctor, 10)
+	date := a.StartsAt.In(mustLoadLocation(t, doctor.TimeZone)).Format("2006-01-02")
+
+	holidays := []ClinicHoliday{{Name: fmt.Sprintf("Test Day %d", time.Now().UnixNano()), StartDate: date, EndDate: date}}
+	if _, err := SaveClinicHolidays(db, holidays); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM clinic_holidays WHERE id = $1`, holidays[0].ID) })
+	if note := conflictNote(t, a.ID); note != "Clinic closed: "+holidays[0].Name {
+		t.Fatalf("note = %q, want the holiday", note)
+	}
+
+	if err := DeleteClinicHoliday(db, holidays[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if note := conflictNote(t, a.ID); note != "" {
+		t.Errorf("note after deleting the holiday = %q, want none", note)
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SlotHold reserves a slot for a patient for a short time, e.g. while they
+// pay, so nobody else can book it in the meantime. Expired holds are
+// ignored and swept up by DeleteExpiredSlotHolds.
+type SlotHold struct {
+	ID              int       `json:"id"`
+	DoctorID        int       `json:"doctor_id"`
+	PatientID       int       `json:"patient_id"`
+	StartsAt        time.Time `json:"starts_at"`
+	DurationMinutes int       `json:"duration_minutes"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// slotClaim is a span of a doctor's time about to be taken by an
+// appointment or hold
+type slotClaim struct {
+	doctorID      int
+	start         time.Time
+	minutes       int
+	buffer        int // the doctor's buffer, kept free on either side
+	appointmentID int // the appointment being moved, if any, which doesn't clash with itself
+	patientID     int // the patient claiming it, whose own holds don't clash
+}
+
+// lockScheduleSettings locks the doctor's schedule like lockSchedule and
+// returns the slot settings, so bookings for a doctor happen one at a time
+func lockScheduleSettings(tx *sql.Tx, doctorID int) (*ScheduleSettings, error) {
+	settings := &ScheduleSettings{}
+	err := tx.QueryRow(`SELECT slot_minutes, buffer_minutes, timezone FROM doctors WHERE id = $1 FOR UPDATE`,
+		doctorID).Scan(&settings.SlotMinutes, &settings.BufferMinutes, &settings.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// checkSlotFree returns ErrSlotUnavailable if the claim overlaps another
+// appointment or a live hold, including the buffer around them. The
+// doctor's schedule must be locked.
+func checkSlotFree(tx *sql.Tx, c slotClaim) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM appointments
+			WHERE doctor_id = $1 AND status != 'cancelled' AND id != $5
+			  AND starts_at < $2::timestamptz + ($3 + $4) * INTERVAL '1 minute'
+			  AND starts_at + (duration_minutes + $4) * INTERVAL '1 minute' > $2
+		) OR EXISTS (
+			SELECT 1 FROM slot_holds
+			WHERE doctor_id = $1 AND patient_id != $6 AND expires_at > CURRENT_TIMESTAMP
+			  AND starts_at < $2::timestamptz + ($3 + $4) * INTERVAL '1 minute'
+			  AND starts_at + (duration_minutes + $4) * INTERVAL '1 minute' > $2
+		)
+	`
+
+	var taken bool
+	err := tx.QueryRow(query, c.doctorID, c.start, c.minutes, c.buffer, c.appointmentID, c.patientID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSlotUnavailable
+	}
+	return nil
+}
+
+// HoldSlot reserves hold.StartsAt with the doctor for the patient until ttl
+// from now. A patient holds one slot at a time, so an earlier hold is
+// replaced. It returns ErrSlotUnavailable if the slot is taken.
+func HoldSlot(db *sql.DB, hold *SlotHold, ttl time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	settings, err := lockScheduleSettings(tx, hold.DoctorID)
+	if err != nil {
+		return err
+	}
+
+	err = checkSlotFree(tx, slotClaim{
+		doctorID:  hold.DoctorID,
+		start:     hold.StartsAt,
+		minutes:   settings.SlotMinutes,
+		buffer:    settings.BufferMinutes,
+		patientID: hold.PatientID,
+	})
+	if err != nil {
+		return err
+	}
+
+	hold.DurationMinutes = settings.SlotMinutes
+	if err := putSlotHold(tx, hold, ttl); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// putSlotHold stores a hold lasting ttl from now, replacing the patient's
+// earlier hold if any. Holds of waitlist offers made to the patient are
+// kept.
+func putSlotHold(tx *sql.Tx, hold *SlotHold, ttl time.Duration) error {
+	query := `
+		INSERT INTO slot_holds (doctor_id, patient_id, starts_at, duration_minutes, expires_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second')
+		ON CONFLICT (patient_id) WHERE offer_id IS NULL DO UPDATE
+		SET doctor_id = EXCLUDED.doctor_id, starts_at = EXCLUDED.starts_at,
+		    duration_minutes = EXCLUDED.duration_minutes, expires_at = EXCLUDED.expires_at,
+		    created_at = CURRENT_TIMESTAMP
+		RETURNING id, expires_at, created_at
+	`
+	return tx.QueryRow(query, hold.DoctorID, hold.PatientID, hold.StartsAt, hold.DurationMinutes, int(ttl.Seconds())).Scan(
+		&hold.ID, &hold.ExpiresAt, &hold.CreatedAt)
+}
+
+// ReleaseSlotHold gives up a patient's hold. It returns sql.ErrNoRows if
+// the hold doesn't belong to the patient; the hold of a waitlist offer
+// goes with the offer instead.
+func ReleaseSlotHold(db *sql.DB, holdID, patientID int) error {
+	result, err := db.Exec(`DELETE FROM slot_holds WHERE id = $1 AND patient_id = $2 AND offer_id IS NULL`, holdID, patientID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteExpiredSlotHolds removes holds that expired before now
+func DeleteExpiredSlotHolds(db *sql.DB, now time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM slot_holds WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
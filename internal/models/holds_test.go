@@ -0,0 +1,137 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/appointment"
+)
+
+// testSlot returns a whole hour a month from now, plus offset
+func testSlot(offset time.Duration) time.Time {
+	return time.Now().UTC().Truncate(time.Hour).AddDate(0, 1, 0).Add(offset)
+}
+
+// race books or holds each start concurrently, every claim by a different
+// patient, alternating between CreateAppointment and HoldSlot. It returns
+// the starts that were claimed; every other claim must have failed with
+// ErrSlotUnavailable.
+func race(t *testing.T, doctor *Doctor, starts []time.Time) []time.Time {
+	t.Helper()
+	db := testDB(t)
+	patients := make([]*User, len(starts))
+	for i := range starts {
+		patients[i] = createTestUser(t, db, "patient")
+	}
+
+	var mu sync.Mutex
+	var won []time.Time
+	var wg sync.WaitGroup
+	begin := make(chan struct{})
+	for i, start := range starts {
+		wg.Add(1)
+		go func(i int, start time.Time) {
+			defer wg.Done()
+			<-begin
+
+			var err error
+			if i%2 == 0 {
+				err = CreateAppointment(db, &Appointment{PatientID: patients[i].ID, DoctorID: doctor.ID, StartsAt: start})
+			} else {
+				err = HoldSlot(db, &SlotHold{PatientID: patients[i].ID, DoctorID: doctor.ID, StartsAt: start}, time.Hour)
+			}
+			switch {
+			case err == nil:
+				mu.Lock()
+				won = append(won, start)
+				mu.Unlock()
+			case !errors.Is(err, ErrSlotUnavailable):
+				t.Errorf("claim %d at %v: %v", i, start, err)
+			}
+		}(i, start)
+	}
+	close(begin)
+	wg.Wait()
+	return won
+}
+
+func TestConcurrentBookingsOfOneSlot(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+
+	starts := make([]time.Time, 12)
+	for i := range starts {
+		starts[i] = testSlot(0)
+	}
+	if won := race(t, doctor, starts); len(won) != 1 {
+		t.Fatalf("%d claims of the same slot succeeded, want 1", len(won))
+	}
+}
+
+func TestConcurrentBookingsOfOverlappingSlots(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 90, 0)
+
+	// A 90 minute appointment from 10:00 to 11:30, booked before the doctor
+	// moved to 45 minute slots with a 15 minute buffer
+	first := createTestUser(t, db, "patient")
+	long := &Appointment{PatientID: first.ID, DoctorID: doctor.ID, StartsAt: testSlot(10 * time.Hour)}
+	if err := CreateAppointment(db, long); err != nil {
+		t.Fatal(err)
+	}
+	if long.DurationMinutes != 90 {
+		t.Fatalf("appointment lasts %d minutes, want 90", long.DurationMinutes)
+	}
+	setSlotLength(t, db, doctor.ID, 45, 15)
+
+	// 11:00 and 11:30 run into the long appointment or its buffer; the
+	// others, from 11:45 to 12:15, all overlap each other
+	var starts []time.Time
+	for _, m := range []int{60, 90, 105, 105, 115, 120, 120, 135} {
+		starts = append(starts, testSlot(10*time.Hour+time.Duration(m)*time.Minute))
+	}
+	won := race(t, doctor, starts)
+	if len(won) != 1 {
+		t.Fatalf("%d overlapping claims succeeded (%v), want 1", len(won), won)
+	}
+	if won[0].Before(testSlot(11*time.Hour + 45*time.Minute)) {
+		t.Errorf("claim at %v succeeded next to the 90 minute appointment", won[0])
+	}
+
+	// A slot clear of both, at 13:00, is still free
+	later := createTestUser(t, db, "patient")
+	if err := CreateAppointment(db, &Appointment{PatientID: later.ID, DoctorID: doctor.ID, StartsAt: testSlot(13 * time.Hour)}); err != nil {
+		t.Errorf("booking a free slot: %v", err)
+	}
+}
+
+func TestCancelledAppointmentFreesItsSlot(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	start := testSlot(0)
+
+	first := createTestUser(t, db, "patient")
+	a := &Appointment{PatientID: first.ID, DoctorID: doctor.ID, StartsAt: start}
+	if err := CreateAppointment(db, a); err != nil {
+		t.Fatal(err)
+	}
+	second := createTestUser(t, db, "patient")
+	if err := HoldSlot(db, &SlotHold{PatientID: second.ID, DoctorID: doctor.ID, StartsAt: start}, time.Hour); !errors.Is(err, ErrSlotUnavailable) {
+		t.Fatalf("holding a booked slot: err = %v, want ErrSlotUnavailable", err)
+	}
+
+	if err := CancelAppointment(db, a.ID, first.ID, "", appointment.Policy{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent claims of the freed slot still go to exactly one patient
+	starts := make([]time.Time, 6)
+	for i := range starts {
+		starts[i] = start
+	}
+	if won := race(t, doctor, starts); len(won) != 1 {
+		t.Fatalf("%d claims of the freed slot succeeded, want 1", len(won))
+	}
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+)
+
+// ErrNotPaid is returned when invoicing a payment that hasn't gone through
+var ErrNotPaid = errors.New("payment has not been made")
+
+// InvoiceSettings are the clinic's details and tax printed on invoices.
+// Each invoice keeps a copy, so later changes don't alter issued ones.
+type InvoiceSettings struct {
+	Prefix        string // invoice numbers are Prefix-YEAR-NNNNNN
+	SellerName    string
+	SellerAddress string
+	SellerTaxID   string
+	TaxName       string  // e.g. VAT
+	TaxRate       float64 // percent, included in the fee
+}
+
+// Invoice is the bill for a paid appointment. Numbers run without gaps
+// within each year.
+type Invoice struct {
+	ID            int           `json:"id"`
+	Number        string        `json:"number"`
+	PaymentID     int           `json:"payment_id"`
+	AppointmentID int           `json:"appointment_id"`
+	PatientID     int           `json:"patient_id"`
+	IssuedAt      time.Time     `json:"issued_at"`
+	SellerName    string        `json:"seller_name"`
+	SellerAddress string        `json:"seller_address"`
+	SellerTaxID   string        `json:"seller_tax_id"`
+	BuyerName     string        `json:"buyer_name"`
+	BuyerEmail    string        `json:"buyer_email"`
+	Subtotal      money.Money   `json:"subtotal"` // Total without tax
+	TaxName       string        `json:"tax_name"`
+	TaxRate       float64       `json:"tax_rate"`
+	TaxAmount     money.Money   `json:"tax_amount"`
+	Total         money.Money   `json:"total"`
+	Items         []InvoiceItem `json:"items"`
+
+	// How it was paid
+	Gateway          string     `json:"gateway"`
+	PaymentReference string     `json:"payment_reference"`
+	PaidAt           *time.Time `json:"paid_at,omitempty"`
+}
+
+// InvoiceItem is a line of an invoice
+type InvoiceItem struct {
+	Description string      `json:"description"`
+	Quantity    int         `json:"quantity"`
+	UnitPrice   money.Money `json:"unit_price"`
+	Amount      money.Money `json:"amount"`
+}
+
+// IssueInvoice issues the invoice for a paid payment, dated now, or
+// returns the one already issued. Payments refunded since they were made
+// can still be invoiced. It returns ErrNotPaid for payments that haven't
+// gone through.
+func IssueInvoice(db *sql.DB, paymentID int, settings InvoiceSettings, now time.Time) (*Invoice, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the payment makes concurrent calls wait for the first invoice
+	var status string
+	err = tx.QueryRow(`SELECT status FROM payments WHERE id = $1 FOR UPDATE`, paymentID).Scan(&status)
+	if err != nil {
+		return nil, err
+	}
+	if status != PaymentPaid && status != PaymentRefunded && status != PaymentPartlyRefunded {
+		return nil, ErrNotPaid
+	}
+
+	var invoiceID int
+	err = tx.QueryRow(`SELECT id FROM invoices WHERE payment_id = $1`, paymentID).Scan(&invoiceID)
+	if err == nil {
+		tx.Rollback()
+		return GetInvoiceByID(db, invoiceID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	inv := &Invoice{
+		PaymentID:     paymentID,
+		IssuedAt:      now,
+		SellerName:    settings.SellerName,
+		SellerAddress: settings.SellerAddress,
+		SellerTaxID:   settings.SellerTaxID,
+		TaxName:       settings.TaxName,
+		TaxRate:       settings.TaxRate,
+	}
+	var doctorName, specialty, timeZone string
+	var startsAt time.Time
+	err = tx.QueryRow(`
+		SELECT p.appointment_id, a.patient_id, pu.first_name || ' ' || pu.last_name, pu.email,
+		       p.amount_minor, p.currency, du.first_name || ' ' || du.last_name, d.specialty, a.starts_at, d.timezone
+		FROM payments p
+		JOIN appointments a ON p.appointment_id = a.id
+		JOIN users pu ON a.patient_id = pu.id
+		JOIN doctors d ON a.doctor_id = d.id
+		JOIN users du ON d.user_id = du.id
+		WHERE p.id = $1
+	`, paymentID).Scan(&inv.AppointmentID, &inv.PatientID, &inv.BuyerName, &inv.BuyerEmail,
+		&inv.Total.Minor, &inv.Total.Currency, &doctorName, &specialty, &startsAt, &timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fees include tax, so the tax is taken out of the total
+	inv.TaxAmount = includedTax(inv.Total, settings.TaxRate)
+	inv.Subtotal = inv.Total.Sub(inv.TaxAmount)
+
+	start := startsAt
+	if loc, err := time.LoadLocation(timeZone); err == nil {
+		start = start.In(loc)
+	}
+	inv.Items = []InvoiceItem{{
+		Description: fmt.Sprintf("Consultation with Dr. %s (%s), %s", doctorName, specialty,
+			start.Format("2 January 2006 15:04 MST")),
+		Quantity:  1,
+		UnitPrice: inv.Total,
+		Amount:    inv.Total,
+	}}
+
+	// The counter row stays locked until commit, so numbers are handed out
+	// one at a time and a rolled back invoice doesn't leave a gap
+	var seq int
+	err = tx.QueryRow(`
+		INSERT INTO invoice_counters (year, last_number) VALUES ($1, 1)
+		ON CONFLICT (year) DO UPDATE SET last_number = invoice_counters.last_number + 1
+		RETURNING last_number
+	`, now.Year()).Scan(&seq)
+	if err != nil {
+		return nil, err
+	}
+	inv.Number = fmt.Sprintf("%s-%d-%06d", settings.Prefix, now.Year(), seq)
+
+	err = tx.QueryRow(`
+		INSERT INTO invoices (number, payment_id, appointment_id, patient_id, issued_at,
+		                      seller_name, seller_address, seller_tax_id, buyer_name, buyer_email,
+		                      currency, subtotal_minor, tax_name, tax_rate, tax_minor, total_minor)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		RETURNING id
+	`, inv.Number, inv.PaymentID, inv.AppointmentID, inv.PatientID, inv.IssuedAt,
+		inv.SellerName, inv.SellerAddress, inv.SellerTaxID, inv.BuyerName, inv.BuyerEmail,
+		inv.Total.Currency, inv.Subtotal.Minor, inv.TaxName, inv.TaxRate, inv.TaxAmount.Minor, inv.Total.Minor).Scan(&inv.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range inv.Items {
+		_, err := tx.Exec(`
+			INSERT INTO invoice_items (invoice_id, position, description, quantity, unit_price_minor, amount_minor)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, inv.ID, i+1, item.Description, item.Quantity, item.UnitPrice.Minor, item.Amount.Minor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetInvoiceByID(db, inv.ID)
+}
+
+// includedTax returns the tax contained in total at rate percent, rounded
+// half up to whole minor units. The rate is stored with two decimals, so
+// it is exact in hundredths of a percent and the split needs no floats.
+func includedTax(total money.Money, rate float64) money.Money {
+	basisPoints := int64(math.Round(rate * 100))
+	if basisPoints <= 0 {
+		return money.New(0, total.Currency)
+	}
+	// Whole multiples of the gross rate divide exactly; only the remainder
+	// is rounded, which also keeps the products well inside int64
+	gross := 10000 + basisPoints
+	whole, rest := total.Minor/gross, total.Minor%gross
+	return money.New(whole*basisPoints+(2*rest*basisPoints+gross)/(2*gross), total.Currency)
+}
+
+// GetInvoiceByID retrieves an invoice with its items and payment
+func GetInvoiceByID(db *sql.DB, invoiceID int) (*Invoice, error) {
+	inv := &Invoice{}
+	var paidAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT i.id, i.number, i.payment_id, i.appointment_id, i.patient_id, i.issued_at,
+		       i.seller_name, i.seller_address, i.seller_tax_id, i.buyer_name, i.buyer_email,
+		       i.currency, i.subtotal_minor, i.tax_name, i.tax_rate, i.tax_minor, i.total_minor,
+		       p.gateway, p.reference, p.paid_at
+		FROM invoices i
+		JOIN payments p ON i.payment_id = p.id
+		WHERE i.id = $1
+	`, invoiceID).Scan(&inv.ID, &inv.Number, &inv.PaymentID, &inv.AppointmentID, &inv.PatientID, &inv.IssuedAt,
+		&inv.SellerName, &inv.SellerAddress, &inv.SellerTaxID, &inv.BuyerName, &inv.BuyerEmail,
+		&inv.Total.Currency, &inv.Subtotal.Minor, &inv.TaxName, &inv.TaxRate, &inv.TaxAmount.Minor, &inv.Total.Minor,
+		&inv.Gateway, &inv.PaymentReference, &paidAt)
+	if err != nil {
+		return nil, err
+	}
+	if paidAt.Valid {
+		inv.PaidAt = &paidAt.Time
+	}
+	inv.Subtotal.Currency = inv.Total.Currency
+	inv.TaxAmount.Currency = inv.Total.Currency
+
+	rows, err := db.Query(`
+		SELECT description, quantity, unit_price_minor, amount_minor
+		FROM invoice_items
+		WHERE invoice_id = $1
+		ORDER BY position
+	`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item InvoiceItem
+		if err := rows.Scan(&item.Description, &item.Quantity, &item.UnitPrice.Minor, &item.Amount.Minor); err != nil {
+			return nil, err
+		}
+		item.UnitPrice.Currency = inv.Total.Currency
+		item.Amount.Currency = inv.Total.Currency
+		inv.Items = append(inv.Items, item)
+	}
+	return inv, rows.Err()
+}
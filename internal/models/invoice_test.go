@@ -0,0 +1,148 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+
+	"github.com/lib/pq"
+)
+
+func TestIncludedTax(t *testing.T) {
+	tests := []struct {
+		name  string
+		total money.Money
+		rate  float64
+		want  int64
+	}{
+		{"no tax", money.New(1000000, "KZT"), 0, 0},
+		{"12 percent", money.New(1120000, "KZT"), 12, 120000},
+		{"rounds down", money.New(1000, "KZT"), 12, 107}, // 107.14
+		{"rounds up", money.New(1005, "KZT"), 12, 108},   // 107.68
+		{"rounds half up", money.New(5, "KZT"), 100, 3},  // 2.5
+		{"fractional rate", money.New(1125000, "KZT"), 12.5, 125000},
+		{"no minor units", money.New(11200, "JPY"), 12, 1200},
+		{"large amount", money.New(1_120_000_000_000_000_005, "KZT"), 12, 120_000_000_000_000_001}, // .54
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := includedTax(tt.total, tt.rate)
+			if got != money.New(tt.want, tt.total.Currency) {
+				t.Errorf("includedTax(%v, %v) = %v, want %d minor units", tt.total, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+// invoiceSettingsForYears returns settings with a prefix of their own and
+// resets the counters of years, so numbers in them start from 1
+func invoiceSettingsForYears(t *testing.T, years ...int) InvoiceSettings {
+	t.Helper()
+	db := testDB(t)
+	if _, err := db.Exec(`DELETE FROM invoice_counters WHERE year = ANY($1)`, pq.Array(years)); err != nil {
+		t.Fatal(err)
+	}
+	return InvoiceSettings{Prefix: fmt.Sprintf("T%d", time.Now().UnixNano()), SellerName: "Test clinic", TaxName: "VAT", TaxRate: 12}
+}
+
+func TestInvoiceNumbersHaveNoGaps(t *testing.T) {
+	db := testDB(t)
+	settings := invoiceSettingsForYears(t, 2201)
+	issued := time.Date(2201, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	paymentIDs := make([]int, 8)
+	for i := range paymentIDs {
+		paymentIDs[i] = createTestPayment(t, db, "test", money.New(1120000, "KZT")).ID
+	}
+
+	// Every payment twice at once; each gets one invoice
+	numbers := make([]string, 2*len(paymentIDs))
+	var wg sync.WaitGroup
+	for i := range numbers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			inv, err := IssueInvoice(db, paymentIDs[i%len(paymentIDs)], settings, issued)
+			if err != nil {
+				t.Errorf("invoice %d: %v", i, err)
+				return
+			}
+			numbers[i] = inv.Number
+		}(i)
+	}
+	wg.Wait()
+
+	for i, id := range paymentIDs {
+		if numbers[i] != numbers[i+len(paymentIDs)] {
+			t.Errorf("payment %d got invoices %s and %s", id, numbers[i], numbers[i+len(paymentIDs)])
+		}
+	}
+	got := slices.Sorted(slices.Values(numbers[:len(paymentIDs)]))
+	var want []string
+	for n := 1; n <= len(paymentIDs); n++ {
+		want = append(want, fmt.Sprintf("%s-2201-%06d", settings.Prefix, n))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("numbers = %v, want %v", got, want)
+	}
+
+	// A payment that can't be invoiced doesn't use up a number
+	failed := createTestPayment(t, db, "test", money.New(1120000, "KZT"))
+	if _, err := db.Exec(`UPDATE payments SET status = 'failed' WHERE id = $1`, failed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IssueInvoice(db, failed.ID, settings, issued); err != ErrNotPaid {
+		t.Errorf("invoicing a failed payment = %v, want ErrNotPaid", err)
+	}
+	next, err := IssueInvoice(db, createTestPayment(t, db, "test", money.New(1120000, "KZT")).ID, settings, issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%s-2201-%06d", settings.Prefix, len(paymentIDs)+1); next.Number != want {
+		t.Errorf("next number = %s, want %s", next.Number, want)
+	}
+}
+
+func TestInvoiceNumbersRestartEachYear(t *testing.T) {
+	db := testDB(t)
+	settings := invoiceSettingsForYears(t, 2202, 2203)
+
+	issue := func(at time.Time) string {
+		t.Helper()
+		inv, err := IssueInvoice(db, createTestPayment(t, db, "test", money.New(1120000, "KZT")).ID, settings, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return inv.Number
+	}
+	got := []string{
+		issue(time.Date(2202, 12, 31, 23, 0, 0, 0, time.UTC)),
+		issue(time.Date(2202, 12, 31, 23, 30, 0, 0, time.UTC)),
+		issue(time.Date(2203, 1, 1, 0, 30, 0, 0, time.UTC)),
+	}
+	want := []string{settings.Prefix + "-2202-000001", settings.Prefix + "-2202-000002", settings.Prefix + "-2203-000001"}
+	if !slices.Equal(got, want) {
+		t.Errorf("numbers = %v, want %v", got, want)
+	}
+}
+
+func TestIssueInvoiceSplitsTax(t *testing.T) {
+	db := testDB(t)
+	settings := invoiceSettingsForYears(t, 2204)
+	p := createTestPayment(t, db, "test", money.New(1000, "KZT"))
+
+	inv, err := IssueInvoice(db, p.ID, settings, time.Date(2204, 6, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.TaxAmount != money.New(107, "KZT") || inv.Subtotal != money.New(893, "KZT") || inv.Total != p.Amount {
+		t.Errorf("invoice is %v + %v tax = %v, want 8.93 + 1.07 = %v", inv.Subtotal, inv.TaxAmount, inv.Total, p.Amount)
+	}
+	if len(inv.Items) != 1 || inv.Items[0].Amount != p.Amount {
+		t.Errorf("items = %+v, want one for %v", inv.Items, p.Amount)
+	}
+}
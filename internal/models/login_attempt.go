@@ -0,0 +1,172 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Reasons a login attempt failed
+const (
+	LoginUnknownEmail = "unknown_email"
+	LoginBadPassword  = "bad_password"
+)
+
+// LoginAttempt is a recorded failed login
+type LoginAttempt struct {
+	ID          int
+	Email       string
+	IP          string
+	UserID      *int // nil when the email doesn't belong to an account
+	Reason      string
+	AttemptedAt time.Time
+}
+
+// LoginState is an account's brute-force protection state
+type LoginState struct {
+	FailedLogins      int        // consecutive failures since the last success
+	LastFailedLoginAt *time.Time // nil if there were none
+	LockedUntil       *time.Time // nil unless the account is locked
+}
+
+// IsLocked reports whether the account is locked at time now
+func (s *LoginState) IsLocked(now time.Time) bool {
+	return s.LockedUntil != nil && s.LockedUntil.After(now)
+}
+
+// LockedAccount is an account that is currently locked out
+type LockedAccount struct {
+	UserID      int
+	Email       string
+	UserType    string
+	LockedUntil time.Time
+}
+
+// GetLoginState returns the brute-force protection state of the account
+func GetLoginState(db *sql.DB, userID int) (*LoginState, error) {
+	query := `SELECT failed_logins, last_failed_login_at, locked_until FROM users WHERE id = $1`
+
+	s := &LoginState{}
+	err := db.QueryRow(query, userID).Scan(&s.FailedLogins, &s.LastFailedLoginAt, &s.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RecordFailedLogin stores a failed attempt made at now and, when userID
+// names an account, counts it against that account. It returns the
+// account's consecutive failure count (0 for unknown emails).
+func RecordFailedLogin(db *sql.DB, email, ip string, userID int, reason string, now time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO login_attempts (email, ip, user_id, reason, attempted_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+	`, email, ip, userID, reason, now)
+	if err != nil {
+		return 0, err
+	}
+
+	failures := 0
+	if userID != 0 {
+		err = tx.QueryRow(`
+			UPDATE users SET failed_logins = failed_logins + 1, last_failed_login_at = $2
+			WHERE id = $1
+			RETURNING failed_logins
+		`, userID, now).Scan(&failures)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return failures, tx.Commit()
+}
+
+// LockAccount blocks logins to the account until the given time and starts
+// its failure count afresh for when the lock expires
+func LockAccount(db *sql.DB, userID int, until time.Time) error {
+	_, err := db.Exec(`UPDATE users SET locked_until = $2, failed_logins = 0 WHERE id = $1`, userID, until)
+	return err
+}
+
+// ResetFailedLogins clears the account's failure count and any lock. It
+// runs after a successful login and when an admin unlocks the account.
+func ResetFailedLogins(db *sql.DB, userID int) error {
+	query := `
+		UPDATE users SET failed_logins = 0, last_failed_login_at = NULL, locked_until = NULL
+		WHERE id = $1 AND (failed_logins <> 0 OR locked_until IS NOT NULL)
+	`
+	_, err := db.Exec(query, userID)
+	return err
+}
+
+// CountFailedLoginsByIP returns how many failed logins came from ip since
+// the given time, and when the latest one was
+func CountFailedLoginsByIP(db *sql.DB, ip string, since time.Time) (int, *time.Time, error) {
+	query := `SELECT COUNT(*), MAX(attempted_at) FROM login_attempts WHERE ip = $1 AND attempted_at > $2`
+
+	var count int
+	var last *time.Time
+	err := db.QueryRow(query, ip, since).Scan(&count, &last)
+	return count, last, err
+}
+
+// GetFailedLoginAttempts returns the most recent failed logins, newest
+// first. Empty email or ip match everything.
+func GetFailedLoginAttempts(db *sql.DB, email, ip string, limit int) ([]LoginAttempt, error) {
+	query := `
+		SELECT id, email, ip, user_id, reason, attempted_at
+		FROM login_attempts
+		WHERE ($1 = '' OR email = $1) AND ($2 = '' OR ip = $2)
+		ORDER BY attempted_at DESC
+		LIMIT $3
+	`
+
+	rows, err := db.Query(query, email, ip, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []LoginAttempt
+	for rows.Next() {
+		var a LoginAttempt
+		if err := rows.Scan(&a.ID, &a.Email, &a.IP, &a.UserID, &a.Reason, &a.AttemptedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+// GetLockedAccounts returns the accounts that are locked right now
+func GetLockedAccounts(db *sql.DB) ([]LockedAccount, error) {
+	query := `
+		SELECT id, email, user_type, locked_until
+		FROM users
+		WHERE locked_until > $1
+		ORDER BY locked_until
+	`
+
+	rows, err := db.Query(query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []LockedAccount
+	for rows.Next() {
+		var a LockedAccount
+		if err := rows.Scan(&a.UserID, &a.Email, &a.UserType, &a.LockedUntil); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+
+	return accounts, rows.Err()
+}
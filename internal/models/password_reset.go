@@ -0,0 +1,65 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CreatePasswordReset stores a hashed, expiring password reset token
+func CreatePasswordReset(db *sql.DB, userID int, tokenHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO password_resets (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`
+	_, err := db.Exec(query, userID, tokenHash, expiresAt)
+	return err
+}
+
+// PasswordResetIsValid reports whether tokenHash names an unused,
+// unexpired reset token
+func PasswordResetIsValid(db *sql.DB, tokenHash string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM password_resets
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		)
+	`
+	var valid bool
+	err := db.QueryRow(query, tokenHash, time.Now()).Scan(&valid)
+	return valid, err
+}
+
+// ResetPassword consumes the reset token and sets the user's new password
+// hash in one transaction. It returns sql.ErrNoRows if the token is unknown,
+// expired or already used. Every other outstanding token for the user is
+// invalidated as well.
+func ResetPassword(db *sql.DB, tokenHash, passwordHash string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`
+		UPDATE password_resets SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING user_id
+	`, tokenHash, time.Now()).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, passwordHash, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL`,
+		userID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPasswordReset(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db, "patient")
+	// token returns the hash of a named reset token, as stored
+	token := func(name string) string {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s-%d", name, user.ID)))
+		return hex.EncodeToString(sum[:])
+	}
+	now := time.Now()
+
+	if err := CreatePasswordReset(db, user.ID, token("expired"), now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreatePasswordReset(db, user.ID, token("first"), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreatePasswordReset(db, user.ID, token("second"), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{"expired": false, "unknown": false, "first": true, "second": true} {
+		if valid, err := PasswordResetIsValid(db, token(name)); err != nil || valid != want {
+			t.Errorf("PasswordResetIsValid(%s) = %v, %v; want %v", name, valid, err, want)
+		}
+	}
+
+	if _, err := ResetPassword(db, token("expired"), "expired hash"); err != sql.ErrNoRows {
+		t.Errorf("ResetPassword with an expired token = %v, want sql.ErrNoRows", err)
+	}
+
+	userID, err := ResetPassword(db, token("first"), "new hash")
+	if err != nil || userID != user.ID {
+		t.Fatalf("ResetPassword = %d, %v; want %d", userID, err, user.ID)
+	}
+	if u, err := GetUserByID(db, user.ID); err != nil || u.PasswordHash != "new hash" {
+		t.Errorf("password hash is %q, %v after the reset", u.PasswordHash, err)
+	}
+
+	// Tokens are single use, and a reset voids the user's other tokens
+	for _, name := range []string{"first", "second"} {
+		if _, err := ResetPassword(db, token(name), "replayed hash"); err != sql.ErrNoRows {
+			t.Errorf("ResetPassword with the used %s token = %v, want sql.ErrNoRows", name, err)
+		}
+		if valid, _ := PasswordResetIsValid(db, token(name)); valid {
+			t.Errorf("the %s token is still valid", name)
+		}
+	}
+	if u, _ := GetUserByID(db, user.ID); u.PasswordHash != "new hash" {
+		t.Errorf("password hash changed to %q by a used token", u.PasswordHash)
+	}
+}
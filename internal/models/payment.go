@@ -0,0 +1,371 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"online-doctor-appointment/internal/appointment"
+	"online-doctor-appointment/internal/money"
+
+	"github.com/lib/pq"
+)
+
+// Payment states
+const (
+	PaymentPending        = "pending"            // order created, waiting for the gateway
+	PaymentPaid           = "paid"               // the gateway confirmed the payment
+	PaymentFailed         = "failed"             // declined, cancelled, or the order couldn't be created
+	PaymentRefunded       = "refunded"           // paid and given back
+	PaymentPartlyRefunded = "partially_refunded" // paid and partly given back
+)
+
+// ErrPaymentMismatch is returned when a gateway reports an order, amount or
+// currency other than the one the payment was made for
+var ErrPaymentMismatch = errors.New("payment does not match the order")
+
+// Errors returned by OpenPayment
+var (
+	ErrNotPayable        = errors.New("appointment can't be paid online")
+	ErrAlreadyPaid       = errors.New("appointment has already been paid")
+	ErrPaymentInProgress = errors.New("a payment for the appointment is being started")
+)
+
+// Payment is an online payment for an appointment. Reference is our order
+// ID at the gateway; an appointment may have several payments when the
+// patient retries after a failure.
+type Payment struct {
+	ID             int         `json:"id"`
+	AppointmentID  int         `json:"appointment_id"`
+	Reference      string      `json:"reference"`
+	Gateway        string      `json:"gateway"`
+	GatewayOrderID string      `json:"gateway_order_id"`
+	PaymentURL     string      `json:"-"` // where the patient pays the order
+	Amount         money.Money `json:"amount"`
+	Status         string      `json:"status"`
+	FailureReason  string      `json:"failure_reason,omitempty"`
+	PaidAt         *time.Time  `json:"paid_at,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+
+	// The appointment's patient and status
+	PatientID         int    `json:"patient_id"`
+	AppointmentStatus string `json:"appointment_status"`
+}
+
+// paymentColumns are selected by every payment query, from payments p
+// joined with appointments a
+const paymentColumns = `
+	p.id, p.appointment_id, p.reference, p.gateway, COALESCE(p.gateway_order_id, ''), COALESCE(p.payment_url, ''),
+	p.amount_minor, p.currency, p.status, COALESCE(p.failure_reason, ''), p.paid_at,
+	p.created_at, p.updated_at, a.patient_id, a.status
+`
+
+// scanPayment scans a row of paymentColumns
+func scanPayment(row interface{ Scan(...interface{}) error }) (*Payment, error) {
+	p := &Payment{}
+	var paidAt sql.NullTime
+	err := row.Scan(&p.ID, &p.AppointmentID, &p.Reference, &p.Gateway, &p.GatewayOrderID,
+		&p.PaymentURL, &p.Amount.Minor, &p.Amount.Currency, &p.Status, &p.FailureReason, &paidAt,
+		&p.CreatedAt, &p.UpdatedAt, &p.PatientID, &p.AppointmentStatus)
+	if err != nil {
+		return nil, err
+	}
+	if paidAt.Valid {
+		p.PaidAt = &paidAt.Time
+	}
+	return p, nil
+}
+
+// insertPayment stores a pending payment
+const insertPayment = `
+	INSERT INTO payments (appointment_id, reference, gateway, amount_minor, currency)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, status, created_at, updated_at
+`
+
+// CreatePayment stores a pending payment before its order is sent to the
+// gateway
+func CreatePayment(db *sql.DB, p *Payment) error {
+	return db.QueryRow(insertPayment, p.AppointmentID, p.Reference, p.Gateway, p.Amount.Minor, p.Amount.Currency).Scan(
+		&p.ID, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+}
+
+// orderCreationTimeout is how long a payment may wait for its gateway
+// order before OpenPayment gives up on it
+const orderCreationTimeout = time.Minute
+
+// OpenPayment stores p as a pending payment for its appointment, checking
+// under the appointment's lock that nothing else is paying it. It returns
+// the appointment's earlier pending payment instead if that one's order is
+// ready to pay, and ErrPaymentInProgress if its order is still being
+// created; one that took over orderCreationTimeout is failed instead. It
+// returns ErrAlreadyPaid if the appointment has been paid, and
+// ErrNotPayable unless it is pending, pending payment or confirmed.
+func OpenPayment(db *sql.DB, p *Payment) (*Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`SELECT patient_id, status FROM appointments WHERE id = $1 FOR UPDATE`, p.AppointmentID).Scan(
+		&p.PatientID, &p.AppointmentStatus)
+	if err != nil {
+		return nil, err
+	}
+	switch appointment.Status(p.AppointmentStatus) {
+	case appointment.Pending, appointment.PendingPayment, appointment.Confirmed:
+	default:
+		return nil, ErrNotPayable
+	}
+
+	open, err := scanPayment(tx.QueryRow(`
+		SELECT `+paymentColumns+`
+		FROM payments p
+		JOIN appointments a ON p.appointment_id = a.id
+		WHERE p.appointment_id = $1 AND p.status IN ('pending', 'paid')
+		ORDER BY p.status = 'paid' DESC, p.id DESC
+		LIMIT 1
+	`, p.AppointmentID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Nothing is paying the appointment yet
+	case err != nil:
+		return nil, err
+	case open.Status == PaymentPaid:
+		return nil, ErrAlreadyPaid
+	case open.PaymentURL == "" && time.Since(open.CreatedAt) < orderCreationTimeout:
+		return nil, ErrPaymentInProgress
+	case open.PaymentURL == "":
+		// Its order was never saved, so it can't be paid
+		_, err = tx.Exec(`
+			UPDATE payments SET status = 'failed', failure_reason = 'The payment was not started',
+			       updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`, open.ID)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return open, nil
+	}
+
+	err = tx.QueryRow(insertPayment, p.AppointmentID, p.Reference, p.Gateway, p.Amount.Minor, p.Amount.Currency).Scan(
+		&p.ID, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return p, tx.Commit()
+}
+
+// SetPaymentOrder records the gateway's ID for a payment's order and where
+// the patient pays it
+func SetPaymentOrder(db *sql.DB, paymentID int, gatewayOrderID, paymentURL string) error {
+	_, err := db.Exec(`
+		UPDATE payments SET gateway_order_id = $1, payment_url = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, gatewayOrderID, paymentURL, paymentID)
+	return err
+}
+
+// FailPayment marks a pending payment failed, e.g. when the gateway
+// refused to create its order
+func FailPayment(db *sql.DB, paymentID int, reason string) error {
+	_, err := db.Exec(`
+		UPDATE payments SET status = 'failed', failure_reason = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = 'pending'
+	`, reason, paymentID)
+	return err
+}
+
+// GetPaymentByReference retrieves a payment by our order ID
+func GetPaymentByReference(db *sql.DB, reference string) (*Payment, error) {
+	return scanPayment(db.QueryRow(`
+		SELECT `+paymentColumns+`
+		FROM payments p
+		JOIN appointments a ON p.appointment_id = a.id
+		WHERE p.reference = $1
+	`, reference))
+}
+
+// GetPaidPayments returns the payments of an appointment that went through
+// and weren't refunded, usually at most one
+func GetPaidPayments(db *sql.DB, appointmentID int) ([]Payment, error) {
+	rows, err := db.Query(`
+		SELECT `+paymentColumns+`
+		FROM payments p
+		JOIN appointments a ON p.appointment_id = a.id
+		WHERE p.appointment_id = $1 AND p.status = 'paid'
+		ORDER BY p.id
+	`, appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paid []Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		paid = append(paid, *p)
+	}
+	return paid, rows.Err()
+}
+
+// GetSettledPayment returns the latest payment of an appointment that went
+// through, even if it was refunded since
+func GetSettledPayment(db *sql.DB, appointmentID int) (*Payment, error) {
+	return scanPayment(db.QueryRow(`
+		SELECT `+paymentColumns+`
+		FROM payments p
+		JOIN appointments a ON p.appointment_id = a.id
+		WHERE p.appointment_id = $1 AND p.status IN ('paid', 'refunded', 'partially_refunded')
+		ORDER BY p.id DESC
+		LIMIT 1
+	`, appointmentID))
+}
+
+// RecordPaymentResult applies the outcome a gateway reported for the order
+// with the given reference and gateway order ID. status is PaymentPaid or PaymentFailed. Once
+// paid, an appointment still waiting for payment or confirmation is
+// confirmed; one cancelled in the meantime stays cancelled.
+// Repeated callbacks are harmless: a payment that already has the outcome
+// is returned unchanged, and a paid payment is never marked failed. A
+// failed payment can still turn paid, as gateways may report a late
+// success. It returns ErrPaymentMismatch if the order ID, amount or
+// currency differ from the payment's.
+func RecordPaymentResult(db *sql.DB, reference, orderID, status string, amount money.Money, reason string) (*Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The appointment is locked before the payment, like everywhere else
+	var appointmentID int
+	err = tx.QueryRow(`SELECT appointment_id FROM payments WHERE reference = $1`, reference).Scan(&appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`SELECT 1 FROM appointments WHERE id = $1 FOR UPDATE`, appointmentID); err != nil {
+		return nil, err
+	}
+
+	p, err := scanPayment(tx.QueryRow(`
+		SELECT `+paymentColumns+`
+		FROM payments p
+		JOIN appointments a ON p.appointment_id = a.id
+		WHERE p.reference = $1
+		FOR UPDATE OF p
+	`, reference))
+	if err != nil {
+		return nil, err
+	}
+
+	if p.GatewayOrderID == "" || orderID != p.GatewayOrderID || amount != p.Amount {
+		return nil, ErrPaymentMismatch
+	}
+
+	switch {
+	case p.Status == status:
+		return p, nil
+	case status == PaymentPaid && (p.Status == PaymentPending || p.Status == PaymentFailed):
+		err = tx.QueryRow(`
+			UPDATE payments SET status = 'paid', failure_reason = NULL,
+			       paid_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING paid_at, updated_at
+		`, p.ID).Scan(&p.PaidAt, &p.UpdatedAt)
+		p.FailureReason = ""
+		if err == nil {
+			err = confirmPaidAppointment(tx, p)
+		}
+	case status == PaymentFailed && p.Status == PaymentPending:
+		err = tx.QueryRow(`
+			UPDATE payments SET status = 'failed', failure_reason = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2
+			RETURNING updated_at
+		`, reason, p.ID).Scan(&p.UpdatedAt)
+		p.FailureReason = reason
+	default:
+		return p, nil // an outcome that would undo a later state
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Status = status
+
+	return p, tx.Commit()
+}
+
+// confirmPaidAppointment confirms the appointment of a payment that went
+// through, if it is waiting for payment or confirmation
+func confirmPaidAppointment(tx *sql.Tx, p *Payment) error {
+	from := appointment.Status(p.AppointmentStatus)
+	if from != appointment.Pending && from != appointment.PendingPayment {
+		return nil
+	}
+
+	_, err := tx.Exec(`UPDATE appointments SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
+		appointment.Confirmed, p.AppointmentID)
+	if err != nil {
+		return err
+	}
+	p.AppointmentStatus = string(appointment.Confirmed)
+	return recordStatusChange(tx, p.AppointmentID, string(from), p.AppointmentStatus, 0, "Paid online")
+}
+
+// CancelUnpaidAppointments cancels appointments booked to be paid online
+// that are still unpaid timeout after booking, fails their open payments,
+// and returns them so their slots can be offered again
+func CancelUnpaidAppointments(db *sql.DB, timeout time.Duration) ([]Appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE appointments SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'pending_payment' AND created_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'
+		RETURNING id, patient_id, doctor_id, starts_at, status
+	`, int(timeout.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	var cancelled []Appointment
+	for rows.Next() {
+		var a Appointment
+		if err := rows.Scan(&a.ID, &a.PatientID, &a.DoctorID, &a.StartsAt, &a.Status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cancelled = append(cancelled, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(cancelled))
+	for i, a := range cancelled {
+		ids[i] = a.ID
+		err := recordStatusChange(tx, a.ID, string(appointment.PendingPayment), a.Status, 0, "Not paid in time")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE payments SET status = 'failed', failure_reason = 'Not paid in time', updated_at = CURRENT_TIMESTAMP
+		WHERE appointment_id = ANY($1) AND status = 'pending'
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	return cancelled, tx.Commit()
+}
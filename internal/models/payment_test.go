@@ -0,0 +1,141 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+)
+
+func TestRecordPaymentResultChecksTheOrder(t *testing.T) {
+	db := testDB(t)
+	p := createTestPayment(t, db, "test", money.New(750000, "KZT"))
+	orderID := "order-" + p.Reference
+
+	tests := []struct {
+		name    string
+		orderID string
+		amount  money.Money
+	}{
+		{"other order", "order-other", p.Amount},
+		{"no order", "", p.Amount},
+		{"other amount", orderID, money.New(100, "KZT")},
+		{"other currency", orderID, money.New(750000, "RUB")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RecordPaymentResult(db, p.Reference, tt.orderID, PaymentFailed, tt.amount, "declined")
+			if !errors.Is(err, ErrPaymentMismatch) {
+				t.Errorf("RecordPaymentResult = %v, want %v", err, ErrPaymentMismatch)
+			}
+		})
+	}
+
+	got, err := GetPaymentByReference(db, p.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != PaymentPaid {
+		t.Errorf("status after mismatched callbacks = %s, want %s", got.Status, PaymentPaid)
+	}
+}
+
+func TestRecordPaymentResultBeforeTheOrderIsSaved(t *testing.T) {
+	db := testDB(t)
+	a := bookLocal(t, createTestDoctor(t, db, 60, 0), 10)
+	p := &Payment{AppointmentID: a.ID, Reference: fmt.Sprintf("early-%d", time.Now().UnixNano()), Gateway: "test", Amount: money.New(500000, "KZT")}
+	if err := CreatePayment(db, p); err != nil {
+		t.Fatal(err)
+	}
+
+	// A callback can't be matched to an order we haven't recorded
+	if _, err := RecordPaymentResult(db, p.Reference, "order-1", PaymentPaid, p.Amount, ""); !errors.Is(err, ErrPaymentMismatch) {
+		t.Errorf("RecordPaymentResult = %v, want %v", err, ErrPaymentMismatch)
+	}
+}
+
+func TestOpenPayment(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	amount := money.New(500000, "KZT")
+	newPayment := func(a *Appointment) *Payment {
+		return &Payment{AppointmentID: a.ID, Reference: fmt.Sprintf("open-%d-%d", a.ID, time.Now().UnixNano()), Gateway: "test", Amount: amount}
+	}
+
+	a := bookLocal(t, doctor, 10)
+	first, err := OpenPayment(db, newPayment(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.PatientID != a.PatientID {
+		t.Errorf("PatientID = %d, want %d", first.PatientID, a.PatientID)
+	}
+
+	// Until its order is saved, the payment can't be joined
+	if _, err := OpenPayment(db, newPayment(a)); !errors.Is(err, ErrPaymentInProgress) {
+		t.Errorf("opening while the order is created = %v, want %v", err, ErrPaymentInProgress)
+	}
+
+	if err := SetPaymentOrder(db, first.ID, "order-1", "https://pay.example/order-1"); err != nil {
+		t.Fatal(err)
+	}
+	again, err := OpenPayment(db, newPayment(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID || again.PaymentURL != "https://pay.example/order-1" {
+		t.Errorf("opening again gave payment %d at %q, want %d at the saved URL", again.ID, again.PaymentURL, first.ID)
+	}
+
+	if _, err := RecordPaymentResult(db, first.Reference, "order-1", PaymentPaid, amount, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPayment(db, newPayment(a)); !errors.Is(err, ErrAlreadyPaid) {
+		t.Errorf("opening a paid appointment = %v, want %v", err, ErrAlreadyPaid)
+	}
+
+	// A failed payment can be retried with a new one
+	b := bookLocal(t, doctor, 12)
+	failed, err := OpenPayment(db, newPayment(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := FailPayment(db, failed.ID, "gateway down"); err != nil {
+		t.Fatal(err)
+	}
+	retry, err := OpenPayment(db, newPayment(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.ID == failed.ID {
+		t.Error("retrying reused the failed payment")
+	}
+
+	// A payment whose order was never saved doesn't block the appointment
+	c := bookLocal(t, doctor, 13)
+	stuck, err := OpenPayment(db, newPayment(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE payments SET created_at = created_at - INTERVAL '1 hour' WHERE id = $1`, stuck.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPayment(db, newPayment(c)); err != nil {
+		t.Errorf("opening after a stuck payment: %v", err)
+	}
+	if got, err := GetPaymentByReference(db, stuck.Reference); err != nil || got.Status != PaymentFailed {
+		t.Errorf("stuck payment = %v, %v; want it failed", got, err)
+	}
+
+	for i, status := range []string{"cancelled", "completed", "no_show"} {
+		c := bookLocal(t, doctor, 14+i)
+		if _, err := db.Exec(`UPDATE appointments SET status = $1 WHERE id = $2`, status, c.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenPayment(db, newPayment(c)); !errors.Is(err, ErrNotPayable) {
+			t.Errorf("opening a %s appointment = %v, want %v", status, err, ErrNotPayable)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+)
+
+// Refund states
+const (
+	RefundPending   = "pending"   // recorded, not yet confirmed by the gateway
+	RefundSucceeded = "succeeded" // the gateway returned the money
+	RefundFailed    = "failed"    // the gateway refused or couldn't be reached; can be retried
+)
+
+// ErrRefundDone is returned when completing or failing a refund that has
+// already succeeded
+var ErrRefundDone = errors.New("refund has already succeeded")
+
+// Refund gives back all or part of a paid payment. Reference is our refund
+// ID at the gateway, which makes retrying a refund safe.
+type Refund struct {
+	ID              int         `json:"id"`
+	PaymentID       int         `json:"payment_id"`
+	Reference       string      `json:"reference"`
+	GatewayRefundID string      `json:"gateway_refund_id,omitempty"`
+	Amount          money.Money `json:"amount"`
+	Reason          string      `json:"reason,omitempty"`
+	Status          string      `json:"status"`
+	FailureReason   string      `json:"failure_reason,omitempty"`
+	Attempts        int         `json:"attempts"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+
+	// The refunded payment and its appointment
+	Gateway        string      `json:"gateway"`
+	GatewayOrderID string      `json:"gateway_order_id"`
+	PaymentAmount  money.Money `json:"payment_amount"`
+	AppointmentID  int         `json:"appointment_id"`
+	PatientName    string      `json:"patient_name"`
+	PatientEmail   string      `json:"patient_email"`
+}
+
+// refundColumns are selected by every refund query, from refunds r joined
+// with payments p, appointments a and the patient u
+const refundColumns = `
+	r.id, r.payment_id, r.reference, COALESCE(r.gateway_refund_id, ''), r.amount_minor, r.currency,
+	COALESCE(r.reason, ''), r.status, COALESCE(r.failure_reason, ''), r.attempts,
+	r.created_at, r.updated_at,
+	p.gateway, COALESCE(p.gateway_order_id, ''), p.amount_minor, p.currency, p.appointment_id,
+	u.first_name || ' ' || u.last_name, u.email
+`
+
+// refundJoins joins a refund to what refundColumns needs
+const refundJoins = `
+	FROM refunds r
+	JOIN payments p ON r.payment_id = p.id
+	JOIN appointments a ON p.appointment_id = a.id
+	JOIN users u ON a.patient_id = u.id
+`
+
+// scanRefund scans a row of refundColumns
+func scanRefund(row interface{ Scan(...interface{}) error }) (*Refund, error) {
+	r := &Refund{}
+	err := row.Scan(&r.ID, &r.PaymentID, &r.Reference, &r.GatewayRefundID, &r.Amount.Minor, &r.Amount.Currency,
+		&r.Reason, &r.Status, &r.FailureReason, &r.Attempts,
+		&r.CreatedAt, &r.UpdatedAt,
+		&r.Gateway, &r.GatewayOrderID, &r.PaymentAmount.Minor, &r.PaymentAmount.Currency, &r.AppointmentID,
+		&r.PatientName, &r.PatientEmail)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CreateRefund records a pending refund for r.PaymentID and fills in r. A
+// payment is refunded at most once: if it already has a refund, r is
+// filled with that one instead, whatever its state.
+func CreateRefund(db *sql.DB, r *Refund) error {
+	_, err := db.Exec(`
+		INSERT INTO refunds (payment_id, reference, amount_minor, currency, reason)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (payment_id) DO NOTHING
+	`, r.PaymentID, r.Reference, r.Amount.Minor, r.Amount.Currency, r.Reason)
+	if err != nil {
+		return err
+	}
+
+	stored, err := scanRefund(db.QueryRow(`SELECT `+refundColumns+refundJoins+`WHERE r.payment_id = $1`, r.PaymentID))
+	if err != nil {
+		return err
+	}
+	*r = *stored
+	return nil
+}
+
+// GetRefundByID retrieves a refund
+func GetRefundByID(db *sql.DB, refundID int) (*Refund, error) {
+	return scanRefund(db.QueryRow(`SELECT `+refundColumns+refundJoins+`WHERE r.id = $1`, refundID))
+}
+
+// GetOpenRefunds returns the refunds that are pending or failed, oldest
+// first
+func GetOpenRefunds(db *sql.DB) ([]Refund, error) {
+	rows, err := db.Query(`SELECT ` + refundColumns + refundJoins + `WHERE r.status != 'succeeded' ORDER BY r.created_at, r.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []Refund
+	for rows.Next() {
+		r, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, *r)
+	}
+	return refunds, rows.Err()
+}
+
+// CompleteRefund records that the gateway made a refund, and marks its
+// payment refunded, or partially refunded if only part of it came back
+func CompleteRefund(db *sql.DB, refundID int, gatewayRefundID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var paymentID int
+	var amount int64
+	err = tx.QueryRow(`
+		UPDATE refunds SET status = 'succeeded', gateway_refund_id = $1, failure_reason = NULL,
+		       attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status != 'succeeded'
+		RETURNING payment_id, amount_minor
+	`, gatewayRefundID, refundID).Scan(&paymentID, &amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRefundDone
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE payments
+		SET status = CASE WHEN amount_minor <= $1 THEN 'refunded' ELSE 'partially_refunded' END,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, amount, paymentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// FailRefund records a failed attempt at a refund, so it can be retried
+func FailRefund(db *sql.DB, refundID int, reason string) error {
+	result, err := db.Exec(`
+		UPDATE refunds SET status = 'failed', failure_reason = $1,
+		       attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status != 'succeeded'
+	`, reason, refundID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrRefundDone
+	}
+	return nil
+}
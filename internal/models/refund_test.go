@@ -0,0 +1,74 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"online-doctor-appointment/internal/money"
+)
+
+// TestCreateRefundOncePerPayment records refunds for one payment from
+// many goroutines, as repeated cancellations and webhooks would; all of
+// them must end up with the first refund recorded
+func TestCreateRefundOncePerPayment(t *testing.T) {
+	db := testDB(t)
+	p := createTestPayment(t, db, "test", money.New(1500000, "KZT"))
+
+	refunds := make([]*Refund, 10)
+	var wg sync.WaitGroup
+	for i := range refunds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			refunds[i] = &Refund{
+				PaymentID: p.ID,
+				Reference: fmt.Sprintf("%s-refund-%d", p.Reference, i),
+				Amount:    p.Amount.Percent(50 + i),
+				Reason:    "Cancelled by the patient",
+			}
+			if err := CreateRefund(db, refunds[i]); err != nil {
+				t.Errorf("refund %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM refunds WHERE payment_id = $1`, p.ID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("payment has %d refunds, want 1", count)
+	}
+	for i, r := range refunds[1:] {
+		if r.ID != refunds[0].ID || r.Reference != refunds[0].Reference || r.Amount != refunds[0].Amount {
+			t.Errorf("refund %d is %d %s %s; refund 0 is %d %s %s", i+1, r.ID, r.Reference, r.Amount,
+				refunds[0].ID, refunds[0].Reference, refunds[0].Amount)
+		}
+	}
+
+	// Once it succeeded, recording it again still returns the same refund
+	first := refunds[0]
+	if err := CompleteRefund(db, first.ID, "gw-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompleteRefund(db, first.ID, "gw-2"); err != ErrRefundDone {
+		t.Errorf("completing twice: err = %v, want ErrRefundDone", err)
+	}
+	again := &Refund{PaymentID: p.ID, Reference: "another", Amount: p.Amount}
+	if err := CreateRefund(db, again); err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID || again.Status != RefundSucceeded || again.GatewayRefundID != "gw-1" {
+		t.Errorf("recording again gave refund %d %s %q, want %d succeeded gw-1", again.ID, again.Status, again.GatewayRefundID, first.ID)
+	}
+
+	paid, err := GetPaidPayments(db, p.AppointmentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paid) != 0 {
+		t.Errorf("%d payments are still refundable after the refund", len(paid))
+	}
+}
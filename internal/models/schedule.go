@@ -0,0 +1,290 @@
+package models
+
+import (
+	"database/sql"
+	"slices"
+	"time"
+
+	"online-doctor-appointment/internal/schedule"
+)
+
+// DoctorBreak is a recurring pause inside a doctor's working hours, such
+// as lunch
+type DoctorBreak struct {
+	ID        int    `json:"id"`
+	DoctorID  int    `json:"doctor_id"`
+	DayOfWeek *int   `json:"day_of_week"` // nil applies to every day
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Label     string `json:"label"`
+}
+
+// ScheduleSettings controls how a doctor's working hours are cut into slots
+type ScheduleSettings struct {
+	SlotMinutes   int    `json:"slot_minutes"`
+	BufferMinutes int    `json:"buffer_minutes"` // kept free between visits
+	TimeZone      string `json:"time_zone"`      // working hours are wall times in this zone
+}
+
+// Options converts the settings for the slot generator
+func (s ScheduleSettings) Options() schedule.Options {
+	return schedule.Options{
+		Duration: time.Duration(s.SlotMinutes) * time.Minute,
+		Buffer:   time.Duration(s.BufferMinutes) * time.Minute,
+	}
+}
+
+// Location returns the doctor's time zone, or the server's if it is unknown
+func (s ScheduleSettings) Location() *time.Location {
+	return schedule.LocationOr(s.TimeZone, time.Local)
+}
+
+// GetScheduleSettings retrieves a doctor's slot length, buffer and time zone
+func GetScheduleSettings(db *sql.DB, doctorID int) (*ScheduleSettings, error) {
+	settings := &ScheduleSettings{}
+	err := db.QueryRow(`SELECT slot_minutes, buffer_minutes, timezone FROM doctors WHERE id = $1`, doctorID).Scan(
+		&settings.SlotMinutes, &settings.BufferMinutes, &settings.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateDoctorTimeZone changes the zone a doctor's working hours are in.
+// The same wall times now fall at other instants, so confirmed appointments
+// left outside working hours are flagged and returned.
+func UpdateDoctorTimeZone(db *sql.DB, doctorID int, timeZone string) ([]Appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE doctors SET timezone = $1 WHERE id = $2`, timeZone, doctorID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return commitWithOrphans(tx, doctorID, 0, 1, 2, 3, 4, 5, 6)
+}
+
+// GetAvailabilityForDay retrieves a doctor's active working windows on a
+// day of the week
+func GetAvailabilityForDay(db *sql.DB, doctorID, dayOfWeek int) ([]DoctorAvailability, error) {
+	query := `
+		SELECT id, doctor_id, day_of_week, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'),
+		       is_active, created_at
+		FROM doctor_availability
+		WHERE doctor_id = $1 AND day_of_week = $2 AND is_active = true
+		ORDER BY start_time
+	`
+
+	rows, err := db.Query(query, doctorID, dayOfWeek)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []DoctorAvailability
+	for rows.Next() {
+		var a DoctorAvailability
+		err := rows.Scan(&a.ID, &a.DoctorID, &a.DayOfWeek, &a.StartTime, &a.EndTime, &a.IsActive, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, a)
+	}
+
+	return windows, rows.Err()
+}
+
+// GetBreaksForDay retrieves the breaks a doctor takes on a day of the week,
+// including those taken every day
+func GetBreaksForDay(db *sql.DB, doctorID, dayOfWeek int) ([]DoctorBreak, error) {
+	query := `
+		SELECT id, doctor_id, day_of_week, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'),
+		       COALESCE(label, '')
+		FROM doctor_breaks
+		WHERE doctor_id = $1 AND (day_of_week = $2 OR day_of_week IS NULL)
+		ORDER BY start_time
+	`
+
+	rows, err := db.Query(query, doctorID, dayOfWeek)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var breaks []DoctorBreak
+	for rows.Next() {
+		var b DoctorBreak
+		var day sql.NullInt64
+		err := rows.Scan(&b.ID, &b.DoctorID, &day, &b.StartTime, &b.EndTime, &b.Label)
+		if err != nil {
+			return nil, err
+		}
+		if day.Valid {
+			d := int(day.Int64)
+			b.DayOfWeek = &d
+		}
+		breaks = append(breaks, b)
+	}
+
+	return breaks, rows.Err()
+}
+
+// getBookedIntervals returns the time taken by a doctor's appointments and
+// live slot holds during day, whatever their length. Holds by holderID are
+// left out.
+func getBookedIntervals(db *sql.DB, doctorID int, day schedule.Interval, holderID int) ([]schedule.Interval, error) {
+	query := `
+		SELECT starts_at, duration_minutes
+		FROM appointments
+		WHERE doctor_id = $1 AND status != 'cancelled'
+		  AND starts_at < $3 AND starts_at + duration_minutes * INTERVAL '1 minute' > $2
+		UNION ALL
+		SELECT starts_at, duration_minutes
+		FROM slot_holds
+		WHERE doctor_id = $1 AND patient_id != $4 AND expires_at > CURRENT_TIMESTAMP
+		  AND starts_at < $3 AND starts_at + duration_minutes * INTERVAL '1 minute' > $2
+	`
+
+	rows, err := db.Query(query, doctorID, day.Start, day.End, holderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var booked []schedule.Interval
+	for rows.Next() {
+		var start time.Time
+		var minutes int
+		if err := rows.Scan(&start, &minutes); err != nil {
+			return nil, err
+		}
+		booked = append(booked, schedule.Interval{Start: start, End: start.Add(time.Duration(minutes) * time.Minute)})
+	}
+
+	return booked, rows.Err()
+}
+
+// clockIntervals converts start and end wall clock times on day into
+// intervals in day's location
+func clockIntervals(day time.Time, spans [][2]string) ([]schedule.Interval, error) {
+	intervals := make([]schedule.Interval, 0, len(spans))
+	for _, span := range spans {
+		start, err := schedule.At(day, span[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := schedule.At(day, span[1])
+		if err != nil {
+			return nil, err
+		}
+		intervals = append(intervals, schedule.Interval{Start: start, End: end})
+	}
+	return intervals, nil
+}
+
+// GetAvailableTimeSlots returns the start of every free slot a doctor has
+// on date ("2006-01-02"), a day in the doctor's own time zone. Slots
+// follow the doctor's slot length and buffer, fill every working window of
+// the day plus any extra hours, skip breaks, time off and clinic holidays,
+// and avoid existing appointments of any length and slots held by anyone
+// but holderID (0 for nobody).
+func GetAvailableTimeSlots(db *sql.DB, doctorID int, date string, holderID int) ([]time.Time, error) {
+	settings, err := GetScheduleSettings(db, doctorID)
+	if err != nil {
+		return nil, err
+	}
+
+	day, err := schedule.Day(date, settings.Location())
+	if err != nil {
+		return nil, err
+	}
+	dayOfWeek := int(day.Start.Weekday())
+
+	closed, err := IsClinicHoliday(db, date)
+	if err != nil || closed {
+		return []time.Time{}, err
+	}
+
+	availability, err := GetAvailabilityForDay(db, doctorID, dayOfWeek)
+	if err != nil {
+		return nil, err
+	}
+	var spans [][2]string
+	for _, a := range availability {
+		spans = append(spans, [2]string{a.StartTime, a.EndTime})
+	}
+
+	breakRows, err := GetBreaksForDay(db, doctorID, dayOfWeek)
+	if err != nil {
+		return nil, err
+	}
+	var breakSpans [][2]string
+	for _, b := range breakRows {
+		breakSpans = append(breakSpans, [2]string{b.StartTime, b.EndTime})
+	}
+
+	exceptions, err := GetScheduleExceptionsForDate(db, doctorID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	booked, err := getBookedIntervals(db, doctorID, day, holderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return freeSlots(day, *settings, spans, breakSpans, exceptions, booked, time.Now())
+}
+
+// freeSlots cuts a doctor's day into the free slots starting after now.
+// spans and breakSpans are the weekly working windows and breaks of the
+// day, exceptions the doctor's exceptions covering it and booked the time
+// already taken.
+func freeSlots(day schedule.Interval, settings ScheduleSettings, spans, breakSpans [][2]string,
+	exceptions []ScheduleException, booked []schedule.Interval, now time.Time) ([]time.Time, error) {
+	// One-off extra hours add to the weekly windows; time off is treated
+	// like a break and always wins
+	spans = slices.Clone(spans)
+	breakSpans = slices.Clone(breakSpans)
+	for _, e := range exceptions {
+		switch {
+		case e.Kind == ExceptionExtra:
+			spans = append(spans, [2]string{e.StartTime, e.EndTime})
+		case e.IsFullDay():
+			return []time.Time{}, nil
+		default:
+			breakSpans = append(breakSpans, [2]string{e.StartTime, e.EndTime})
+		}
+	}
+
+	if len(spans) == 0 {
+		return []time.Time{}, nil // No availability that day
+	}
+	windows, err := clockIntervals(day.Start, spans)
+	if err != nil {
+		return nil, err
+	}
+	breaks, err := clockIntervals(day.Start, breakSpans)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never offer a slot that has already started
+	availableSlots := []time.Time{}
+	for _, slot := range schedule.Slots(windows, breaks, booked, settings.Options()) {
+		if slot.After(now) {
+			availableSlots = append(availableSlots, slot)
+		}
+	}
+
+	return availableSlots, nil
+}
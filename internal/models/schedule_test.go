@@ -0,0 +1,93 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/schedule"
+)
+
+func TestFreeSlotsAcrossDaylightSaving(t *testing.T) {
+	settings := ScheduleSettings{SlotMinutes: 60, TimeZone: "Europe/Berlin"}
+	loc := settings.Location()
+	past := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		date     string
+		spans    [][2]string
+		breaks   [][2]string
+		booked   []string // start instants, RFC 3339, of one hour appointments
+		want     []string // wall clock with offset
+		wantDays time.Duration
+	}{
+		{
+			name:     "spring forward skips 02:00",
+			date:     "2024-03-31",
+			spans:    [][2]string{{"00:00", "06:00"}},
+			want:     []string{"00:00 +0100", "01:00 +0100", "03:00 +0200", "04:00 +0200", "05:00 +0200"},
+			wantDays: 23 * time.Hour,
+		},
+		{
+			name:     "fall back repeats 02:00",
+			date:     "2024-10-27",
+			spans:    [][2]string{{"01:00", "04:00"}},
+			breaks:   [][2]string{{"03:00", "03:30"}},
+			booked:   []string{"2024-10-27T00:00:00Z"}, // the first 02:00
+			want:     []string{"01:00 +0200", "02:00 +0100"},
+			wantDays: 25 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, err := schedule.Day(tt.date, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if day.End.Sub(day.Start) != tt.wantDays {
+				t.Errorf("day lasts %v, want %v", day.End.Sub(day.Start), tt.wantDays)
+			}
+			var booked []schedule.Interval
+			for _, b := range tt.booked {
+				start, _ := time.Parse(time.RFC3339, b)
+				booked = append(booked, schedule.Interval{Start: start, End: start.Add(time.Hour)})
+			}
+
+			slots, err := freeSlots(day, settings, tt.spans, tt.breaks, nil, booked, past)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, s := range slots {
+				got = append(got, s.In(loc).Format("15:04 -0700"))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("slots %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	almaty, _ := time.LoadLocation("Asia/Almaty")
+	changed := time.Date(2024, 3, 30, 23, 0, 0, 0, time.UTC)
+	a := Appointment{
+		StartsAt: time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC),
+		History:  []StatusChange{{ToStatus: "pending", ChangedAt: changed}},
+	}
+
+	a.Localize(berlin)
+	if a.AppointmentDate != "2024-03-31" || a.AppointmentTime != "03:30" || a.TimeZone != "Europe/Berlin" {
+		t.Errorf("in Berlin: %s %s %s, want 2024-03-31 03:30 after the clocks went forward", a.AppointmentDate, a.AppointmentTime, a.TimeZone)
+	}
+	if h := a.History[0].ChangedAt; h.Location() != berlin || !h.Equal(changed) {
+		t.Errorf("history time %v is not the same instant in Berlin", h)
+	}
+
+	a.Localize(almaty)
+	if a.AppointmentDate != "2024-03-31" || a.AppointmentTime != "06:30" {
+		t.Errorf("in Almaty: %s %s, want 2024-03-31 06:30", a.AppointmentDate, a.AppointmentTime)
+	}
+}
@@ -0,0 +1,326 @@
+package models
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+	"online-doctor-appointment/internal/schedule"
+
+	"github.com/lib/pq"
+)
+
+// FoundSlot is a free slot found by SearchAvailableSlots
+type FoundSlot struct {
+	StartsAt time.Time
+	Doctor   *Doctor
+}
+
+// weeklyHours is a doctor's working windows and breaks by day of the week.
+// Breaks without a day apply to every day.
+type weeklyHours struct {
+	windows [7][][2]string
+	breaks  [7][][2]string
+}
+
+// SearchAvailableSlots returns the earliest limit free slots of any of the
+// doctors on the dates from to to ("2006-01-02", inclusive), each date
+// taken in the doctor's own time zone. Slots are ordered by time, then by
+// consultation fee. Like GetAvailableTimeSlots, slots held by holderID are
+// still offered to them. The schedules of all doctors are loaded with a
+// handful of queries however many doctors and days are searched.
+func SearchAvailableSlots(db *sql.DB, doctors []Doctor, from, to string, holderID, limit int) ([]FoundSlot, error) {
+	found := []FoundSlot{}
+	if len(doctors) == 0 || limit <= 0 {
+		return found, nil
+	}
+
+	first, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, err
+	}
+	last, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return nil, err
+	}
+	var dates []string
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format("2006-01-02"))
+	}
+
+	ids := make([]int, len(doctors))
+	for i, d := range doctors {
+		ids[i] = d.ID
+	}
+
+	settings, err := getScheduleSettingsFor(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	hours, err := getWeeklyHoursFor(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	exceptions, err := getScheduleExceptionsFor(db, ids, from, to)
+	if err != nil {
+		return nil, err
+	}
+	holidays, err := getClinicHolidaysBetween(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// The dates start and end at different instants in each doctor's zone,
+	// so the booked time of all doctors is loaded over the widest span
+	var span schedule.Interval
+	for i, id := range ids {
+		firstDay, err := schedule.Day(from, settings[id].Location())
+		if err != nil {
+			return nil, err
+		}
+		lastDay, err := schedule.Day(to, settings[id].Location())
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 || firstDay.Start.Before(span.Start) {
+			span.Start = firstDay.Start
+		}
+		if i == 0 || lastDay.End.After(span.End) {
+			span.End = lastDay.End
+		}
+	}
+	booked, err := getBookedIntervalsFor(db, ids, span, holderID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range doctors {
+		doctor := &doctors[i]
+		s := settings[doctor.ID]
+		h := hours[doctor.ID]
+
+		// Each doctor's slots come in time order, so no doctor can add
+		// more than limit slots to the earliest limit overall
+		n := 0
+		for _, date := range dates {
+			if n >= limit {
+				break
+			}
+			if holidays.covers(date) {
+				continue
+			}
+
+			day, err := schedule.Day(date, s.Location())
+			if err != nil {
+				return nil, err
+			}
+			weekday := day.Start.Weekday()
+
+			var dayExceptions []ScheduleException
+			for _, e := range exceptions[doctor.ID] {
+				if e.StartDate <= date && date <= e.EndDate {
+					dayExceptions = append(dayExceptions, e)
+				}
+			}
+
+			slots, err := freeSlots(day, s, h.windows[weekday], h.breaks[weekday], dayExceptions, booked[doctor.ID], now)
+			if err != nil {
+				return nil, err
+			}
+			for _, slot := range slots {
+				if n >= limit {
+					break
+				}
+				found = append(found, FoundSlot{StartsAt: slot, Doctor: doctor})
+				n++
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(a, b int) bool {
+		if !found[a].StartsAt.Equal(found[b].StartsAt) {
+			return found[a].StartsAt.Before(found[b].StartsAt)
+		}
+		return money.Compare(found[a].Doctor.ConsultationFee, found[b].Doctor.ConsultationFee) < 0
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	return found, nil
+}
+
+// getScheduleSettingsFor retrieves the schedule settings of several
+// doctors by doctor ID
+func getScheduleSettingsFor(db *sql.DB, doctorIDs []int) (map[int]ScheduleSettings, error) {
+	rows, err := db.Query(`SELECT id, slot_minutes, buffer_minutes, timezone FROM doctors WHERE id = ANY($1)`,
+		pq.Array(doctorIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[int]ScheduleSettings)
+	for rows.Next() {
+		var id int
+		var s ScheduleSettings
+		if err := rows.Scan(&id, &s.SlotMinutes, &s.BufferMinutes, &s.TimeZone); err != nil {
+			return nil, err
+		}
+		settings[id] = s
+	}
+
+	return settings, rows.Err()
+}
+
+// getWeeklyHoursFor retrieves the active working windows and breaks of
+// several doctors by doctor ID
+func getWeeklyHoursFor(db *sql.DB, doctorIDs []int) (map[int]*weeklyHours, error) {
+	hours := make(map[int]*weeklyHours)
+	for _, id := range doctorIDs {
+		hours[id] = &weeklyHours{}
+	}
+
+	rows, err := db.Query(`
+		SELECT doctor_id, day_of_week, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+		FROM doctor_availability
+		WHERE doctor_id = ANY($1) AND is_active = true
+	`, pq.Array(doctorIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var doctorID, day int
+		var span [2]string
+		if err := rows.Scan(&doctorID, &day, &span[0], &span[1]); err != nil {
+			return nil, err
+		}
+		h := hours[doctorID]
+		h.windows[day] = append(h.windows[day], span)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	breakRows, err := db.Query(`
+		SELECT doctor_id, day_of_week, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+		FROM doctor_breaks
+		WHERE doctor_id = ANY($1)
+	`, pq.Array(doctorIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer breakRows.Close()
+
+	for breakRows.Next() {
+		var doctorID int
+		var day sql.NullInt64
+		var span [2]string
+		if err := breakRows.Scan(&doctorID, &day, &span[0], &span[1]); err != nil {
+			return nil, err
+		}
+		h := hours[doctorID]
+		for d := range h.breaks {
+			if !day.Valid || int(day.Int64) == d {
+				h.breaks[d] = append(h.breaks[d], span)
+			}
+		}
+	}
+
+	return hours, breakRows.Err()
+}
+
+// getScheduleExceptionsFor retrieves the exceptions of several doctors
+// that overlap the dates from to to, by doctor ID
+func getScheduleExceptionsFor(db *sql.DB, doctorIDs []int, from, to string) (map[int][]ScheduleException, error) {
+	list, err := queryScheduleExceptions(db, `
+		SELECT `+scheduleExceptionColumns+`
+		FROM schedule_exceptions
+		WHERE doctor_id = ANY($1) AND start_date <= $3 AND end_date >= $2
+	`, pq.Array(doctorIDs), from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	exceptions := make(map[int][]ScheduleException)
+	for _, e := range list {
+		exceptions[e.DoctorID] = append(exceptions[e.DoctorID], e)
+	}
+	return exceptions, nil
+}
+
+// holidayRanges are clinic holidays as inclusive date ranges
+type holidayRanges [][2]string
+
+// covers reports whether the clinic is closed on date
+func (h holidayRanges) covers(date string) bool {
+	for _, r := range h {
+		if r[0] <= date && date <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// getClinicHolidaysBetween retrieves the clinic holidays that overlap the
+// dates from to to
+func getClinicHolidaysBetween(db *sql.DB, from, to string) (holidayRanges, error) {
+	rows, err := db.Query(`
+		SELECT to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD')
+		FROM clinic_holidays
+		WHERE start_date <= $2 AND end_date >= $1
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holidays holidayRanges
+	for rows.Next() {
+		var r [2]string
+		if err := rows.Scan(&r[0], &r[1]); err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, r)
+	}
+
+	return holidays, rows.Err()
+}
+
+// getBookedIntervalsFor is getBookedIntervals for several doctors at once,
+// by doctor ID
+func getBookedIntervalsFor(db *sql.DB, doctorIDs []int, span schedule.Interval, holderID int) (map[int][]schedule.Interval, error) {
+	query := `
+		SELECT doctor_id, starts_at, duration_minutes
+		FROM appointments
+		WHERE doctor_id = ANY($1) AND status != 'cancelled'
+		  AND starts_at < $3 AND starts_at + duration_minutes * INTERVAL '1 minute' > $2
+		UNION ALL
+		SELECT doctor_id, starts_at, duration_minutes
+		FROM slot_holds
+		WHERE doctor_id = ANY($1) AND patient_id != $4 AND expires_at > CURRENT_TIMESTAMP
+		  AND starts_at < $3 AND starts_at + duration_minutes * INTERVAL '1 minute' > $2
+	`
+
+	rows, err := db.Query(query, pq.Array(doctorIDs), span.Start, span.End, holderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	booked := make(map[int][]schedule.Interval)
+	for rows.Next() {
+		var doctorID, minutes int
+		var start time.Time
+		if err := rows.Scan(&doctorID, &start, &minutes); err != nil {
+			return nil, err
+		}
+		booked[doctorID] = append(booked[doctorID], schedule.Interval{Start: start, End: start.Add(time.Duration(minutes) * time.Minute)})
+	}
+
+	return booked, rows.Err()
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+)
+
+// searchDoctor creates a doctor charging fee who works from start to end
+// on date's weekday
+func searchDoctor(t *testing.T, date time.Time, start, end string, fee int64) Doctor {
+	t.Helper()
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	window := &DoctorAvailability{DoctorID: doctor.ID, DayOfWeek: int(date.Weekday()), StartTime: start, EndTime: end, IsActive: true}
+	if _, err := CreateAvailability(db, window); err != nil {
+		t.Fatal(err)
+	}
+	doctor.ConsultationFee = money.FromMajor(fee, "KZT")
+	return *doctor
+}
+
+func TestSearchAvailableSlots(t *testing.T) {
+	db := testDB(t)
+	date := time.Now().In(mustLoadLocation(t, "Asia/Almaty")).AddDate(0, 0, 7)
+	day := date.Format("2006-01-02")
+
+	dear := searchDoctor(t, date, "09:00", "12:00", 10000)
+	cheap := searchDoctor(t, date, "09:00", "12:00", 5000)
+	early := searchDoctor(t, date, "07:00", "08:00", 20000)
+	late := searchDoctor(t, date, "15:00", "17:00", 1000)
+
+	type want struct {
+		clock    string
+		doctorID int
+	}
+	tests := []struct {
+		name    string
+		doctors []Doctor
+		limit   int
+		want    []want
+	}{
+		{
+			name:    "same time cheapest first",
+			doctors: []Doctor{dear, cheap},
+			limit:   4,
+			want:    []want{{"09:00", cheap.ID}, {"09:00", dear.ID}, {"10:00", cheap.ID}, {"10:00", dear.ID}},
+		},
+		{
+			// late's slots are cut off by the limit despite being cheapest;
+			// early comes last in the list but has the first slot
+			name:    "earliest across doctors",
+			doctors: []Doctor{dear, late, early},
+			limit:   3,
+			want:    []want{{"07:00", early.ID}, {"09:00", dear.ID}, {"10:00", dear.ID}},
+		},
+		{
+			name:    "limit of one",
+			doctors: []Doctor{late, dear},
+			limit:   1,
+			want:    []want{{"09:00", dear.ID}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := SearchAvailableSlots(db, tt.doctors, day, day, 0, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []want
+			for _, f := range found {
+				got = append(got, want{f.StartsAt.In(mustLoadLocation(t, "Asia/Almaty")).Format("15:04"), f.Doctor.ID})
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
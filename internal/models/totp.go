@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TOTP is a user's authenticator app enrollment
+type TOTP struct {
+	UserID    int
+	Secret    string
+	EnabledAt *time.Time // nil until the user confirms a first code
+	LastStep  int64      // last accepted time step, used to reject replays
+}
+
+// IsEnabled reports whether the enrollment has been confirmed
+func (t *TOTP) IsEnabled() bool {
+	return t.EnabledAt != nil
+}
+
+// GetTOTP returns the user's TOTP enrollment, or sql.ErrNoRows if there is none
+func GetTOTP(db *sql.DB, userID int) (*TOTP, error) {
+	query := `SELECT user_id, secret, enabled_at, last_step FROM user_totp WHERE user_id = $1`
+
+	t := &TOTP{}
+	err := db.QueryRow(query, userID).Scan(&t.UserID, &t.Secret, &t.EnabledAt, &t.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TOTPEnabled reports whether the user has confirmed two-factor authentication
+func TOTPEnabled(db *sql.DB, userID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1 AND enabled_at IS NOT NULL)`
+
+	var enabled bool
+	err := db.QueryRow(query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// SaveTOTPSecret stores a new, unconfirmed secret for the user. An already
+// enabled enrollment is left untouched.
+func SaveTOTPSecret(db *sql.DB, userID int, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0
+		WHERE user_totp.enabled_at IS NULL
+	`
+	_, err := db.Exec(query, userID, secret)
+	return err
+}
+
+// EnableTOTP confirms the user's pending enrollment, recording step as the
+// last used code, and replaces their recovery codes with codeHashes
+func EnableTOTP(db *sql.DB, userID int, step int64, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE user_totp SET enabled_at = $2, last_step = $3
+		WHERE user_id = $1 AND enabled_at IS NULL
+	`, userID, time.Now(), step)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes discards the user's recovery codes and stores codeHashes
+func ReplaceRecoveryCodes(db *sql.DB, userID int, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseTOTPStep records step as the user's last accepted code. It returns
+// false if a code for this or a later step was already used.
+func UseTOTPStep(db *sql.DB, userID int, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp SET last_step = $2
+		WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_step < $2
+	`
+	res, err := db.Exec(query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode consumes an unused recovery code. It returns false if
+// codeHash doesn't match one.
+func UseRecoveryCode(db *sql.DB, userID int, codeHash string) (bool, error) {
+	query := `
+		UPDATE user_recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	res, err := db.Exec(query, userID, codeHash, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left
+func CountRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	var count int
+	err := db.QueryRow(query, userID).Scan(&count)
+	return count, err
+}
+
+// DisableTOTP removes the user's enrollment and recovery codes
+func DisableTOTP(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,37 @@
+package models
+
+import "testing"
+
+func TestUseTOTPStepRejectsReplays(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db, "doctor")
+
+	if err := SaveTOTPSecret(db, user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := UseTOTPStep(db, user.ID, 100); err != nil || ok {
+		t.Fatalf("UseTOTPStep before enabling = %v, %v; want false", ok, err)
+	}
+	if err := EnableTOTP(db, user.ID, 100, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		step int64
+		ok   bool
+	}{
+		{100, false}, // the code used to enable
+		{101, true},
+		{101, false}, // replayed
+		{100, false}, // older than the last one used
+		{102, true},
+	} {
+		ok, err := UseTOTPStep(db, user.ID, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok {
+			t.Errorf("UseTOTPStep(%d) = %v, want %v", tt.step, ok, tt.ok)
+		}
+	}
+}
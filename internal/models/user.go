@@ -15,6 +15,8 @@ type User struct {
 	UserType     string    `json:"user_type"` // patient, doctor, admin
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
 }
 
 // CreateUser inserts a new user into the database
@@ -35,13 +37,15 @@ func CreateUser(db *sql.DB, user *User) error {
 func GetUserByEmail(db *sql.DB, email string) (*User, error) {
 	user := &User{}
 	query := `
-		SELECT id, email, password_hash, first_name, last_name, phone, user_type, created_at, updated_at
+		SELECT id, email, password_hash, first_name, last_name, phone, user_type, created_at, updated_at,
+		       email_verified_at
 		FROM users WHERE email = $1
 	`
 
 	err := db.QueryRow(query, email).Scan(
 		&user.ID, &user.Email, &user.PasswordHash, &user.FirstName,
 		&user.LastName, &user.Phone, &user.UserType, &user.CreatedAt, &user.UpdatedAt,
+		&user.EmailVerifiedAt,
 	)
 
 	if err != nil {
@@ -55,13 +59,15 @@ func GetUserByEmail(db *sql.DB, email string) (*User, error) {
 func GetUserByID(db *sql.DB, id int) (*User, error) {
 	user := &User{}
 	query := `
-		SELECT id, email, password_hash, first_name, last_name, phone, user_type, created_at, updated_at
+		SELECT id, email, password_hash, first_name, last_name, phone, user_type, created_at, updated_at,
+		       email_verified_at
 		FROM users WHERE id = $1
 	`
 
 	err := db.QueryRow(query, id).Scan(
 		&user.ID, &user.Email, &user.PasswordHash, &user.FirstName,
 		&user.LastName, &user.Phone, &user.UserType, &user.CreatedAt, &user.UpdatedAt,
+		&user.EmailVerifiedAt,
 	)
 
 	if err != nil {
@@ -71,6 +77,17 @@ func GetUserByID(db *sql.DB, id int) (*User, error) {
 	return user, nil
 }
 
+// MarkEmailVerified records that the user confirmed email. It does nothing
+// if the user has since changed their address.
+func MarkEmailVerified(db *sql.DB, userID int, email string) error {
+	query := `
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND email = $2
+	`
+	_, err := db.Exec(query, userID, email)
+	return err
+}
+
 // GetAllPatients retrieves all users with user_type = 'patient'
 func GetAllPatients(db *sql.DB) ([]User, error) {
 	query := `
@@ -99,6 +116,11 @@ func GetAllPatients(db *sql.DB) ([]User, error) {
 	return patients, nil
 }
 
+// IsEmailVerified reports whether the user has confirmed their email address
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
 // GetFullName returns the user's full name
 func (u *User) GetFullName() string {
 	return u.FirstName + " " + u.LastName
@@ -0,0 +1,366 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Waitlist entry states
+const (
+	WaitlistWaiting = "waiting" // in line for a slot
+	WaitlistOffered = "offered" // holding an open offer
+	WaitlistBooked  = "booked"  // claimed an offer
+	WaitlistLeft    = "left"    // removed by the patient
+)
+
+// Waitlist offer states
+const (
+	OfferOpen    = "open"
+	OfferClaimed = "claimed"
+	OfferExpired = "expired"
+)
+
+// ErrOfferUnavailable is returned when a waitlist offer has expired, was
+// already claimed or belongs to another patient
+var ErrOfferUnavailable = errors.New("waitlist offer is no longer available")
+
+// WaitlistEntry puts a patient in line for a slot with a doctor on any
+// date of a range
+type WaitlistEntry struct {
+	ID        int       `json:"id"`
+	DoctorID  int       `json:"doctor_id"`
+	PatientID int       `json:"patient_id"`
+	FromDate  string    `json:"from_date"` // YYYY-MM-DD, in the doctor's time zone
+	ToDate    string    `json:"to_date"`   // YYYY-MM-DD, inclusive
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Doctor *Doctor `json:"doctor,omitempty"`
+}
+
+// WaitlistOffer is a freed slot reserved for the next patient in line
+// until ExpiresAt
+type WaitlistOffer struct {
+	ID        int       `json:"id"`
+	EntryID   int       `json:"entry_id"`
+	DoctorID  int       `json:"doctor_id"`
+	PatientID int       `json:"patient_id"`
+	StartsAt  time.Time `json:"starts_at"` // in the doctor's time zone
+	ExpiresAt time.Time `json:"expires_at"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Patient *User   `json:"patient,omitempty"`
+	Doctor  *Doctor `json:"doctor,omitempty"`
+}
+
+// JoinWaitlist puts a patient in line for a doctor
+func JoinWaitlist(db *sql.DB, e *WaitlistEntry) error {
+	query := `
+		INSERT INTO waitlist_entries (doctor_id, patient_id, from_date, to_date)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, status, created_at
+	`
+	return db.QueryRow(query, e.DoctorID, e.PatientID, e.FromDate, e.ToDate).Scan(&e.ID, &e.Status, &e.CreatedAt)
+}
+
+// GetWaitlistEntriesByPatientID retrieves a patient's waitlist entries that
+// are still in line or holding an offer
+func GetWaitlistEntriesByPatientID(db *sql.DB, patientID int) ([]WaitlistEntry, error) {
+	query := `
+		SELECT w.id, w.doctor_id, w.patient_id, to_char(w.from_date, 'YYYY-MM-DD'), to_char(w.to_date, 'YYYY-MM-DD'),
+		       w.status, w.created_at, d.specialty, du.first_name, du.last_name
+		FROM waitlist_entries w
+		JOIN doctors d ON w.doctor_id = d.id
+		JOIN users du ON d.user_id = du.id
+		WHERE w.patient_id = $1 AND w.status IN ('waiting', 'offered') AND w.to_date >= CURRENT_DATE
+		ORDER BY w.from_date, w.id
+	`
+
+	rows, err := db.Query(query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []WaitlistEntry
+	for rows.Next() {
+		var e WaitlistEntry
+		var doctor Doctor
+		var doctorUser User
+		err := rows.Scan(&e.ID, &e.DoctorID, &e.PatientID, &e.FromDate, &e.ToDate, &e.Status, &e.CreatedAt,
+			&doctor.Specialty, &doctorUser.FirstName, &doctorUser.LastName)
+		if err != nil {
+			return nil, err
+		}
+		doctor.ID = e.DoctorID
+		doctor.User = &doctorUser
+		e.Doctor = &doctor
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// LeaveWaitlist takes a patient out of line. An open offer is cut short
+// so its slot goes to the next patient. It returns sql.ErrNoRows if the
+// entry doesn't belong to the patient or is already closed.
+func LeaveWaitlist(db *sql.DB, entryID, patientID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE waitlist_entries SET status = 'left'
+		WHERE id = $1 AND patient_id = $2 AND status IN ('waiting', 'offered')
+	`, entryID, patientID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	// ExpireWaitlistOffers passes the slot on once the offer has expired
+	_, err = tx.Exec(`
+		DELETE FROM slot_holds h USING waitlist_offers o
+		WHERE h.offer_id = o.id AND o.entry_id = $1 AND o.status = 'open'
+	`, entryID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE waitlist_offers SET expires_at = CURRENT_TIMESTAMP WHERE entry_id = $1 AND status = 'open'`, entryID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// OfferSlot offers a freed slot to the first patient in line for it and
+// holds the slot for them until ttl from now. Patients are in line for a
+// slot if it falls in their date range, their entry isn't holding another
+// offer, and they haven't been offered this slot before. It returns nil if
+// nobody is waiting or the slot has been taken.
+func OfferSlot(db *sql.DB, doctorID int, startsAt time.Time, ttl time.Duration) (*WaitlistOffer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	settings, err := lockScheduleSettings(tx, doctorID)
+	if err != nil {
+		return nil, err
+	}
+
+	offer := &WaitlistOffer{DoctorID: doctorID, StartsAt: startsAt}
+	query := `
+		SELECT w.id, w.patient_id
+		FROM waitlist_entries w
+		JOIN doctors d ON w.doctor_id = d.id
+		WHERE w.doctor_id = $1 AND w.status = 'waiting'
+		  AND ($2::timestamptz AT TIME ZONE d.timezone)::date BETWEEN w.from_date AND w.to_date
+		  AND NOT EXISTS (SELECT 1 FROM waitlist_offers o WHERE o.entry_id = w.id AND o.starts_at = $2)
+		ORDER BY w.created_at, w.id
+		LIMIT 1
+	`
+	err = tx.QueryRow(query, doctorID, startsAt).Scan(&offer.EntryID, &offer.PatientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkSlotFree(tx, slotClaim{
+		doctorID:  doctorID,
+		start:     startsAt,
+		minutes:   settings.SlotMinutes,
+		buffer:    settings.BufferMinutes,
+		patientID: offer.PatientID,
+	})
+	if errors.Is(err, ErrSlotUnavailable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO waitlist_offers (entry_id, doctor_id, patient_id, starts_at, expires_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second')
+		RETURNING id, expires_at, status, created_at
+	`, offer.EntryID, doctorID, offer.PatientID, startsAt, int(ttl.Seconds())).Scan(
+		&offer.ID, &offer.ExpiresAt, &offer.Status, &offer.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// The offer's hold keeps the slot away from everyone else while it is
+	// open. It is the offer's own, so a hold the patient takes while paying
+	// for another booking doesn't replace it.
+	_, err = tx.Exec(`
+		INSERT INTO slot_holds (doctor_id, patient_id, offer_id, starts_at, duration_minutes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, doctorID, offer.PatientID, offer.ID, startsAt, settings.SlotMinutes, offer.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE waitlist_entries SET status = 'offered' WHERE id = $1`, offer.EntryID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetWaitlistOfferByID(db, offer.ID)
+}
+
+// GetWaitlistOfferByID retrieves an offer with its patient and doctor
+func GetWaitlistOfferByID(db *sql.DB, offerID int) (*WaitlistOffer, error) {
+	query := `
+		SELECT o.id, o.entry_id, o.doctor_id, o.patient_id, o.starts_at, o.expires_at, o.status, o.created_at,
+		       u.first_name, u.last_name, u.email,
+		       d.specialty, d.timezone, du.first_name, du.last_name
+		FROM waitlist_offers o
+		JOIN users u ON o.patient_id = u.id
+		JOIN doctors d ON o.doctor_id = d.id
+		JOIN users du ON d.user_id = du.id
+		WHERE o.id = $1
+	`
+
+	offer := &WaitlistOffer{}
+	var patient, doctorUser User
+	var doctor Doctor
+	err := db.QueryRow(query, offerID).Scan(
+		&offer.ID, &offer.EntryID, &offer.DoctorID, &offer.PatientID, &offer.StartsAt, &offer.ExpiresAt,
+		&offer.Status, &offer.CreatedAt,
+		&patient.FirstName, &patient.LastName, &patient.Email,
+		&doctor.Specialty, &doctor.TimeZone, &doctorUser.FirstName, &doctorUser.LastName,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	patient.ID = offer.PatientID
+	doctor.ID = offer.DoctorID
+	doctor.User = &doctorUser
+	offer.Patient = &patient
+	offer.Doctor = &doctor
+	offer.StartsAt = offer.StartsAt.In(doctor.Location())
+
+	return offer, nil
+}
+
+// ClaimWaitlistOffer books the offered slot as appointment, which carries
+// the patient, status and notes, and fills in its doctor and time. It
+// returns ErrOfferUnavailable if the offer is not the patient's, has
+// expired or was already claimed.
+func ClaimWaitlistOffer(db *sql.DB, offerID int, appointment *Appointment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the doctor's schedule first, as booking and offering do
+	var doctorID int
+	err = tx.QueryRow(`SELECT doctor_id FROM waitlist_offers WHERE id = $1`, offerID).Scan(&doctorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOfferUnavailable
+	}
+	if err != nil {
+		return err
+	}
+	if err := lockSchedule(tx, doctorID); err != nil {
+		return err
+	}
+
+	// An offer whose hold is gone no longer reserves its slot
+	var entryID int
+	var startsAt time.Time
+	err = tx.QueryRow(`
+		SELECT o.entry_id, o.starts_at FROM waitlist_offers o
+		WHERE o.id = $1 AND o.patient_id = $2 AND o.status = 'open' AND o.expires_at > CURRENT_TIMESTAMP
+		  AND EXISTS (SELECT 1 FROM slot_holds h WHERE h.offer_id = o.id)
+		FOR UPDATE
+	`, offerID, appointment.PatientID).Scan(&entryID, &startsAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOfferUnavailable
+	}
+	if err != nil {
+		return err
+	}
+
+	appointment.DoctorID, appointment.StartsAt = doctorID, startsAt
+	if err := createAppointment(tx, appointment); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE waitlist_offers SET status = 'claimed' WHERE id = $1`, offerID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM slot_holds WHERE offer_id = $1`, offerID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE waitlist_entries SET status = 'booked' WHERE id = $1`, entryID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ExpireWaitlistOffers closes open offers that expired before now or lost
+// their hold, and puts their patients back in line. It returns the closed
+// offers, whose slots can be offered to the next patient.
+func ExpireWaitlistOffers(db *sql.DB, now time.Time) ([]WaitlistOffer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE waitlist_offers o SET status = 'expired'
+		WHERE o.status = 'open'
+		  AND (o.expires_at <= $1 OR NOT EXISTS (SELECT 1 FROM slot_holds h WHERE h.offer_id = o.id))
+		RETURNING id, entry_id, doctor_id, patient_id, starts_at, expires_at, status, created_at
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expired []WaitlistOffer
+	for rows.Next() {
+		var o WaitlistOffer
+		err := rows.Scan(&o.ID, &o.EntryID, &o.DoctorID, &o.PatientID, &o.StartsAt, &o.ExpiresAt, &o.Status, &o.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, o := range expired {
+		_, err := tx.Exec(`UPDATE waitlist_entries SET status = 'waiting' WHERE id = $1 AND status = 'offered'`, o.EntryID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, tx.Commit()
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// offerTestSlot puts a new patient on doctor's waitlist for the day of
+// startsAt and offers them that slot for an hour
+func offerTestSlot(t *testing.T, doctor *Doctor, startsAt time.Time) *WaitlistOffer {
+	t.Helper()
+	db := testDB(t)
+	patient := createTestUser(t, db, "patient")
+	date := startsAt.In(mustLoadLocation(t, doctor.TimeZone)).Format("2006-01-02")
+	if err := JoinWaitlist(db, &WaitlistEntry{DoctorID: doctor.ID, PatientID: patient.ID, FromDate: date, ToDate: date}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := OfferSlot(db, doctor.ID, startsAt, time.Hour)
+	if err != nil || offer == nil {
+		t.Fatalf("OfferSlot = %v, %v", offer, err)
+	}
+	return offer
+}
+
+// TestOfferHoldOutlivesPatientHold has the offered patient hold another
+// slot while paying; the offered slot must stay reserved for them
+func TestOfferHoldOutlivesPatientHold(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	offer := offerTestSlot(t, doctor, testSlot(0))
+
+	hold := &SlotHold{DoctorID: doctor.ID, PatientID: offer.PatientID, StartsAt: testSlot(2 * time.Hour)}
+	if err := HoldSlot(db, hold, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	other := createTestUser(t, db, "patient")
+	err := CreateAppointment(db, &Appointment{PatientID: other.ID, DoctorID: doctor.ID, StartsAt: offer.StartsAt})
+	if !errors.Is(err, ErrSlotUnavailable) {
+		t.Errorf("booking the offered slot = %v, want %v", err, ErrSlotUnavailable)
+	}
+	if err := ReleaseSlotHold(db, hold.ID, offer.PatientID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ClaimWaitlistOffer(db, offer.ID, &Appointment{PatientID: offer.PatientID}); err != nil {
+		t.Errorf("claiming after holding another slot: %v", err)
+	}
+}
+
+// TestOfferWithoutHoldIsClosed removes an offer's hold; the offer can no
+// longer be claimed and the sweep closes it
+func TestOfferWithoutHoldIsClosed(t *testing.T) {
+	db := testDB(t)
+	doctor := createTestDoctor(t, db, 60, 0)
+	offer := offerTestSlot(t, doctor, testSlot(0))
+	if _, err := db.Exec(`DELETE FROM slot_holds WHERE offer_id = $1`, offer.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ClaimWaitlistOffer(db, offer.ID, &Appointment{PatientID: offer.PatientID})
+	if !errors.Is(err, ErrOfferUnavailable) {
+		t.Errorf("claiming an offer without a hold = %v, want %v", err, ErrOfferUnavailable)
+	}
+
+	closed, err := ExpireWaitlistOffers(db, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, o := range closed {
+		found = found || o.ID == offer.ID
+	}
+	if !found {
+		t.Errorf("ExpireWaitlistOffers didn't close offer %d", offer.ID)
+	}
+}
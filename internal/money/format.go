@@ -0,0 +1,75 @@
+package money
+
+import "strings"
+
+// numberFormat is how a language writes amounts of money
+type numberFormat struct {
+	decimal     string
+	group       string
+	symbolAfter bool // "1 500,00 ₸" rather than "₸1,500.00"
+}
+
+// nbsp keeps amounts from breaking across lines
+const nbsp = "\u00a0"
+
+// formats are the number formats of the supported languages
+var formats = map[string]numberFormat{
+	"en": {".", ",", false},
+	"ru": {",", nbsp, true},
+	"kk": {",", nbsp, true},
+	"uz": {",", nbsp, true},
+	"de": {",", ".", true},
+	"fr": {",", nbsp, true},
+	"tr": {",", ".", false},
+}
+
+// DefaultLocale is used for locales whose language isn't supported
+const DefaultLocale = "en"
+
+// language returns the language of a BCP 47 locale such as "ru-KZ"
+func language(locale string) string {
+	lang, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	return strings.ToLower(lang)
+}
+
+// SupportsLocale reports whether amounts can be formatted for the locale's
+// language
+func SupportsLocale(locale string) bool {
+	_, ok := formats[language(locale)]
+	return ok
+}
+
+// Format returns m written for a locale, with the currency symbol, e.g.
+// "₸1,500.00" in en-KZ and "1 500,00 ₸" in ru-KZ
+func (m Money) Format(locale string) string {
+	c := currency(m.Currency)
+	return m.format(locale, c.Symbol, c.Symbol == c.Code)
+}
+
+// FormatCode is Format with the currency code instead of the symbol, for
+// documents whose fonts lack currency symbols
+func (m Money) FormatCode(locale string) string {
+	return m.format(locale, m.Currency, true)
+}
+
+func (m Money) format(locale, unit string, isCode bool) string {
+	f, ok := formats[language(locale)]
+	if !ok {
+		f = formats[DefaultLocale]
+	}
+
+	digits := m.digits(f.decimal, f.group)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	// Codes are set apart from the digits; symbols only follow them apart
+	switch {
+	case f.symbolAfter:
+		return sign + digits + nbsp + unit
+	case isCode:
+		return sign + unit + nbsp + digits
+	}
+	return sign + unit + digits
+}
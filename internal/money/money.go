@@ -0,0 +1,206 @@
+// Package money represents amounts of money exactly, as integer minor units
+// (tiyn, cents) of an ISO 4217 currency, and formats them for display.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownCurrency is returned for a code that isn't a supported ISO 4217
+// currency
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// ErrInvalidAmount is returned for an amount that can't be parsed, or has
+// more decimals than its currency
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Currency is an ISO 4217 currency
+type Currency struct {
+	Code     string
+	Exponent int    // digits after the decimal point, e.g. 2 for tiyn
+	Symbol   string // as shown next to amounts
+}
+
+// currencies are the currencies amounts may be in
+var currencies = map[string]Currency{
+	"KZT": {"KZT", 2, "₸"},
+	"RUB": {"RUB", 2, "₽"},
+	"UZS": {"UZS", 2, "UZS"},
+	"KGS": {"KGS", 2, "KGS"},
+	"USD": {"USD", 2, "$"},
+	"EUR": {"EUR", 2, "€"},
+	"GBP": {"GBP", 2, "£"},
+	"CNY": {"CNY", 2, "¥"},
+	"TRY": {"TRY", 2, "₺"},
+	"AED": {"AED", 2, "AED"},
+	"JPY": {"JPY", 0, "¥"},
+	"KWD": {"KWD", 3, "KWD"},
+}
+
+// LookupCurrency returns the currency with an ISO 4217 code
+func LookupCurrency(code string) (Currency, error) {
+	c, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// currency returns the currency of code, treating unknown codes as having
+// two decimals so amounts stored in them still display
+func currency(code string) Currency {
+	if c, err := LookupCurrency(code); err == nil {
+		return c
+	}
+	return Currency{Code: code, Exponent: 2, Symbol: code}
+}
+
+// Money is an amount in minor units of a currency. The zero value is
+// nothing, in no currency.
+type Money struct {
+	Minor    int64  // e.g. tiyn for KZT
+	Currency string // ISO 4217 code
+}
+
+// New returns minor units of currency
+func New(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// FromMajor returns whole units of currency, e.g. tenge
+func FromMajor(units int64, code string) Money {
+	minor := units
+	for i := 0; i < currency(code).Exponent; i++ {
+		minor *= 10
+	}
+	return Money{Minor: minor, Currency: code}
+}
+
+// Parse reads a decimal amount such as "1500" or "1500.50" in currency,
+// exactly. It returns ErrInvalidAmount if s has more decimals than the
+// currency, and ErrUnknownCurrency for an unsupported currency.
+func Parse(s, code string) (Money, error) {
+	c, err := LookupCurrency(code)
+	if err != nil {
+		return Money{}, err
+	}
+
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	whole, frac, point := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if whole == "" || point && frac == "" || len(frac) > c.Exponent || strings.ContainsAny(whole+frac, "+-") {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	frac += strings.Repeat("0", c.Exponent-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Minor: minor, Currency: c.Code}, nil
+}
+
+// IsZero reports whether m is no money
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// Sub returns m less o, which must be in the same currency
+func (m Money) Sub(o Money) Money {
+	if m.Currency != o.Currency {
+		panic("money: subtracting " + o.Currency + " from " + m.Currency)
+	}
+	return Money{Minor: m.Minor - o.Minor, Currency: m.Currency}
+}
+
+// Percent returns percent of m, rounded down to whole minor units
+func (m Money) Percent(percent int) Money {
+	return Money{Minor: m.Minor * int64(percent) / 100, Currency: m.Currency}
+}
+
+// Compare orders amounts by currency code, then by amount
+func Compare(a, b Money) int {
+	switch {
+	case a.Currency != b.Currency:
+		return strings.Compare(a.Currency, b.Currency)
+	case a.Minor < b.Minor:
+		return -1
+	case a.Minor > b.Minor:
+		return 1
+	}
+	return 0
+}
+
+// Decimal returns the amount as a plain decimal in major units, e.g.
+// "1500.50", the way payment gateways and forms expect it
+func (m Money) Decimal() string {
+	return m.digits(".", "")
+}
+
+// String returns the amount and currency code, e.g. "1500.50 KZT"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// digits formats the amount in major units with the given separators
+func (m Money) digits(decimal, group string) string {
+	exp := currency(m.Currency).Exponent
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+
+	s := strconv.FormatInt(minor, 10)
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	whole, frac := s[:len(s)-exp], s[len(s)-exp:]
+
+	if group != "" {
+		var b strings.Builder
+		for i, d := range whole {
+			if i > 0 && (len(whole)-i)%3 == 0 {
+				b.WriteString(group)
+			}
+			b.WriteRune(d)
+		}
+		whole = b.String()
+	}
+	if exp == 0 {
+		return sign + whole
+	}
+	return sign + whole + decimal + frac
+}
+
+// moneyJSON is how Money is encoded in JSON
+type moneyJSON struct {
+	Amount   string `json:"amount"` // a decimal, as in Decimal
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON encodes m as its decimal amount and currency
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{m.Decimal(), m.Currency})
+}
+
+// UnmarshalJSON decodes what MarshalJSON encodes
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	parsed, err := Parse(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,175 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s, code string
+		want    Money
+		err     error
+	}{
+		{"1500", "KZT", New(150000, "KZT"), nil},
+		{"1500.5", "KZT", New(150050, "KZT"), nil},
+		{"1500.50", "KZT", New(150050, "KZT"), nil},
+		{" 0.01 ", "KZT", New(1, "KZT"), nil},
+		{"-0.01", "KZT", New(-1, "KZT"), nil},
+		{"1500", "kzt", New(150000, "KZT"), nil},
+		{"1500", "JPY", New(1500, "JPY"), nil},
+		{"1.234", "KWD", New(1234, "KWD"), nil},
+		{"1.2", "KWD", New(1200, "KWD"), nil},
+		{"1500.505", "KZT", Money{}, ErrInvalidAmount},
+		{"1500.5", "JPY", Money{}, ErrInvalidAmount},
+		{"1.2345", "KWD", Money{}, ErrInvalidAmount},
+		{"", "KZT", Money{}, ErrInvalidAmount},
+		{"1.", "KZT", Money{}, ErrInvalidAmount},
+		{".5", "KZT", Money{}, ErrInvalidAmount},
+		{"-", "KZT", Money{}, ErrInvalidAmount},
+		{"--1", "KZT", Money{}, ErrInvalidAmount},
+		{"+1", "KZT", Money{}, ErrInvalidAmount},
+		{"1.-5", "KZT", Money{}, ErrInvalidAmount},
+		{"1,500.00", "KZT", Money{}, ErrInvalidAmount},
+		{"1e3", "KZT", Money{}, ErrInvalidAmount},
+		{"99999999999999999999", "KZT", Money{}, ErrInvalidAmount},
+		{"1500", "XXX", Money{}, ErrUnknownCurrency},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.s, tt.code)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Parse(%q, %s) = %v, %v; want %v, %v", tt.s, tt.code, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestDecimalRoundTrip(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(150050, "KZT"), "1500.50"},
+		{New(150000, "KZT"), "1500.00"},
+		{New(5, "KZT"), "0.05"},
+		{New(0, "KZT"), "0.00"},
+		{New(-1, "KZT"), "-0.01"},
+		{New(-150050, "KZT"), "-1500.50"},
+		{New(1500, "JPY"), "1500"},
+		{New(1, "KWD"), "0.001"},
+		{New(1234567, "KWD"), "1234.567"},
+		{FromMajor(50, "KZT"), "50.00"},
+		{FromMajor(50, "JPY"), "50"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Decimal(); got != tt.want {
+			t.Errorf("%#v.Decimal() = %q, want %q", tt.m, got, tt.want)
+		}
+		back, err := Parse(tt.m.Decimal(), tt.m.Currency)
+		if err != nil || back != tt.m {
+			t.Errorf("Parse(%q) = %v, %v; want %v", tt.m.Decimal(), back, err, tt.m)
+		}
+	}
+	if got := New(150050, "KZT").String(); got != "1500.50 KZT" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		m       Money
+		percent int
+		want    int64
+	}{
+		{New(150000, "KZT"), 100, 150000},
+		{New(150000, "KZT"), 50, 75000},
+		{New(1001, "KZT"), 50, 500}, // 500.5 rounds down
+		{New(999, "KZT"), 33, 329},  // 329.67 rounds down
+		{New(1, "KZT"), 99, 0},
+		{New(150000, "KZT"), 0, 0},
+		{New(1500, "JPY"), 15, 225},
+	}
+	for _, tt := range tests {
+		got := tt.m.Percent(tt.percent)
+		if got.Minor != tt.want || got.Currency != tt.m.Currency {
+			t.Errorf("%v.Percent(%d) = %v, want %d minor units", tt.m, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		m      Money
+		locale string
+		want   string
+	}{
+		{New(150050, "KZT"), "en-KZ", "₸1,500.50"},
+		{New(150050, "KZT"), "ru-KZ", "1 500,50 ₸"},
+		{New(150050, "KZT"), "kk", "1 500,50 ₸"},
+		{New(123456789, "KZT"), "ru_KZ", "1 234 567,89 ₸"},
+		{New(99, "KZT"), "en-KZ", "₸0.99"},
+		{New(-150050, "KZT"), "en-KZ", "-₸1,500.50"},
+		{New(-150050, "KZT"), "ru-KZ", "-1 500,50 ₸"},
+		{New(100000, "USD"), "en-US", "$1,000.00"},
+		{New(100000, "EUR"), "de-DE", "1.000,00 €"},
+		{New(150000, "JPY"), "en", "¥150,000"},
+		{New(1500, "KWD"), "en", "KWD 1.500"},
+		{New(150050, "KZT"), "xx-YY", "₸1,500.50"}, // unsupported languages read like English
+	}
+	for _, tt := range tests {
+		if got := tt.m.Format(tt.locale); got != tt.want {
+			t.Errorf("%v.Format(%s) = %q, want %q", tt.m, tt.locale, got, tt.want)
+		}
+	}
+
+	if got := New(150050, "KZT").FormatCode("en-KZ"); got != "KZT 1,500.50" {
+		t.Errorf("FormatCode(en-KZ) = %q", got)
+	}
+	if got := New(150050, "KZT").FormatCode("ru-KZ"); got != "1 500,50 KZT" {
+		t.Errorf("FormatCode(ru-KZ) = %q", got)
+	}
+	if !SupportsLocale("ru-KZ") || !SupportsLocale("EN") || SupportsLocale("xx") {
+		t.Error("SupportsLocale is wrong")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type fee struct {
+		Fee Money `json:"fee"`
+	}
+	in := fee{New(150050, "KZT")}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"fee":{"amount":"1500.50","currency":"KZT"}}` {
+		t.Errorf("Marshal = %s", data)
+	}
+
+	var out fee
+	if err := json.Unmarshal(data, &out); err != nil || out != in {
+		t.Errorf("Unmarshal = %v, %v; want %v", out, err, in)
+	}
+
+	for _, bad := range []string{
+		`{"fee":{"amount":"1500.505","currency":"KZT"}}`,
+		`{"fee":{"amount":"1500","currency":"XXX"}}`,
+		`{"fee":{"amount":1500,"currency":"KZT"}}`,
+	} {
+		if err := json.Unmarshal([]byte(bad), &out); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	if Compare(New(100, "KZT"), New(200, "KZT")) >= 0 || Compare(New(200, "KZT"), New(100, "KZT")) <= 0 {
+		t.Error("Compare doesn't order by amount")
+	}
+	if Compare(New(100, "KZT"), New(100, "KZT")) != 0 {
+		t.Error("equal amounts don't compare equal")
+	}
+	if Compare(New(999, "EUR"), New(1, "KZT")) >= 0 {
+		t.Error("Compare doesn't order by currency first")
+	}
+}
@@ -0,0 +1,292 @@
+package payments
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+)
+
+// FakeServer is a stand-in for the Kaspi merchant API, for development and
+// tests. It creates orders like Kaspi does and serves a page at each
+// order's payment URL where the payment can be approved or declined; the
+// outcome is posted to the order's callback URL, signed with the webhook
+// secret, and the browser is sent on to the return or fail URL. Paid orders
+// can be refunded, up to their amount.
+// It is safe for concurrent use.
+type FakeServer struct {
+	apiKey        string
+	webhookSecret string
+	client        *http.Client
+	mux           *http.ServeMux
+
+	mu     sync.Mutex
+	orders map[string]*fakeOrder
+	nextID int
+}
+
+// fakeOrder is an order held by the fake server
+type fakeOrder struct {
+	kaspiOrderRequest
+	ID     string
+	Status string // "" until paid or declined
+
+	Refunds  map[string]string // gateway refund IDs by refund_id
+	Refunded money.Money
+}
+
+// NewFakeServer returns a fake gateway accepting orders made with apiKey,
+// or with any key if apiKey is empty, and signing callbacks with
+// webhookSecret
+func NewFakeServer(apiKey, webhookSecret string) *FakeServer {
+	s := &FakeServer{
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 15 * time.Second},
+		mux:           http.NewServeMux(),
+		orders:        make(map[string]*fakeOrder),
+	}
+	s.mux.HandleFunc("POST /v1/orders", s.createOrder)
+	s.mux.HandleFunc("GET /pay/{id}", s.payPage)
+	s.mux.HandleFunc("POST /pay/{id}", s.pay)
+	s.mux.HandleFunc("POST /v1/orders/{id}/refunds", s.refund)
+	return s
+}
+
+// ServeHTTP serves the fake API and payment pages
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Status returns the outcome of an order: StatusPaid, StatusFailed, or ""
+// while it is unpaid or unknown
+func (s *FakeServer) Status(orderID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.orders[orderID]; ok {
+		return o.Status
+	}
+	return ""
+}
+
+// Refunded returns how much of an order has been refunded
+func (s *FakeServer) Refunded(orderID string) money.Money {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.orders[orderID]; ok {
+		return o.Refunded
+	}
+	return money.Money{}
+}
+
+func (s *FakeServer) createOrder(w http.ResponseWriter, r *http.Request) {
+	if s.apiKey != "" && r.Header.Get("Authorization") != "Bearer "+s.apiKey {
+		writeJSON(w, http.StatusUnauthorized, kaspiOrderResponse{Error: "invalid API key"})
+		return
+	}
+
+	var req kaspiOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, kaspiOrderResponse{Error: "invalid order"})
+		return
+	}
+	if req.OrderID == "" || req.CallbackURL == "" {
+		writeJSON(w, http.StatusBadRequest, kaspiOrderResponse{Error: "order_id and callback_url are required"})
+		return
+	}
+	if _, err := money.Parse(req.Amount, req.Currency); err != nil {
+		writeJSON(w, http.StatusBadRequest, kaspiOrderResponse{Error: "invalid amount"})
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	order := &fakeOrder{
+		kaspiOrderRequest: req,
+		ID:                "fake-" + strconv.Itoa(s.nextID),
+		Refunds:           make(map[string]string),
+		Refunded:          money.New(0, req.Currency),
+	}
+	s.orders[order.ID] = order
+	s.mu.Unlock()
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	writeJSON(w, http.StatusCreated, kaspiOrderResponse{
+		ID:         order.ID,
+		PaymentURL: scheme + "://" + r.Host + "/pay/" + order.ID,
+	})
+}
+
+func (s *FakeServer) refund(w http.ResponseWriter, r *http.Request) {
+	if s.apiKey != "" && r.Header.Get("Authorization") != "Bearer "+s.apiKey {
+		writeJSON(w, http.StatusUnauthorized, kaspiRefundResponse{Error: "invalid API key"})
+		return
+	}
+
+	var req kaspiRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefundID == "" {
+		writeJSON(w, http.StatusBadRequest, kaspiRefundResponse{Error: "invalid refund"})
+		return
+	}
+	amount, err := money.Parse(req.Amount, req.Currency)
+	if err != nil || amount.Minor <= 0 {
+		writeJSON(w, http.StatusBadRequest, kaspiRefundResponse{Error: "invalid amount"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[r.PathValue("id")]
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, kaspiRefundResponse{Error: "unknown order"})
+		return
+	case order.Refunds[req.RefundID] != "":
+		writeJSON(w, http.StatusOK, kaspiRefundResponse{ID: order.Refunds[req.RefundID], Status: "succeeded"})
+		return
+	case order.Status != StatusPaid:
+		writeJSON(w, http.StatusConflict, kaspiRefundResponse{Error: "order is not paid"})
+		return
+	case req.Currency != order.Currency:
+		writeJSON(w, http.StatusBadRequest, kaspiRefundResponse{Error: "currency does not match the order"})
+		return
+	}
+	paid, _ := money.Parse(order.Amount, order.Currency)
+	if order.Refunded.Minor+amount.Minor > paid.Minor {
+		writeJSON(w, http.StatusConflict, kaspiRefundResponse{Error: "refund exceeds the order amount"})
+		return
+	}
+
+	id := order.ID + "-refund-" + strconv.Itoa(len(order.Refunds)+1)
+	order.Refunds[req.RefundID] = id
+	order.Refunded.Minor += amount.Minor
+	writeJSON(w, http.StatusCreated, kaspiRefundResponse{ID: id, Status: "succeeded"})
+}
+
+// fakePayPage is the page shown at an order's payment URL
+var fakePayPage = template.Must(template.New("pay").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><title>Fake Kaspi payment</title></head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 40px auto;">
+    <h1>Fake Kaspi payment</h1>
+    <p>{{.Description}}</p>
+    <p><strong>{{.Amount}} {{.Currency}}</strong></p>
+    {{- if .Status}}
+    <p>This order is already {{.Status}}.</p>
+    {{- else}}
+    <form method="POST">
+        <button type="submit" name="outcome" value="paid">Pay</button>
+        <button type="submit" name="outcome" value="failed">Decline</button>
+    </form>
+    {{- end}}
+</body>
+</html>
+`))
+
+func (s *FakeServer) payPage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	order, ok := s.orders[r.PathValue("id")]
+	var snapshot fakeOrder
+	if ok {
+		snapshot = *order
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fakePayPage.Execute(w, snapshot)
+}
+
+func (s *FakeServer) pay(w http.ResponseWriter, r *http.Request) {
+	outcome := r.FormValue("outcome")
+	if outcome != StatusPaid && outcome != StatusFailed {
+		http.Error(w, "outcome must be paid or failed", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[r.PathValue("id")]
+	if ok && order.Status == "" {
+		order.Status = outcome
+	}
+	var snapshot fakeOrder
+	if ok {
+		snapshot = *order
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	cb := kaspiCallback{
+		ID:       snapshot.ID,
+		OrderID:  snapshot.OrderID,
+		Status:   snapshot.Status,
+		Amount:   snapshot.Amount,
+		Currency: snapshot.Currency,
+	}
+	if cb.Status == StatusFailed {
+		cb.Reason = "Declined by the payer"
+	}
+	if err := s.sendCallback(snapshot.CallbackURL, cb); err != nil {
+		log.Printf("Fake gateway: callback for %s failed: %v", snapshot.ID, err)
+	}
+
+	next := snapshot.ReturnURL
+	if cb.Status == StatusFailed {
+		next = snapshot.FailURL
+	}
+	if next == "" {
+		fakePayPage.Execute(w, snapshot)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// sendCallback posts a signed callback like Kaspi does
+func (s *FakeServer) sendCallback(url string, cb kaspiCallback) error {
+	body, err := json.Marshal(cb)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(s.webhookSecret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback rejected: %s", resp.Status)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,99 @@
+package payments
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+)
+
+func TestFakeServer(t *testing.T) {
+	fake := httptest.NewServer(NewFakeServer("key", "secret"))
+	defer fake.Close()
+	g := NewKaspiGateway(fake.URL, "merchant", "key", "secret")
+
+	// The clinic's webhook, checking callbacks like the real one does
+	callbacks := make(chan *Callback, 1)
+	clinic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cb, err := g.ParseCallback(r)
+		if err != nil {
+			t.Errorf("ParseCallback: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		callbacks <- cb
+	}))
+	defer clinic.Close()
+
+	ctx := context.Background()
+	amount := money.FromMajor(7500, "KZT")
+	order, err := g.CreateOrder(ctx, OrderRequest{Reference: "ref-1", Amount: amount, CallbackURL: clinic.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Refund(ctx, RefundRequest{Reference: "early", OrderID: order.ID, Amount: amount}); err == nil {
+		t.Error("refunding an unpaid order succeeded")
+	}
+
+	resp, err := http.PostForm(order.PaymentURL, url.Values{"outcome": {StatusPaid}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var cb *Callback
+	select {
+	case cb = <-callbacks:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no callback for the paid order")
+	}
+	want := Callback{OrderID: order.ID, Reference: "ref-1", Status: StatusPaid, Amount: amount}
+	if *cb != want {
+		t.Errorf("callback = %+v, want %+v", *cb, want)
+	}
+	srv := fake.Config.Handler.(*FakeServer)
+	if status := srv.Status(order.ID); status != StatusPaid {
+		t.Errorf("Status = %q, want %q", status, StatusPaid)
+	}
+
+	part := money.FromMajor(2500, "KZT")
+	first, err := g.Refund(ctx, RefundRequest{Reference: "r1", OrderID: order.ID, Amount: part})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := g.Refund(ctx, RefundRequest{Reference: "r1", OrderID: order.ID, Amount: part})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("repeated refund ID = %q, want %q", again.ID, first.ID)
+	}
+	if refunded := srv.Refunded(order.ID); refunded != part {
+		t.Errorf("Refunded after a repeated refund = %v, want %v", refunded, part)
+	}
+
+	if _, err := g.Refund(ctx, RefundRequest{Reference: "r2", OrderID: order.ID, Amount: amount}); err == nil {
+		t.Error("refunding more than was paid succeeded")
+	}
+	if _, err := g.Refund(ctx, RefundRequest{Reference: "r3", OrderID: order.ID, Amount: amount.Sub(part)}); err != nil {
+		t.Errorf("refunding the rest: %v", err)
+	}
+	if refunded := srv.Refunded(order.ID); refunded != amount {
+		t.Errorf("Refunded = %v, want %v", refunded, amount)
+	}
+}
+
+func TestFakeServerChecksAPIKey(t *testing.T) {
+	fake := httptest.NewServer(NewFakeServer("key", "secret"))
+	defer fake.Close()
+
+	g := NewKaspiGateway(fake.URL, "merchant", "wrong key", "secret")
+	_, err := g.CreateOrder(context.Background(), OrderRequest{Reference: "ref-1", Amount: money.FromMajor(1, "KZT"), CallbackURL: "http://clinic.example"})
+	if err == nil {
+		t.Error("CreateOrder with the wrong API key succeeded")
+	}
+}
@@ -0,0 +1,208 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+)
+
+// Headers Kaspi signs callbacks with
+const (
+	SignatureHeader = "X-Kaspi-Signature"
+	TimestampHeader = "X-Kaspi-Timestamp"
+)
+
+// maxCallbackBytes limits the size of a callback body
+const maxCallbackBytes = 64 << 10
+
+// KaspiGateway takes payments through the Kaspi merchant API. Orders are
+// created with POST {api}/v1/orders; Kaspi then posts the outcome to the
+// order's callback URL, signed with the merchant's webhook secret. Refunds
+// are made with POST {api}/v1/orders/{id}/refunds and take effect at once.
+type KaspiGateway struct {
+	apiURL        string
+	merchantID    string
+	apiKey        string
+	webhookSecret string
+	client        *http.Client
+}
+
+// NewKaspiGateway returns a gateway for the Kaspi API at apiURL
+func NewKaspiGateway(apiURL, merchantID, apiKey, webhookSecret string) *KaspiGateway {
+	return &KaspiGateway{
+		apiURL:        strings.TrimRight(apiURL, "/"),
+		merchantID:    merchantID,
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Name identifies Kaspi in stored payments
+func (g *KaspiGateway) Name() string {
+	return "kaspi"
+}
+
+// kaspiOrderRequest is the body of a create order call
+type kaspiOrderRequest struct {
+	MerchantID  string `json:"merchant_id"`
+	OrderID     string `json:"order_id"`
+	Amount      string `json:"amount"`
+	Currency    string `json:"currency"`
+	Description string `json:"description"`
+	ReturnURL   string `json:"return_url"`
+	FailURL     string `json:"fail_url"`
+	CallbackURL string `json:"callback_url"`
+}
+
+// kaspiOrderResponse is the reply to a create order call
+type kaspiOrderResponse struct {
+	ID         string `json:"id"`
+	PaymentURL string `json:"payment_url"`
+	Error      string `json:"error"`
+}
+
+// CreateOrder registers an order with Kaspi
+func (g *KaspiGateway) CreateOrder(ctx context.Context, req OrderRequest) (*Order, error) {
+	body, err := json.Marshal(kaspiOrderRequest{
+		MerchantID:  g.merchantID,
+		OrderID:     req.Reference,
+		Amount:      req.Amount.Decimal(),
+		Currency:    req.Amount.Currency,
+		Description: req.Description,
+		ReturnURL:   req.ReturnURL,
+		FailURL:     req.FailURL,
+		CallbackURL: req.CallbackURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.apiURL+"/v1/orders", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+g.apiKey)
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out kaspiOrderResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxCallbackBytes)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("kaspi: create order: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kaspi: create order: %s: %s", resp.Status, out.Error)
+	}
+	if out.ID == "" || out.PaymentURL == "" {
+		return nil, fmt.Errorf("kaspi: create order: incomplete response")
+	}
+
+	return &Order{ID: out.ID, PaymentURL: out.PaymentURL}, nil
+}
+
+// kaspiCallback is the body of a callback
+type kaspiCallback struct {
+	ID       string `json:"id"`
+	OrderID  string `json:"order_id"`
+	Status   string `json:"status"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ParseCallback verifies the signature of a Kaspi callback and decodes it
+func (g *KaspiGateway) ParseCallback(r *http.Request) (*Callback, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	err = VerifySignature(g.webhookSecret, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var cb kaspiCallback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		return nil, fmt.Errorf("kaspi: callback: %w", err)
+	}
+	if cb.Status != StatusPaid && cb.Status != StatusFailed {
+		return nil, fmt.Errorf("kaspi: callback: unknown status %q", cb.Status)
+	}
+	amount, err := money.Parse(cb.Amount, cb.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("kaspi: callback: %w", err)
+	}
+	return &Callback{OrderID: cb.ID, Reference: cb.OrderID, Status: cb.Status, Amount: amount, Reason: cb.Reason}, nil
+}
+
+// kaspiRefundRequest is the body of a refund call
+type kaspiRefundRequest struct {
+	MerchantID string `json:"merchant_id"`
+	RefundID   string `json:"refund_id"`
+	Amount     string `json:"amount"`
+	Currency   string `json:"currency"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// kaspiRefundResponse is the reply to a refund call
+type kaspiRefundResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// Refund returns money from a paid Kaspi order. Kaspi answers a repeated
+// refund_id with the refund it already made.
+func (g *KaspiGateway) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	body, err := json.Marshal(kaspiRefundRequest{
+		MerchantID: g.merchantID,
+		RefundID:   req.Reference,
+		Amount:     req.Amount.Decimal(),
+		Currency:   req.Amount.Currency,
+		Reason:     req.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refundURL := g.apiURL + "/v1/orders/" + url.PathEscape(req.OrderID) + "/refunds"
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, refundURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+g.apiKey)
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out kaspiRefundResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxCallbackBytes)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("kaspi: refund: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kaspi: refund: %s: %s", resp.Status, out.Error)
+	}
+	if out.ID == "" || out.Status != "succeeded" {
+		return nil, fmt.Errorf("kaspi: refund: status %q", out.Status)
+	}
+
+	return &Refund{ID: out.ID}, nil
+}
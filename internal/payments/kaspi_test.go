@@ -0,0 +1,171 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"online-doctor-appointment/internal/money"
+)
+
+// signedCallback returns a callback request signed with secret at signedAt
+func signedCallback(secret string, signedAt time.Time, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(body))
+	r.Header.Set(TimestampHeader, strconv.FormatInt(signedAt.Unix(), 10))
+	r.Header.Set(SignatureHeader, Sign(secret, signedAt.Unix(), []byte(body)))
+	return r
+}
+
+func TestParseCallback(t *testing.T) {
+	g := NewKaspiGateway("https://kaspi.example", "merchant", "key", "secret")
+	const paid = `{"id":"kaspi-1","order_id":"ref-1","status":"paid","amount":"7500.50","currency":"KZT"}`
+
+	cb, err := g.ParseCallback(signedCallback("secret", time.Now(), paid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Callback{OrderID: "kaspi-1", Reference: "ref-1", Status: StatusPaid, Amount: money.New(750050, "KZT")}
+	if *cb != want {
+		t.Errorf("ParseCallback = %+v, want %+v", *cb, want)
+	}
+
+	// The signature of the paid callback, on a cheaper one
+	tampered := signedCallback("secret", time.Now(), paid)
+	tampered.Body = io.NopCloser(strings.NewReader(strings.Replace(paid, "7500.50", "1.00", 1)))
+
+	tests := []struct {
+		name string
+		r    *http.Request
+	}{
+		{"tampered body", tampered},
+		{"wrong secret", signedCallback("another secret", time.Now(), paid)},
+		{"stale", signedCallback("secret", time.Now().Add(-time.Hour), paid)},
+		{"unsigned", httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(paid))},
+		{"unknown status", signedCallback("secret", time.Now(), strings.Replace(paid, `"paid"`, `"refunded"`, 1))},
+		{"bad amount", signedCallback("secret", time.Now(), strings.Replace(paid, "7500.50", "7500.505", 1))},
+		{"not JSON", signedCallback("secret", time.Now(), "status=paid")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cb, err := g.ParseCallback(tt.r); err == nil {
+				t.Errorf("ParseCallback = %+v, want an error", *cb)
+			}
+		})
+	}
+}
+
+func TestKaspiCreateOrder(t *testing.T) {
+	var got kaspiOrderRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/orders" {
+			t.Errorf("request = %s %s, want POST /v1/orders", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+			t.Errorf("Authorization = %q, want %q", auth, "Bearer key")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		writeJSON(w, http.StatusCreated, kaspiOrderResponse{ID: "kaspi-1", PaymentURL: "https://pay.example/kaspi-1"})
+	}))
+	defer srv.Close()
+
+	g := NewKaspiGateway(srv.URL+"/", "merchant", "key", "secret")
+	order, err := g.CreateOrder(context.Background(), OrderRequest{
+		Reference:   "ref-1",
+		Amount:      money.New(750050, "KZT"),
+		Description: "Appointment",
+		ReturnURL:   "https://clinic.example/paid",
+		FailURL:     "https://clinic.example/failed",
+		CallbackURL: "https://clinic.example/payments/webhook",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *order != (Order{ID: "kaspi-1", PaymentURL: "https://pay.example/kaspi-1"}) {
+		t.Errorf("CreateOrder = %+v", *order)
+	}
+
+	want := kaspiOrderRequest{
+		MerchantID:  "merchant",
+		OrderID:     "ref-1",
+		Amount:      "7500.50",
+		Currency:    "KZT",
+		Description: "Appointment",
+		ReturnURL:   "https://clinic.example/paid",
+		FailURL:     "https://clinic.example/failed",
+		CallbackURL: "https://clinic.example/payments/webhook",
+	}
+	if got != want {
+		t.Errorf("order request = %+v, want %+v", got, want)
+	}
+}
+
+func TestKaspiCreateOrderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"rejected", http.StatusBadRequest, `{"error":"invalid amount"}`},
+		{"no payment URL", http.StatusCreated, `{"id":"kaspi-1"}`},
+		{"not JSON", http.StatusBadGateway, `<html>Bad gateway</html>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			g := NewKaspiGateway(srv.URL, "merchant", "key", "secret")
+			if _, err := g.CreateOrder(context.Background(), OrderRequest{Reference: "ref-1", Amount: money.FromMajor(1, "KZT")}); err == nil {
+				t.Error("CreateOrder succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestKaspiRefund(t *testing.T) {
+	var got kaspiRefundRequest
+	status := "succeeded"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.EscapedPath() != "/v1/orders/kaspi%2F1/refunds" {
+			t.Errorf("request = %s %s, want POST /v1/orders/kaspi%%2F1/refunds", r.Method, r.URL.EscapedPath())
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+			t.Errorf("Authorization = %q, want %q", auth, "Bearer key")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		writeJSON(w, http.StatusCreated, kaspiRefundResponse{ID: "refund-1", Status: status})
+	}))
+	defer srv.Close()
+
+	g := NewKaspiGateway(srv.URL, "merchant", "key", "secret")
+	req := RefundRequest{Reference: "ref-1-r1", OrderID: "kaspi/1", Amount: money.New(250000, "KZT"), Reason: "Cancelled"}
+	refund, err := g.Refund(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.ID != "refund-1" {
+		t.Errorf("refund ID = %q, want %q", refund.ID, "refund-1")
+	}
+	want := kaspiRefundRequest{MerchantID: "merchant", RefundID: "ref-1-r1", Amount: "2500.00", Currency: "KZT", Reason: "Cancelled"}
+	if got != want {
+		t.Errorf("refund request = %+v, want %+v", got, want)
+	}
+
+	status = "pending"
+	if _, err := g.Refund(context.Background(), req); err == nil {
+		t.Error("Refund with status pending succeeded, want an error")
+	}
+}
@@ -0,0 +1,146 @@
+// Package payments creates payment orders with a payment gateway and
+// verifies the gateway's signed callbacks.
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"online-doctor-appointment/internal/config"
+	"online-doctor-appointment/internal/money"
+)
+
+// Outcomes a gateway reports in a callback
+const (
+	StatusPaid   = "paid"
+	StatusFailed = "failed"
+)
+
+// ErrInvalidSignature is returned for callbacks that are unsigned, signed
+// with another secret, or too old
+var ErrInvalidSignature = errors.New("invalid payment callback signature")
+
+// OrderRequest asks a gateway to take a payment
+type OrderRequest struct {
+	Reference   string // our order ID, echoed back in callbacks
+	Amount      money.Money
+	Description string
+	ReturnURL   string // where the patient lands after paying
+	FailURL     string // where the patient lands after a failed or cancelled payment
+	CallbackURL string // where the gateway posts the outcome
+}
+
+// Order is a payment order created by a gateway
+type Order struct {
+	ID         string // the gateway's order ID
+	PaymentURL string // where to send the patient to pay
+}
+
+// Callback is the outcome of an order, as posted by the gateway
+type Callback struct {
+	OrderID   string // the gateway's order ID
+	Reference string // our order ID
+	Status    string // StatusPaid or StatusFailed
+	Amount    money.Money
+	Reason    string // why a payment failed
+}
+
+// RefundRequest asks a gateway to return money from a paid order
+type RefundRequest struct {
+	Reference string      // our refund ID; repeating a request with it refunds only once
+	OrderID   string      // the gateway's ID of the paid order
+	Amount    money.Money // at most what is left of the order
+	Reason    string
+}
+
+// Refund is a refund made by a gateway
+type Refund struct {
+	ID string // the gateway's refund ID
+}
+
+// Gateway takes payments for appointments
+type Gateway interface {
+	// Name identifies the gateway in stored payments
+	Name() string
+	// CreateOrder registers an order and returns where to pay it
+	CreateOrder(ctx context.Context, req OrderRequest) (*Order, error)
+	// ParseCallback verifies and decodes a callback request
+	ParseCallback(r *http.Request) (*Callback, error)
+	// Refund returns money from a paid order. It is safe to retry with the
+	// same reference after an error.
+	Refund(ctx context.Context, req RefundRequest) (*Refund, error)
+}
+
+// FromEnv builds the gateway selected by PAYMENT_GATEWAY:
+//
+//	kaspi - Kaspi at KASPI_API_URL with KASPI_MERCHANT_ID, KASPI_API_KEY and
+//	        KASPI_WEBHOOK_SECRET; point KASPI_API_URL at cmd/fakegateway
+//	        for local development
+//	none  - no online payments; patients pay at the clinic
+//
+// When PAYMENT_GATEWAY is unset, kaspi is used if KASPI_API_URL is set and
+// none otherwise, in which case the returned gateway is nil.
+func FromEnv() (Gateway, error) {
+	driver := config.String("PAYMENT_GATEWAY", "")
+	if driver == "" {
+		driver = "none"
+		if config.String("KASPI_API_URL", "") != "" {
+			driver = "kaspi"
+		}
+	}
+
+	switch driver {
+	case "kaspi":
+		secret := config.String("KASPI_WEBHOOK_SECRET", "")
+		if secret == "" {
+			return nil, fmt.Errorf("KASPI_WEBHOOK_SECRET must be set")
+		}
+		return NewKaspiGateway(
+			config.String("KASPI_API_URL", ""),
+			config.String("KASPI_MERCHANT_ID", ""),
+			config.String("KASPI_API_KEY", ""),
+			secret,
+		), nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown PAYMENT_GATEWAY %q", driver)
+}
+
+// callbackMaxAge is how old a callback's timestamp may be, so captured
+// callbacks can't be replayed later
+const callbackMaxAge = 5 * time.Minute
+
+// Sign returns the hex HMAC-SHA256 of timestamp and body, as sent in the
+// signature header of callbacks
+func Sign(secret string, timestamp int64, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifySignature checks a callback signature made by Sign with a
+// timestamp no older than callbackMaxAge
+func VerifySignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > callbackMaxAge || age < -callbackMaxAge {
+		return ErrInvalidSignature
+	}
+
+	want := Sign(secret, unix, body)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
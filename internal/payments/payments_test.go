@@ -0,0 +1,49 @@
+package payments
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	const secret = "webhook secret"
+	body := []byte(`{"id":"order-1","order_id":"ref-1","status":"paid","amount":"7500.00","currency":"KZT"}`)
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		signedAt  time.Time
+		timestamp string // sent instead of signedAt when set
+		secret    string
+		body      []byte
+		ok        bool
+	}{
+		{"valid", now, "", secret, body, true},
+		{"five minutes old", now.Add(-callbackMaxAge), "", secret, body, true},
+		{"five minutes ahead", now.Add(callbackMaxAge), "", secret, body, true},
+		{"stale", now.Add(-callbackMaxAge - time.Second), "", secret, body, false},
+		{"from the future", now.Add(callbackMaxAge + time.Second), "", secret, body, false},
+		{"wrong secret", now, "", "another secret", body, false},
+		{"tampered body", now, "", secret, []byte(`{"id":"order-1","order_id":"ref-1","status":"paid","amount":"1.00","currency":"KZT"}`), false},
+		{"timestamp not signed", now, strconv.FormatInt(now.Unix()+1, 10), secret, body, false},
+		{"malformed timestamp", now, "yesterday", secret, body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature := Sign(tt.secret, tt.signedAt.Unix(), tt.body)
+			timestamp := tt.timestamp
+			if timestamp == "" {
+				timestamp = strconv.FormatInt(tt.signedAt.Unix(), 10)
+			}
+
+			err := VerifySignature(secret, timestamp, signature, body, now)
+			if tt.ok && err != nil {
+				t.Errorf("VerifySignature = %v, want nil", err)
+			}
+			if !tt.ok && err != ErrInvalidSignature {
+				t.Errorf("VerifySignature = %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package pdf
+
+import "unicode"
+
+// Glyph widths of the standard fonts for the printable ASCII characters,
+// space to tilde, in thousandths of the font size (from the Adobe AFM files)
+var (
+	helvetica = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBold = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// defaultWidth is used for characters outside ASCII, most of which are
+// letters about as wide as a digit
+const defaultWidth = 556
+
+// winAnsiExtras are the characters Windows-1252 places in 0x80-0x9F
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, '‰': 0x89,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// cyrillic transliterates the Russian and Kazakh alphabets, lower case
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'ә': "a", 'ғ': "g", 'қ': "q", 'ң': "n", 'ө': "o", 'ұ': "u", 'ү': "u",
+	'һ': "h", 'і': "i",
+}
+
+// encode converts s to Windows-1252 for the standard fonts. Cyrillic is
+// transliterated; other characters the fonts can't show become '?'.
+func encode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			out = append(out, ' ')
+		case r >= 0x20 && r <= 0x7E, r >= 0xA0 && r <= 0xFF:
+			out = append(out, byte(r))
+		case winAnsiExtras[r] != 0:
+			out = append(out, winAnsiExtras[r])
+		default:
+			latin, ok := cyrillic[unicode.ToLower(r)]
+			if !ok {
+				out = append(out, '?')
+				continue
+			}
+			if unicode.IsUpper(r) && latin != "" {
+				latin = string(unicode.ToUpper(rune(latin[0]))) + latin[1:]
+			}
+			out = append(out, latin...)
+		}
+	}
+	return out
+}
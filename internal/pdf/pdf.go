@@ -0,0 +1,192 @@
+// Package pdf writes simple PDF documents: pages of text in the standard
+// Helvetica fonts, lines and shaded boxes. It needs no font files, so
+// documents can be produced anywhere, but text is limited to the
+// Windows-1252 character set. Russian and Kazakh Cyrillic is transliterated
+// to Latin, which loses the original spelling; characters of other scripts
+// are drawn as '?'.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Font is one of the standard fonts a document can use
+type Font int
+
+// Fonts
+const (
+	Regular Font = iota // Helvetica
+	Bold                // Helvetica-Bold
+)
+
+// resource returns the name the font is registered under in every page
+func (f Font) resource() string {
+	if f == Bold {
+		return "/F2"
+	}
+	return "/F1"
+}
+
+// Document is a PDF document being built
+type Document struct {
+	Title string
+	pages []*Page
+}
+
+// Page is a page of a document. Coordinates are in points from the bottom
+// left corner.
+type Page struct {
+	content bytes.Buffer
+}
+
+// AddPage appends an empty A4 page
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws s with its baseline starting at x, y
+func (p *Page) Text(x, y float64, font Font, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT %s %s Tf %s %s Td (%s) Tj ET\n",
+		font.resource(), num(size), num(x), num(y), escape(encode(s)))
+}
+
+// TextRight draws s ending at x, for right-aligned columns
+func (p *Page) TextRight(x, y float64, font Font, size float64, s string) {
+	p.Text(x-TextWidth(s, font, size), y, font, size, s)
+}
+
+// Line draws a straight line of the given width
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n", num(width), num(x1), num(y1), num(x2), num(y2))
+}
+
+// Box fills a rectangle with a shade of grey, 0 black to 1 white
+func (p *Page) Box(x, y, width, height, grey float64) {
+	fmt.Fprintf(&p.content, "%s g %s %s %s %s re f 0 g\n", num(grey), num(x), num(y), num(width), num(height))
+}
+
+// TextWidth returns how wide s is drawn in font at size
+func TextWidth(s string, font Font, size float64) float64 {
+	widths := &helvetica
+	if font == Bold {
+		widths = &helveticaBold
+	}
+
+	units := 0
+	for _, c := range encode(s) {
+		if c >= 32 && c <= 126 {
+			units += widths[c-32]
+		} else {
+			units += defaultWidth
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// Write writes the document to w
+func (d *Document) Write(w io.Writer) error {
+	out := &writer{}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are fixed; each page then takes two, itself and its content
+	kids := &bytes.Buffer{}
+	for i := range d.pages {
+		fmt.Fprintf(kids, "%d 0 R ", 5+2*i)
+	}
+	out.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	out.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", bytes.TrimSpace(kids.Bytes()), len(d.pages)))
+	out.object(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	out.object(4, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.pages {
+		id := 5 + 2*i
+		out.object(id, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			num(PageWidth), num(PageHeight), id+1))
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(p.content.Bytes())
+		zw.Close()
+		out.object(id+1, fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream",
+			compressed.Len(), compressed.Bytes()))
+	}
+
+	info := 5 + 2*len(d.pages)
+	out.object(info, fmt.Sprintf("<< /Title (%s) /Producer (online-doctor-appointment) >>", escape(encode(d.Title))))
+
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", info+1)
+	for _, offset := range out.offsets[1:] {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", info+1, info, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// writer collects a PDF file and the offset of each object in it
+type writer struct {
+	bytes.Buffer
+	offsets []int // by object number; 0 is unused
+}
+
+// object writes object number id, which must follow the previous one
+func (w *writer) object(id int, body string) {
+	if len(w.offsets) == 0 {
+		w.offsets = []int{0}
+	}
+	w.offsets = append(w.offsets, w.Len())
+	fmt.Fprintf(w, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+// num formats a number for a content stream, with at most two decimals
+func num(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	s = trimZeros(s)
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+func trimZeros(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '0' {
+		s = s[:len(s)-1]
+	}
+	if len(s) > 0 && s[len(s)-1] == '.' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// escape quotes bytes for a PDF literal string
+func escape(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		switch c {
+		case '\\', '(', ')':
+			out = append(out, '\\', c)
+		case '\r':
+			out = append(out, '\\', 'r')
+		case '\n':
+			out = append(out, '\\', 'n')
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"ASCII", "Receipt INV-2026-000001", "Receipt INV-2026-000001"},
+		{"Latin-1", "Zoë Müller", "Zo\xeb M\xfcller"},
+		{"Windows-1252 extras", "€ – “quoted”", "\x80 \x96 \x93quoted\x94"},
+		{"Russian", "Асель Нурланова", "Asel Nurlanova"},
+		{"Kazakh", "Әлия Қасымова", "Aliya Qasymova"},
+		{"upper case digraph", "Шынар Жумабек", "Shynar Zhumabek"},
+		{"other scripts", "王芳 ✓", "?? ?"},
+		{"tab", "a\tb", "a b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(encode(tt.in)); got != tt.want {
+				t.Errorf("encode(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// Objects and cross-reference entries of a written document
+var (
+	objectHeader = regexp.MustCompile(`^(\d+) 0 obj\n`)
+	xrefEntry    = regexp.MustCompile(`^(\d{10}) 00000 n \n$`)
+	trailer      = regexp.MustCompile(`trailer\n<< /Size (\d+) /Root 1 0 R /Info (\d+) 0 R >>\nstartxref\n(\d+)\n%%EOF\n$`)
+	streamObject = regexp.MustCompile(`(?s)<< /Length (\d+) /Filter /FlateDecode >>\nstream\n(.*?)\nendstream`)
+)
+
+func TestWriteIsWellFormed(t *testing.T) {
+	doc := &Document{Title: "Receipt (test)"}
+	first := doc.AddPage()
+	first.Text(50, 800, Bold, 22, "Receipt")
+	first.TextRight(545, 800, Regular, 10, `Paid \ (in full)`)
+	first.Line(50, 60, 545, 60, 0.5)
+	first.Box(45, 700, 505, 20, 0.92)
+	doc.AddPage().Text(50, 800, Regular, 10, "Page two")
+
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) {
+		t.Fatalf("output starts with %q", out[:min(len(out), 16)])
+	}
+	m := trailer.FindSubmatch(out)
+	if m == nil {
+		t.Fatalf("no trailer at the end of %q", out[max(0, len(out)-120):])
+	}
+	size, _ := strconv.Atoi(string(m[1]))
+	startxref, _ := strconv.Atoi(string(m[3]))
+	if want := 1 + 4 + 2*2 + 1; size != want {
+		t.Errorf("/Size = %d, want %d", size, want)
+	}
+
+	// The cross-reference table must point at each object in turn
+	xref := out[startxref:]
+	if !bytes.HasPrefix(xref, []byte("xref\n0 "+strconv.Itoa(size)+"\n0000000000 65535 f \n")) {
+		t.Fatalf("startxref %d points at %q", startxref, xref[:min(len(xref), 40)])
+	}
+	entries := bytes.SplitAfter(xref[bytes.Index(xref, []byte("f \n"))+3:], []byte("\n"))
+	for id := 1; id < size; id++ {
+		e := xrefEntry.FindSubmatch(entries[id-1])
+		if e == nil {
+			t.Fatalf("xref entry %d is %q", id, entries[id-1])
+		}
+		offset, _ := strconv.Atoi(string(e[1]))
+		h := objectHeader.FindSubmatch(out[offset:])
+		if h == nil || string(h[1]) != strconv.Itoa(id) {
+			t.Errorf("xref entry %d points at %q", id, out[offset:min(len(out), offset+20)])
+		}
+	}
+
+	// Each page's content stream must have its stated length and inflate
+	streams := streamObject.FindAllSubmatch(out, -1)
+	if len(streams) != 2 {
+		t.Fatalf("found %d content streams, want 2", len(streams))
+	}
+	var content [][]byte
+	for _, s := range streams {
+		length, _ := strconv.Atoi(string(s[1]))
+		if length != len(s[2]) {
+			t.Errorf("stream /Length %d, but it has %d bytes", length, len(s[2]))
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(s[2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, text)
+	}
+	for _, want := range []string{"/F2 22 Tf 50 800 Td (Receipt) Tj", `(Paid \\ \(in full\)) Tj`, "0.5 w 50 60 m 545 60 l S", "0.92 g 45 700 505 20 re f 0 g"} {
+		if !bytes.Contains(content[0], []byte(want)) {
+			t.Errorf("page 1 does not draw %q:\n%s", want, content[0])
+		}
+	}
+	if !bytes.Contains(content[1], []byte("(Page two) Tj")) {
+		t.Errorf("page 2 does not draw its text:\n%s", content[1])
+	}
+	if !bytes.Contains(out, []byte(`/Title (Receipt \(test\))`)) {
+		t.Error("the title is missing or not escaped")
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	// "Hi" is H (722) and i (222) in Helvetica, H (722) and i (278) in bold
+	if got := TextWidth("Hi", Regular, 10); got != 9.44 {
+		t.Errorf("regular width = %v, want 9.44", got)
+	}
+	if got := TextWidth("Hi", Bold, 10); got != 10 {
+		t.Errorf("bold width = %v, want 10", got)
+	}
+}
@@ -0,0 +1,22 @@
+package ratelimit
+
+import "time"
+
+// Backoff returns how long to wait after the given number of consecutive
+// failures. The first free failures cost nothing; after that the delay
+// starts at base and doubles with every failure, up to max.
+func Backoff(failures, free int, base, max time.Duration) time.Duration {
+	n := failures - free
+	if n <= 0 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
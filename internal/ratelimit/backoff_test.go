@@ -0,0 +1,27 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0}, // all free
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{9, 32 * time.Second},
+		{10, time.Minute}, // 64s, capped
+		{1000, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.failures, 3, time.Second, time.Minute); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows at most limit events per key within a sliding window.
+// It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+// New returns a limiter allowing limit events per key every window
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Allow records an event for key and reports whether it is within the
+// limit. Rejected events are not recorded.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+
+	// Drop events that have left the window
+	recent := l.events[key][:0]
+	for _, t := range l.events[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= l.limit {
+		l.events[key] = recent
+		return false
+	}
+
+	l.events[key] = append(recent, now)
+	return true
+}
@@ -0,0 +1,25 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(3, 50*time.Millisecond)
+
+	for i := 1; i <= 5; i++ {
+		if got, want := l.Allow("user 1"), i <= 3; got != want {
+			t.Errorf("event %d: Allow = %v, want %v", i, got, want)
+		}
+	}
+	if !l.Allow("user 2") {
+		t.Error("another key was limited")
+	}
+
+	// Rejected events don't count, so the window starts at the first event
+	time.Sleep(60 * time.Millisecond)
+	if !l.Allow("user 1") {
+		t.Error("still limited after the window passed")
+	}
+}
@@ -0,0 +1,120 @@
+// Package schedule computes bookable appointment slots from a doctor's
+// working windows, breaks and existing appointments.
+package schedule
+
+import (
+	"sort"
+	"time"
+)
+
+// Interval is the half-open span of time [Start, End)
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Overlaps reports whether i and o share any time
+func (i Interval) Overlaps(o Interval) bool {
+	return i.Start.Before(o.End) && o.Start.Before(i.End)
+}
+
+// Options controls slot generation
+type Options struct {
+	Duration time.Duration // length of one visit
+	Buffer   time.Duration // free time kept between visits
+}
+
+// Merge returns intervals sorted by start, with overlapping or touching
+// intervals joined and empty ones dropped
+func Merge(intervals []Interval) []Interval {
+	sorted := make([]Interval, 0, len(intervals))
+	for _, iv := range intervals {
+		if iv.Start.Before(iv.End) {
+			sorted = append(sorted, iv)
+		}
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Start.Before(sorted[b].Start) })
+
+	var merged []Interval
+	for _, iv := range sorted {
+		if n := len(merged); n > 0 && !iv.Start.After(merged[n-1].End) {
+			if iv.End.After(merged[n-1].End) {
+				merged[n-1].End = iv.End
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// Subtract returns the parts of windows not covered by any interval in cut
+func Subtract(windows, cut []Interval) []Interval {
+	cut = Merge(cut)
+
+	var free []Interval
+	for _, w := range Merge(windows) {
+		start := w.Start
+		for _, c := range cut {
+			if !c.End.After(start) || !c.Start.Before(w.End) {
+				continue
+			}
+			if c.Start.After(start) {
+				free = append(free, Interval{start, c.Start})
+			}
+			if c.End.After(start) {
+				start = c.End
+			}
+		}
+		if start.Before(w.End) {
+			free = append(free, Interval{start, w.End})
+		}
+	}
+	return free
+}
+
+// Slots returns the start time of every visit of opts.Duration that fits
+// inside windows, outside breaks, and no closer than opts.Buffer to a busy
+// interval. Busy intervals may have any length, so appointments booked
+// with a different slot duration are still respected.
+func Slots(windows, breaks, busy []Interval, opts Options) []time.Time {
+	if opts.Duration <= 0 {
+		return nil
+	}
+
+	// Pad every existing appointment with the buffer on both sides
+	blocked := make([]Interval, len(busy))
+	for i, b := range busy {
+		blocked[i] = Interval{b.Start.Add(-opts.Buffer), b.End.Add(opts.Buffer)}
+	}
+	blocked = Merge(blocked)
+
+	var slots []time.Time
+	for _, free := range Subtract(windows, breaks) {
+		t := free.Start
+		for !t.Add(opts.Duration).After(free.End) {
+			candidate := Interval{t, t.Add(opts.Duration)}
+
+			// Jump past the first appointment this visit would collide with
+			if next, clash := firstClash(candidate, blocked); clash {
+				t = next
+				continue
+			}
+
+			slots = append(slots, t)
+			t = candidate.End.Add(opts.Buffer)
+		}
+	}
+	return slots
+}
+
+// firstClash returns the end of the first blocked interval that overlaps
+// candidate
+func firstClash(candidate Interval, blocked []Interval) (time.Time, bool) {
+	for _, b := range blocked {
+		if candidate.Overlaps(b) {
+			return b.End, true
+		}
+	}
+	return time.Time{}, false
+}
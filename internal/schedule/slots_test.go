@@ -0,0 +1,139 @@
+package schedule
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// clock returns the time hh:mm on a fixed day
+func clock(hhmm string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", "2024-05-06 "+hhmm)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// span returns the interval between two clock times
+func span(from, to string) Interval {
+	return Interval{clock(from), clock(to)}
+}
+
+// clocks formats times as hh:mm for comparison
+func clocks(times []time.Time) []string {
+	out := make([]string, len(times))
+	for i, t := range times {
+		out[i] = t.Format("15:04")
+	}
+	return out
+}
+
+func TestSlots(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []Interval
+		breaks  []Interval
+		busy    []Interval
+		opts    Options
+		want    []string
+	}{
+		{
+			name:    "two windows in a day",
+			windows: []Interval{span("09:00", "12:00"), span("14:00", "17:00")},
+			opts:    Options{Duration: time.Hour},
+			want:    []string{"09:00", "10:00", "11:00", "14:00", "15:00", "16:00"},
+		},
+		{
+			name:    "overlapping windows are joined",
+			windows: []Interval{span("09:00", "11:00"), span("10:30", "12:00")},
+			opts:    Options{Duration: time.Hour},
+			want:    []string{"09:00", "10:00", "11:00"},
+		},
+		{
+			name:    "lunch break",
+			windows: []Interval{span("09:00", "17:00")},
+			breaks:  []Interval{span("12:00", "13:00")},
+			opts:    Options{Duration: time.Hour},
+			want:    []string{"09:00", "10:00", "11:00", "13:00", "14:00", "15:00", "16:00"},
+		},
+		{
+			name:    "buffer around a 90 minute appointment",
+			windows: []Interval{span("09:00", "14:00")},
+			busy:    []Interval{span("10:00", "11:30")},
+			opts:    Options{Duration: 30 * time.Minute, Buffer: 15 * time.Minute},
+			want:    []string{"09:00", "11:45", "12:30", "13:15"},
+		},
+		{
+			name:    "appointment without a buffer can be touched",
+			windows: []Interval{span("09:00", "12:00")},
+			busy:    []Interval{span("10:00", "11:30")},
+			opts:    Options{Duration: 30 * time.Minute},
+			want:    []string{"09:00", "09:30", "11:30"},
+		},
+		{
+			name:    "overlapping appointments of different lengths",
+			windows: []Interval{span("09:00", "12:00")},
+			busy:    []Interval{span("09:00", "09:20"), span("09:10", "10:40")},
+			opts:    Options{Duration: 30 * time.Minute},
+			want:    []string{"10:40", "11:10"},
+		},
+		{
+			name:    "buffered appointment next to a break",
+			windows: []Interval{span("09:00", "13:00")},
+			breaks:  []Interval{span("11:00", "12:00")},
+			busy:    []Interval{span("10:00", "11:00")},
+			opts:    Options{Duration: 30 * time.Minute, Buffer: 10 * time.Minute},
+			want:    []string{"09:00", "12:00"},
+		},
+		{
+			name:    "slot ending exactly at the window end",
+			windows: []Interval{span("09:00", "11:00")},
+			opts:    Options{Duration: time.Hour},
+			want:    []string{"09:00", "10:00"},
+		},
+		{
+			name:    "slot that would run past the window end",
+			windows: []Interval{span("09:00", "11:30")},
+			opts:    Options{Duration: time.Hour},
+			want:    []string{"09:00", "10:00"},
+		},
+		{
+			name:    "buffer is not needed at the window end",
+			windows: []Interval{span("09:00", "10:00")},
+			opts:    Options{Duration: 30 * time.Minute, Buffer: 30 * time.Minute},
+			want:    []string{"09:00"},
+		},
+		{
+			name:    "window shorter than a visit",
+			windows: []Interval{span("09:00", "09:45")},
+			opts:    Options{Duration: time.Hour},
+			want:    []string{},
+		},
+		{
+			name:    "no duration",
+			windows: []Interval{span("09:00", "17:00")},
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clocks(Slots(tt.windows, tt.breaks, tt.busy, tt.opts))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Slots = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	got := Subtract(
+		[]Interval{span("09:00", "12:00"), span("14:00", "18:00")},
+		[]Interval{span("08:00", "09:30"), span("11:00", "15:00"), span("16:00", "16:30")},
+	)
+	want := []Interval{span("09:30", "11:00"), span("15:00", "16:00"), span("16:30", "18:00")}
+	if !slices.Equal(got, want) {
+		t.Errorf("Subtract = %v, want %v", got, want)
+	}
+}
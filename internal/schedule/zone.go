@@ -0,0 +1,58 @@
+package schedule
+
+import (
+	"sync"
+	"time"
+)
+
+// zones caches loaded time zones by IANA name
+var zones sync.Map
+
+// Location returns the IANA time zone with the given name, such as
+// "Asia/Almaty"
+func Location(name string) (*time.Location, error) {
+	if loc, ok := zones.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	zones.Store(name, loc)
+	return loc, nil
+}
+
+// LocationOr returns the named time zone, or fallback if the name is empty
+// or unknown
+func LocationOr(name string, fallback *time.Location) *time.Location {
+	if name == "" {
+		return fallback
+	}
+	loc, err := Location(name)
+	if err != nil {
+		return fallback
+	}
+	return loc
+}
+
+// Day returns the start and end of date ("2006-01-02") in loc. Across a
+// daylight saving change the day is shorter or longer than 24 hours.
+func Day(date string, loc *time.Location) (Interval, error) {
+	start, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return Interval{}, err
+	}
+	return Interval{start, start.AddDate(0, 0, 1)}, nil
+}
+
+// At returns the time at clock ("15:04") on day's date in day's location.
+// Wall times skipped or repeated by a daylight saving change resolve to one
+// of the two offsets, as with time.Date.
+func At(day time.Time, clock string) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location()), nil
+}
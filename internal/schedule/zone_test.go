@@ -0,0 +1,112 @@
+package schedule
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func berlin(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := Location("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestDayAcrossDaylightSaving(t *testing.T) {
+	loc := berlin(t)
+	tests := []struct {
+		date string
+		want time.Duration
+	}{
+		{"2024-03-30", 24 * time.Hour},
+		{"2024-03-31", 23 * time.Hour}, // clocks go forward at 02:00
+		{"2024-04-01", 24 * time.Hour},
+		{"2024-10-27", 25 * time.Hour}, // clocks go back at 03:00
+		{"2024-10-28", 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		day, err := Day(tt.date, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := day.End.Sub(day.Start); got != tt.want {
+			t.Errorf("%s lasts %v, want %v", tt.date, got, tt.want)
+		}
+		if day.Start.Format("2006-01-02 15:04") != tt.date+" 00:00" || day.End.Format("15:04") != "00:00" {
+			t.Errorf("%s runs from %v to %v, want midnight to midnight", tt.date, day.Start, day.End)
+		}
+	}
+
+	if _, err := Day("2024-02-30", loc); err == nil {
+		t.Error("Day accepted an invalid date")
+	}
+}
+
+func TestAtAcrossDaylightSaving(t *testing.T) {
+	loc := berlin(t)
+	tests := []struct {
+		name  string
+		date  string
+		clock string
+		want  string // in UTC
+	}{
+		{"before spring forward", "2024-03-31", "01:30", "2024-03-31 00:30"},
+		{"skipped wall time moves forward", "2024-03-31", "02:30", "2024-03-31 01:30"},
+		{"after spring forward", "2024-03-31", "03:30", "2024-03-31 01:30"},
+		{"before fall back", "2024-10-27", "01:30", "2024-10-26 23:30"},
+		{"repeated wall time takes the later offset", "2024-10-27", "02:30", "2024-10-27 01:30"},
+		{"after fall back", "2024-10-27", "03:30", "2024-10-27 02:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, err := Day(tt.date, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := At(day.Start, tt.clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := got.UTC().Format("2006-01-02 15:04"); s != tt.want {
+				t.Errorf("At(%s %s) = %s UTC, want %s", tt.date, tt.clock, s, tt.want)
+			}
+		})
+	}
+
+	if _, err := At(time.Now(), "25:00"); err == nil {
+		t.Error("At accepted an invalid clock")
+	}
+}
+
+// TestSlotsAcrossDaylightSaving generates hourly slots in a 01:00-05:00
+// window on the days the clocks change. The window is 3 hours long in
+// spring and 5 in autumn, and each slot is a real hour.
+func TestSlotsAcrossDaylightSaving(t *testing.T) {
+	loc := berlin(t)
+	tests := []struct {
+		date string
+		want []string // wall clock with offset
+	}{
+		{"2024-03-31", []string{"01:00 +0100", "03:00 +0200", "04:00 +0200"}},
+		{"2024-10-27", []string{"01:00 +0200", "02:00 +0200", "02:00 +0100", "03:00 +0100", "04:00 +0100"}},
+	}
+	for _, tt := range tests {
+		day, err := Day(tt.date, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, _ := At(day.Start, "01:00")
+		end, _ := At(day.Start, "05:00")
+
+		var got []string
+		for _, slot := range Slots([]Interval{{start, end}}, nil, nil, Options{Duration: time.Hour}) {
+			got = append(got, slot.In(loc).Format("15:04 -0700"))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: slots %v, want %v", tt.date, got, tt.want)
+		}
+	}
+}
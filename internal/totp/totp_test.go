@@ -0,0 +1,106 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 Appendix B, base32 encoded
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// The SHA-1 test vectors of RFC 6238 Appendix B. The RFC prints 8 digit
+// codes; 6 digit codes are their last 6 digits.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeAtRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("code at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestCodeAtLowercaseSecret(t *testing.T) {
+	code, err := CodeAt(strings.ToLower(rfcSecret), Step(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Errorf("CodeAt with a lowercase secret = %s, %v", code, err)
+	}
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("CodeAt accepted an invalid secret")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	step := Step(at)
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		ok     bool
+	}{
+		{"same step", 0, true},
+		{"one step early", -Period, true},
+		{"one step late", Period, true},
+		{"two steps early", -2 * Period, false},
+		{"two steps late", 2 * Period, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := CodeAt(rfcSecret, Step(at.Add(tt.offset)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched, ok := Validate(rfcSecret, code, at)
+			if ok != tt.ok {
+				t.Fatalf("Validate = %v, want %v", ok, tt.ok)
+			}
+			if ok && matched != Step(at.Add(tt.offset)) {
+				t.Errorf("matched step %d, want %d", matched, Step(at.Add(tt.offset)))
+			}
+		})
+	}
+
+	if _, ok := Validate(rfcSecret, " 050 471 ", at); !ok {
+		t.Error("Validate rejected a code with spaces")
+	}
+	if _, ok := Validate(rfcSecret, "50471", at); ok {
+		t.Error("Validate accepted a short code")
+	}
+	if _, ok := Validate(rfcSecret, "000000", at); ok {
+		t.Errorf("Validate accepted a wrong code at step %d", step)
+	}
+}
+
+// TestValidateReplay checks that a code keeps matching the step it was
+// made for while it is in the window, which is what lets UseTOTPStep
+// reject it the second time
+func TestValidateReplay(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+	code, err := CodeAt(rfcSecret, Step(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, later := range []time.Duration{0, Period / 2, Period} {
+		matched, ok := Validate(rfcSecret, code, at.Add(later))
+		if !ok || matched != Step(at) {
+			t.Errorf("%v later: Validate = %d, %v; want step %d", later, matched, ok, Step(at))
+		}
+	}
+}
@@ -0,0 +1,12 @@
+-- Persistent login sessions (replaces the in-memory session map)
+CREATE TABLE IF NOT EXISTS sessions (
+                          token VARCHAR(128) PRIMARY KEY,
+                          user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                          user_type VARCHAR(20) NOT NULL,
+                          email VARCHAR(255) NOT NULL,
+                          expire_at TIMESTAMPTZ NOT NULL,
+                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expire_at ON sessions(expire_at);
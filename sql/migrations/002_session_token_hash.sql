@@ -0,0 +1,5 @@
+-- Session tokens are now stored as SHA-256 hashes. Existing plaintext
+-- sessions cannot be converted, so everyone is logged out once.
+DELETE FROM sessions;
+ALTER TABLE sessions RENAME COLUMN token TO token_hash;
+ALTER TABLE sessions ALTER COLUMN token_hash TYPE CHAR(64);
@@ -0,0 +1,2 @@
+-- Sessions carry the doctor profile ID so handlers don't need to look it up
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS doctor_id INTEGER REFERENCES doctors(id) ON DELETE CASCADE;
@@ -0,0 +1,11 @@
+-- Password reset tokens (only the SHA-256 hash of the emailed token is kept)
+CREATE TABLE IF NOT EXISTS password_resets (
+                                 id SERIAL PRIMARY KEY,
+                                 user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                 token_hash CHAR(64) UNIQUE NOT NULL,
+                                 expires_at TIMESTAMPTZ NOT NULL,
+                                 used_at TIMESTAMPTZ,
+                                 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user ON password_resets(user_id);
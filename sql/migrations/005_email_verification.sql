@@ -0,0 +1,4 @@
+-- Email verification. Accounts that existed before verification was
+-- introduced are treated as verified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE email_verified_at IS NULL;
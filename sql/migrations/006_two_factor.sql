@@ -0,0 +1,19 @@
+-- Two-factor authentication (TOTP) and recovery codes
+CREATE TABLE IF NOT EXISTS user_totp (
+                           user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+                           secret VARCHAR(64) NOT NULL, -- base32
+                           enabled_at TIMESTAMPTZ, -- NULL until the first code is confirmed
+                           last_step BIGINT NOT NULL DEFAULT 0, -- last accepted time step, rejects replayed codes
+                           created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Single-use recovery codes (only the SHA-256 hash is kept)
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+                                     id SERIAL PRIMARY KEY,
+                                     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                     code_hash CHAR(64) NOT NULL,
+                                     used_at TIMESTAMPTZ,
+                                     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user ON user_recovery_codes(user_id);
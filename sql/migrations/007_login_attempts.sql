@@ -0,0 +1,16 @@
+-- Brute-force protection: per-account failure tracking and a log of failed logins
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+                                id SERIAL PRIMARY KEY,
+                                email VARCHAR(255) NOT NULL,
+                                ip VARCHAR(45) NOT NULL,
+                                user_id INTEGER REFERENCES users(id) ON DELETE SET NULL, -- NULL for unknown emails
+                                reason VARCHAR(20) NOT NULL,
+                                attempted_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip, attempted_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts(email, attempted_at);
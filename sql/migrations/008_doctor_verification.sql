@@ -0,0 +1,9 @@
+-- Doctor verification. Doctors that existed before the approval workflow
+-- are treated as approved; new registrations start pending.
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS license_number VARCHAR(50);
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS verification_status VARCHAR(30) NOT NULL DEFAULT 'approved'
+    CHECK (verification_status IN ('pending_verification', 'approved', 'rejected'));
+ALTER TABLE doctors ALTER COLUMN verification_status SET DEFAULT 'pending_verification';
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS verification_note TEXT;
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMPTZ;
@@ -0,0 +1,22 @@
+-- Appointment lifecycle: add the no_show status and record every status change
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_status_check;
+ALTER TABLE appointments ADD CONSTRAINT appointments_status_check
+    CHECK (status IN ('pending', 'confirmed', 'cancelled', 'completed', 'no_show'));
+
+CREATE TABLE IF NOT EXISTS appointment_status_history (
+                                            id SERIAL PRIMARY KEY,
+                                            appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+                                            from_status VARCHAR(20), -- NULL when the appointment was created
+                                            to_status VARCHAR(20) NOT NULL,
+                                            actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL, -- NULL for automatic changes
+                                            reason TEXT,
+                                            changed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_appointment_status_history_appointment ON appointment_status_history(appointment_id);
+
+-- Start the history of existing appointments with their current status
+INSERT INTO appointment_status_history (appointment_id, to_status, changed_at)
+SELECT a.id, a.status, a.updated_at
+FROM appointments a
+WHERE NOT EXISTS (SELECT 1 FROM appointment_status_history h WHERE h.appointment_id = a.id);
@@ -0,0 +1,2 @@
+-- Count how often patients move an appointment, for the reschedule limit
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS reschedule_count INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,19 @@
+-- Per-doctor slot length and buffer, appointment durations and recurring breaks
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS slot_minutes INTEGER NOT NULL DEFAULT 60 CHECK (slot_minutes BETWEEN 5 AND 480);
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS buffer_minutes INTEGER NOT NULL DEFAULT 0 CHECK (buffer_minutes BETWEEN 0 AND 120);
+
+-- Existing appointments were all booked as one-hour slots
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS duration_minutes INTEGER NOT NULL DEFAULT 60;
+
+CREATE TABLE IF NOT EXISTS doctor_breaks (
+                               id SERIAL PRIMARY KEY,
+                               doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                               day_of_week INTEGER CHECK (day_of_week BETWEEN 0 AND 6), -- NULL applies to every day
+                               start_time TIME NOT NULL,
+                               end_time TIME NOT NULL,
+                               label VARCHAR(100),
+                               created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                               CHECK (start_time < end_time)
+);
+
+CREATE INDEX IF NOT EXISTS idx_doctor_breaks_doctor ON doctor_breaks(doctor_id);
@@ -0,0 +1,31 @@
+-- Time off, extra hours, clinic holidays, and flags on appointments they conflict with
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS conflict_note TEXT;
+
+-- One-off changes to a doctor's schedule: time off, or extra working hours
+CREATE TABLE IF NOT EXISTS schedule_exceptions (
+                                     id SERIAL PRIMARY KEY,
+                                     doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                                     kind VARCHAR(10) NOT NULL CHECK (kind IN ('block', 'extra')),
+                                     start_date DATE NOT NULL,
+                                     end_date DATE NOT NULL, -- inclusive
+                                     start_time TIME, -- NULL with end_time for a full-day block
+                                     end_time TIME,
+                                     reason TEXT,
+                                     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                     CHECK (start_date <= end_date),
+                                     CHECK ((start_time IS NULL AND end_time IS NULL AND kind = 'block')
+                                         OR (start_time IS NOT NULL AND end_time IS NOT NULL AND start_time < end_time))
+);
+
+CREATE INDEX IF NOT EXISTS idx_schedule_exceptions_doctor ON schedule_exceptions(doctor_id, end_date);
+
+-- Days the whole clinic is closed, entered by hand or imported from iCalendar
+CREATE TABLE IF NOT EXISTS clinic_holidays (
+                                 id SERIAL PRIMARY KEY,
+                                 name VARCHAR(255) NOT NULL,
+                                 start_date DATE NOT NULL,
+                                 end_date DATE NOT NULL, -- inclusive
+                                 uid VARCHAR(255) UNIQUE, -- iCalendar UID, so re-imports update instead of duplicating
+                                 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                 CHECK (start_date <= end_date)
+);
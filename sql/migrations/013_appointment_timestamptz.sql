@@ -0,0 +1,32 @@
+-- Doctors get a time zone, and appointments are stored as instants rather than
+-- a date and time without a zone
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'Asia/Almaty';
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS starts_at TIMESTAMPTZ;
+
+-- Existing appointments were booked in their doctor's local time
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'appointments' AND column_name = 'appointment_date') THEN
+        UPDATE appointments a
+        SET starts_at = (a.appointment_date + a.appointment_time) AT TIME ZONE d.timezone
+        FROM doctors d
+        WHERE d.id = a.doctor_id AND a.starts_at IS NULL;
+
+        -- Appointments whose doctor was deleted fall back to the default zone
+        UPDATE appointments
+        SET starts_at = (appointment_date + appointment_time) AT TIME ZONE 'Asia/Almaty'
+        WHERE starts_at IS NULL;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'appointments_doctor_id_starts_at_key') THEN
+        ALTER TABLE appointments ADD CONSTRAINT appointments_doctor_id_starts_at_key UNIQUE (doctor_id, starts_at);
+    END IF;
+END $$;
+
+ALTER TABLE appointments ALTER COLUMN starts_at SET NOT NULL;
+
+-- Dropping the columns also drops their unique constraint and index
+ALTER TABLE appointments DROP COLUMN IF EXISTS appointment_date;
+ALTER TABLE appointments DROP COLUMN IF EXISTS appointment_time;
+CREATE INDEX IF NOT EXISTS idx_appointments_starts_at ON appointments(starts_at);
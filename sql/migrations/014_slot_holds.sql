@@ -0,0 +1,18 @@
+-- Bookings are checked for overlaps under a lock on the doctor's row. The old
+-- unique constraint kept cancelled appointments blocking their slot forever,
+-- so only live appointments are kept unique now.
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_doctor_id_starts_at_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_appointments_active_slot ON appointments(doctor_id, starts_at) WHERE status != 'cancelled';
+
+-- Slots reserved for a patient for a short time, e.g. while they pay
+CREATE TABLE IF NOT EXISTS slot_holds (
+                            id SERIAL PRIMARY KEY,
+                            doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                            patient_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE, -- one hold per patient
+                            starts_at TIMESTAMPTZ NOT NULL,
+                            duration_minutes INTEGER NOT NULL,
+                            expires_at TIMESTAMPTZ NOT NULL,
+                            created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_slot_holds_doctor ON slot_holds(doctor_id, starts_at);
@@ -0,0 +1,29 @@
+-- Patients waiting for a slot with a doctor on any day in a date range
+CREATE TABLE IF NOT EXISTS waitlist_entries (
+                                  id SERIAL PRIMARY KEY,
+                                  doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                                  patient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                  from_date DATE NOT NULL,
+                                  to_date DATE NOT NULL, -- inclusive
+                                  status VARCHAR(20) NOT NULL DEFAULT 'waiting' CHECK (status IN ('waiting', 'offered', 'booked', 'left')),
+                                  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                  CHECK (from_date <= to_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_waitlist_entries_doctor ON waitlist_entries(doctor_id, status, to_date);
+CREATE INDEX IF NOT EXISTS idx_waitlist_entries_patient ON waitlist_entries(patient_id);
+
+-- Freed slots offered to waitlisted patients, one at a time, in line order
+CREATE TABLE IF NOT EXISTS waitlist_offers (
+                                 id SERIAL PRIMARY KEY,
+                                 entry_id INTEGER NOT NULL REFERENCES waitlist_entries(id) ON DELETE CASCADE,
+                                 doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                                 patient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                 starts_at TIMESTAMPTZ NOT NULL,
+                                 expires_at TIMESTAMPTZ NOT NULL,
+                                 status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'claimed', 'expired')),
+                                 created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_waitlist_offers_status ON waitlist_offers(status, expires_at);
+CREATE INDEX IF NOT EXISTS idx_waitlist_offers_entry ON waitlist_offers(entry_id, starts_at);
@@ -0,0 +1,18 @@
+-- Online payments for appointments. reference is our order ID at the
+-- gateway; an appointment can have several payments when a patient retries.
+CREATE TABLE IF NOT EXISTS payments (
+                          id SERIAL PRIMARY KEY,
+                          appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+                          reference VARCHAR(64) NOT NULL UNIQUE,
+                          gateway VARCHAR(20) NOT NULL,
+                          gateway_order_id VARCHAR(255),
+                          amount NUMERIC(10, 2) NOT NULL,
+                          currency CHAR(3) NOT NULL,
+                          status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid', 'failed', 'refunded')),
+                          failure_reason TEXT,
+                          paid_at TIMESTAMPTZ,
+                          created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+                          updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_appointment ON payments(appointment_id);
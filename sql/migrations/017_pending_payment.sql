@@ -0,0 +1,6 @@
+-- Appointments booked to be paid online wait in pending_payment until the payment arrives
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_status_check;
+ALTER TABLE appointments ADD CONSTRAINT appointments_status_check
+    CHECK (status IN ('pending', 'pending_payment', 'confirmed', 'cancelled', 'completed', 'no_show'));
+
+CREATE INDEX IF NOT EXISTS idx_appointments_pending_payment ON appointments(created_at) WHERE status = 'pending_payment';
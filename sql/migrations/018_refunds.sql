@@ -0,0 +1,23 @@
+-- Refunds: payments can be refunded in part
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'paid', 'failed', 'refunded', 'partially_refunded'));
+
+-- Refunds of paid payments, at most one per payment. reference is our
+-- refund ID at the gateway, so retrying a refund never pays out twice.
+CREATE TABLE IF NOT EXISTS refunds (
+                         id SERIAL PRIMARY KEY,
+                         payment_id INTEGER NOT NULL UNIQUE REFERENCES payments(id) ON DELETE CASCADE,
+                         reference VARCHAR(64) NOT NULL UNIQUE,
+                         gateway_refund_id VARCHAR(255),
+                         amount NUMERIC(10, 2) NOT NULL,
+                         currency CHAR(3) NOT NULL,
+                         reason TEXT,
+                         status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+                         failure_reason TEXT,
+                         attempts INTEGER NOT NULL DEFAULT 0,
+                         created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+                         updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_open ON refunds(created_at) WHERE status != 'succeeded';
@@ -0,0 +1,41 @@
+-- Invoices for paid appointments, one per payment. Numbers are handed out
+-- from invoice_counters so they run without gaps within a year; the clinic
+-- and patient details are copied so issued invoices never change.
+CREATE TABLE IF NOT EXISTS invoice_counters (
+                                  year INTEGER PRIMARY KEY,
+                                  last_number INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+                          id SERIAL PRIMARY KEY,
+                          number VARCHAR(40) NOT NULL UNIQUE,
+                          payment_id INTEGER NOT NULL UNIQUE REFERENCES payments(id),
+                          appointment_id INTEGER NOT NULL REFERENCES appointments(id),
+                          patient_id INTEGER NOT NULL REFERENCES users(id),
+                          issued_at TIMESTAMPTZ NOT NULL,
+                          seller_name VARCHAR(255) NOT NULL,
+                          seller_address TEXT NOT NULL DEFAULT '',
+                          seller_tax_id VARCHAR(50) NOT NULL DEFAULT '',
+                          buyer_name VARCHAR(255) NOT NULL,
+                          buyer_email VARCHAR(255) NOT NULL,
+                          currency CHAR(3) NOT NULL,
+                          subtotal NUMERIC(10, 2) NOT NULL,
+                          tax_name VARCHAR(20) NOT NULL DEFAULT '',
+                          tax_rate NUMERIC(5, 2) NOT NULL DEFAULT 0,
+                          tax_amount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+                          total NUMERIC(10, 2) NOT NULL,
+                          created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_appointment ON invoices(appointment_id);
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+                               id SERIAL PRIMARY KEY,
+                               invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+                               position INTEGER NOT NULL,
+                               description TEXT NOT NULL,
+                               quantity INTEGER NOT NULL DEFAULT 1,
+                               unit_price NUMERIC(10, 2) NOT NULL,
+                               amount NUMERIC(10, 2) NOT NULL,
+                               UNIQUE (invoice_id, position)
+);
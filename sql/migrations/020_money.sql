@@ -0,0 +1,76 @@
+-- Amounts of money are stored as integers in the currency's minor units
+-- (tiyn, cents) next to their ISO 4217 currency, instead of as decimals.
+-- Consultation fees had no currency; they were charged in the payment
+-- currency, PAYMENT_CURRENCY. Pass it to psql as the currency variable,
+-- e.g. psql -v currency=RUB -f 020_money.sql; without it the fees are
+-- taken to be in KZT, the default.
+\if :{?currency}
+\else
+\set currency KZT
+\endif
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS consultation_fee_minor BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS consultation_fee_currency CHAR(3) NOT NULL DEFAULT :'currency';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS amount_minor BIGINT;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS amount_minor BIGINT;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS subtotal_minor BIGINT;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax_minor BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS total_minor BIGINT;
+ALTER TABLE invoice_items ADD COLUMN IF NOT EXISTS unit_price_minor BIGINT;
+ALTER TABLE invoice_items ADD COLUMN IF NOT EXISTS amount_minor BIGINT;
+
+-- Minor units per major unit of a currency
+CREATE OR REPLACE FUNCTION pg_temp.minor_units(currency CHAR(3)) RETURNS INTEGER AS $$
+    SELECT CASE currency WHEN 'JPY' THEN 1 WHEN 'KWD' THEN 1000 ELSE 100 END
+$$ LANGUAGE SQL IMMUTABLE;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'doctors' AND column_name = 'consultation_fee') THEN
+        UPDATE doctors
+        SET consultation_fee_minor = ROUND(COALESCE(consultation_fee, 0) * pg_temp.minor_units(consultation_fee_currency));
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'payments' AND column_name = 'amount') THEN
+        UPDATE payments SET amount_minor = ROUND(amount * pg_temp.minor_units(currency))
+        WHERE amount_minor IS NULL;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'refunds' AND column_name = 'amount') THEN
+        UPDATE refunds SET amount_minor = ROUND(amount * pg_temp.minor_units(currency))
+        WHERE amount_minor IS NULL;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'invoices' AND column_name = 'total') THEN
+        UPDATE invoices
+        SET subtotal_minor = ROUND(subtotal * pg_temp.minor_units(currency)),
+            tax_minor = ROUND(tax_amount * pg_temp.minor_units(currency)),
+            total_minor = ROUND(total * pg_temp.minor_units(currency))
+        WHERE total_minor IS NULL;
+
+        UPDATE invoice_items ii
+        SET unit_price_minor = ROUND(ii.unit_price * pg_temp.minor_units(i.currency)),
+            amount_minor = ROUND(ii.amount * pg_temp.minor_units(i.currency))
+        FROM invoices i
+        WHERE i.id = ii.invoice_id AND ii.amount_minor IS NULL;
+    END IF;
+END $$;
+
+ALTER TABLE payments ALTER COLUMN amount_minor SET NOT NULL;
+ALTER TABLE refunds ALTER COLUMN amount_minor SET NOT NULL;
+ALTER TABLE invoices ALTER COLUMN subtotal_minor SET NOT NULL;
+ALTER TABLE invoices ALTER COLUMN total_minor SET NOT NULL;
+ALTER TABLE invoice_items ALTER COLUMN unit_price_minor SET NOT NULL;
+ALTER TABLE invoice_items ALTER COLUMN amount_minor SET NOT NULL;
+
+ALTER TABLE doctors DROP COLUMN IF EXISTS consultation_fee;
+ALTER TABLE payments DROP COLUMN IF EXISTS amount;
+ALTER TABLE refunds DROP COLUMN IF EXISTS amount;
+ALTER TABLE invoices DROP COLUMN IF EXISTS subtotal;
+ALTER TABLE invoices DROP COLUMN IF EXISTS tax_amount;
+ALTER TABLE invoices DROP COLUMN IF EXISTS total;
+ALTER TABLE invoice_items DROP COLUMN IF EXISTS unit_price;
+ALTER TABLE invoice_items DROP COLUMN IF EXISTS amount;
@@ -0,0 +1,6 @@
+-- Waitlist offers hold their slot in a row of their own, so a patient's
+-- hold while paying and the holds of offers made to them don't replace
+-- each other
+ALTER TABLE slot_holds ADD COLUMN IF NOT EXISTS offer_id INTEGER UNIQUE REFERENCES waitlist_offers(id) ON DELETE CASCADE;
+ALTER TABLE slot_holds DROP CONSTRAINT IF EXISTS slot_holds_patient_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_slot_holds_patient ON slot_holds(patient_id) WHERE offer_id IS NULL;
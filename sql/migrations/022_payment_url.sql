@@ -0,0 +1,3 @@
+-- Where the patient pays a pending payment, so a second attempt to pay the
+-- same appointment goes back to it instead of opening another order
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS payment_url TEXT;
@@ -7,6 +7,10 @@ CREATE TABLE users (
                        last_name VARCHAR(100) NOT NULL,
                        phone VARCHAR(20),
                        user_type VARCHAR(20) NOT NULL CHECK (user_type IN ('patient', 'doctor', 'admin')),
+                       email_verified_at TIMESTAMPTZ,
+                       failed_logins INTEGER NOT NULL DEFAULT 0, -- consecutive, reset on success
+                       last_failed_login_at TIMESTAMPTZ,
+                       locked_until TIMESTAMPTZ,
                        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -19,8 +23,18 @@ CREATE TABLE doctors (
                          experience_years INTEGER DEFAULT 0,
                          education TEXT,
                          about TEXT,
-                         consultation_fee DECIMAL(10,2) DEFAULT 0.00,
+                         consultation_fee_minor BIGINT NOT NULL DEFAULT 0, -- in minor units (tiyn, cents) of the currency
+                         consultation_fee_currency CHAR(3) NOT NULL DEFAULT 'KZT', -- ISO 4217
+                         slot_minutes INTEGER NOT NULL DEFAULT 60 CHECK (slot_minutes BETWEEN 5 AND 480),
+                         buffer_minutes INTEGER NOT NULL DEFAULT 0 CHECK (buffer_minutes BETWEEN 0 AND 120), -- kept free between visits
+                         timezone VARCHAR(64) NOT NULL DEFAULT 'Asia/Almaty', -- IANA zone of the working hours
                          is_active BOOLEAN DEFAULT true,
+                         license_number VARCHAR(50),
+                         verification_status VARCHAR(30) NOT NULL DEFAULT 'pending_verification'
+                             CHECK (verification_status IN ('pending_verification', 'approved', 'rejected')),
+                         verification_note TEXT, -- admin's reason for the decision
+                         reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+                         reviewed_at TIMESTAMPTZ,
                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                          updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -36,18 +50,165 @@ CREATE TABLE doctor_availability (
                                      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Recurring breaks inside working hours, such as lunch
+CREATE TABLE doctor_breaks (
+                               id SERIAL PRIMARY KEY,
+                               doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                               day_of_week INTEGER CHECK (day_of_week BETWEEN 0 AND 6), -- NULL applies to every day
+                               start_time TIME NOT NULL,
+                               end_time TIME NOT NULL,
+                               label VARCHAR(100),
+                               created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                               CHECK (start_time < end_time)
+);
+
+CREATE INDEX idx_doctor_breaks_doctor ON doctor_breaks(doctor_id);
+
 -- Appointments table
 CREATE TABLE appointments (
                               id SERIAL PRIMARY KEY,
                               patient_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
                               doctor_id INTEGER REFERENCES doctors(id) ON DELETE CASCADE,
-                              appointment_date DATE NOT NULL,
-                              appointment_time TIME NOT NULL,
-                              status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'confirmed', 'cancelled', 'completed')),
+                              starts_at TIMESTAMPTZ NOT NULL,
+                              duration_minutes INTEGER NOT NULL DEFAULT 60, -- the doctor's slot length when booked
+                              status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'pending_payment', 'confirmed', 'cancelled', 'completed', 'no_show')),
                               notes TEXT,
+                              reschedule_count INTEGER NOT NULL DEFAULT 0,
+                              conflict_note TEXT, -- set when the schedule changed under a confirmed appointment
                               created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-                              updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-                              UNIQUE(doctor_id, appointment_date, appointment_time)
+                              updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Bookings are checked for overlaps under a lock on the doctor's row; this
+-- backstop only applies to live appointments, so cancelled ones free their slot
+CREATE UNIQUE INDEX idx_appointments_active_slot ON appointments(doctor_id, starts_at) WHERE status != 'cancelled';
+CREATE INDEX idx_appointments_pending_payment ON appointments(created_at) WHERE status = 'pending_payment';
+
+-- Patients waiting for a slot with a doctor on any day in a date range
+CREATE TABLE waitlist_entries (
+                                  id SERIAL PRIMARY KEY,
+                                  doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                                  patient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                  from_date DATE NOT NULL,
+                                  to_date DATE NOT NULL, -- inclusive
+                                  status VARCHAR(20) NOT NULL DEFAULT 'waiting' CHECK (status IN ('waiting', 'offered', 'booked', 'left')),
+                                  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                  CHECK (from_date <= to_date)
+);
+
+CREATE INDEX idx_waitlist_entries_doctor ON waitlist_entries(doctor_id, status, to_date);
+CREATE INDEX idx_waitlist_entries_patient ON waitlist_entries(patient_id);
+
+-- Freed slots offered to waitlisted patients, one at a time, in line order
+CREATE TABLE waitlist_offers (
+                                 id SERIAL PRIMARY KEY,
+                                 entry_id INTEGER NOT NULL REFERENCES waitlist_entries(id) ON DELETE CASCADE,
+                                 doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                                 patient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                 starts_at TIMESTAMPTZ NOT NULL,
+                                 expires_at TIMESTAMPTZ NOT NULL,
+                                 status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'claimed', 'expired')),
+                                 created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_waitlist_offers_status ON waitlist_offers(status, expires_at);
+CREATE INDEX idx_waitlist_offers_entry ON waitlist_offers(entry_id, starts_at);
+
+-- Slots reserved for a patient for a short time, e.g. while they pay, or
+-- for a waitlist offer while it is open
+CREATE TABLE slot_holds (
+                            id SERIAL PRIMARY KEY,
+                            doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                            patient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                            offer_id INTEGER UNIQUE REFERENCES waitlist_offers(id) ON DELETE CASCADE, -- NULL for the patient's own hold
+                            starts_at TIMESTAMPTZ NOT NULL,
+                            duration_minutes INTEGER NOT NULL,
+                            expires_at TIMESTAMPTZ NOT NULL,
+                            created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_slot_holds_doctor ON slot_holds(doctor_id, starts_at);
+CREATE UNIQUE INDEX idx_slot_holds_patient ON slot_holds(patient_id) WHERE offer_id IS NULL; -- one hold of their own per patient
+
+-- Online payments for appointments. reference is our order ID at the
+-- gateway; an appointment can have several payments when a patient retries.
+CREATE TABLE payments (
+                          id SERIAL PRIMARY KEY,
+                          appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+                          reference VARCHAR(64) NOT NULL UNIQUE,
+                          gateway VARCHAR(20) NOT NULL,
+                          gateway_order_id VARCHAR(255),
+                          payment_url TEXT, -- where the patient pays the order
+                          amount_minor BIGINT NOT NULL, -- in minor units of the currency
+                          currency CHAR(3) NOT NULL,
+                          status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid', 'failed', 'refunded', 'partially_refunded')),
+                          failure_reason TEXT,
+                          paid_at TIMESTAMPTZ,
+                          created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+                          updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_payments_appointment ON payments(appointment_id);
+
+-- Refunds of paid payments, at most one per payment. reference is our
+-- refund ID at the gateway, so retrying a refund never pays out twice.
+CREATE TABLE refunds (
+                         id SERIAL PRIMARY KEY,
+                         payment_id INTEGER NOT NULL UNIQUE REFERENCES payments(id) ON DELETE CASCADE,
+                         reference VARCHAR(64) NOT NULL UNIQUE,
+                         gateway_refund_id VARCHAR(255),
+                         amount_minor BIGINT NOT NULL,
+                         currency CHAR(3) NOT NULL,
+                         reason TEXT,
+                         status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+                         failure_reason TEXT,
+                         attempts INTEGER NOT NULL DEFAULT 0,
+                         created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+                         updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refunds_open ON refunds(created_at) WHERE status != 'succeeded';
+
+-- Invoices for paid appointments, one per payment. Numbers are handed out
+-- from invoice_counters so they run without gaps within a year; the clinic
+-- and patient details are copied so issued invoices never change.
+CREATE TABLE invoice_counters (
+                                  year INTEGER PRIMARY KEY,
+                                  last_number INTEGER NOT NULL
+);
+
+CREATE TABLE invoices (
+                          id SERIAL PRIMARY KEY,
+                          number VARCHAR(40) NOT NULL UNIQUE,
+                          payment_id INTEGER NOT NULL UNIQUE REFERENCES payments(id),
+                          appointment_id INTEGER NOT NULL REFERENCES appointments(id),
+                          patient_id INTEGER NOT NULL REFERENCES users(id),
+                          issued_at TIMESTAMPTZ NOT NULL,
+                          seller_name VARCHAR(255) NOT NULL,
+                          seller_address TEXT NOT NULL DEFAULT '',
+                          seller_tax_id VARCHAR(50) NOT NULL DEFAULT '',
+                          buyer_name VARCHAR(255) NOT NULL,
+                          buyer_email VARCHAR(255) NOT NULL,
+                          currency CHAR(3) NOT NULL,
+                          subtotal_minor BIGINT NOT NULL,
+                          tax_name VARCHAR(20) NOT NULL DEFAULT '',
+                          tax_rate NUMERIC(5, 2) NOT NULL DEFAULT 0,
+                          tax_minor BIGINT NOT NULL DEFAULT 0,
+                          total_minor BIGINT NOT NULL,
+                          created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_invoices_appointment ON invoices(appointment_id);
+
+CREATE TABLE invoice_items (
+                               id SERIAL PRIMARY KEY,
+                               invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+                               position INTEGER NOT NULL,
+                               description TEXT NOT NULL,
+                               quantity INTEGER NOT NULL DEFAULT 1,
+                               unit_price_minor BIGINT NOT NULL,
+                               amount_minor BIGINT NOT NULL,
+                               UNIQUE (invoice_id, position)
 );
 
 
@@ -64,7 +225,7 @@ CREATE TABLE chat_logs (
 CREATE INDEX idx_users_email ON users(email);
 CREATE INDEX idx_users_type ON users(user_type);
 CREATE INDEX idx_doctors_specialty ON doctors(specialty);
-CREATE INDEX idx_appointments_date ON appointments(appointment_date);
+CREATE INDEX idx_appointments_starts_at ON appointments(starts_at);
 CREATE INDEX idx_appointments_patient ON appointments(patient_id);
 CREATE INDEX idx_appointments_doctor ON appointments(doctor_id);
 
@@ -85,4 +246,105 @@ CREATE TRIGGER update_doctors_updated_at BEFORE UPDATE ON doctors
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
 CREATE TRIGGER update_appointments_updated_at BEFORE UPDATE ON appointments
-    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
\ No newline at end of file
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Login sessions
+CREATE TABLE sessions (
+                          token_hash CHAR(64) PRIMARY KEY, -- SHA-256 of the cookie value
+                          user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                          user_type VARCHAR(20) NOT NULL,
+                          email VARCHAR(255) NOT NULL,
+                          doctor_id INTEGER REFERENCES doctors(id) ON DELETE CASCADE,
+                          expire_at TIMESTAMPTZ NOT NULL,
+                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_sessions_user ON sessions(user_id);
+CREATE INDEX idx_sessions_expire_at ON sessions(expire_at);
+
+-- Password reset tokens (only the SHA-256 hash of the emailed token is kept)
+CREATE TABLE password_resets (
+                                 id SERIAL PRIMARY KEY,
+                                 user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                 token_hash CHAR(64) UNIQUE NOT NULL,
+                                 expires_at TIMESTAMPTZ NOT NULL,
+                                 used_at TIMESTAMPTZ,
+                                 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_password_resets_user ON password_resets(user_id);
+
+-- Two-factor authentication with authenticator apps (RFC 6238 TOTP)
+CREATE TABLE user_totp (
+                           user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+                           secret VARCHAR(64) NOT NULL, -- base32
+                           enabled_at TIMESTAMPTZ, -- NULL until the first code is confirmed
+                           last_step BIGINT NOT NULL DEFAULT 0, -- last accepted time step, rejects replayed codes
+                           created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Single-use recovery codes (only the SHA-256 hash is kept)
+CREATE TABLE user_recovery_codes (
+                                     id SERIAL PRIMARY KEY,
+                                     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                     code_hash CHAR(64) NOT NULL,
+                                     used_at TIMESTAMPTZ,
+                                     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_recovery_codes_user ON user_recovery_codes(user_id);
+
+-- Failed logins, for brute-force protection and auditing
+CREATE TABLE login_attempts (
+                                id SERIAL PRIMARY KEY,
+                                email VARCHAR(255) NOT NULL,
+                                ip VARCHAR(45) NOT NULL,
+                                user_id INTEGER REFERENCES users(id) ON DELETE SET NULL, -- NULL for unknown emails
+                                reason VARCHAR(20) NOT NULL,
+                                attempted_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_attempts_ip ON login_attempts(ip, attempted_at);
+CREATE INDEX idx_login_attempts_email ON login_attempts(email, attempted_at);
+
+-- Every appointment status change: who made it, when and why
+CREATE TABLE appointment_status_history (
+                                            id SERIAL PRIMARY KEY,
+                                            appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+                                            from_status VARCHAR(20), -- NULL when the appointment was created
+                                            to_status VARCHAR(20) NOT NULL,
+                                            actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL, -- NULL for automatic changes
+                                            reason TEXT,
+                                            changed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_appointment_status_history_appointment ON appointment_status_history(appointment_id);
+
+-- One-off changes to a doctor's schedule: time off, or extra working hours
+CREATE TABLE schedule_exceptions (
+                                     id SERIAL PRIMARY KEY,
+                                     doctor_id INTEGER NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+                                     kind VARCHAR(10) NOT NULL CHECK (kind IN ('block', 'extra')),
+                                     start_date DATE NOT NULL,
+                                     end_date DATE NOT NULL, -- inclusive
+                                     start_time TIME, -- NULL with end_time for a full-day block
+                                     end_time TIME,
+                                     reason TEXT,
+                                     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                     CHECK (start_date <= end_date),
+                                     CHECK ((start_time IS NULL AND end_time IS NULL AND kind = 'block')
+                                         OR (start_time IS NOT NULL AND end_time IS NOT NULL AND start_time < end_time))
+);
+
+CREATE INDEX idx_schedule_exceptions_doctor ON schedule_exceptions(doctor_id, end_date);
+
+-- Days the whole clinic is closed, entered by hand or imported from iCalendar
+CREATE TABLE clinic_holidays (
+                                 id SERIAL PRIMARY KEY,
+                                 name VARCHAR(255) NOT NULL,
+                                 start_date DATE NOT NULL,
+                                 end_date DATE NOT NULL, -- inclusive
+                                 uid VARCHAR(255) UNIQUE, -- iCalendar UID, so re-imports update instead of duplicating
+                                 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                 CHECK (start_date <= end_date)
+);
@@ -14,10 +14,10 @@ INSERT INTO users (email, password_hash, first_name, last_name, phone, user_type
                                                                                       ('patient2@email.com', '$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi', 'Bob', 'Davis', '1234567895', 'patient');
 
 -- Insert doctor details
-INSERT INTO doctors (user_id, specialty, experience_years, education, about, consultation_fee) VALUES
-                                                                                                   (2, 'Cardiology', 10, 'MD from Harvard Medical School', 'Specialized in heart diseases and cardiovascular surgery', 150.00),
-                                                                                                   (3, 'Dermatology', 8, 'MD from Johns Hopkins', 'Expert in skin conditions and cosmetic procedures', 100.00),
-                                                                                                   (4, 'General Practice', 5, 'MD from State University', 'Family medicine and general health consultations', 80.00);
+INSERT INTO doctors (user_id, specialty, experience_years, education, about, consultation_fee_minor, consultation_fee_currency) VALUES
+                                                                                                   (2, 'Cardiology', 10, 'MD from Harvard Medical School', 'Specialized in heart diseases and cardiovascular surgery', 15000, 'KZT'),
+                                                                                                   (3, 'Dermatology', 8, 'MD from Johns Hopkins', 'Expert in skin conditions and cosmetic procedures', 10000, 'KZT'),
+                                                                                                   (4, 'General Practice', 5, 'MD from State University', 'Family medicine and general health consultations', 8000, 'KZT');
 
 -- Insert doctor availability (Monday to Friday, 9 AM to 5 PM)
 INSERT INTO doctor_availability (doctor_id, day_of_week, start_time, end_time) VALUES
@@ -41,4 +41,19 @@ INSERT INTO doctor_availability (doctor_id, day_of_week, start_time, end_time) V
 (3, 3, '08:00', '18:00'), -- Wednesday
 (3, 4, '08:00', '18:00'), -- Thursday
 (3, 5, '08:00', '18:00'), -- Friday
-(3, 6, '09:00', '13:00'); -- Saturday
\ No newline at end of file
+(3, 6, '09:00', '13:00'); -- Saturday
+
+-- Dr. Brown sees patients in short visits with a few minutes in between
+UPDATE doctors SET slot_minutes = 30, buffer_minutes = 5 WHERE id = 3;
+
+-- Lunch breaks on weekdays
+INSERT INTO doctor_breaks (doctor_id, day_of_week, start_time, end_time, label)
+SELECT doctor_id, day_of_week, '13:00', '14:00', 'Lunch'
+FROM doctor_availability
+WHERE doctor_id IN (1, 3) AND day_of_week BETWEEN 1 AND 5;
+
+-- Demo accounts don't need to confirm their email
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP;
+
+-- Sample doctors are already verified
+UPDATE doctors SET verification_status = 'approved', reviewed_at = CURRENT_TIMESTAMP;
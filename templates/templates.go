@@ -0,0 +1,10 @@
+// Package templates embeds the HTML page templates so the server binary
+// does not depend on its working directory.
+package templates
+
+import "embed"
+
+// FS holds every *.html template in this directory
+//
+//go:embed *.html
+var FS embed.FS